@@ -7,5 +7,6 @@ require (
 	github.com/gomodule/redigo v1.8.6
 	github.com/labstack/echo/v4 v4.6.1
 	github.com/pelletier/go-toml v1.9.4
+	github.com/tidwall/btree v1.1.0
 	github.com/tidwall/redcon v1.4.4
 )
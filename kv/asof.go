@@ -0,0 +1,83 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"strconv"
+	"time"
+)
+
+// OpenAt opens the db as of the time t, the entries written after t are ignored.
+// The db is read-only, the writes return ErrDBIsReadOnly, and the keys are expired according to t.
+// Nothing in the db directory is repaired or written: the torn tails and corrupt db files are only skipped in memory,
+// the hint files are not written, and the db files are opened read-only.
+// It returns ErrReclaimNotRecovered if a committed reclaim is not finished, open the db by Open once to recover it.
+// The transactions committed after t are discarded as a whole.
+// Reclaim and compaction keep only the latest entries, so the history before them may be lost.
+func OpenAt(config Config, t time.Time) (*KVDB, error) {
+	return open(config, t.UnixNano())
+}
+
+// now returns the current unix time in milliseconds, it is the time of snapshot if db is opened by OpenAt.
+func (db *KVDB) now() int64 {
+	if db.asOf != 0 {
+		return db.asOf / int64(time.Millisecond)
+	}
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// ttl returns the time to live in seconds of the deadline in milliseconds, it is rounded like Redis.
+func (db *KVDB) ttl(deadline int64) int64 {
+	return (deadline - db.now() + 500) / 1000
+}
+
+// writtenAfterAsOf reports whether the entry is written after the time of snapshot.
+// The Timestamp of expire entry is its deadline, and the time when it is written is saved in its extra,
+// the old expire entries without it are considered to be written with the previous entry.
+// prev is the write time of the previous entry, it is updated.
+func (db *KVDB) writtenAfterAsOf(e *storage.Entry, prev *int64) bool {
+	if db.asOf == 0 {
+		return false
+	}
+
+	writeTime := int64(e.Timestamp)
+	if isExpireEntry(e) {
+		writeTime = *prev
+		written := e.Meta.Extra
+		// the extra of field expire entry is the field, so its write time is saved in the value.
+		if e.GetType() == Hash && e.GetMark() == HashHFieldExpire {
+			written = e.Meta.Value
+		}
+		if t, err := strconv.ParseInt(string(written), 10, 64); err == nil {
+			writeTime = t
+		}
+	}
+	*prev = writeTime
+	return writeTime > db.asOf
+}
+
+func isExpireEntry(e *storage.Entry) bool {
+	mark := e.GetMark()
+	switch e.GetType() {
+	case Hash:
+		return mark == HashHExpire || mark == HashHPExpire || mark == HashHFieldExpire
+	case String:
+		return mark == StringExpire
+	case List:
+		return mark == ListLExpire
+	case Set:
+		return mark == SetSExpire
+	case ZSet:
+		return mark == ZSetZExpire
+	}
+	return false
+}
+
+// expireDeadline returns the deadline of expire entry in milliseconds.
+// The deadlines of HashHPExpire and HashHFieldExpire are saved in milliseconds, and the others are saved in seconds.
+func expireDeadline(e *storage.Entry) int64 {
+	if e.GetType() == Hash && (e.GetMark() == HashHPExpire || e.GetMark() == HashHFieldExpire) {
+		return int64(e.Timestamp)
+	}
+	return int64(e.Timestamp) * 1000
+}
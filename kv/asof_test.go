@@ -0,0 +1,240 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// dirFiles returns the content of every file in the dir and its sub dirs by their relative paths.
+func dirFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[rel] = string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// assertDirUnchanged checks that the files in dir are the same as before.
+func assertDirUnchanged(t *testing.T, dir string, before map[string]string) {
+	t.Helper()
+
+	after := dirFiles(t, dir)
+	for name, content := range before {
+		if got, ok := after[name]; !ok {
+			t.Fatalf("%s is removed", name)
+		} else if got != content {
+			t.Fatalf("%s is changed", name)
+		}
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			t.Fatalf("%s is created", name)
+		}
+	}
+}
+
+func openAt(t *testing.T, cfg Config, at time.Time) *KVDB {
+	t.Helper()
+
+	db, err := OpenAt(cfg, at)
+	if err != nil {
+		t.Fatalf("open db at %v: %v", at, err)
+	}
+	t.Cleanup(func() {
+		closeTestDB(t, db)
+	})
+	return db
+}
+
+func rwMethods() map[string]storage.FileRWMethod {
+	return map[string]storage.FileRWMethod{"FileIO": storage.FileIO, "MMap": storage.MMap}
+}
+
+// The db is read as of the time, even if it is still opened by a writer.
+func TestOpenAt(t *testing.T) {
+	for name, method := range rwMethods() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				cfg.RwMethod = method
+			})
+
+			if err := db.Set([]byte("a"), []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.HSet([]byte("hash"), []byte("f"), []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Expire([]byte("a"), 100); err != nil {
+				t.Fatal(err)
+			}
+			snapshot := time.Now()
+			time.Sleep(2 * time.Millisecond)
+			if err := db.Set([]byte("a"), []byte("new")); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Set([]byte("b"), []byte("new")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.HSet([]byte("hash"), []byte("f"), []byte("new")); err != nil {
+				t.Fatal(err)
+			}
+
+			before := dirFiles(t, db.config.DirPath)
+			at := openAt(t, db.config, snapshot)
+			val, err := at.Get([]byte("a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, val, "old")
+			if ttl := at.TTL([]byte("a")); ttl < 99 || ttl > 100 {
+				t.Fatalf("TTL as of the snapshot: got %d, want about 100", ttl)
+			}
+			if _, err := at.Get([]byte("b")); err != ErrKeyNotExist {
+				t.Fatalf("Get a key written after the snapshot: got %v, want ErrKeyNotExist", err)
+			}
+			assertBytes(t, at.HGet([]byte("hash"), []byte("f")), "old")
+
+			if err := at.Set([]byte("c"), []byte("v")); err != ErrDBIsReadOnly {
+				t.Fatalf("Set: got %v, want ErrDBIsReadOnly", err)
+			}
+			if err := at.Reclaim(); err != ErrDBIsReadOnly {
+				t.Fatalf("Reclaim: got %v, want ErrDBIsReadOnly", err)
+			}
+			closeTestDB(t, at)
+			assertDirUnchanged(t, db.config.DirPath, before)
+
+			// the writer is not disturbed.
+			val, err = db.Get([]byte("a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, val, "new")
+		})
+	}
+}
+
+// Nothing is repaired or written by OpenAt, the damaged db files are skipped in memory.
+func TestOpenAtReadOnly(t *testing.T) {
+	for name, method := range rwMethods() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				smallFiles(cfg)
+				cfg.RwMethod = method
+			})
+
+			for i := 0; i < 120; i++ {
+				if err := db.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			writeInterruptedTxn(t, db, 1000)
+			if len(db.archFiles[String]) < 2 {
+				t.Fatalf("got %d archived files, want at least 2", len(db.archFiles[String]))
+			}
+			var oldest, corrupt *storage.DBFile
+			for _, df := range db.archFiles[String] {
+				if oldest == nil || df.Id < oldest.Id {
+					oldest = df
+				}
+				if corrupt == nil || df.Id > corrupt.Id {
+					corrupt = df
+				}
+			}
+			activeFile, err := db.getActiveFile(String)
+			if err != nil {
+				t.Fatal(err)
+			}
+			corruptName, corruptId, torn := corrupt.Name(String), corrupt.Id, activeFile.Offset-3
+			closeTestDB(t, db)
+
+			dir := db.config.DirPath
+			// the hint files are rebuilt, the tail is truncated and the corrupt file is quarantined by Open.
+			for _, id := range []uint32{oldest.Id, corruptId} {
+				if err := os.Remove(hintPath(db, String, id)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			flipByte(t, corruptName, corrupt.DataOffset()+20)
+			if err := os.Truncate(activeFile.Name(String), torn); err != nil {
+				t.Fatal(err)
+			}
+			// the uncommitted reclaim is removed by Open.
+			if err := os.MkdirAll(dir+reclaimPath, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			copyFile(t, oldest.Name(String), dir+reclaimPath+dbFileName(oldest.Id, String))
+
+			before := dirFiles(t, dir)
+			at := openAt(t, db.config, time.Now())
+			report := at.RecoveryReport()
+			if len(report.Truncated) != 1 || len(report.Quarantined) != 1 || report.Quarantined[0].Path != corruptName {
+				t.Fatalf("got the recovery report %+v", report)
+			}
+			if df := at.archFiles[String][corruptId]; df == nil || df.Offset != df.DataOffset() {
+				t.Fatal("the corrupt tail is loaded")
+			}
+			var found int
+			for i := 0; i < 120; i++ {
+				if _, err := at.Get([]byte(fmt.Sprintf("k%03d", i))); err == nil {
+					found++
+				}
+			}
+			if found == 0 || found == 120 {
+				t.Fatalf("got %d keys, want the keys out of the corrupt file", found)
+			}
+			if _, err := at.Get([]byte("str")); err != ErrKeyNotExist {
+				t.Fatalf("Get the key of interrupted transaction: got %v, want ErrKeyNotExist", err)
+			}
+			closeTestDB(t, at)
+			assertDirUnchanged(t, dir, before)
+
+			// the damage is still repaired by Open.
+			db = openTestConfig(t, db.config)
+			if report := db.RecoveryReport(); len(report.Truncated) != 1 || len(report.Quarantined) != 1 {
+				t.Fatalf("got the recovery report %+v", report)
+			}
+		})
+	}
+}
+
+func TestOpenAtReclaimNotRecovered(t *testing.T) {
+	db, _ := prepareCommittedReclaim(t)
+
+	if _, err := OpenAt(db.config, time.Now()); err != ErrReclaimNotRecovered {
+		t.Fatalf("OpenAt with a committed reclaim: got %v, want ErrReclaimNotRecovered", err)
+	}
+	db = openTestConfig(t, db.config)
+	closeTestDB(t, db)
+	checkReclaimed(t, openAt(t, db.config, time.Now()))
+}
+
+func TestOpenAtMissingDir(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DirPath = filepath.Join(t.TempDir(), "missing")
+	if _, err := OpenAt(cfg, time.Now()); err == nil {
+		t.Fatal("OpenAt a missing dir: got nil error")
+	}
+	if _, err := os.Stat(cfg.DirPath); !os.IsNotExist(err) {
+		t.Fatalf("the missing dir is created: %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+	"MetaDB/kv/utils"
+
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrBackupNotExist the backup manifest is not found in the backup dir.
+	ErrBackupNotExist = errors.New("rosedb: backup manifest not exist")
+
+	// ErrBackupCorrupt a file in backup doesn't match its checksum in the manifest.
+	ErrBackupCorrupt = errors.New("rosedb: backup is corrupt")
+
+	// ErrRestoreTargetNotEmpty the db can only be restored to an empty dir.
+	ErrRestoreTargetNotEmpty = errors.New("rosedb: restore target dir is not empty")
+)
+
+const (
+	// The manifest of backup, it is written after all files are copied.
+	backupManifestFile = string(os.PathSeparator) + "BACKUP"
+
+	backupVersion = 1
+)
+
+type (
+	// backupManifest records the files of a backup and their checksums.
+	backupManifest struct {
+		Version   int          `json:"version"`
+		CreatedAt int64        `json:"created_at"`
+		Files     []backupFile `json:"files"`
+	}
+
+	backupFile struct {
+		Name     string   `json:"name"`
+		DataType DataType `json:"data_type"`
+		FileId   uint32   `json:"file_id"`
+		Hint     bool     `json:"hint"`
+		Active   bool     `json:"active"`
+		Size     int64    `json:"size"`
+		Crc32    uint32   `json:"crc32"`
+		// the modification time of source file, an archived file is not copied again if it is not changed.
+		ModTime int64 `json:"mod_time"`
+	}
+)
+
+// Backup saves a consistent snapshot of db to dir.
+// The archived files and the written part of active files are frozen at the beginning, and then copied without blocking writes.
+// If dir has a backup already, the archived files which are not changed since it are not copied again.
+// A manifest with the checksums of files is written at last, so an interrupted backup is never restored, run it again.
+func (db *KVDB) Backup(dir string) (err error) {
+	// the archived files can't be removed by reclaim or compaction during copying.
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if atomic.LoadUint32(&db.closed) == 1 {
+		return ErrDBIsClosed
+	}
+	// the files have the entries after the snapshot of OpenAt.
+	if db.asOf != 0 {
+		return ErrDBIsReadOnly
+	}
+
+	files, err := db.backupSnapshot()
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// the old manifest is removed first, since the files in it will be overwritten.
+	prev, err := readBackupManifest(dir)
+	if err != nil && err != ErrBackupNotExist {
+		return err
+	}
+	if err = removeIfExist(dir + backupManifestFile); err != nil {
+		return err
+	}
+	saved := make(map[string]backupFile)
+	if prev != nil {
+		for _, f := range prev.Files {
+			saved[f.Name] = f
+		}
+	}
+
+	manifest := &backupManifest{Version: backupVersion, CreatedAt: time.Now().Unix()}
+	for _, f := range files {
+		if old, ok := saved[f.Name]; ok && !old.Active && !f.Active && old.Size == f.Size && old.ModTime == f.ModTime {
+			f.Crc32 = old.Crc32
+		} else {
+			src := filepath.Join(db.config.DirPath, f.Name)
+			if f.Crc32, err = copyFileN(src, filepath.Join(dir, f.Name), f.Size); err != nil {
+				return err
+			}
+		}
+		manifest.Files = append(manifest.Files, f)
+		delete(saved, f.Name)
+	}
+
+	// the files not in the snapshot are removed, such as the db files reclaimed.
+	for name := range saved {
+		if err = removeIfExist(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	if err = utils.SyncDir(dir); err != nil {
+		return err
+	}
+	return writeBackupManifest(dir, manifest)
+}
+
+// backupSnapshot freezes the files to back up, the writes are blocked only while the sizes are read.
+func (db *KVDB) backupSnapshot() ([]backupFile, error) {
+	var dTypes []DataType
+	for i := 0; i < DataStructureNum; i++ {
+		dTypes = append(dTypes, uint16(i))
+	}
+	unlock := db.lockMgr.RLock(dTypes...)
+	defer unlock()
+
+	var files []backupFile
+	addFile := func(f backupFile) error {
+		info, err := os.Stat(filepath.Join(db.config.DirPath, f.Name))
+		if err != nil {
+			return err
+		}
+		if !f.Active {
+			f.Size = info.Size()
+		}
+		f.ModTime = info.ModTime().UnixNano()
+		files = append(files, f)
+		return nil
+	}
+
+	for _, dType := range dTypes {
+		for id, df := range db.archFiles[dType] {
+			if err := addFile(backupFile{Name: filepath.Base(df.Name(dType)), DataType: dType, FileId: id}); err != nil {
+				return nil, err
+			}
+			hint := storage.HintName(id, dType)[1:]
+			if !utils.Exist(filepath.Join(db.config.DirPath, hint)) {
+				continue
+			}
+			if err := addFile(backupFile{Name: hint, DataType: dType, FileId: id, Hint: true}); err != nil {
+				return nil, err
+			}
+		}
+
+		// only the written part of active file is copied.
+		activeFile, err := db.getActiveFile(dType)
+		if err != nil {
+			return nil, err
+		}
+		f := backupFile{Name: filepath.Base(activeFile.Name(dType)), DataType: dType, FileId: activeFile.Id, Active: true, Size: activeFile.Offset}
+		if err = addFile(f); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Restore restores the backup in backupDir to targetDir, which must be empty or not exist.
+// The checksums of all files are verified before restoring, the db can be opened from targetDir after restoring.
+func Restore(backupDir, targetDir string) error {
+	manifest, err := readBackupManifest(backupDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range manifest.Files {
+		crc, size, err := fileChecksum(filepath.Join(backupDir, f.Name))
+		if err != nil {
+			return err
+		}
+		if crc != f.Crc32 || size != f.Size {
+			return fmt.Errorf("%s: %w", f.Name, ErrBackupCorrupt)
+		}
+	}
+
+	if utils.Exist(targetDir) {
+		dir, err := ioutil.ReadDir(targetDir)
+		if err != nil {
+			return err
+		}
+		if len(dir) > 0 {
+			return ErrRestoreTargetNotEmpty
+		}
+	}
+	if err = os.MkdirAll(targetDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// verify the checksums again when copying, the backup may be changed after verifying.
+	for _, f := range manifest.Files {
+		crc, err := copyFileN(filepath.Join(backupDir, f.Name), filepath.Join(targetDir, f.Name), f.Size)
+		if err != nil {
+			return err
+		}
+		if crc != f.Crc32 {
+			return fmt.Errorf("%s: %w", f.Name, ErrBackupCorrupt)
+		}
+	}
+	return utils.SyncDir(targetDir)
+}
+
+func readBackupManifest(dir string) (*backupManifest, error) {
+	b, err := ioutil.ReadFile(dir + backupManifestFile)
+	if os.IsNotExist(err) {
+		return nil, ErrBackupNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(backupManifest)
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("manifest: %w", ErrBackupCorrupt)
+	}
+	return m, nil
+}
+
+func writeBackupManifest(dir string, m *backupManifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	path := dir + backupManifestFile
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(b); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return utils.SyncDir(dir)
+}
+
+// copyFileN copies the first n bytes of src to dst atomically, and returns the crc32 of them.
+func copyFileN(src, dst string, n int64) (uint32, error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer srcFile.Close()
+
+	tmpPath := dst + ".tmp"
+	dstFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, storage.FilePerm)
+	if err != nil {
+		return 0, err
+	}
+	hash := crc32.NewIEEE()
+	if _, err = io.CopyN(io.MultiWriter(dstFile, hash), srcFile, n); err != nil {
+		dstFile.Close()
+		return 0, err
+	}
+	if err = dstFile.Sync(); err != nil {
+		dstFile.Close()
+		return 0, err
+	}
+	if err = dstFile.Close(); err != nil {
+		return 0, err
+	}
+	return hash.Sum32(), os.Rename(tmpPath, dst)
+}
+
+func fileChecksum(path string) (uint32, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	hash := crc32.NewIEEE()
+	size, err := io.Copy(hash, file)
+	return hash.Sum32(), size, err
+}
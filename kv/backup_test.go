@@ -0,0 +1,215 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// openRestored restores the backup to a new dir, and opens the db from it.
+func openRestored(t *testing.T, db *KVDB, backupDir string) *KVDB {
+	t.Helper()
+
+	target := filepath.Join(t.TempDir(), "restored")
+	if err := Restore(backupDir, target); err != nil {
+		t.Fatal(err)
+	}
+	cfg := db.config
+	cfg.DirPath = target
+	return openTestConfig(t, cfg)
+}
+
+func TestBackupRestore(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	for i := 0; i < 60; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.HSet([]byte("hash"), []byte("f"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RPush([]byte("list"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd([]byte("set"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd([]byte("zset"), 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	if err := db.Backup(backupDir); err != nil {
+		t.Fatal(err)
+	}
+	// the writes after the backup are not in it.
+	if err := db.Set([]byte("after"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set([]byte("k00"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openRestored(t, db, backupDir)
+	for i := 0; i < 60; i++ {
+		val, err := restored.Get([]byte(fmt.Sprintf("k%02d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, fmt.Sprintf("v%d", i))
+	}
+	if _, err := restored.Get([]byte("after")); err != ErrKeyNotExist {
+		t.Fatalf("Get a key written after the backup: got %v, want ErrKeyNotExist", err)
+	}
+	assertBytes(t, restored.HGet([]byte("hash"), []byte("f")), "v")
+	assertList(t, restored, "list", "a")
+	if !restored.SIsMember([]byte("set"), []byte("a")) || restored.ZCard([]byte("zset")) != 1 {
+		t.Fatal("the set or zset is not restored")
+	}
+}
+
+// The archived files not changed since the last backup are not copied again, and the reclaimed ones are removed.
+func TestBackupIncremental(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	for i := 0; i < 100; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("k%02d", i%50)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	if err := db.Backup(backupDir); err != nil {
+		t.Fatal(err)
+	}
+
+	var archived string
+	for _, df := range db.archFiles[String] {
+		archived = filepath.Join(backupDir, filepath.Base(df.Name(String)))
+		break
+	}
+	// make the copied file look old, it is unchanged unless it is copied again.
+	old := time.Unix(1, 0)
+	if err := os.Chtimes(archived, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set([]byte("k00"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Backup(backupDir); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(archived); err != nil || !info.ModTime().Equal(old) {
+		t.Fatalf("the unchanged archived file is copied again: %v", err)
+	}
+
+	// the db files removed by reclaim are removed from the backup too.
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Backup(backupDir); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readBackupManifest(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := filepath.Glob(filepath.Join(backupDir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != len(m.Files)+1 {
+		t.Fatalf("got %d files in backup dir, want %d and the manifest", len(names), len(m.Files))
+	}
+
+	restored := openRestored(t, db, backupDir)
+	val, err := restored.Get([]byte("k00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "new")
+	for i := 51; i < 100; i++ {
+		val, err := restored.Get([]byte(fmt.Sprintf("k%02d", i%50)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, fmt.Sprintf("v%d", i))
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	db := openTestDB(t, nil)
+	if err := db.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	if err := db.Backup(backupDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(t.TempDir(), filepath.Join(t.TempDir(), "target")); err != ErrBackupNotExist {
+		t.Fatalf("restore without manifest: got %v, want ErrBackupNotExist", err)
+	}
+	if err := Restore(backupDir, db.config.DirPath); err != ErrRestoreTargetNotEmpty {
+		t.Fatalf("restore to a non-empty dir: got %v, want ErrRestoreTargetNotEmpty", err)
+	}
+
+	activeFile, err := db.getActiveFile(String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, filepath.Join(backupDir, filepath.Base(activeFile.Name(String))), activeFile.DataOffset())
+	target := filepath.Join(t.TempDir(), "target")
+	if err := Restore(backupDir, target); !errors.Is(err, ErrBackupCorrupt) {
+		t.Fatalf("restore a corrupt backup: got %v, want ErrBackupCorrupt", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatal("the corrupt backup is restored partly")
+	}
+}
+
+// The backup doesn't block writes, and it has all the keys written before it starts, run it with -race.
+func TestBackupConcurrentWrites(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	for i := 0; i < 50; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("before%02d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stop, n int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for atomic.LoadInt32(&stop) == 0 {
+			i := atomic.AddInt32(&n, 1)
+			if err := db.Set([]byte(fmt.Sprintf("during%d", i)), []byte("v")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	backupDir := filepath.Join(t.TempDir(), "backup")
+	err := db.Backup(backupDir)
+	atomic.StoreInt32(&stop, 1)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openRestored(t, db, backupDir)
+	if restored.RecoveryReport().Recovered() {
+		t.Fatalf("the restored db is repaired: %+v", restored.RecoveryReport())
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := restored.Get([]byte(fmt.Sprintf("before%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
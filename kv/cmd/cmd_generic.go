@@ -0,0 +1,413 @@
+package cmd
+
+import (
+	"MetaDB/kv"
+
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/redcon"
+)
+
+// the number of keys visited by every scan of keys command.
+const keysScanCount = 1000
+
+// the number of locks serializing the writes of keys, see lockKeys.
+const keyLockNum = 256
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrUnknownType   = errors.New("unknown type")
+	ErrWrongType     = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+)
+
+// keyLocks the keys are type-checked and written under their locks, so a key can't be written as two data types.
+var keyLocks [keyLockNum]sync.Mutex
+
+// dataTypes the data types named as the type command of Redis.
+var dataTypes = map[string]kv.DataType{
+	"string": kv.String,
+	"hash":   kv.Hash,
+	"list":   kv.List,
+	"set":    kv.Set,
+	"zset":   kv.ZSet,
+}
+
+func parseDataType(name string) (kv.DataType, error) {
+	dType, ok := dataTypes[strings.ToLower(name)]
+	if !ok {
+		return 0, ErrUnknownType
+	}
+	return dType, nil
+}
+
+func typeName(dType kv.DataType) string {
+	switch dType {
+	case kv.String:
+		return "string"
+	case kv.Hash:
+		return "hash"
+	case kv.List:
+		return "list"
+	case kv.Set:
+		return "set"
+	case kv.ZSet:
+		return "zset"
+	}
+	return "none"
+}
+
+// lockKeys locks the keys in the order of their locks, and returns the func to unlock them.
+func lockKeys(keys []string) func() {
+	var ids []int
+	locked := make(map[int]bool)
+	for _, key := range keys {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		if id := int(h.Sum32() % keyLockNum); !locked[id] {
+			locked[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		keyLocks[id].Lock()
+	}
+	return func() {
+		for _, id := range ids {
+			keyLocks[id].Unlock()
+		}
+	}
+}
+
+// checkType returns ErrWrongType if any key is held by another data type.
+func checkType(db *kv.KVDB, dType kv.DataType, keys []string) error {
+	for _, key := range keys {
+		for _, t := range db.Types([]byte(key)) {
+			if t != dType {
+				return ErrWrongType
+			}
+		}
+	}
+	return nil
+}
+
+// writeCmd type-checks the keys of a write command of dType before executing it, keys picks them from the arguments.
+func writeCmd(dType kv.DataType, keys func(args []string) []string, cmdFunc ExecCmdFunc) ExecCmdFunc {
+	return func(db *kv.KVDB, args []string) (interface{}, error) {
+		writeKeys := keys(args)
+		unlock := lockKeys(writeKeys)
+		defer unlock()
+
+		if err := checkType(db, dType, writeKeys); err != nil {
+			return nil, err
+		}
+		return cmdFunc(db, args)
+	}
+}
+
+// firstKey the key of most commands is the first argument.
+func firstKey(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	return args[:1]
+}
+
+// firstTwoKeys the source and destination keys, like smove.
+func firstTwoKeys(args []string) []string {
+	if len(args) < 2 {
+		return firstKey(args)
+	}
+	return args[:2]
+}
+
+// allKeys every argument is a key, like sunionstore.
+func allKeys(args []string) []string {
+	return args
+}
+
+// pairKeys the keys are followed by their values, like mset.
+func pairKeys(args []string) []string {
+	var keys []string
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// removeKey removes the key of data type, a key may exist in several data types.
+func removeKey(db *kv.KVDB, dType kv.DataType, key []byte) error {
+	switch dType {
+	case kv.String:
+		return db.Remove(key)
+	case kv.Hash:
+		return db.HClear(key)
+	case kv.List:
+		return db.LClear(key)
+	case kv.Set:
+		return db.SClear(key)
+	case kv.ZSet:
+		return db.ZClear(key)
+	}
+	return kv.ErrInvalidDataType
+}
+
+func expireKey(db *kv.KVDB, dType kv.DataType, key []byte, seconds int64) error {
+	switch dType {
+	case kv.String:
+		return db.Expire(key, seconds)
+	case kv.Hash:
+		return db.HExpire(key, seconds)
+	case kv.List:
+		return db.LExpire(key, seconds)
+	case kv.Set:
+		return db.SExpire(key, seconds)
+	case kv.ZSet:
+		return db.ZExpire(key, seconds)
+	}
+	return kv.ErrInvalidDataType
+}
+
+func persistKey(db *kv.KVDB, dType kv.DataType, key []byte) error {
+	switch dType {
+	case kv.String:
+		return db.Persist(key)
+	case kv.Hash:
+		return db.HPersist(key)
+	case kv.List:
+		return db.LPersist(key)
+	case kv.Set:
+		return db.SPersist(key)
+	case kv.ZSet:
+		return db.ZPersist(key)
+	}
+	return kv.ErrInvalidDataType
+}
+
+// del key [key ...]
+func del(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 1 {
+		err = newWrongNumOfArgsError("del")
+		return
+	}
+	removed := 0
+	for _, arg := range args {
+		key := []byte(arg)
+		dTypes := db.Types(key)
+		for _, dType := range dTypes {
+			// the key may be removed by others at the same time.
+			if err = removeKey(db, dType, key); err != nil && err != kv.ErrKeyNotExist {
+				return
+			}
+		}
+		if len(dTypes) > 0 {
+			removed++
+		}
+	}
+	res, err = redcon.SimpleInt(removed), nil
+	return
+}
+
+// exists key [key ...]
+func exists(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 1 {
+		err = newWrongNumOfArgsError("exists")
+		return
+	}
+	count := 0
+	for _, key := range args {
+		if len(db.Types([]byte(key))) > 0 {
+			count++
+		}
+	}
+	res = redcon.SimpleInt(count)
+	return
+}
+
+// type key, the first data type is returned if the key exists in several data types.
+func keyType(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("type")
+		return
+	}
+	name := "none"
+	if dTypes := db.Types([]byte(args[0])); len(dTypes) > 0 {
+		name = typeName(dTypes[0])
+	}
+	res = redcon.SimpleString(name)
+	return
+}
+
+// expire key seconds, the key is removed if seconds is not positive.
+func expire(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("expire")
+		return
+	}
+	seconds, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	key := []byte(args[0])
+	dTypes := db.Types(key)
+	for _, dType := range dTypes {
+		if seconds <= 0 {
+			err = removeKey(db, dType, key)
+		} else {
+			err = expireKey(db, dType, key, seconds)
+		}
+		if err != nil && err != kv.ErrKeyNotExist {
+			return
+		}
+	}
+	res, err = redcon.SimpleInt(0), nil
+	if len(dTypes) > 0 {
+		res = redcon.SimpleInt(1)
+	}
+	return
+}
+
+// ttl key, it is -2 if the key does not exist, and -1 if the key has no expired time.
+func ttl(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("ttl")
+		return
+	}
+	key := []byte(args[0])
+	dTypes := db.Types(key)
+	if len(dTypes) == 0 {
+		res = redcon.SimpleInt(-2)
+		return
+	}
+	pttl := db.PTTL(dTypes[0], key)
+	if pttl >= 0 {
+		pttl = (pttl + 500) / 1000
+	}
+	res = redcon.SimpleInt(pttl)
+	return
+}
+
+// persist key, it returns 1 if the expired time of the key is removed.
+func persist(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("persist")
+		return
+	}
+	key := []byte(args[0])
+	persisted := 0
+	for _, dType := range db.Types(key) {
+		if db.PTTL(dType, key) < 0 {
+			continue
+		}
+		if err = persistKey(db, dType, key); err != nil && err != kv.ErrKeyNotExist {
+			return
+		}
+		persisted = 1
+	}
+	res, err = redcon.SimpleInt(persisted), nil
+	return
+}
+
+// keys pattern [TYPE type]
+func keys(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 && len(args) != 3 {
+		err = newWrongNumOfArgsError("keys")
+		return
+	}
+	var dTypes []kv.DataType
+	if len(args) == 3 {
+		if strings.ToLower(args[1]) != "type" {
+			err = ErrSyntaxIncorrect
+			return
+		}
+		var dType kv.DataType
+		if dType, err = parseDataType(args[2]); err != nil {
+			return
+		}
+		dTypes = append(dTypes, dType)
+	}
+
+	found := []string{}
+	var cursor uint64
+	for {
+		var batch [][]byte
+		if batch, cursor, err = db.Scan(cursor, args[0], keysScanCount, dTypes...); err != nil {
+			return
+		}
+		for _, key := range batch {
+			found = append(found, string(key))
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	res = found
+	return
+}
+
+// scan cursor [MATCH pattern] [COUNT count] [TYPE type]
+func scan(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) == 0 || len(args)%2 != 1 {
+		err = newWrongNumOfArgsError("scan")
+		return
+	}
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		err = ErrInvalidCursor
+		return
+	}
+
+	var match string
+	var count int
+	var dTypes []kv.DataType
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "match":
+			match = args[i+1]
+		case "count":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count <= 0 {
+				err = ErrSyntaxIncorrect
+				return
+			}
+		case "type":
+			var dType kv.DataType
+			if dType, err = parseDataType(args[i+1]); err != nil {
+				return
+			}
+			dTypes = append(dTypes, dType)
+		default:
+			err = ErrSyntaxIncorrect
+			return
+		}
+	}
+
+	batch, next, err := db.Scan(cursor, match, count, dTypes...)
+	if err != nil {
+		return
+	}
+	found := []string{}
+	for _, key := range batch {
+		found = append(found, string(key))
+	}
+	res = []interface{}{strconv.FormatUint(next, 10), found}
+	return
+}
+
+func init() {
+	addExecCommand("keys", keys)
+	addExecCommand("scan", scan)
+	addExecCommand("del", del)
+	addExecCommand("exists", exists)
+	addExecCommand("type", keyType)
+	addExecCommand("expire", expire)
+	addExecCommand("ttl", ttl)
+	addExecCommand("persist", persist)
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestWrongType(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "OK", "set", "s", "v")
+	assertReply(t, db, "1", "hset", "h", "f", "v")
+	assertReply(t, db, "1", "rpush", "l", "a")
+	assertReply(t, db, "1", "sadd", "set", "a")
+	assertReply(t, db, "1", "zadd", "z", "1", "a")
+
+	for _, args := range [][]string{
+		{"set", "h", "v"},
+		{"mset", "new", "v", "l", "v"},
+		{"incr", "set"},
+		{"hset", "s", "f", "v"},
+		{"hincrby", "z", "f", "1"},
+		{"lpush", "h", "a"},
+		{"rpop", "s"},
+		{"sadd", "l", "a"},
+		{"smove", "set", "z", "a"},
+		{"sunionstore", "h", "set"},
+		{"zadd", "set", "1", "a"},
+		{"zrem", "l", "a"},
+	} {
+		assertError(t, db, ErrWrongType, args...)
+	}
+	// nothing is written by the failed commands.
+	assertReply(t, db, "0", "exists", "new")
+	assertReply(t, db, "hash", "type", "h")
+	assertReply(t, db, "1", "scard", "set")
+
+	// the key of the same data type is written.
+	assertReply(t, db, "OK", "set", "s", "v2")
+	assertReply(t, db, "1", "smove", "set", "set2", "a")
+}
+
+func TestType(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "OK", "set", "s", "v")
+	assertReply(t, db, "1", "hset", "h", "f", "v")
+	assertReply(t, db, "1", "rpush", "l", "a")
+	assertReply(t, db, "1", "sadd", "set", "a")
+	assertReply(t, db, "1", "zadd", "z", "1", "a")
+	for key, want := range map[string]string{"s": "string", "h": "hash", "l": "list", "set": "set", "z": "zset",
+		"missing": "none"} {
+		assertReply(t, db, want, "type", key)
+	}
+}
+
+// A key is never written as two data types by the commands at the same time, run it with -race.
+func TestWrongTypeConcurrently(t *testing.T) {
+	db := openTestDB(t)
+
+	var wg sync.WaitGroup
+	for _, args := range [][]string{{"set", "k", "v"}, {"hset", "k", "f", "v"}, {"sadd", "k", "a"}} {
+		wg.Add(1)
+		go func(args []string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, err := ExecCmd[args[0]](db, args[1:]); err != nil && err != ErrWrongType {
+					t.Error(err)
+					return
+				}
+				if _, err := ExecCmd["del"](db, []string{"k"}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(args)
+	}
+	wg.Wait()
+	if dTypes := db.Types([]byte("k")); len(dTypes) > 1 {
+		t.Fatalf("the key is held by the data types %v", dTypes)
+	}
+}
+
+func TestGenericCommands(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "OK", "set", "s", "v")
+	assertReply(t, db, "1", "hset", "h", "f", "v")
+	assertReply(t, db, "1", "rpush", "l", "a")
+	assertReply(t, db, "2", "exists", "s", "h", "missing")
+
+	assertReply(t, db, "-1", "ttl", "s")
+	assertReply(t, db, "-2", "ttl", "missing")
+	assertReply(t, db, "1", "expire", "h", "100")
+	assertReply(t, db, "100", "ttl", "h")
+	assertReply(t, db, "0", "expire", "missing", "100")
+	assertReply(t, db, "1", "persist", "h")
+	assertReply(t, db, "0", "persist", "h")
+	assertReply(t, db, "-1", "ttl", "h")
+	// the key is removed if the expired time is not positive.
+	assertReply(t, db, "1", "expire", "l", "0")
+	assertReply(t, db, "none", "type", "l")
+
+	assertReply(t, db, "[h s]", "keys", "*")
+	assertReply(t, db, "[h]", "keys", "h*")
+	assertReply(t, db, "[s]", "keys", "*", "TYPE", "string")
+	assertReply(t, db, "1", "del", "s", "missing")
+	assertReply(t, db, "0", "exists", "s")
+	assertReply(t, db, "[h]", "keys", "*")
+
+	assertError(t, db, newWrongNumOfArgsError("del"), "del")
+	assertError(t, db, newWrongNumOfArgsError("type"), "type", "h", "s")
+	assertError(t, db, ErrSyntaxIncorrect, "expire", "h", "x")
+	assertError(t, db, ErrSyntaxIncorrect, "keys", "*", "kind", "hash")
+	assertError(t, db, ErrUnknownType, "keys", "*", "type", "stream")
+}
+
+func TestScanCommand(t *testing.T) {
+	db := openTestDB(t)
+
+	var want []string
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		assertReply(t, db, "OK", "set", key, "v")
+		want = append(want, key)
+	}
+	assertReply(t, db, "1", "sadd", "set", "a")
+
+	var got []string
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls > 100 {
+			t.Fatal("the scan does not end")
+		}
+		res, err := ExecCmd["scan"](db, []string{cursor, "MATCH", "k*", "COUNT", "7", "TYPE", "string"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := res.([]interface{})
+		got = append(got, reply[1].([]string)...)
+		if cursor = reply[0].(string); cursor == "0" {
+			break
+		}
+		if _, err := strconv.ParseUint(cursor, 10, 64); err != nil {
+			t.Fatalf("invalid cursor %q", cursor)
+		}
+	}
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got the keys %q, want %q", got, want)
+	}
+
+	assertReply(t, db, "[0 [set]]", "scan", "0", "type", "set")
+	assertError(t, db, newWrongNumOfArgsError("scan"), "scan", "0", "match")
+	assertError(t, db, ErrInvalidCursor, "scan", "x")
+	assertError(t, db, ErrSyntaxIncorrect, "scan", "0", "count", "0")
+	assertError(t, db, ErrSyntaxIncorrect, "scan", "0", "limit", "1")
+	assertError(t, db, ErrUnknownType, "scan", "0", "type", "stream")
+}
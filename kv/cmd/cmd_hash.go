@@ -5,23 +5,39 @@ import (
 
 	"fmt"
 	"errors"
-	
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/tidwall/redcon"
 )
 
-var ErrSyntaxIncorrect = errors.New("syntax err")
+var (
+	ErrSyntaxIncorrect = errors.New("syntax error")
+	ErrNumFields       = errors.New("numfields must be positive and match the number of fields")
+	ErrInvalidExpire   = errors.New("invalid expire time")
+	ErrNotInteger      = errors.New("value is not an integer or out of range")
+	ErrNotFloat        = errors.New("value is not a valid float")
+	ErrHashNotInteger  = errors.New("hash value is not an integer")
+	ErrHashNotFloat    = errors.New("hash value is not a float")
+)
 
 func newWrongNumOfArgsError(cmd string) error {
 	return fmt.Errorf("wrong number of arguments for '%s' command", cmd)
 }
 
 func hSet(db *kv.KVDB, args []string) (res interface{}, err error) {
-	if len(args) != 3 {
+	if len(args) < 3 || len(args)%2 != 1 {
 		err = newWrongNumOfArgsError("hset")
 		return
 	}
+	var values [][]byte
+	for _, v := range args[1:] {
+		values = append(values, []byte(v))
+	}
 	var count int
-	if count, err = db.HSet([]byte(args[0]), []byte(args[1]), []byte(args[2])); err == nil {
+	if count, err = db.HSetPairs([]byte(args[0]), values...); err == nil {
 		res = redcon.SimpleInt(count)
 	}
 	return
@@ -45,11 +61,12 @@ func hSetNx(db *kv.KVDB, args []string) (res interface{}, err error) {
 
 func hGet(db *kv.KVDB, args []string) (res interface{}, err error) {
 	if len(args) != 2 {
-		err = ErrSyntaxIncorrect
+		err = newWrongNumOfArgsError("hget")
 		return
 	}
+	// a missing field is nil, and an empty value is replied as it is.
 	val := db.HGet([]byte(args[0]), []byte(args[1]))
-	if len(val) == 0 {
+	if val == nil {
 		res = nil
 	} else {
 		res = string(val)
@@ -88,7 +105,11 @@ func hExists(db *kv.KVDB, args []string) (res interface{}, err error) {
 		err = newWrongNumOfArgsError("hexists")
 		return
 	}
-	exists := db.HExists([]byte(args[0]), []byte(args[1]))
+	// HExists returns 0 if the field exists, but the reply is 1 like Redis.
+	exists := 0
+	if db.HExists([]byte(args[0]), []byte(args[1])) == 0 {
+		exists = 1
+	}
 	res = redcon.SimpleInt(exists)
 	return
 }
@@ -105,7 +126,7 @@ func hLen(db *kv.KVDB, args []string) (res interface{}, err error) {
 
 func hKeys(db *kv.KVDB, args []string) (res interface{}, err error) {
 	if len(args) != 1 {
-		err = ErrSyntaxIncorrect
+		err = newWrongNumOfArgsError("hkeys")
 		return
 	}
 	res = db.HKeys([]byte(args[0]))
@@ -121,14 +142,380 @@ func hVals(db *kv.KVDB, args []string) (res interface{}, err error) {
 	return
 }
 
+func hIncrBy(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 3 {
+		err = newWrongNumOfArgsError("hincrby")
+		return
+	}
+	incr, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		err = ErrNotInteger
+		return
+	}
+	var val int64
+	switch val, err = db.HIncrBy([]byte(args[0]), []byte(args[1]), incr); err {
+	case nil:
+		res = val
+	case kv.ErrWrongValueType:
+		err = ErrHashNotInteger
+	}
+	return
+}
+
+func hIncrByFloat(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 3 {
+		err = newWrongNumOfArgsError("hincrbyfloat")
+		return
+	}
+	incr, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || math.IsNaN(incr) || math.IsInf(incr, 0) {
+		err = ErrNotFloat
+		return
+	}
+	var val float64
+	switch val, err = db.HIncrByFloat([]byte(args[0]), []byte(args[1]), incr); err {
+	case nil:
+		res = formatFloat(val)
+	case kv.ErrWrongFloatType:
+		err = ErrHashNotFloat
+	}
+	return
+}
+
+// hscan key cursor [MATCH pattern] [COUNT count]
+func hScan(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		err = newWrongNumOfArgsError("hscan")
+		return
+	}
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		err = ErrInvalidCursor
+		return
+	}
+
+	var match string
+	var count int
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "match":
+			match = args[i+1]
+		case "count":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count <= 0 {
+				err = ErrSyntaxIncorrect
+				return
+			}
+		default:
+			err = ErrSyntaxIncorrect
+			return
+		}
+	}
+
+	pairs, next, err := db.HScan([]byte(args[0]), cursor, match, count)
+	if err != nil {
+		return
+	}
+	found := []string{}
+	for _, p := range pairs {
+		found = append(found, string(p))
+	}
+	res = []interface{}{strconv.FormatUint(next, 10), found}
+	return
+}
+
+func hMSet(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		err = newWrongNumOfArgsError("hmset")
+		return
+	}
+	var values [][]byte
+	for _, v := range args[1:] {
+		values = append(values, []byte(v))
+	}
+	if err = db.HMSet([]byte(args[0]), values...); err == nil {
+		res = redcon.SimpleString("OK")
+	}
+	return
+}
+
+func hMGet(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 2 {
+		err = newWrongNumOfArgsError("hmget")
+		return
+	}
+	var fields [][]byte
+	for _, f := range args[1:] {
+		fields = append(fields, []byte(f))
+	}
+	res = bulkOrNil(db.HMGet([]byte(args[0]), fields...))
+	return
+}
+
+func hStrLen(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("hstrlen")
+		return
+	}
+	res = redcon.SimpleInt(db.HStrLen([]byte(args[0]), []byte(args[1])))
+	return
+}
+
+// hrandfield key [count [WITHVALUES]]
+func hRandField(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 1 || len(args) > 3 {
+		err = newWrongNumOfArgsError("hrandfield")
+		return
+	}
+	if len(args) == 1 {
+		if fields := db.HRandField([]byte(args[0]), 1, false); len(fields) > 0 {
+			res = string(fields[0])
+		}
+		return
+	}
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		err = ErrNotInteger
+		return
+	}
+	withValues := false
+	if len(args) == 3 {
+		if strings.ToLower(args[2]) != "withvalues" {
+			err = ErrSyntaxIncorrect
+			return
+		}
+		withValues = true
+	}
+	found := []string{}
+	for _, v := range db.HRandField([]byte(args[0]), count, withValues) {
+		found = append(found, string(v))
+	}
+	res = found
+	return
+}
+
+// hgetdel key FIELDS numfields field [field ...]
+func hGetDel(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 4 {
+		err = newWrongNumOfArgsError("hgetdel")
+		return
+	}
+	fields, err := parseFields(args[1:])
+	if err != nil {
+		return
+	}
+	var values [][]byte
+	if values, err = db.HGetDel([]byte(args[0]), fields...); err == nil {
+		res = bulkOrNil(values)
+	}
+	return
+}
+
+// hgetex key [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]
+// FIELDS numfields field [field ...]
+func hGetEx(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 4 {
+		err = newWrongNumOfArgsError("hgetex")
+		return
+	}
+
+	var deadline int64
+	i := 1
+	switch opt := strings.ToLower(args[i]); opt {
+	case "persist":
+		deadline = -1
+		i++
+	case "ex", "px", "exat", "pxat":
+		if i+1 >= len(args) {
+			err = ErrSyntaxIncorrect
+			return
+		}
+		if deadline, err = parseDeadline(opt, args[i+1]); err != nil {
+			return
+		}
+		i += 2
+	}
+	fields, err := parseFields(args[i:])
+	if err != nil {
+		return
+	}
+
+	var values [][]byte
+	if values, err = db.HGetEx([]byte(args[0]), deadline, fields...); err == nil {
+		res = bulkOrNil(values)
+	}
+	return
+}
+
+func hExpire(db *kv.KVDB, args []string) (res interface{}, err error) {
+	return hExpireFields(db, args, "hexpire", "ex")
+}
+
+func hPExpire(db *kv.KVDB, args []string) (res interface{}, err error) {
+	return hExpireFields(db, args, "hpexpire", "px")
+}
+
+func hExpireAt(db *kv.KVDB, args []string) (res interface{}, err error) {
+	return hExpireFields(db, args, "hexpireat", "exat")
+}
+
+func hPExpireAt(db *kv.KVDB, args []string) (res interface{}, err error) {
+	return hExpireFields(db, args, "hpexpireat", "pxat")
+}
+
+// hExpireFields sets the expired time of fields: cmd key time [NX | XX | GT | LT] FIELDS numfields field [field ...]
+// The reply of every field is -2 if it does not exist, 0 if the condition is not met,
+// 1 if the expired time is set, and 2 if the field is removed since the time is passed.
+func hExpireFields(db *kv.KVDB, args []string, cmd, unit string) (res interface{}, err error) {
+	if len(args) < 5 {
+		err = newWrongNumOfArgsError(cmd)
+		return
+	}
+	deadline, err := parseDeadline(unit, args[1])
+	if err != nil {
+		return
+	}
+	cond, i := kv.FieldExpireAlways, 2
+	switch strings.ToLower(args[i]) {
+	case "nx":
+		cond = kv.FieldExpireNX
+	case "xx":
+		cond = kv.FieldExpireXX
+	case "gt":
+		cond = kv.FieldExpireGT
+	case "lt":
+		cond = kv.FieldExpireLT
+	}
+	if cond != kv.FieldExpireAlways {
+		i++
+	}
+	fields, err := parseFields(args[i:])
+	if err != nil {
+		return
+	}
+	res, err = db.HFieldsPExpireAt([]byte(args[0]), deadline, cond, fields...)
+	return
+}
+
+func hTTL(db *kv.KVDB, args []string) (res interface{}, err error) {
+	return hTTLFields(db, args, "httl", time.Second)
+}
+
+func hPTTL(db *kv.KVDB, args []string) (res interface{}, err error) {
+	return hTTLFields(db, args, "hpttl", time.Millisecond)
+}
+
+// hTTLFields returns the time to live of fields: cmd key FIELDS numfields field [field ...]
+// The reply of every field is -2 if it does not exist, and -1 if it has no expired time.
+func hTTLFields(db *kv.KVDB, args []string, cmd string, unit time.Duration) (res interface{}, err error) {
+	if len(args) < 4 {
+		err = newWrongNumOfArgsError(cmd)
+		return
+	}
+	fields, err := parseFields(args[1:])
+	if err != nil {
+		return
+	}
+
+	replies := db.HFieldsPTTL([]byte(args[0]), fields...)
+	ms := int64(unit / time.Millisecond)
+	for i, pttl := range replies {
+		if pttl > 0 {
+			replies[i] = (pttl + ms/2) / ms
+		}
+	}
+	res = replies
+	return
+}
+
+// hpersist key FIELDS numfields field [field ...]
+// The reply of every field is -2 if it does not exist, -1 if it has no expired time, and 1 if the expired time is removed.
+func hPersist(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 4 {
+		err = newWrongNumOfArgsError("hpersist")
+		return
+	}
+	fields, err := parseFields(args[1:])
+	if err != nil {
+		return
+	}
+
+	res, err = db.HFieldsPersist([]byte(args[0]), fields...)
+	return
+}
+
+// parseFields parses the fields in the form of: FIELDS numfields field [field ...]
+func parseFields(args []string) ([][]byte, error) {
+	if len(args) < 3 || strings.ToLower(args[0]) != "fields" {
+		return nil, ErrSyntaxIncorrect
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n <= 0 || n != len(args)-2 {
+		return nil, ErrNumFields
+	}
+	var fields [][]byte
+	for _, f := range args[2:] {
+		fields = append(fields, []byte(f))
+	}
+	return fields, nil
+}
+
+// parseDeadline returns the unix time in milliseconds of the expired time in the unit of ex, px, exat or pxat.
+// The expired time must be positive like Redis.
+func parseDeadline(unit, arg string) (int64, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, ErrInvalidExpire
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	switch unit {
+	case "ex":
+		return now + n*1000, nil
+	case "px":
+		return now + n, nil
+	case "exat":
+		return n * 1000, nil
+	}
+	return n, nil
+}
+
+// bulkOrNil converts the values to a reply, in which the nil values are null bulk strings.
+func bulkOrNil(values [][]byte) []interface{} {
+	res := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		if v == nil {
+			res = append(res, nil)
+		} else {
+			res = append(res, string(v))
+		}
+	}
+	return res
+}
+
 func init() {
-	addExecCommand("hset", hSet)
-	addExecCommand("hsetnx", hSetNx)
+	addWriteCommand("hset", kv.Hash, firstKey, hSet)
+	addWriteCommand("hsetnx", kv.Hash, firstKey, hSetNx)
 	addExecCommand("hget", hGet)
 	addExecCommand("hgetall", hGetAll)
-	addExecCommand("hdel", hDel)
+	addWriteCommand("hdel", kv.Hash, firstKey, hDel)
 	addExecCommand("hexists", hExists)
 	addExecCommand("hlen", hLen)
 	addExecCommand("hkeys", hKeys)
 	addExecCommand("hvals", hVals)
+	addWriteCommand("hincrby", kv.Hash, firstKey, hIncrBy)
+	addWriteCommand("hincrbyfloat", kv.Hash, firstKey, hIncrByFloat)
+	addExecCommand("hscan", hScan)
+	addWriteCommand("hmset", kv.Hash, firstKey, hMSet)
+	addExecCommand("hmget", hMGet)
+	addExecCommand("hstrlen", hStrLen)
+	addExecCommand("hrandfield", hRandField)
+	addWriteCommand("hgetdel", kv.Hash, firstKey, hGetDel)
+	addWriteCommand("hgetex", kv.Hash, firstKey, hGetEx)
+	addWriteCommand("hexpire", kv.Hash, firstKey, hExpire)
+	addWriteCommand("hpexpire", kv.Hash, firstKey, hPExpire)
+	addWriteCommand("hexpireat", kv.Hash, firstKey, hExpireAt)
+	addWriteCommand("hpexpireat", kv.Hash, firstKey, hPExpireAt)
+	addExecCommand("httl", hTTL)
+	addExecCommand("hpttl", hPTTL)
+	addWriteCommand("hpersist", kv.Hash, firstKey, hPersist)
 }
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"MetaDB/kv"
+
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *kv.KVDB {
+	t.Helper()
+
+	cfg := kv.DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	db, err := kv.Open(cfg)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("close db: %v", err)
+		}
+	})
+	return db
+}
+
+// exec runs the command like the server, and returns its reply formatted by formatReply.
+func exec(t *testing.T, db *kv.KVDB, args ...string) (string, error) {
+	t.Helper()
+
+	fn, ok := ExecCmd[args[0]]
+	if !ok {
+		t.Fatalf("unknown command %q", args[0])
+	}
+	res, err := fn(db, args[1:])
+	return formatReply(res), err
+}
+
+// formatReply formats the reply by fmt, except that the bytes are strings and nil is (nil) like redis-cli.
+func formatReply(res interface{}) string {
+	var items []string
+	switch v := res.(type) {
+	case nil:
+		return "(nil)"
+	case []byte:
+		return string(v)
+	case [][]byte:
+		for _, item := range v {
+			items = append(items, formatReply(item))
+		}
+	case []interface{}:
+		for _, item := range v {
+			items = append(items, formatReply(item))
+		}
+	default:
+		return fmt.Sprint(res)
+	}
+	return "[" + strings.Join(items, " ") + "]"
+}
+
+func assertReply(t *testing.T, db *kv.KVDB, want string, args ...string) {
+	t.Helper()
+
+	got, err := exec(t, db, args...)
+	if err != nil {
+		t.Fatalf("%q: %v", args, err)
+	}
+	if got != want {
+		t.Fatalf("%q: got %s, want %s", args, got, want)
+	}
+}
+
+func assertError(t *testing.T, db *kv.KVDB, want error, args ...string) {
+	t.Helper()
+
+	if _, err := exec(t, db, args...); err == nil || err.Error() != want.Error() {
+		t.Fatalf("%q: got the error %v, want %v", args, err, want)
+	}
+}
+
+func TestHIncrBy(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "5", "hincrby", "h", "n", "5")
+	assertReply(t, db, "-1", "hincrby", "h", "n", "-6")
+	assertReply(t, db, "1.5", "hincrbyfloat", "h", "x", "1.5")
+	assertReply(t, db, "1", "hincrbyfloat", "h", "x", "-0.5")
+	assertReply(t, db, "-0.5", "hincrbyfloat", "h", "n", "0.5")
+
+	assertError(t, db, newWrongNumOfArgsError("hincrby"), "hincrby", "h", "n")
+	assertError(t, db, newWrongNumOfArgsError("hincrbyfloat"), "hincrbyfloat", "h", "x", "1", "2")
+	assertError(t, db, ErrNotInteger, "hincrby", "h", "n", "1.5")
+	assertError(t, db, ErrNotFloat, "hincrbyfloat", "h", "x", "nan")
+	assertError(t, db, ErrHashNotInteger, "hincrby", "h", "n", "1")
+	assertReply(t, db, "OK", "hmset", "h", "s", "abc")
+	assertError(t, db, ErrHashNotFloat, "hincrbyfloat", "h", "s", "1")
+	if _, err := exec(t, db, "hincrbyfloat", "h", "y", "1.7976931348623157e308"); err != nil {
+		t.Fatal(err)
+	}
+	assertError(t, db, kv.ErrFloatOverflow, "hincrbyfloat", "h", "y", "1.7976931348623157e308")
+}
+
+func TestHExpire(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "OK", "hmset", "h", "a", "1", "b", "2")
+	assertReply(t, db, "[1 -2]", "hexpire", "h", "100", "nx", "fields", "2", "a", "missing")
+	assertReply(t, db, "[0 -2]", "hexpire", "h", "200", "NX", "FIELDS", "2", "a", "missing")
+	// a field without expired time lives forever.
+	assertReply(t, db, "[1 0]", "hpexpire", "h", "200000", "gt", "fields", "2", "a", "b")
+	assertReply(t, db, "[1 1]", "hpexpire", "h", "50000", "lt", "fields", "2", "a", "b")
+	assertReply(t, db, "[0]", "hexpire", "h", "60", "lt", "fields", "1", "a")
+	assertReply(t, db, "[50 50 -2]", "httl", "h", "fields", "3", "a", "b", "missing")
+	assertReply(t, db, "[1 -2]", "hpersist", "h", "fields", "2", "a", "missing")
+	assertReply(t, db, "[-1 -1]", "hpersist", "h", "fields", "2", "a", "a")
+	assertReply(t, db, "[-1]", "hpttl", "h", "fields", "1", "a")
+	assertReply(t, db, "[2]", "hexpireat", "h", "1", "fields", "1", "b")
+	assertReply(t, db, "1", "hlen", "h")
+
+	assertError(t, db, newWrongNumOfArgsError("hexpire"), "hexpire", "h", "100", "fields", "1")
+	assertError(t, db, ErrNumFields, "hexpire", "h", "100", "fields", "2", "a")
+	assertError(t, db, ErrSyntaxIncorrect, "httl", "h", "field", "1", "a")
+	assertError(t, db, ErrInvalidExpire, "hexpire", "h", "-1", "fields", "1", "a")
+	assertError(t, db, ErrInvalidExpire, "hexpire", "h", "0", "fields", "1", "a")
+	assertError(t, db, ErrInvalidExpire, "hpexpireat", "h", "0", "fields", "1", "a")
+	assertError(t, db, ErrInvalidExpire, "hgetex", "h", "ex", "0", "fields", "1", "a")
+	assertError(t, db, ErrInvalidExpire, "hgetex", "h", "pxat", "0", "fields", "1", "a")
+}
+
+func TestHExists(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "OK", "hmset", "h", "a", "1")
+	assertReply(t, db, "1", "hexists", "h", "a")
+	assertReply(t, db, "0", "hexists", "h", "missing")
+	assertReply(t, db, "0", "hexists", "missing", "a")
+	assertReply(t, db, "[2]", "hpexpireat", "h", "1", "fields", "1", "a")
+	assertReply(t, db, "0", "hexists", "h", "a")
+}
+
+func TestHSet(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "2", "hset", "h", "a", "1", "b", "2")
+	assertReply(t, db, "1", "hset", "h", "a", "3", "c", "4")
+	assertReply(t, db, "0", "hset", "h", "a", "3")
+	assertReply(t, db, "3", "hlen", "h")
+	assertError(t, db, newWrongNumOfArgsError("hset"), "hset", "h", "a")
+	assertError(t, db, newWrongNumOfArgsError("hset"), "hset", "h", "a", "1", "b")
+}
+
+func TestHGet(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "1", "hset", "h", "empty", "")
+	if res, err := ExecCmd["hget"](db, []string{"h", "empty"}); err != nil || res != "" {
+		t.Fatalf("hget an empty value: got %#v, %v", res, err)
+	}
+	if res, err := ExecCmd["hget"](db, []string{"h", "missing"}); err != nil || res != nil {
+		t.Fatalf("hget a missing field: got %#v, %v, want nil", res, err)
+	}
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"MetaDB/kv"
+	"MetaDB/kv/ds/list"
+
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+)
+
+var ErrIndexOutOfRange = errors.New("index out of range")
+
+func lPush(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 2 {
+		err = newWrongNumOfArgsError("lpush")
+		return
+	}
+	var values [][]byte
+	for _, v := range args[1:] {
+		values = append(values, []byte(v))
+	}
+	var length int
+	if length, err = db.LPush([]byte(args[0]), values...); err == nil {
+		res = redcon.SimpleInt(length)
+	}
+	return
+}
+
+func rPush(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 2 {
+		err = newWrongNumOfArgsError("rpush")
+		return
+	}
+	var values [][]byte
+	for _, v := range args[1:] {
+		values = append(values, []byte(v))
+	}
+	var length int
+	if length, err = db.RPush([]byte(args[0]), values...); err == nil {
+		res = redcon.SimpleInt(length)
+	}
+	return
+}
+
+func lPop(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("lpop")
+		return
+	}
+	var val []byte
+	if val, err = db.LPop([]byte(args[0])); err == kv.ErrKeyExpired {
+		return nil, nil
+	}
+	if err == nil && val != nil {
+		res = string(val)
+	}
+	return
+}
+
+func rPop(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("rpop")
+		return
+	}
+	var val []byte
+	if val, err = db.RPop([]byte(args[0])); err == kv.ErrKeyExpired {
+		return nil, nil
+	}
+	if err == nil && val != nil {
+		res = string(val)
+	}
+	return
+}
+
+func lIndex(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("lindex")
+		return
+	}
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	if val := db.LIndex([]byte(args[0]), index); val != nil {
+		res = string(val)
+	}
+	return
+}
+
+func lSet(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 3 {
+		err = newWrongNumOfArgsError("lset")
+		return
+	}
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	var ok bool
+	if ok, err = db.LSet([]byte(args[0]), index, []byte(args[2])); err == nil {
+		if ok {
+			res = redcon.SimpleString("OK")
+		} else {
+			err = ErrIndexOutOfRange
+		}
+	}
+	return
+}
+
+func lRange(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 3 {
+		err = newWrongNumOfArgsError("lrange")
+		return
+	}
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	end, err := strconv.Atoi(args[2])
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	var values [][]byte
+	if values, err = db.LRange([]byte(args[0]), start, end); err == kv.ErrKeyExpired {
+		return [][]byte{}, nil
+	}
+	if err == nil {
+		if values == nil {
+			values = [][]byte{}
+		}
+		res = values
+	}
+	return
+}
+
+func lTrim(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 3 {
+		err = newWrongNumOfArgsError("ltrim")
+		return
+	}
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	end, err := strconv.Atoi(args[2])
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	if err = db.LTrim([]byte(args[0]), start, end); err == nil || err == kv.ErrKeyExpired {
+		res, err = redcon.SimpleString("OK"), nil
+	}
+	return
+}
+
+func lRem(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 3 {
+		err = newWrongNumOfArgsError("lrem")
+		return
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	var removed int
+	if removed, err = db.LRem([]byte(args[0]), []byte(args[2]), count); err == nil {
+		res = redcon.SimpleInt(removed)
+	}
+	return
+}
+
+func lInsert(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 4 {
+		err = newWrongNumOfArgsError("linsert")
+		return
+	}
+	var option list.InsertOption
+	switch strings.ToLower(args[1]) {
+	case "before":
+		option = list.Before
+	case "after":
+		option = list.After
+	default:
+		err = ErrSyntaxIncorrect
+		return
+	}
+	var count int
+	if count, err = db.LInsert([]byte(args[0]), option, []byte(args[2]), []byte(args[3])); err == nil {
+		res = redcon.SimpleInt(count)
+	}
+	return
+}
+
+func lLen(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("llen")
+		return
+	}
+	length := db.LLen([]byte(args[0]))
+	res = redcon.SimpleInt(length)
+	return
+}
+
+func init() {
+	addWriteCommand("lpush", kv.List, firstKey, lPush)
+	addWriteCommand("rpush", kv.List, firstKey, rPush)
+	addWriteCommand("lpop", kv.List, firstKey, lPop)
+	addWriteCommand("rpop", kv.List, firstKey, rPop)
+	addExecCommand("lindex", lIndex)
+	addWriteCommand("lset", kv.List, firstKey, lSet)
+	addExecCommand("lrange", lRange)
+	addWriteCommand("ltrim", kv.List, firstKey, lTrim)
+	addWriteCommand("lrem", kv.List, firstKey, lRem)
+	addWriteCommand("linsert", kv.List, firstKey, lInsert)
+	addExecCommand("llen", lLen)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestListCommands(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "2", "rpush", "l", "b", "c")
+	assertReply(t, db, "3", "lpush", "l", "a")
+	assertReply(t, db, "[a b c]", "lrange", "l", "0", "-1")
+	assertReply(t, db, "[]", "lrange", "missing", "0", "-1")
+	assertReply(t, db, "3", "llen", "l")
+	assertReply(t, db, "b", "lindex", "l", "1")
+	assertReply(t, db, "(nil)", "lindex", "l", "10")
+	assertReply(t, db, "OK", "lset", "l", "1", "x")
+	assertReply(t, db, "4", "linsert", "l", "before", "x", "b")
+	assertReply(t, db, "5", "linsert", "l", "AFTER", "c", "x")
+	assertReply(t, db, "[a b x c x]", "lrange", "l", "0", "-1")
+	assertReply(t, db, "2", "lrem", "l", "0", "x")
+	assertReply(t, db, "OK", "ltrim", "l", "0", "1")
+	assertReply(t, db, "[a b]", "lrange", "l", "0", "-1")
+	assertReply(t, db, "a", "lpop", "l")
+	assertReply(t, db, "b", "rpop", "l")
+	assertReply(t, db, "(nil)", "rpop", "l")
+	assertReply(t, db, "0", "llen", "l")
+
+	assertError(t, db, newWrongNumOfArgsError("lpush"), "lpush", "l")
+	assertError(t, db, newWrongNumOfArgsError("lrange"), "lrange", "l", "0")
+	assertError(t, db, ErrSyntaxIncorrect, "lindex", "l", "x")
+	assertError(t, db, ErrSyntaxIncorrect, "linsert", "l", "middle", "a", "b")
+	assertReply(t, db, "1", "rpush", "l", "a")
+	assertError(t, db, ErrIndexOutOfRange, "lset", "l", "5", "x")
+}
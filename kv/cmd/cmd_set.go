@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"MetaDB/kv"
+
+	"strconv"
+
+	"github.com/tidwall/redcon"
+)
+
+func toBytesSlice(args []string) [][]byte {
+	var res [][]byte
+	for _, arg := range args {
+		res = append(res, []byte(arg))
+	}
+	return res
+}
+
+func sAdd(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 2 {
+		err = newWrongNumOfArgsError("sadd")
+		return
+	}
+	var count int
+	if count, err = db.SAdd([]byte(args[0]), toBytesSlice(args[1:])...); err == nil {
+		res = redcon.SimpleInt(count)
+	}
+	return
+}
+
+func sPop(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 && len(args) != 2 {
+		err = newWrongNumOfArgsError("spop")
+		return
+	}
+	count := 1
+	if len(args) == 2 {
+		if count, err = strconv.Atoi(args[1]); err != nil || count < 0 {
+			err = ErrSyntaxIncorrect
+			return
+		}
+	}
+
+	var values [][]byte
+	if values, err = db.SPop([]byte(args[0]), count); err == kv.ErrKeyExpired {
+		values, err = nil, nil
+	}
+	if err != nil {
+		return
+	}
+	// without the count argument, the reply is a single member.
+	if len(args) == 1 {
+		if len(values) > 0 {
+			res = string(values[0])
+		}
+		return
+	}
+	if values == nil {
+		values = [][]byte{}
+	}
+	res = values
+	return
+}
+
+func sIsMember(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("sismember")
+		return
+	}
+	if ok := db.SIsMember([]byte(args[0]), []byte(args[1])); ok {
+		res = redcon.SimpleInt(1)
+	} else {
+		res = redcon.SimpleInt(0)
+	}
+	return
+}
+
+func sRandMember(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 && len(args) != 2 {
+		err = newWrongNumOfArgsError("srandmember")
+		return
+	}
+	count := 1
+	if len(args) == 2 {
+		if count, err = strconv.Atoi(args[1]); err != nil {
+			err = ErrSyntaxIncorrect
+			return
+		}
+	}
+
+	values := db.SRandMember([]byte(args[0]), count)
+	// without the count argument, the reply is a single member.
+	if len(args) == 1 {
+		if len(values) > 0 {
+			res = string(values[0])
+		}
+		return
+	}
+	if values == nil {
+		values = [][]byte{}
+	}
+	res = values
+	return
+}
+
+func sRem(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 2 {
+		err = newWrongNumOfArgsError("srem")
+		return
+	}
+	var count int
+	if count, err = db.SRem([]byte(args[0]), toBytesSlice(args[1:])...); err == nil {
+		res = redcon.SimpleInt(count)
+	}
+	return
+}
+
+func sMove(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 3 {
+		err = newWrongNumOfArgsError("smove")
+		return
+	}
+	var ok bool
+	if ok, err = db.SMove([]byte(args[0]), []byte(args[1]), []byte(args[2])); err == nil {
+		if ok {
+			res = redcon.SimpleInt(1)
+		} else {
+			res = redcon.SimpleInt(0)
+		}
+	}
+	return
+}
+
+func sCard(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("scard")
+		return
+	}
+	card := db.SCard([]byte(args[0]))
+	res = redcon.SimpleInt(card)
+	return
+}
+
+func sMembers(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("smembers")
+		return
+	}
+	values := db.SMembers([]byte(args[0]))
+	if values == nil {
+		values = [][]byte{}
+	}
+	res = values
+	return
+}
+
+func sUnion(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) == 0 {
+		err = newWrongNumOfArgsError("sunion")
+		return
+	}
+	values := db.SUnion(toBytesSlice(args)...)
+	if values == nil {
+		values = [][]byte{}
+	}
+	res = values
+	return
+}
+
+func sDiff(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) == 0 {
+		err = newWrongNumOfArgsError("sdiff")
+		return
+	}
+	values := db.SDiff(toBytesSlice(args)...)
+	if values == nil {
+		values = [][]byte{}
+	}
+	res = values
+	return
+}
+
+func sInter(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) == 0 {
+		err = newWrongNumOfArgsError("sinter")
+		return
+	}
+	values := db.SInter(toBytesSlice(args)...)
+	if values == nil {
+		values = [][]byte{}
+	}
+	res = values
+	return
+}
+
+func sUnionStore(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 2 {
+		err = newWrongNumOfArgsError("sunionstore")
+		return
+	}
+	var count int
+	if count, err = db.SUnionStore([]byte(args[0]), toBytesSlice(args[1:])...); err == nil {
+		res = redcon.SimpleInt(count)
+	}
+	return
+}
+
+func sDiffStore(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 2 {
+		err = newWrongNumOfArgsError("sdiffstore")
+		return
+	}
+	var count int
+	if count, err = db.SDiffStore([]byte(args[0]), toBytesSlice(args[1:])...); err == nil {
+		res = redcon.SimpleInt(count)
+	}
+	return
+}
+
+func sInterStore(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 2 {
+		err = newWrongNumOfArgsError("sinterstore")
+		return
+	}
+	var count int
+	if count, err = db.SInterStore([]byte(args[0]), toBytesSlice(args[1:])...); err == nil {
+		res = redcon.SimpleInt(count)
+	}
+	return
+}
+
+func init() {
+	addWriteCommand("sadd", kv.Set, firstKey, sAdd)
+	addWriteCommand("spop", kv.Set, firstKey, sPop)
+	addExecCommand("sismember", sIsMember)
+	addExecCommand("srandmember", sRandMember)
+	addWriteCommand("srem", kv.Set, firstKey, sRem)
+	addWriteCommand("smove", kv.Set, firstTwoKeys, sMove)
+	addExecCommand("scard", sCard)
+	addExecCommand("smembers", sMembers)
+	addExecCommand("sunion", sUnion)
+	addExecCommand("sdiff", sDiff)
+	addExecCommand("sinter", sInter)
+	addWriteCommand("sunionstore", kv.Set, allKeys, sUnionStore)
+	addWriteCommand("sdiffstore", kv.Set, allKeys, sDiffStore)
+	addWriteCommand("sinterstore", kv.Set, allKeys, sInterStore)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "2", "sadd", "s", "a", "b")
+	assertReply(t, db, "1", "sadd", "s", "b", "c")
+	assertReply(t, db, "3", "scard", "s")
+	assertReply(t, db, "1", "sismember", "s", "a")
+	assertReply(t, db, "0", "sismember", "s", "x")
+	assertReply(t, db, "1", "srem", "s", "c", "x")
+	assertReply(t, db, "[]", "smembers", "missing")
+
+	assertReply(t, db, "1", "sadd", "t", "b")
+	assertReply(t, db, "[b]", "sinter", "s", "t")
+	assertReply(t, db, "[a]", "sdiff", "s", "t")
+	assertReply(t, db, "2", "sunionstore", "u", "s", "t")
+	assertReply(t, db, "1", "sinterstore", "i", "s", "t")
+	assertReply(t, db, "1", "sdiffstore", "d", "s", "t")
+	assertReply(t, db, "[a]", "smembers", "d")
+
+	assertReply(t, db, "1", "smove", "s", "t", "a")
+	assertReply(t, db, "0", "smove", "s", "t", "a")
+	assertReply(t, db, "[b]", "smembers", "s")
+	assertReply(t, db, "b", "srandmember", "s")
+	assertReply(t, db, "[b]", "srandmember", "s", "5")
+	assertReply(t, db, "b", "spop", "s")
+	assertReply(t, db, "(nil)", "spop", "s")
+	assertReply(t, db, "[]", "spop", "s", "2")
+	assertReply(t, db, "0", "scard", "s")
+
+	assertError(t, db, newWrongNumOfArgsError("sadd"), "sadd", "s")
+	assertError(t, db, newWrongNumOfArgsError("smove"), "smove", "s", "t")
+	assertError(t, db, ErrSyntaxIncorrect, "spop", "t", "x")
+	assertError(t, db, ErrSyntaxIncorrect, "srandmember", "t", "x")
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"MetaDB/kv"
+
+	"strconv"
+
+	"github.com/tidwall/redcon"
+)
+
+func set(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("set")
+		return
+	}
+	if err = db.Set([]byte(args[0]), []byte(args[1])); err == nil {
+		res = redcon.SimpleString("OK")
+	}
+	return
+}
+
+func setNx(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("setnx")
+		return
+	}
+	var ok int
+	if ok, err = db.SetNx([]byte(args[0]), []byte(args[1])); err == nil {
+		res = redcon.SimpleInt(ok)
+	}
+	return
+}
+
+func setEx(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 3 {
+		err = newWrongNumOfArgsError("setex")
+		return
+	}
+	duration, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	if err = db.SetEx([]byte(args[0]), []byte(args[2]), duration); err == nil {
+		res = redcon.SimpleString("OK")
+	}
+	return
+}
+
+func get(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("get")
+		return
+	}
+	val, err := db.Get([]byte(args[0]))
+	if err == kv.ErrKeyNotExist || err == kv.ErrKeyExpired {
+		return nil, nil
+	}
+	if err == nil {
+		res = string(val)
+	}
+	return
+}
+
+func getSet(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("getset")
+		return
+	}
+	var val []byte
+	if val, err = db.GetSet([]byte(args[0]), []byte(args[1])); err == nil && val != nil {
+		res = string(val)
+	}
+	return
+}
+
+func mSet(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		err = newWrongNumOfArgsError("mset")
+		return
+	}
+	var values [][]byte
+	for _, arg := range args {
+		values = append(values, []byte(arg))
+	}
+	if err = db.MSet(values...); err == nil {
+		res = redcon.SimpleString("OK")
+	}
+	return
+}
+
+func mGet(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) == 0 {
+		err = newWrongNumOfArgsError("mget")
+		return
+	}
+	var keys [][]byte
+	for _, arg := range args {
+		keys = append(keys, []byte(arg))
+	}
+	var values [][]byte
+	if values, err = db.MGet(keys...); err != nil {
+		return
+	}
+	reply := make([]interface{}, len(values))
+	for i, val := range values {
+		if val != nil {
+			reply[i] = string(val)
+		}
+	}
+	res = reply
+	return
+}
+
+func appendStr(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("append")
+		return
+	}
+	var length int
+	if length, err = db.Append([]byte(args[0]), []byte(args[1])); err == nil {
+		res = redcon.SimpleInt(length)
+	}
+	return
+}
+
+func strLen(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("strlen")
+		return
+	}
+	length := db.StrLen([]byte(args[0]))
+	res = redcon.SimpleInt(length)
+	return
+}
+
+func incr(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("incr")
+		return
+	}
+	var val int64
+	if val, err = db.Incr([]byte(args[0])); err == nil {
+		res = val
+	}
+	return
+}
+
+func incrBy(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("incrby")
+		return
+	}
+	incr, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	var val int64
+	if val, err = db.IncrBy([]byte(args[0]), incr); err == nil {
+		res = val
+	}
+	return
+}
+
+func decr(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("decr")
+		return
+	}
+	var val int64
+	if val, err = db.Decr([]byte(args[0])); err == nil {
+		res = val
+	}
+	return
+}
+
+func decrBy(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("decrby")
+		return
+	}
+	decr, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	var val int64
+	if val, err = db.DecrBy([]byte(args[0]), decr); err == nil {
+		res = val
+	}
+	return
+}
+
+func init() {
+	addWriteCommand("set", kv.String, firstKey, set)
+	addWriteCommand("setnx", kv.String, firstKey, setNx)
+	addWriteCommand("setex", kv.String, firstKey, setEx)
+	addExecCommand("get", get)
+	addWriteCommand("getset", kv.String, firstKey, getSet)
+	addWriteCommand("mset", kv.String, pairKeys, mSet)
+	addExecCommand("mget", mGet)
+	addWriteCommand("append", kv.String, firstKey, appendStr)
+	addExecCommand("strlen", strLen)
+	addWriteCommand("incr", kv.String, firstKey, incr)
+	addWriteCommand("incrby", kv.String, firstKey, incrBy)
+	addWriteCommand("decr", kv.String, firstKey, decr)
+	addWriteCommand("decrby", kv.String, firstKey, decrBy)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"MetaDB/kv"
+
+	"testing"
+)
+
+func TestStrCommands(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "OK", "set", "k", "v")
+	assertReply(t, db, "v", "get", "k")
+	assertReply(t, db, "(nil)", "get", "missing")
+	assertReply(t, db, "0", "setnx", "k", "v2")
+	assertReply(t, db, "1", "setnx", "nx", "v")
+	assertReply(t, db, "v", "getset", "k", "v3")
+	assertReply(t, db, "(nil)", "getset", "new", "v")
+	assertReply(t, db, "5", "append", "k", "abc")
+	assertReply(t, db, "v3abc", "get", "k")
+	assertReply(t, db, "5", "strlen", "k")
+	assertReply(t, db, "0", "strlen", "missing")
+
+	assertReply(t, db, "OK", "mset", "a", "1", "b", "2")
+	assertReply(t, db, "[1 (nil) 2]", "mget", "a", "missing", "b")
+
+	assertReply(t, db, "1", "incr", "n")
+	assertReply(t, db, "11", "incrby", "n", "10")
+	assertReply(t, db, "10", "decr", "n")
+	assertReply(t, db, "-5", "decrby", "n", "15")
+
+	assertReply(t, db, "OK", "setex", "ex", "100", "v")
+	// the expired time of string is in seconds.
+	if got, err := exec(t, db, "ttl", "ex"); err != nil || (got != "99" && got != "100") {
+		t.Fatalf("ttl: got %s, %v, want 100", got, err)
+	}
+	assertReply(t, db, "v", "get", "ex")
+
+	assertError(t, db, newWrongNumOfArgsError("set"), "set", "k")
+	assertError(t, db, newWrongNumOfArgsError("mset"), "mset", "a", "1", "b")
+	assertError(t, db, newWrongNumOfArgsError("get"), "get")
+	assertError(t, db, ErrSyntaxIncorrect, "incrby", "n", "x")
+	assertError(t, db, ErrSyntaxIncorrect, "setex", "k", "x", "v")
+	assertError(t, db, kv.ErrWrongValueType, "incr", "k")
+	assertReply(t, db, "OK", "set", "max", "9223372036854775807")
+	assertError(t, db, kv.ErrIntegerOverflow, "incr", "max")
+}
@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"MetaDB/kv"
+	"MetaDB/kv/ds/zset"
+
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+)
+
+const withScoresArg = "withscores"
+
+func zAdd(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		err = newWrongNumOfArgsError("zadd")
+		return
+	}
+
+	scores := make([]float64, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		var score float64
+		if score, err = strconv.ParseFloat(args[i], 64); err != nil || math.IsNaN(score) {
+			err = ErrSyntaxIncorrect
+			return
+		}
+		scores = append(scores, score)
+	}
+
+	var count int
+	for i, score := range scores {
+		var added int
+		if added, err = db.ZAdd([]byte(args[0]), score, []byte(args[2*i+2])); err != nil {
+			return
+		}
+		count += added
+	}
+	res = redcon.SimpleInt(count)
+	return
+}
+
+func zScore(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("zscore")
+		return
+	}
+	if score, ok := db.ZScore([]byte(args[0]), []byte(args[1])); ok {
+		res = formatFloat(score)
+	}
+	return
+}
+
+func zCard(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 1 {
+		err = newWrongNumOfArgsError("zcard")
+		return
+	}
+	card := db.ZCard([]byte(args[0]))
+	res = redcon.SimpleInt(card)
+	return
+}
+
+func zRank(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("zrank")
+		return
+	}
+	if rank := db.ZRank([]byte(args[0]), []byte(args[1])); rank >= 0 {
+		res = rank
+	}
+	return
+}
+
+func zRevRank(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 2 {
+		err = newWrongNumOfArgsError("zrevrank")
+		return
+	}
+	if rank := db.ZRevRank([]byte(args[0]), []byte(args[1])); rank >= 0 {
+		res = rank
+	}
+	return
+}
+
+func zIncrBy(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 3 {
+		err = newWrongNumOfArgsError("zincrby")
+		return
+	}
+	incr, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || math.IsNaN(incr) {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	var score float64
+	if score, err = db.ZIncrBy([]byte(args[0]), incr, []byte(args[2])); err == nil {
+		res = formatFloat(score)
+	}
+	return
+}
+
+func zRange(db *kv.KVDB, args []string) (res interface{}, err error) {
+	return zRawRange(db, args, false)
+}
+
+func zRevRange(db *kv.KVDB, args []string) (res interface{}, err error) {
+	return zRawRange(db, args, true)
+}
+
+func zRangeByScore(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 3 {
+		err = newWrongNumOfArgsError("zrangebyscore")
+		return
+	}
+	min, err := parseScoreBound(args[1], true)
+	if err != nil {
+		return
+	}
+	max, err := parseScoreBound(args[2], false)
+	if err != nil {
+		return
+	}
+
+	var withScores bool
+	offset, count := 0, -1
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case withScoresArg:
+			withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				err = ErrSyntaxIncorrect
+				return
+			}
+			if offset, err = strconv.Atoi(args[i+1]); err != nil {
+				err = ErrSyntaxIncorrect
+				return
+			}
+			if count, err = strconv.Atoi(args[i+2]); err != nil {
+				err = ErrSyntaxIncorrect
+				return
+			}
+			i += 2
+		default:
+			err = ErrSyntaxIncorrect
+			return
+		}
+	}
+
+	// a negative offset returns an empty result.
+	if offset < 0 {
+		return [][]byte{}, nil
+	}
+	pairs := db.ZRangeByScore([]byte(args[0]), min, max, offset, count)
+	res = pairsReply(pairs, withScores)
+	return
+}
+
+func zCount(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) != 3 {
+		err = newWrongNumOfArgsError("zcount")
+		return
+	}
+	min, err := parseScoreBound(args[1], true)
+	if err != nil {
+		return
+	}
+	max, err := parseScoreBound(args[2], false)
+	if err != nil {
+		return
+	}
+	count := db.ZCount([]byte(args[0]), min, max)
+	res = redcon.SimpleInt(count)
+	return
+}
+
+func zRem(db *kv.KVDB, args []string) (res interface{}, err error) {
+	if len(args) < 2 {
+		err = newWrongNumOfArgsError("zrem")
+		return
+	}
+	var count int
+	if count, err = db.ZRem([]byte(args[0]), toBytesSlice(args[1:])...); err == nil {
+		res = redcon.SimpleInt(count)
+	}
+	return
+}
+
+func zRawRange(db *kv.KVDB, args []string, reverse bool) (res interface{}, err error) {
+	if len(args) != 3 && len(args) != 4 {
+		if reverse {
+			err = newWrongNumOfArgsError("zrevrange")
+		} else {
+			err = newWrongNumOfArgsError("zrange")
+		}
+		return
+	}
+
+	var withScores bool
+	if len(args) == 4 {
+		if strings.ToLower(args[3]) != withScoresArg {
+			err = ErrSyntaxIncorrect
+			return
+		}
+		withScores = true
+	}
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		err = ErrSyntaxIncorrect
+		return
+	}
+
+	var pairs []zset.Pair
+	if reverse {
+		pairs = db.ZRevRange([]byte(args[0]), start, stop)
+	} else {
+		pairs = db.ZRange([]byte(args[0]), start, stop)
+	}
+	res = pairsReply(pairs, withScores)
+	return
+}
+
+// parseScoreBound parses the min or max of a score range, "(" means an exclusive bound.
+func parseScoreBound(arg string, isMin bool) (float64, error) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+
+	score, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, ErrSyntaxIncorrect
+	}
+	if exclusive {
+		if isMin {
+			score = math.Nextafter(score, math.Inf(1))
+		} else {
+			score = math.Nextafter(score, math.Inf(-1))
+		}
+	}
+	return score, nil
+}
+
+func pairsReply(pairs []zset.Pair, withScores bool) [][]byte {
+	res := make([][]byte, 0, len(pairs))
+	for _, p := range pairs {
+		res = append(res, p.Member)
+		if withScores {
+			res = append(res, []byte(formatFloat(p.Score)))
+		}
+	}
+	return res
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func init() {
+	addWriteCommand("zadd", kv.ZSet, firstKey, zAdd)
+	addExecCommand("zscore", zScore)
+	addExecCommand("zcard", zCard)
+	addExecCommand("zrank", zRank)
+	addExecCommand("zrevrank", zRevRank)
+	addWriteCommand("zincrby", kv.ZSet, firstKey, zIncrBy)
+	addExecCommand("zrange", zRange)
+	addExecCommand("zrevrange", zRevRange)
+	addExecCommand("zrangebyscore", zRangeByScore)
+	addExecCommand("zcount", zCount)
+	addWriteCommand("zrem", kv.ZSet, firstKey, zRem)
+}
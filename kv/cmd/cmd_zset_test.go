@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestZSetCommands(t *testing.T) {
+	db := openTestDB(t)
+
+	assertReply(t, db, "3", "zadd", "z", "1", "a", "2", "b", "3", "c")
+	assertReply(t, db, "0", "zadd", "z", "1.5", "a")
+	assertReply(t, db, "3", "zcard", "z")
+	assertReply(t, db, "1.5", "zscore", "z", "a")
+	assertReply(t, db, "(nil)", "zscore", "z", "x")
+	assertReply(t, db, "4.5", "zincrby", "z", "3", "a")
+	assertReply(t, db, "2", "zrank", "z", "a")
+	assertReply(t, db, "0", "zrevrank", "z", "a")
+	assertReply(t, db, "(nil)", "zrank", "z", "x")
+
+	assertReply(t, db, "[b c a]", "zrange", "z", "0", "-1")
+	assertReply(t, db, "[a 4.5 c 3]", "zrevrange", "z", "0", "1", "WITHSCORES")
+	assertReply(t, db, "[c a]", "zrangebyscore", "z", "(2", "+inf")
+	assertReply(t, db, "[c 3]", "zrangebyscore", "z", "-inf", "+inf", "withscores", "limit", "1", "1")
+	assertReply(t, db, "[]", "zrangebyscore", "z", "0", "10", "limit", "-1", "1")
+	assertReply(t, db, "2", "zcount", "z", "2", "3")
+	assertReply(t, db, "1", "zcount", "z", "(2", "3")
+
+	assertReply(t, db, "2", "zrem", "z", "a", "b", "x")
+	assertReply(t, db, "[c]", "zrange", "z", "0", "-1")
+
+	assertError(t, db, newWrongNumOfArgsError("zadd"), "zadd", "z", "1")
+	assertError(t, db, newWrongNumOfArgsError("zrange"), "zrange", "z", "0")
+	assertError(t, db, ErrSyntaxIncorrect, "zadd", "z", "nan", "a")
+	assertError(t, db, ErrSyntaxIncorrect, "zincrby", "z", "x", "a")
+	assertError(t, db, ErrSyntaxIncorrect, "zrange", "z", "0", "1", "scores")
+	assertError(t, db, ErrSyntaxIncorrect, "zrangebyscore", "z", "x", "1")
+	assertError(t, db, ErrSyntaxIncorrect, "zrangebyscore", "z", "0", "1", "limit", "1")
+}
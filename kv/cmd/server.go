@@ -19,6 +19,11 @@ func addExecCommand(cmd string, cmdFunc ExecCmdFunc) {
 	ExecCmd[strings.ToLower(cmd)] = cmdFunc
 }
 
+// addWriteCommand adds a command writing the keys of dType, it replies WRONGTYPE if a key is held by another data type.
+func addWriteCommand(cmd string, dType kv.DataType, keys func(args []string) []string, cmdFunc ExecCmdFunc) {
+	addExecCommand(cmd, writeCmd(dType, keys, cmdFunc))
+}
+
 type Server struct {
 	server *redcon.Server
 	db     *kv.KVDB
@@ -31,6 +36,15 @@ func NewServer(config kv.Config) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	report := db.RecoveryReport()
+	for _, f := range report.Truncated {
+		log.Printf("recovery: truncated the torn tail of %s at offset %d, dropped %d entries: %v", f.Path, f.Offset, f.Dropped, f.Err)
+	}
+	for _, f := range report.Quarantined {
+		log.Printf("recovery: quarantined the corrupt tail of db file(type %d, id %d) at offset %d to %s, dropped %d entries: %v",
+			f.DataType, f.FileId, f.Offset, f.Path, f.Dropped, f.Err)
+	}
 	return &Server{db: db}, nil
 }
 
@@ -90,8 +104,31 @@ func (s *Server) handleCmd(conn redcon.Conn, cmd redcon.Command) {
 	}
 	reply, err := exec(s.db, args)
 	if err != nil {
-		conn.WriteError(err.Error())
+		conn.WriteError(errorReply(err))
 		return
 	}
 	conn.WriteAny(reply)
 }
+
+// the messages of Redis for the errors of kv, the others are replied with their own messages.
+var kvErrorReplies = map[error]string{
+	kv.ErrWrongValueType:  "value is not an integer or out of range",
+	kv.ErrWrongFloatType:  "value is not a valid float",
+	kv.ErrIntegerOverflow: "increment or decrement would overflow",
+	kv.ErrFloatOverflow:   "increment would produce NaN or Infinity",
+	kv.ErrKeyNotExist:     "no such key",
+	kv.ErrInvalidTTL:      "invalid expire time",
+}
+
+// errorReply returns the error reply of err like Redis, it starts with an error prefix so the clients can tell the kind of error.
+// The errors which already start with ERR or WRONGTYPE are replied as they are, and the others are prefixed by ERR.
+func errorReply(err error) string {
+	msg, ok := kvErrorReplies[err]
+	if !ok {
+		msg = strings.TrimPrefix(err.Error(), "rosedb: ")
+	}
+	if strings.HasPrefix(msg, "ERR ") || strings.HasPrefix(msg, "WRONGTYPE ") {
+		return msg
+	}
+	return "ERR " + msg
+}
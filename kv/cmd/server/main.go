@@ -65,10 +65,11 @@ func newConfigFromFile(config string) (*kv.Config, error) {
 		return nil, err
 	}
 
-	var cfg = new(kv.Config)
-	err = toml.Unmarshal(data, cfg)
+	// the options not in the file keep their default values.
+	var cfg = kv.DefaultConfig()
+	err = toml.Unmarshal(data, &cfg)
 	if err != nil {
 		return nil, err
 	}
-	return cfg, nil
+	return &cfg, nil
 }
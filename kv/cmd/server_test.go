@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"MetaDB/kv"
+
+	"errors"
+	"testing"
+
+	"github.com/tidwall/redcon"
+)
+
+func TestErrorReply(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{newWrongNumOfArgsError("hget"), "ERR wrong number of arguments for 'hget' command"},
+		{ErrSyntaxIncorrect, "ERR syntax error"},
+		{ErrHashNotInteger, "ERR hash value is not an integer"},
+		{kv.ErrWrongValueType, "ERR value is not an integer or out of range"},
+		{kv.ErrDBIsReadOnly, "ERR db is read-only"},
+		{errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"),
+			"WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{errors.New("ERR unknown command 'x'"), "ERR unknown command 'x'"},
+	}
+	for _, tt := range tests {
+		if got := errorReply(tt.err); got != tt.want {
+			t.Fatalf("errorReply(%v): got %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// replyConn records the replies written to the connection.
+type replyConn struct {
+	redcon.Conn
+	replies []interface{}
+}
+
+func (c *replyConn) WriteError(msg string) {
+	c.replies = append(c.replies, errors.New(msg))
+}
+
+func (c *replyConn) WriteAny(v interface{}) {
+	c.replies = append(c.replies, v)
+}
+
+func TestHandleCmdError(t *testing.T) {
+	s := &Server{db: openTestDB(t)}
+	conn := &replyConn{}
+	for _, args := range [][]string{{"hget", "h"}, {"HINCRBY", "h", "f", "x"}, {"nosuch"}} {
+		cmd := redcon.Command{}
+		for _, arg := range args {
+			cmd.Args = append(cmd.Args, []byte(arg))
+		}
+		s.handleCmd(conn, cmd)
+	}
+
+	want := []string{
+		"ERR wrong number of arguments for 'hget' command",
+		"ERR value is not an integer or out of range",
+		"ERR unknown command 'nosuch'",
+	}
+	if len(conn.replies) != len(want) {
+		t.Fatalf("got the replies %v, want %q", conn.replies, want)
+	}
+	for i, reply := range conn.replies {
+		if err, ok := reply.(error); !ok || err.Error() != want[i] {
+			t.Fatalf("got the reply %v, want the error %q", reply, want[i])
+		}
+	}
+}
@@ -0,0 +1,650 @@
+package kv
+
+import (
+	"MetaDB/kv/index"
+	"MetaDB/kv/storage"
+	"MetaDB/kv/utils"
+
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrInvalidCompactWindow the compact window is not like "02:00-05:00".
+	ErrInvalidCompactWindow = errors.New("rosedb: invalid compact window")
+
+	// ErrDBFilePinned the db file can't be compacted alone, reclaim all db files instead.
+	ErrDBFilePinned = errors.New("rosedb: db file is pinned by a transaction or tombstone, reclaim it instead")
+
+	// errCompactionStopped the compaction is stopped by closing db.
+	errCompactionStopped = errors.New("rosedb: compaction is stopped")
+)
+
+type (
+	// fileStat the live and dead bytes of a db file, it is used to find the db files worth compacting.
+	fileStat struct {
+		total int64
+		dead  int64
+
+		// the db file can only be reclaimed with its neighbours,
+		// because a transaction crosses its boundary, or it has a tombstone which can't be moved.
+		pinned bool
+	}
+
+	// garbageStats tracks the db files of every data type, an entry is dead when it is overwritten or removed.
+	// The dead bytes are only counted for hash and string, the others are tracked for the pinned files.
+	garbageStats struct {
+		mu    sync.Mutex
+		files map[DataType]map[uint32]*fileStat
+	}
+
+	compactCandidate struct {
+		dType  DataType
+		fileId uint32
+		ratio  float64
+	}
+
+	// compactWindow the time of day when background compaction is allowed, in minutes.
+	compactWindow struct {
+		start, end int
+		anyTime    bool
+	}
+
+	// rateLimiter limits the bytes read and written per second.
+	rateLimiter struct {
+		rate  int64
+		start time.Time
+		bytes int64
+	}
+)
+
+func newGarbageStats() *garbageStats {
+	files := make(map[DataType]map[uint32]*fileStat)
+	for dType := DataType(0); dType < DataStructureNum; dType++ {
+		files[dType] = make(map[uint32]*fileStat)
+	}
+	return &garbageStats{files: files}
+}
+
+// add counts an entry written to the db file, the begin and rollback markers of transaction are dead at once.
+func (g *garbageStats) add(e *storage.Entry, fileId uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stat := g.get(e.GetType(), fileId)
+	if stat == nil {
+		return
+	}
+	stat.total += int64(e.Size())
+	if mark := e.GetMark(); mark == TxBegin || mark == TxRollback {
+		stat.dead += int64(e.Size())
+	}
+}
+
+func (g *garbageStats) discard(dType DataType, fileId uint32, size int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if stat := g.get(dType, fileId); stat != nil {
+		stat.dead += size
+	}
+}
+
+// markTxnSpan pins the db files from one to another if a transaction crosses them.
+func (g *garbageStats) markTxnSpan(dType DataType, from, to uint32) {
+	if from == to {
+		return
+	}
+	for id := from; id <= to; id++ {
+		g.pin(dType, id)
+	}
+}
+
+func (g *garbageStats) pin(dType DataType, fileId uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if stat := g.get(dType, fileId); stat != nil {
+		stat.pinned = true
+	}
+}
+
+// reset sets the stats of a db file which has only valid entries.
+func (g *garbageStats) reset(dType DataType, fileId uint32, total int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if files, ok := g.files[dType]; ok {
+		files[fileId] = &fileStat{total: total}
+	}
+}
+
+func (g *garbageStats) remove(dType DataType, fileId uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if files, ok := g.files[dType]; ok {
+		delete(files, fileId)
+	}
+}
+
+// move keeps the stats of a db file whose id is changed.
+func (g *garbageStats) move(dType DataType, from, to uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if files, ok := g.files[dType]; ok {
+		if stat, ok := files[from]; ok {
+			files[to] = stat
+			delete(files, from)
+		}
+	}
+}
+
+func (g *garbageStats) stat(dType DataType, fileId uint32) (fileStat, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if stat := g.get(dType, fileId); stat != nil {
+		return *stat, true
+	}
+	return fileStat{}, false
+}
+
+func (g *garbageStats) get(dType DataType, fileId uint32) *fileStat {
+	files, ok := g.files[dType]
+	if !ok {
+		return nil
+	}
+	if files[fileId] == nil {
+		files[fileId] = new(fileStat)
+	}
+	return files[fileId]
+}
+
+// discardIndexer counts the entry which the index points to as dead, it is called before the index is overwritten or removed.
+func (db *KVDB) discardIndexer(dType DataType, idx *index.Indexer) {
+	if idx == nil {
+		return
+	}
+	e := &storage.Entry{Meta: idx.Meta}
+	db.garbage.discard(dType, idx.FileId, int64(e.Size()))
+}
+
+func (db *KVDB) discardHashField(key, field string) {
+	idx, _ := db.hashIndex.indexes.HGet(key, field)
+	db.discardIndexer(Hash, idx)
+}
+
+func (db *KVDB) discardHashKey(key string) {
+	indexers, _ := db.hashIndex.indexes.HGetAll(key)
+	for _, idx := range indexers {
+		db.discardIndexer(Hash, idx)
+	}
+}
+
+func (db *KVDB) discardStr(key string) {
+	db.discardIndexer(String, db.strIndex.indexes[key])
+}
+
+// startCompactor starts the background compaction, it is stopped in Close.
+func (db *KVDB) startCompactor() error {
+	if db.config.CompactInterval <= 0 {
+		return nil
+	}
+
+	window, err := parseCompactWindow(db.config.CompactWindow)
+	if err != nil {
+		return err
+	}
+
+	db.compactStop = make(chan struct{})
+	db.compactWg.Add(1)
+	go func() {
+		defer db.compactWg.Done()
+
+		ticker := time.NewTicker(time.Duration(db.config.CompactInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-db.compactStop:
+				return
+			case now := <-ticker.C:
+				if !window.contains(now) {
+					continue
+				}
+				if err := db.compact(); err != nil && err != errCompactionStopped {
+					log.Println("background compaction err: ", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (db *KVDB) stopCompactor() {
+	if db.compactStop == nil {
+		return
+	}
+	close(db.compactStop)
+	db.compactWg.Wait()
+	db.compactStop = nil
+}
+
+// compact compacts the db files whose garbage reaches the triggers, the worst first.
+func (db *KVDB) compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if atomic.LoadUint32(&db.closed) == 1 {
+		return nil
+	}
+
+	limiter := newRateLimiter(db.config.CompactRateLimit)
+	for _, c := range db.compactCandidates() {
+		err := db.compactFile(c.dType, c.fileId, limiter, db.compactStop)
+		if err == ErrDBFilePinned {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactCandidates returns the archived files to compact, the worst first.
+// The dead bytes are only tracked for hash and string, so the db files of other types are never compacted.
+func (db *KVDB) compactCandidates() []compactCandidate {
+	ratio := db.config.CompactGarbageRatio
+	if ratio <= 0 {
+		ratio = DefaultCompactGarbageRatio
+	}
+
+	var candidates []compactCandidate
+	for _, dType := range []DataType{Hash, String} {
+		unlock := db.lockMgr.RLock(dType)
+		for id := range db.archFiles[dType] {
+			stat, ok := db.garbage.stat(dType, id)
+			if !ok || stat.pinned || stat.total == 0 || stat.dead < db.config.CompactMinGarbage {
+				continue
+			}
+			if r := float64(stat.dead) / float64(stat.total); r >= ratio {
+				candidates = append(candidates, compactCandidate{dType: dType, fileId: id, ratio: r})
+			}
+		}
+		unlock()
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ratio > candidates[j].ratio
+	})
+	if max := db.config.CompactMaxFiles; max > 0 && len(candidates) > max {
+		candidates = candidates[:max]
+	}
+	return candidates
+}
+
+// compactFile rewrites the valid entries of an archived file to the active file, and then removes the archived file.
+// For list, set and zset, the keys written in the archived file are rewritten with their current values instead.
+// The entries are processed in batches, so that the reads and writes of the data type are not blocked for long.
+// The caller must hold db.mu, so it never runs with Reclaim at the same time.
+func (db *KVDB) compactFile(dType DataType, fileId uint32, limiter *rateLimiter, stop <-chan struct{}) error {
+	unlock := db.lockMgr.RLock(dType)
+	df, ok := db.archFiles[dType][fileId]
+	// the tombstones are needed only if there are older entries.
+	oldest := true
+	for id := range db.archFiles[dType] {
+		if id < fileId {
+			oldest = false
+		}
+	}
+	unlock()
+	if !ok {
+		return ErrDBFileNotExist
+	}
+	if stat, _ := db.garbage.stat(dType, fileId); stat.pinned {
+		return ErrDBFilePinned
+	}
+
+	// the archived file is never changed, so it can be read without lock.
+	var items []*storage.HintItem
+	for offset := df.DataOffset(); ; {
+		e, err := df.Read(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(e.Meta.Key) == 0 {
+			break
+		}
+		items = append(items, &storage.HintItem{Entry: e, Offset: offset})
+		offset += int64(e.Size())
+		limiter.wait(int64(e.Size()), stop)
+	}
+
+	// the entries of list, set and zset depend on the older ones of the same key, so the keys are rewritten instead.
+	if dType != Hash && dType != String {
+		if err := db.compactKeys(dType, items, limiter, stop); err != nil {
+			return err
+		}
+		items = nil
+	}
+
+	const batchSize = 256
+	var block txnBlock
+	for len(items) > 0 {
+		select {
+		case <-stop:
+			return errCompactionStopped
+		default:
+		}
+
+		n := batchSize
+		if len(items) < n {
+			n = len(items)
+		}
+		written, err := db.compactEntries(dType, fileId, items[:n], &block, oldest)
+		if err != nil {
+			return err
+		}
+		items = items[n:]
+		limiter.wait(written, stop)
+	}
+
+	unlock = db.lockMgr.Lock(dType)
+	defer unlock()
+
+	// the rewritten entries must be persisted before the archived file is removed.
+	activeFile, err := db.getActiveFile(dType)
+	if err != nil {
+		return err
+	}
+	if err = activeFile.Sync(); err != nil {
+		return err
+	}
+
+	delete(db.archFiles[dType], fileId)
+	db.garbage.remove(dType, fileId)
+	if err = df.Close(false); err != nil {
+		return err
+	}
+	if err = df.RemoveHint(dType); err != nil {
+		return err
+	}
+	if err = os.Remove(df.Name(dType)); err != nil {
+		return err
+	}
+	return utils.SyncDir(db.config.DirPath)
+}
+
+// compactEntries rewrites the valid entries to the active file, and returns the written bytes.
+func (db *KVDB) compactEntries(dType DataType, fileId uint32, items []*storage.HintItem, block *txnBlock, oldest bool) (int64, error) {
+	unlock := db.lockMgr.Lock(dType)
+	defer unlock()
+
+	var written int64
+	for _, item := range items {
+		e := item.Entry
+		valid, err := db.validCompactEntry(e, item.Offset, fileId, block, oldest)
+		if err != nil {
+			// the entries moved already are duplicates now, they are dropped when the db file is reclaimed.
+			db.garbage.pin(dType, fileId)
+			return written, err
+		}
+		if !valid {
+			continue
+		}
+
+		if err := db.store(e); err != nil {
+			return written, err
+		}
+		if isIndexed(e) {
+			idx, err := db.newIndexer(e)
+			if err != nil {
+				return written, err
+			}
+			db.resetIndexer(dType, idx)
+		}
+		db.garbage.discard(dType, fileId, int64(e.Size()))
+		written += int64(e.Size())
+	}
+	return written, nil
+}
+
+// validCompactEntry checks whether the entry should be moved to the active file in compaction.
+// Different from reclaim, the tombstones are kept unless there is no older db file,
+// and ErrDBFilePinned is returned if a tombstone is needed but moving it would remove newer data.
+func (db *KVDB) validCompactEntry(e *storage.Entry, offset int64, fileId uint32, block *txnBlock, oldest bool) (bool, error) {
+	if isTxnMarker(e) || !isTombstone(e) {
+		return db.validTxnEntry(e, offset, fileId, block), nil
+	}
+
+	if block.remaining > 0 {
+		block.remaining--
+		if _, aborted := db.abortedTxns[block.id]; aborted {
+			return false, nil
+		}
+	}
+	if oldest {
+		return false, nil
+	}
+
+	// a tombstone followed by newer data is not needed, the newer data overrides the older ones anyway.
+	key := string(e.Meta.Key)
+	if e.GetType() == Hash {
+		if e.GetMark() == HashHDel {
+			idx, _ := db.hashIndex.indexes.HGet(key, string(e.Meta.Extra))
+			return idx == nil, nil
+		}
+		if e.GetMark() == HashHPersist {
+			_, exist := db.expires[Hash][key]
+			return !exist, nil
+		}
+		if e.GetMark() == HashHFieldPersist {
+			_, exist := db.fieldExpires[key][string(e.Meta.Extra)]
+			return !exist, nil
+		}
+		// the older fields which are not set again are still removed by HClear, so it must stay before the newer fields.
+		if db.hashIndex.indexes.HKeyExists(key) {
+			return false, ErrDBFilePinned
+		}
+		return true, nil
+	}
+
+	if e.GetMark() == StringRem {
+		_, exist := db.strIndex.indexes[key]
+		return !exist, nil
+	}
+	_, exist := db.expires[String][key]
+	return !exist, nil
+}
+
+// compactKeys rewrites the keys of list, set or zset which are written in the archived file, and the commit markers in it.
+// Every key is rewritten as a snapshot: it is cleared first, then its current elements and expired time are written,
+// so the entries of it in the archived file are not needed any more.
+func (db *KVDB) compactKeys(dType DataType, items []*storage.HintItem, limiter *rateLimiter, stop <-chan struct{}) error {
+	var (
+		keys    []string
+		markers []*storage.Entry
+	)
+	seen := make(map[string]bool)
+	addKey := func(key []byte) {
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			keys = append(keys, string(key))
+		}
+	}
+	for _, item := range items {
+		e := item.Entry
+		switch {
+		case e.GetMark() == TxCommit:
+			markers = append(markers, e)
+		case isTxnMarker(e):
+		default:
+			addKey(e.Meta.Key)
+			// the member is moved to the set in extra.
+			if dType == Set && e.GetMark() == SetSMove {
+				addKey(e.Meta.Extra)
+			}
+		}
+	}
+
+	const batchSize = 256
+	for len(keys) > 0 {
+		select {
+		case <-stop:
+			return errCompactionStopped
+		default:
+		}
+
+		n := batchSize
+		if len(keys) < n {
+			n = len(keys)
+		}
+		written, err := db.storeSnapshots(dType, keys[:n])
+		if err != nil {
+			return err
+		}
+		keys = keys[n:]
+		limiter.wait(written, stop)
+	}
+
+	// the commit markers of the transactions in other data types are still needed.
+	unlock := db.lockMgr.Lock(dType)
+	defer unlock()
+	for _, e := range markers {
+		if err := db.store(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeSnapshots writes the snapshots of the keys in a transaction, so a key is never left cleared by a crash.
+// It returns the written bytes.
+func (db *KVDB) storeSnapshots(dType DataType, keys []string) (int64, error) {
+	unlock := db.lockMgr.Lock(dType)
+	defer unlock()
+
+	var (
+		entries []*storage.Entry
+		written int64
+	)
+	for _, key := range keys {
+		for _, e := range db.snapshot(dType, key) {
+			entries = append(entries, e)
+			written += int64(e.Size())
+		}
+	}
+	return written, db.storeTxn(dType, entries)
+}
+
+// snapshot returns the entries which set the key of list, set or zset to its current value.
+// The caller must hold the lock of dType.
+func (db *KVDB) snapshot(dType DataType, key string) []*storage.Entry {
+	k := []byte(key)
+	entries := []*storage.Entry{newClearEntry(k, dType)}
+	// the expired key is removed when it is accessed, so it is just cleared.
+	if db.keyExpired(dType, key) {
+		return entries
+	}
+
+	var mark uint16
+	switch dType {
+	case List:
+		for _, val := range db.listIndex.indexes.LRange(key, 0, -1) {
+			entries = append(entries, storage.NewEntryNoExtra(k, val, List, ListRPush))
+		}
+		mark = ListLExpire
+	case Set:
+		for _, member := range db.setIndex.indexes.SMembers(key) {
+			entries = append(entries, storage.NewEntryNoExtra(k, member, Set, SetSAdd))
+		}
+		mark = SetSExpire
+	case ZSet:
+		for _, p := range db.zsetIndex.indexes.ZRange(key, 0, -1) {
+			entries = append(entries, storage.NewEntry(k, p.Member, formatScore(p.Score), ZSet, ZSetZAdd))
+		}
+		mark = ZSetZExpire
+	}
+	if deadline, exist := db.expires[dType][key]; exist {
+		entries = append(entries, storage.NewEntryWithExpire(k, nil, deadline/1000, dType, mark))
+	}
+	return entries
+}
+
+func isTombstone(e *storage.Entry) bool {
+	mark := e.GetMark()
+	switch e.GetType() {
+	case Hash:
+		return mark == HashHDel || mark == HashHClear || mark == HashHPersist || mark == HashHFieldPersist
+	case String:
+		return mark == StringRem || mark == StringPersist
+	}
+	return false
+}
+
+func parseCompactWindow(window string) (compactWindow, error) {
+	if window == "" {
+		return compactWindow{anyTime: true}, nil
+	}
+
+	var startHour, startMin, endHour, endMin int
+	if _, err := fmt.Sscanf(window, "%d:%d-%d:%d", &startHour, &startMin, &endHour, &endMin); err != nil {
+		return compactWindow{}, ErrInvalidCompactWindow
+	}
+	for _, v := range []int{startHour, endHour} {
+		if v < 0 || v > 24 {
+			return compactWindow{}, ErrInvalidCompactWindow
+		}
+	}
+	for _, v := range []int{startMin, endMin} {
+		if v < 0 || v > 59 {
+			return compactWindow{}, ErrInvalidCompactWindow
+		}
+	}
+	return compactWindow{start: startHour*60 + startMin, end: endHour*60 + endMin}, nil
+}
+
+func (w compactWindow) contains(t time.Time) bool {
+	if w.anyTime {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	// the window crosses midnight.
+	return minute >= w.start || minute < w.end
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, start: time.Now()}
+}
+
+// wait blocks until the n bytes are allowed by the rate, or the stop channel is closed.
+func (l *rateLimiter) wait(n int64, stop <-chan struct{}) {
+	if l == nil || l.rate <= 0 {
+		return
+	}
+
+	l.bytes += n
+	expected := time.Duration(float64(l.bytes) / float64(l.rate) * float64(time.Second))
+	if d := expected - time.Since(l.start); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-stop:
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// compactFiles makes every archived file with dead bytes a candidate of compaction.
+func compactFiles(cfg *Config) {
+	smallFiles(cfg)
+	cfg.ReclaimThreshold = 1000
+	cfg.CompactMinGarbage = 1
+	cfg.CompactMaxFiles = 0
+}
+
+func TestCompact(t *testing.T) {
+	for name, update := range testConfigs() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				update(cfg)
+				compactFiles(cfg)
+			})
+
+			for i := 0; i < 40; i++ {
+				if err := db.Set([]byte(fmt.Sprintf("k%02d", i)), []byte("old")); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.HSet([]byte("hash"), []byte(fmt.Sprintf("f%02d", i)), []byte("old")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// overwrite or remove everything, so the old files are garbage.
+			for i := 0; i < 40; i++ {
+				key, field := []byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("f%02d", i))
+				if i%2 == 0 {
+					if err := db.Remove(key); err != nil {
+						t.Fatal(err)
+					}
+					if _, err := db.HDel([]byte("hash"), field); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				if err := db.Set(key, []byte(fmt.Sprintf("v%d", i))); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.HSet([]byte("hash"), field, []byte(fmt.Sprintf("v%d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := db.Expire([]byte("k01"), 1000); err != nil {
+				t.Fatal(err)
+			}
+
+			check := func(db *KVDB) {
+				t.Helper()
+				for i := 0; i < 40; i++ {
+					key, field := []byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("f%02d", i))
+					val, err := db.Get(key)
+					if i%2 == 0 {
+						if err != ErrKeyNotExist || db.HGet([]byte("hash"), field) != nil {
+							t.Fatalf("the removed %s is back: %v", key, err)
+						}
+						continue
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					assertBytes(t, val, fmt.Sprintf("v%d", i))
+					assertBytes(t, db.HGet([]byte("hash"), field), fmt.Sprintf("v%d", i))
+				}
+				if ttl := db.TTL([]byte("k01")); ttl <= 0 {
+					t.Fatalf("TTL: got %d", ttl)
+				}
+			}
+
+			candidates := db.compactCandidates()
+			if len(candidates) == 0 {
+				t.Fatal("no db file to compact")
+			}
+			if err := db.compact(); err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range candidates {
+				if _, ok := db.archFiles[c.dType][c.fileId]; ok {
+					if stat, _ := db.garbage.stat(c.dType, c.fileId); !stat.pinned {
+						t.Fatalf("the db file(type %d, id %d) is not compacted", c.dType, c.fileId)
+					}
+				}
+			}
+			check(db)
+			db = reopenTestDB(t, db)
+			check(db)
+		})
+	}
+}
+
+// The tombstone is kept by compaction if an older db file has the data it removes.
+func TestCompactKeepsTombstones(t *testing.T) {
+	db := openTestDB(t, compactFiles)
+
+	if err := db.Set([]byte("removed"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("filler%02d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Remove([]byte("removed")); err != nil {
+		t.Fatal(err)
+	}
+	// overwrite the fillers after the tombstone, so its db file is garbage but not the oldest one.
+	for i := 0; i < 30; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("filler%02d", i)), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 30; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("filler%02d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var oldest uint32 = 1 << 31
+	for id := range db.archFiles[String] {
+		if id < oldest {
+			oldest = id
+		}
+	}
+	for _, c := range db.compactCandidates() {
+		if c.dType == String && c.fileId != oldest {
+			if err := db.compactFile(c.dType, c.fileId, nil, nil); err != nil && err != ErrDBFilePinned {
+				t.Fatal(err)
+			}
+		}
+	}
+	db = reopenTestDB(t, db)
+	if _, err := db.Get([]byte("removed")); err != ErrKeyNotExist {
+		t.Fatalf("the removed key is back after compaction: %v", err)
+	}
+}
+
+// Only the db files of hash and string are compacted, the others are left to Reclaim.
+func TestCompactCandidatesTypes(t *testing.T) {
+	db := openTestDB(t, compactFiles)
+
+	for i := 0; i < 100; i++ {
+		if _, err := db.RPush([]byte("list"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.LPop([]byte("list")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SAdd([]byte("set"), []byte("m")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SRem([]byte("set"), []byte("m")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Set([]byte("str"), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.archFiles[List]) == 0 || len(db.archFiles[Set]) == 0 {
+		t.Fatal("no archived file of list or set")
+	}
+
+	var str bool
+	for _, c := range db.compactCandidates() {
+		if c.dType != Hash && c.dType != String {
+			t.Fatalf("got a candidate of type %d", c.dType)
+		}
+		str = str || c.dType == String
+	}
+	if !str {
+		t.Fatal("the db files of string are not candidates")
+	}
+}
+
+func TestCompactMaxFiles(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		compactFiles(cfg)
+		cfg.CompactMaxFiles = 1
+	})
+
+	for i := 0; i < 200; i++ {
+		if err := db.Set([]byte("str"), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(db.compactCandidates()); n != 1 {
+		t.Fatalf("got %d candidates, want 1", n)
+	}
+}
+
+func TestCompactWindow(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2021, 1, 1, hour, min, 0, 0, time.Local)
+	}
+
+	w, err := parseCompactWindow("02:00-05:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.contains(at(2, 0)) || !w.contains(at(5, 29)) || w.contains(at(5, 30)) || w.contains(at(1, 59)) {
+		t.Fatal("the window 02:00-05:30")
+	}
+
+	// the window crosses midnight.
+	if w, err = parseCompactWindow("23:00-01:00"); err != nil {
+		t.Fatal(err)
+	}
+	if !w.contains(at(23, 30)) || !w.contains(at(0, 30)) || w.contains(at(1, 0)) || w.contains(at(12, 0)) {
+		t.Fatal("the window 23:00-01:00")
+	}
+
+	if w, err = parseCompactWindow(""); err != nil || !w.contains(at(12, 0)) {
+		t.Fatalf("the empty window: %v", err)
+	}
+	for _, s := range []string{"2am-5am", "25:00-01:00", "01:60-02:00"} {
+		if _, err := parseCompactWindow(s); err != ErrInvalidCompactWindow {
+			t.Fatalf("parse %q: got %v, want ErrInvalidCompactWindow", s, err)
+		}
+	}
+}
+
+func TestSingleReclaim(t *testing.T) {
+	db := openTestDB(t, compactFiles)
+
+	for i := 0; i < 60; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("k%02d", i%20)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.HSet([]byte("hash"), []byte(fmt.Sprintf("f%02d", i%20)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SAdd([]byte("set"), []byte(fmt.Sprintf("m%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.RPush([]byte("list"), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ZAdd([]byte("zset"), float64(i), []byte(fmt.Sprintf("m%02d", i%20))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := db.LPop([]byte("list")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.LExpire([]byte("list"), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dType := range []DataType{String, Hash, List, Set, ZSet} {
+		var fileId uint32
+		for id := range db.archFiles[dType] {
+			fileId = id
+			break
+		}
+		if err := db.SingleReclaim(dType, fileId); err != nil {
+			t.Fatalf("SingleReclaim the db file(type %d, id %d): %v", dType, fileId, err)
+		}
+		if _, ok := db.archFiles[dType][fileId]; ok {
+			t.Fatalf("the db file(type %d, id %d) is not reclaimed", dType, fileId)
+		}
+		// the file id is checked against the db files of dType.
+		if err := db.SingleReclaim(dType, fileId); err != ErrDBFileNotExist {
+			t.Fatalf("SingleReclaim a removed file: got %v, want ErrDBFileNotExist", err)
+		}
+	}
+	if err := db.SingleReclaim(DataStructureNum, 0); err != ErrInvalidDataType {
+		t.Fatalf("SingleReclaim an invalid data type: got %v, want ErrInvalidDataType", err)
+	}
+
+	check := func(db *KVDB) {
+		t.Helper()
+		for i := 40; i < 60; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("k%02d", i%20)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, val, fmt.Sprintf("v%d", i))
+			assertBytes(t, db.HGet([]byte("hash"), []byte(fmt.Sprintf("f%02d", i%20))), fmt.Sprintf("v%d", i))
+		}
+		if n := db.SCard([]byte("set")); n != 60 {
+			t.Fatalf("SCard: got %d, want 60", n)
+		}
+		values, err := db.LRange([]byte("list"), 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 40 {
+			t.Fatalf("LRange: got %d values, want 40", len(values))
+		}
+		for i, val := range values {
+			assertBytes(t, val, fmt.Sprintf("v%d", i+20))
+		}
+		if ttl := db.LTTL([]byte("list")); ttl < 99 || ttl > 100 {
+			t.Fatalf("LTTL: got %d, want 100", ttl)
+		}
+		pairs := db.ZRange([]byte("zset"), 0, -1)
+		if len(pairs) != 20 {
+			t.Fatalf("ZRange: got %d pairs, want 20", len(pairs))
+		}
+		for i, p := range pairs {
+			assertBytes(t, p.Member, fmt.Sprintf("m%02d", i))
+			if p.Score != float64(i+40) {
+				t.Fatalf("the score of %s: got %v, want %d", p.Member, p.Score, i+40)
+			}
+		}
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
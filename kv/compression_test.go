@@ -0,0 +1,127 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func compressibleValue(i int) []byte {
+	return bytes.Repeat([]byte(fmt.Sprintf("value-%d|", i)), 512)
+}
+
+func TestCompression(t *testing.T) {
+	for _, codec := range []string{"flate", "gzip", "zlib"} {
+		for name, update := range testConfigs() {
+			t.Run(codec+"/"+name, func(t *testing.T) {
+				db := openTestDB(t, func(cfg *Config) {
+					update(cfg)
+					cfg.Compression = codec
+				})
+
+				for i := 0; i < 10; i++ {
+					if err := db.Set([]byte(fmt.Sprintf("k%d", i)), compressibleValue(i)); err != nil {
+						t.Fatal(err)
+					}
+					if _, err := db.HSet([]byte("hash"), []byte(fmt.Sprintf("f%d", i)), compressibleValue(i)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				// the small value is not compressed.
+				if err := db.Set([]byte("small"), []byte("v")); err != nil {
+					t.Fatal(err)
+				}
+
+				activeFile, err := db.getActiveFile(String)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if raw := int64(10 * len(compressibleValue(0))); activeFile.Offset > raw/4 {
+					t.Fatalf("the values are not compressed: %d bytes in db file, %d bytes of values", activeFile.Offset, raw)
+				}
+
+				check := func(db *KVDB) {
+					t.Helper()
+					for i := 0; i < 10; i++ {
+						val, err := db.Get([]byte(fmt.Sprintf("k%d", i)))
+						if err != nil {
+							t.Fatal(err)
+						}
+						assertBytes(t, val, string(compressibleValue(i)))
+						assertBytes(t, db.HGet([]byte("hash"), []byte(fmt.Sprintf("f%d", i))), string(compressibleValue(i)))
+					}
+					val, err := db.Get([]byte("small"))
+					if err != nil {
+						t.Fatal(err)
+					}
+					assertBytes(t, val, "v")
+				}
+				check(db)
+				db = reopenTestDB(t, db)
+				check(db)
+			})
+		}
+	}
+}
+
+// The codec id is saved with the value, so the values compressed by another codec or none are still read.
+func TestCompressionChangeCodec(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.Compression = "gzip"
+	})
+	if err := db.Set([]byte("gzip"), compressibleValue(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	closeTestDB(t, db)
+	cfg := db.config
+	cfg.Compression = ""
+	db = openTestConfig(t, cfg)
+	if err := db.Set([]byte("plain"), compressibleValue(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	closeTestDB(t, db)
+	cfg.Compression = "zlib"
+	db = openTestConfig(t, cfg)
+	for key, i := range map[string]int{"gzip": 1, "plain": 2} {
+		val, err := db.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, string(compressibleValue(i)))
+	}
+
+	cfg.Compression = "lz4"
+	if _, err := Open(cfg); err != storage.ErrUnknownCodec {
+		t.Fatalf("open with an unknown codec: got %v, want ErrUnknownCodec", err)
+	}
+}
+
+// The compressed values are rewritten by reclaim and read back after reopening.
+func TestCompressionReclaim(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.Compression = "flate"
+		cfg.BlockSize = 4 * 1024
+		cfg.ReclaimThreshold = 2
+	})
+
+	for i := 0; i < 40; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("k%d", i%10)), compressibleValue(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	for i := 30; i < 40; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("k%d", i%10)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, string(compressibleValue(i)))
+	}
+}
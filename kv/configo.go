@@ -38,6 +38,39 @@ const (
 	// DefaultReclaimThreshold default disk files reclaim threshold: 64.
 	// This means that it will be reclaimed when there are at least 64 archived files on disk.
 	DefaultReclaimThreshold = 64
+
+	// DefaultCompactInterval default interval of checking garbage for background compaction: 0, which means disabled.
+	// Set CompactInterval to enable it, such as 60 to check the garbage every minute.
+	DefaultCompactInterval = 0
+
+	// DefaultCompactGarbageRatio default garbage ratio to compact a db file: 0.5.
+	// This means that a db file will be compacted when at least half of its bytes are dead.
+	DefaultCompactGarbageRatio = 0.5
+
+	// DefaultCompactMinGarbage default min dead bytes to compact a db file: 1mb.
+	DefaultCompactMinGarbage = 1024 * 1024
+
+	// DefaultCompactMaxFiles default max number of db files compacted in one round: 4.
+	DefaultCompactMaxFiles = 4
+
+	// DefaultCompressThreshold default min size of value to compress: 1kb.
+	DefaultCompressThreshold = 1024
+
+	// DefaultGroupCommitMaxBatch default max number of writes persisted by one fsync: 256.
+	DefaultGroupCommitMaxBatch = 256
+
+	// DefaultSyncInterval default interval of syncing in the interval policy: 1000ms.
+	DefaultSyncInterval = 1000
+
+	// DefaultSyncBytes default bytes written between syncs in the bytes policy: 4mb.
+	DefaultSyncBytes = 4 * 1024 * 1024
+
+	// DefaultExpireInterval default interval of active expiration: 0, which means disabled.
+	// Set ExpireInterval to enable it, such as 100 to remove the expired keys every 100ms.
+	DefaultExpireInterval = 0
+
+	// DefaultExpireSamples default number of keys sampled by active expiration each time: 20.
+	DefaultExpireSamples = 20
 )
 
 // Config the opening options of rosedb.
@@ -51,15 +84,98 @@ type Config struct {
 	MaxKeySize   uint32               `json:"max_key_size" toml:"max_key_size"`
 	MaxValueSize uint32               `json:"max_value_size" toml:"max_value_size"`
 
+	// HashOrderedFields is whether to keep the fields of every hash in lexicographic order in memory,
+	// so HKeys, HVals and HGetAll return the fields in order, and HRangeByField doesn't sort all fields of the hash.
+	// The fields are also kept in the order of their hashes, so HScan doesn't read all fields of the hash in every call.
+	HashOrderedFields bool `json:"hash_ordered_fields" toml:"hash_ordered_fields"`
+
 	// Sync is whether to sync writes from the OS buffer cache through to actual disk.
 	// If false, and the machine crashes, then some recent writes may be lost.
 	//
 	// Note that if it is just the process that crashes (and the machine does not) then no writes will be lost.
 	//
 	// The default value is false.
+	//
+	// The concurrent writes are persisted together by one fsync, see GroupCommitMaxBatch and GroupCommitMaxWait.
+	// It is the always policy of SyncPolicy.
 	Sync bool `json:"sync" toml:"sync"`
 
+	// GroupCommitMaxBatch is the max number of writes persisted by one fsync if Sync is set.
+	GroupCommitMaxBatch int `json:"group_commit_max_batch" toml:"group_commit_max_batch"`
+
+	// GroupCommitMaxWait is the max time in microseconds to wait for more writes before an fsync if Sync is set.
+	// If it is 0, an fsync covers the writes arrived during the previous one without waiting.
+	GroupCommitMaxWait int64 `json:"group_commit_max_wait" toml:"group_commit_max_wait"`
+
+	// SyncPolicy is when to sync the writes to disk, like the appendfsync of Redis.
+	//   always:   every write is synced before it returns, the same as Sync.
+	//   interval: the writes are synced every SyncInterval milliseconds in background.
+	//   bytes:    the writes are synced in background after every SyncBytes bytes written.
+	//   no:       the writes are only synced when the db files are archived or closed.
+	// Empty means always if Sync is set, otherwise no.
+	SyncPolicy string `json:"sync_policy" toml:"sync_policy"`
+
+	// SyncInterval is the interval in milliseconds of the interval policy.
+	SyncInterval int64 `json:"sync_interval" toml:"sync_interval"`
+
+	// SyncBytes is the number of bytes written between syncs of the bytes policy.
+	SyncBytes int64 `json:"sync_bytes" toml:"sync_bytes"`
+
+	// DataSync is whether to sync by fdatasync instead of fsync, which doesn't flush the metadata such as modification time.
+	// It is the same as fsync on the systems without fdatasync.
+	DataSync bool `json:"data_sync" toml:"data_sync"`
+
+	// Preallocate is whether to allocate the disk space of active files to BlockSize when they are created,
+	// so the file size is not changed by writes, and syncing them flushes less metadata.
+	Preallocate bool `json:"preallocate" toml:"preallocate"`
+
 	ReclaimThreshold int `json:"reclaim_threshold" toml:"reclaim_threshold"` // threshold to reclaim disk
+
+	// CompactInterval is the interval in seconds of checking garbage for background compaction.
+	// The archived files of hash and string whose dead bytes reach the triggers are compacted, the worst first.
+	// The dead bytes are only tracked for hash and string, so the db files of list, set and zset are never compacted
+	// in background, their garbage is cleaned by Reclaim or SingleReclaim.
+	// The background compaction is disabled if it is not greater than 0, which is the default, set it such as 60 to enable it.
+	CompactInterval int64 `json:"compact_interval" toml:"compact_interval"`
+
+	// CompactGarbageRatio and CompactMinGarbage are the triggers of compacting a db file,
+	// the ratio of dead bytes and the number of dead bytes must reach both of them.
+	CompactGarbageRatio float64 `json:"compact_garbage_ratio" toml:"compact_garbage_ratio"`
+	CompactMinGarbage   int64   `json:"compact_min_garbage" toml:"compact_min_garbage"`
+
+	// CompactMaxFiles is the max number of db files compacted in one round, 0 means no limit.
+	CompactMaxFiles int `json:"compact_max_files" toml:"compact_max_files"`
+
+	// CompactWindow is the time of day when background compaction is allowed, such as "02:00-05:00".
+	// The end can be earlier than the start, which means the window crosses midnight. Empty means any time.
+	CompactWindow string `json:"compact_window" toml:"compact_window"`
+
+	// CompactRateLimit is the max bytes per second read and written by background compaction, 0 means no limit.
+	CompactRateLimit int64 `json:"compact_rate_limit" toml:"compact_rate_limit"`
+
+	// Compression is the codec to compress values in db files, it can be flate, gzip or zlib, empty means no compression.
+	// The compressed values can always be read, so it can be changed when reopening db.
+	Compression string `json:"compression" toml:"compression"`
+
+	// CompressThreshold is the min size of value to compress, the smaller ones are not worth compressing.
+	CompressThreshold int `json:"compress_threshold" toml:"compress_threshold"`
+
+	// EncryptionKeyFile is the path of the key file to encrypt db files with AES-GCM, empty means no encryption.
+	// Each line of the file is the id and the hex encoded key separated by space, the key must be 16, 24 or 32 bytes.
+	// The key with the greatest id encrypts new entries, and the old ones are re-encrypted with it by Reclaim.
+	EncryptionKeyFile string `json:"encryption_key_file" toml:"encryption_key_file"`
+
+	// ExpireInterval is the interval in milliseconds of active expiration, which removes the expired keys never written.
+	// The reads only skip the expired keys, so they are kept in memory until written or reclaimed if it is disabled.
+	// The active expiration is disabled if it is not greater than 0, which is the default, set it such as 100 to enable it.
+	ExpireInterval int64 `json:"expire_interval" toml:"expire_interval"`
+
+	// ExpireSamples is the number of keys with expire info sampled each time by active expiration,
+	// it samples again if more than a quarter of them are expired.
+	ExpireSamples int `json:"expire_samples" toml:"expire_samples"`
+
+	// KeyProvider provides the keys instead of the key file if it is set.
+	KeyProvider KeyProvider `json:"-" toml:"-"`
 }
 
 // DefaultConfig get the default config.
@@ -74,5 +190,17 @@ func DefaultConfig() Config {
 		MaxValueSize:     DefaultMaxValueSize,
 		Sync:             false,
 		ReclaimThreshold: DefaultReclaimThreshold,
+
+		GroupCommitMaxBatch: DefaultGroupCommitMaxBatch,
+		SyncInterval:        DefaultSyncInterval,
+		SyncBytes:           DefaultSyncBytes,
+		ExpireInterval:      DefaultExpireInterval,
+		ExpireSamples:       DefaultExpireSamples,
+
+		CompactInterval:     DefaultCompactInterval,
+		CompactGarbageRatio: DefaultCompactGarbageRatio,
+		CompactMinGarbage:   DefaultCompactMinGarbage,
+		CompactMaxFiles:     DefaultCompactMaxFiles,
+		CompressThreshold:   DefaultCompressThreshold,
 	}
 }
\ No newline at end of file
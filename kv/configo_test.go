@@ -0,0 +1,47 @@
+package kv
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/pelletier/go-toml"
+)
+
+// The options in config.toml are parsed into Config, the compaction, sync and expiration ones have the default values.
+func TestConfigFile(t *testing.T) {
+	data, err := ioutil.ReadFile("config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := toml.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	def := DefaultConfig()
+	if cfg.CompactInterval != def.CompactInterval || cfg.CompactGarbageRatio != def.CompactGarbageRatio ||
+		cfg.CompactMinGarbage != def.CompactMinGarbage || cfg.CompactMaxFiles != def.CompactMaxFiles ||
+		cfg.CompactWindow != def.CompactWindow || cfg.CompactRateLimit != def.CompactRateLimit {
+		t.Fatalf("got the compaction options %+v, want %+v", cfg, def)
+	}
+	if _, err := parseCompactWindow(cfg.CompactWindow); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Sync != def.Sync || cfg.SyncPolicy != def.SyncPolicy || cfg.SyncInterval != def.SyncInterval ||
+		cfg.SyncBytes != def.SyncBytes || cfg.GroupCommitMaxBatch != def.GroupCommitMaxBatch ||
+		cfg.GroupCommitMaxWait != def.GroupCommitMaxWait || cfg.DataSync != def.DataSync || cfg.Preallocate != def.Preallocate {
+		t.Fatalf("got the sync options %+v, want %+v", cfg, def)
+	}
+	if _, err := syncPolicy(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// the background compaction and active expiration are disabled by default.
+	if cfg.ExpireInterval != def.ExpireInterval || cfg.ExpireSamples != def.ExpireSamples {
+		t.Fatalf("got the expiration options %+v, want %+v", cfg, def)
+	}
+	if def.CompactInterval != 0 || def.ExpireInterval != 0 {
+		t.Fatalf("got the compact interval %d and expire interval %d, want 0", def.CompactInterval, def.ExpireInterval)
+	}
+}
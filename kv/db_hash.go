@@ -2,10 +2,15 @@ package kv
 
 import (
 	"MetaDB/kv/ds"
+	"MetaDB/kv/index"
 	"MetaDB/kv/storage"
+	"MetaDB/kv/utils"
 
 	"sync"
 	"bytes"
+	"log"
+	"math"
+	"strconv"
 	"time"
 )
 
@@ -14,30 +19,266 @@ type HashIdx struct {
 	indexes *hash.Hash
 }
 
-func newHashIdx() *HashIdx {
+// FieldExpireCond the condition of HFieldsPExpireAt to set the expired time of a field.
+type FieldExpireCond uint8
+
+const (
+	// FieldExpireAlways set the expired time whatever it is.
+	FieldExpireAlways FieldExpireCond = iota
+	// FieldExpireNX set the expired time only if the field has none.
+	FieldExpireNX
+	// FieldExpireXX set the expired time only if the field has one.
+	FieldExpireXX
+	// FieldExpireGT set the expired time only if it is greater than the current one, a field without it lives forever.
+	FieldExpireGT
+	// FieldExpireLT set the expired time only if it is less than the current one, a field without it lives forever.
+	FieldExpireLT
+)
+
+// the results of HFieldsPExpireAt, HFieldsPTTL and HFieldsPersist for every field, the same as the replies of Redis.
+const (
+	FieldNotExist   = -2
+	FieldNoTTL      = -1
+	FieldCondNotMet = 0
+	FieldTTLUpdated = 1
+	FieldDeleted    = 2
+)
+
+func newHashIdx(ordered bool) *HashIdx {
+	if ordered {
+		return &HashIdx{indexes: hash.NewOrdered(), mu: new(sync.RWMutex)}
+	}
 	return &HashIdx{indexes: hash.New(), mu: new(sync.RWMutex)}
 }
 
+// HSet sets field in the hash stored at key to value, and returns 1 if field is a new field in the hash, otherwise 0.
 func (db *KVDB) HSet(key []byte, field []byte, value []byte) (res int, err error) {
 	if err = db.checkKeyValue(key, value); err != nil {
 		return
 	}
 
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	// the expired hash and field are removed, so the field is added as a new one.
+	db.checkExpired(key, Hash)
+	db.checkFieldExpired(key, field)
+
 	// If the existed value is the same as the set value, nothing will be done.
-	oldVal := db.HGet(key, field)
-	if bytes.Equal(oldVal, value) {
+	// It is read under the lock, so it can't be changed by others before writing.
+	// A missing field is nil, so an empty value still adds it.
+	if oldVal := db.hGet(key, field); oldVal != nil && bytes.Equal(oldVal, value) {
+		return
+	}
+	return db.hSet(key, field, value, false)
+}
+
+// HIncrBy increments the number stored at field in the hash stored at key by incr.
+// If the field does not exist, it is set to 0 before performing the operation, and the time to live of field is kept.
+// ErrWrongValueType is returned if the value is not an integer, and ErrIntegerOverflow if the result overflows.
+func (db *KVDB) HIncrBy(key, field []byte, incr int64) (res int64, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
-	e := storage.NewEntry(key, value, field, Hash, HashHSet)
-	if err = db.store(e); err != nil {
+	err = db.hUpdate(key, field, func(val []byte) ([]byte, error) {
+		if val != nil {
+			var err error
+			if res, err = strconv.ParseInt(string(val), 10, 64); err != nil {
+				return nil, ErrWrongValueType
+			}
+		}
+		if (incr < 0 && res < math.MinInt64-incr) || (incr > 0 && res > math.MaxInt64-incr) {
+			return nil, ErrIntegerOverflow
+		}
+		res += incr
+		return []byte(strconv.FormatInt(res, 10)), nil
+	})
+	return
+}
+
+// HIncrByFloat increments the floating point number stored at field in the hash stored at key by incr.
+// If the field does not exist, it is set to 0 before performing the operation, and the time to live of field is kept.
+// ErrWrongFloatType is returned if the value is not a float, and ErrFloatOverflow if the result is NaN or infinity.
+func (db *KVDB) HIncrByFloat(key, field []byte, incr float64) (res float64, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	err = db.hUpdate(key, field, func(val []byte) ([]byte, error) {
+		if val != nil {
+			var err error
+			res, err = strconv.ParseFloat(string(val), 64)
+			if err != nil || math.IsNaN(res) || math.IsInf(res, 0) {
+				return nil, ErrWrongFloatType
+			}
+		}
+		res += incr
+		if math.IsNaN(res) || math.IsInf(res, 0) {
+			return nil, ErrFloatOverflow
+		}
+		return []byte(strconv.FormatFloat(res, 'f', -1, 64)), nil
+	})
+	return
+}
+
+// HMSet sets the fields to their values in the hash stored at key, the fields and values are given in pairs.
+// HMSet is atomic, and the time to live of every field set is discarded like HSet.
+func (db *KVDB) HMSet(key []byte, values ...[]byte) (err error) {
+	_, err = db.HSetPairs(key, values...)
+	return
+}
+
+// HSetPairs sets the fields to their values like HMSet, and returns the number of fields added to the hash.
+func (db *KVDB) HSetPairs(key []byte, values ...[]byte) (res int, err error) {
+	if len(values) == 0 || len(values)%2 != 0 {
+		return 0, ErrWrongNumberOfArgs
+	}
+	for i := 0; i < len(values); i += 2 {
+		if err = db.checkKeyValue(key, values[i+1]); err != nil {
+			return
+		}
+	}
+
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	db.checkExpired(key, Hash)
+	for i := 0; i < len(values); i += 2 {
+		db.checkFieldExpired(key, values[i])
+		if oldVal := db.hGet(key, values[i]); oldVal != nil && bytes.Equal(oldVal, values[i+1]) {
+			continue
+		}
+		var added int
+		if added, err = db.hSet(key, values[i], values[i+1], false); err != nil {
+			return
+		}
+		res += added
+	}
+	return
+}
+
+// HMGet returns the values associated with the fields in the hash stored at key.
+// For every field that does not exist, nil is returned.
+func (db *KVDB) HMGet(key []byte, fields ...[]byte) [][]byte {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil
+	}
+
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	res := make([][]byte, 0, len(fields))
+	for _, field := range fields {
+		res = append(res, db.hGet(key, field))
+	}
+	return res
+}
+
+// HStrLen returns the length of the value associated with field in the hash stored at key.
+func (db *KVDB) HStrLen(key, field []byte) int {
+	return len(db.HGet(key, field))
+}
+
+// HRandField returns random fields of the hash stored at key, the same as SRandMember of set.
+// The fields are distinct if count is positive, otherwise the same field may be returned more than once.
+// If withValues is true, every field name is followed by its value like HGetAll.
+func (db *KVDB) HRandField(key []byte, count int, withValues bool) [][]byte {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil
+	}
+
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	if db.keyExpired(Hash, string(key)) {
+		return nil
+	}
+
+	var res [][]byte
+	for _, idx := range db.hRandField(string(key), count) {
+		res = append(res, idx.Meta.Extra)
+		if !withValues {
+			continue
+		}
+		val, err := db.getHashVal(idx)
+		if err != nil {
+			return nil
+		}
+		res = append(res, val)
+	}
+	return res
+}
+
+// HGetDel returns the values associated with the fields in the hash stored at key, and removes the fields.
+// For every field that does not exist, nil is returned.
+func (db *KVDB) HGetDel(key []byte, fields ...[]byte) (res [][]byte, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
 		return
 	}
 
-	res = db.hashIndex.indexes.HSet(string(key), string(field), value)
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	db.checkExpired(key, Hash)
+	for _, field := range fields {
+		db.checkFieldExpired(key, field)
+		val := db.hGet(key, field)
+		if val != nil {
+			if err = db.hDelField(key, field); err != nil {
+				return nil, err
+			}
+		}
+		res = append(res, val)
+	}
+	return
+}
+
+// HGetEx returns the values associated with the fields in the hash stored at key, and sets the expired time of the fields.
+// The deadline is the unix time in milliseconds, the fields are removed if it is passed.
+// If deadline is 0, the time to live of the fields is kept, and if it is negative, the time to live is discarded.
+// For every field that does not exist, nil is returned.
+func (db *KVDB) HGetEx(key []byte, deadline int64, fields ...[]byte) (res [][]byte, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	db.checkExpired(key, Hash)
+	for _, field := range fields {
+		db.checkFieldExpired(key, field)
+		val := db.hGet(key, field)
+		res = append(res, val)
+		if val == nil {
+			continue
+		}
+
+		switch {
+		case deadline > 0:
+			err = db.hFieldExpireAt(key, field, deadline)
+		case deadline < 0:
+			if _, exist := db.fieldExpires[string(key)][string(field)]; exist {
+				err = db.hFieldPersist(key, field)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 	return
 }
 
@@ -49,19 +290,30 @@ func (db *KVDB) HSetNx(key, field, value []byte) (res int, err error) {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
-	if res = db.hashIndex.indexes.HSetNx(string(key), string(field), value); res == 1 {
-		e := storage.NewEntry(key, value, field, Hash, HashHSet)
-		if err = db.store(e); err != nil {
-			return
-		}
+	db.checkExpired(key, Hash)
+	if !db.checkFieldExpired(key, field) && db.hashIndex.indexes.HExists(string(key), string(field)) == 0 {
+		return
+	}
+
+	e := storage.NewEntry(key, value, field, Hash, HashHSet)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	idx, err := db.newIndexer(e)
+	if err != nil {
+		return
 	}
+	res = db.hashIndex.indexes.HSetNx(string(key), string(field), idx)
 	return
 }
 
 // HGet returns the value associated with field in the hash stored at key.
+// Nil is returned if the field does not exist, and an empty slice if its value is empty.
 func (db *KVDB) HGet(key, field []byte) []byte {
 	if err := db.checkKeyValue(key, nil); err != nil {
 		return nil
@@ -70,17 +322,87 @@ func (db *KVDB) HGet(key, field []byte) []byte {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
-	if db.checkExpired(key, Hash) {
+	return db.hGet(key, field)
+}
+
+// HGetAll returns all fields and values of the hash stored at key.
+// In the returned value, every field name is followed by its value, so the length of the reply is twice the size of the hash.
+func (db *KVDB) HGetAll(key []byte) [][]byte {
+	if err := db.checkKeyValue(key, nil); err != nil {
 		return nil
 	}
 
-	res, _ := db.hashIndex.indexes.HGet(string(key), string(field))
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	if db.keyExpired(Hash, string(key)) {
+		return nil
+	}
+
+	indexes, _ := db.hashIndex.indexes.HGetAll(string(key))
+	expired := db.expiredFields(string(key))
+	res := make([][]byte, 0, len(indexes)*2)
+	for _, idx := range indexes {
+		if _, ok := expired[string(idx.Meta.Extra)]; ok {
+			continue
+		}
+		val, err := db.getHashVal(idx)
+		if err != nil {
+			return nil
+		}
+		res = append(res, idx.Meta.Extra, val)
+	}
 	return res
 }
 
-// HGetAll returns all fields and values of the hash stored at key.
-// In the returned value, every field name is followed by its value, so the length of the reply is twice the size of the hash.
-func (db *KVDB) HGetAll(key []byte) [][]byte {
+// HScan iterates the fields of the hash stored at key incrementally, a scan starts with cursor 0 and ends when the returned cursor is 0.
+// About count fields are visited by a call, and only the ones matching the glob-style pattern are returned,
+// every field name is followed by its value like HGetAll.
+// The fields are visited in the order of their hashes, and the cursor is the hash to continue from,
+// so the cursor keeps no state in the db and stays valid while the hash is modified.
+// Like Redis, a field existing during the whole scan is returned at least once.
+// The fields are kept in the order of their hashes only if HashOrderedFields is set,
+// otherwise every call reads all fields of the hash to select the visited ones.
+func (db *KVDB) HScan(key []byte, cursor uint64, match string, count int) ([][]byte, uint64, error) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil, 0, err
+	}
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	if db.keyExpired(Hash, string(key)) {
+		return nil, 0, nil
+	}
+	expired := db.expiredFields(string(key))
+
+	indexes, next := db.hashIndex.indexes.ScanFields(string(key), cursor, count)
+
+	res := make([][]byte, 0, len(indexes)*2)
+	for _, idx := range indexes {
+		if _, ok := expired[string(idx.Meta.Extra)]; ok {
+			continue
+		}
+		if match != "" && !utils.GlobMatch(match, string(idx.Meta.Extra)) {
+			continue
+		}
+		val, err := db.getHashVal(idx)
+		if err != nil {
+			return nil, 0, err
+		}
+		res = append(res, idx.Meta.Extra, val)
+	}
+	return res, next, nil
+}
+
+// HRangeByField returns the fields between start and end in lexicographic order and their values,
+// every field name is followed by its value like HGetAll. Both start and end are inclusive, and an empty end means no upper bound.
+// At most limit fields are returned if limit is positive, so a range can be resumed from the last field returned.
+// It is fast for large hashes only if HashOrderedFields is set, otherwise all fields of the hash are sorted.
+func (db *KVDB) HRangeByField(key, start, end []byte, limit int) [][]byte {
 	if err := db.checkKeyValue(key, nil); err != nil {
 		return nil
 	}
@@ -88,11 +410,31 @@ func (db *KVDB) HGetAll(key []byte) [][]byte {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
-	if db.checkExpired(key, Hash) {
+	if db.keyExpired(Hash, string(key)) {
 		return nil
 	}
 
-	res, _ := db.hashIndex.indexes.HGetAll(string(key))
+	// the expired fields are skipped, so more fields are read to return limit ones.
+	expired := db.expiredFields(string(key))
+	n := limit
+	if n > 0 {
+		n += len(expired)
+	}
+	indexes := db.hashIndex.indexes.HRange(string(key), string(start), string(end), n)
+	res := make([][]byte, 0, len(indexes)*2)
+	for _, idx := range indexes {
+		if limit > 0 && len(res) == limit*2 {
+			break
+		}
+		if _, ok := expired[string(idx.Meta.Extra)]; ok {
+			continue
+		}
+		val, err := db.getHashVal(idx)
+		if err != nil {
+			return nil
+		}
+		res = append(res, idx.Meta.Extra, val)
+	}
 	return res
 }
 
@@ -110,15 +452,18 @@ func (db *KVDB) HDel(key []byte, field ...[]byte) (res int, err error) {
 		return
 	}
 
+	defer db.waitSync(&err)
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
 	for _, f := range field {
-		if ok := db.hashIndex.indexes.HDel(string(key), string(f)); ok == 1 {
+		db.discardHashField(string(key), string(f))
+		if ok := db.hashIndex.indexes.HDel(string(key), string(f)); ok == 0 {
 			e := storage.NewEntry(key, nil, f, Hash, HashHDel)
 			if err = db.store(e); err != nil {
 				return
 			}
+			db.deleteFieldExpire(string(key), string(f))
 			res++
 		}
 	}
@@ -134,25 +479,26 @@ func (db *KVDB) HKeyExists(key []byte) (ok bool) {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
-	if db.checkExpired(key, Hash) {
+	if db.keyExpired(Hash, string(key)) {
 		return
 	}
 	return db.hashIndex.indexes.HKeyExists(string(key))
 }
 
 // HExists returns if field is an existing field in the hash stored at key.
+// Return 0 if the field exists, otherwise 1, the same as the index.
+// The expired keys and fields don't exist.
 func (db *KVDB) HExists(key, field []byte) int {
 	if err := db.checkKeyValue(key, nil); err != nil {
-		return 0
+		return 1
 	}
 
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
-	if db.checkExpired(key, Hash) {
-		return 0
+	if db.keyExpired(Hash, string(key)) || db.fieldExpired(string(key), string(field)) {
+		return 1
 	}
-
 	return db.hashIndex.indexes.HExists(string(key), string(field))
 }
 
@@ -165,11 +511,10 @@ func (db *KVDB) HLen(key []byte) int {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
-	if db.checkExpired(key, Hash) {
+	if db.keyExpired(Hash, string(key)) {
 		return 0
 	}
-
-	return db.hashIndex.indexes.HLen(string(key))
+	return db.hashIndex.indexes.HLen(string(key)) - len(db.expiredFields(string(key)))
 }
 
 // HKeys returns all field names in the hash stored at key.
@@ -181,12 +526,18 @@ func (db *KVDB) HKeys(key []byte) (val []string) {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
-	if db.checkExpired(key, Hash) {
+	if db.keyExpired(Hash, string(key)) {
 		return nil
 	}
 
-	res, _ := db.hashIndex.indexes.HKeys(string(key))
-	return res
+	fields, _ := db.hashIndex.indexes.HKeys(string(key))
+	expired := db.expiredFields(string(key))
+	for _, field := range fields {
+		if _, ok := expired[field]; !ok {
+			val = append(val, field)
+		}
+	}
+	return
 }
 
 // HVals returns all values in the hash stored at key.
@@ -198,12 +549,23 @@ func (db *KVDB) HVals(key []byte) (val [][]byte) {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
-	if db.checkExpired(key, Hash) {
+	if db.keyExpired(Hash, string(key)) {
 		return nil
 	}
 
-	res, _ := db.hashIndex.indexes.HVals(string(key))
-	return res
+	indexes, _ := db.hashIndex.indexes.HVals(string(key))
+	expired := db.expiredFields(string(key))
+	for _, idx := range indexes {
+		if _, ok := expired[string(idx.Meta.Extra)]; ok {
+			continue
+		}
+		v, err := db.getHashVal(idx)
+		if err != nil {
+			return nil
+		}
+		val = append(val, v)
+	}
+	return
 }
 
 // HClear clear the key in hash.
@@ -216,6 +578,7 @@ func (db *KVDB) HClear(key []byte) (err error) {
 		return ErrKeyNotExist
 	}
 
+	defer db.waitSync(&err)
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
@@ -224,8 +587,10 @@ func (db *KVDB) HClear(key []byte) (err error) {
 		return err
 	}
 
+	db.discardHashKey(string(key))
 	db.hashIndex.indexes.HClear(string(key))
 	delete(db.expires[Hash], string(key))
+	delete(db.fieldExpires, string(key))
 	return
 }
 
@@ -234,23 +599,81 @@ func (db *KVDB) HExpire(key []byte, duration int64) (err error) {
 	if duration <= 0 {
 		return ErrInvalidTTL
 	}
+	return db.hExpireAt(key, time.Now().UnixNano()/int64(time.Millisecond)+duration*1000)
+}
+
+// HPExpire set expired time for a hash key in milliseconds.
+func (db *KVDB) HPExpire(key []byte, duration int64) (err error) {
+	if duration <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.hExpireAt(key, time.Now().UnixNano()/int64(time.Millisecond)+duration)
+}
+
+// HExpireAt set the deadline of a hash key as a unix timestamp in seconds.
+// The key is removed at once if the deadline has passed.
+func (db *KVDB) HExpireAt(key []byte, timestamp int64) (err error) {
+	if timestamp <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.hExpireAt(key, timestamp*1000)
+}
+
+// hExpireAt set the deadline of a hash key in milliseconds.
+func (db *KVDB) hExpireAt(key []byte, deadline int64) (err error) {
 	if err = db.checkKeyValue(key, nil); err != nil {
 		return
 	}
-	if !db.HKeyExists(key) {
+
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	if db.checkExpired(key, Hash) || !db.hashIndex.indexes.HKeyExists(string(key)) {
 		return ErrKeyNotExist
 	}
 
+	e := storage.NewEntryWithExpire(key, nil, deadline, Hash, HashHPExpire)
+	if deadline <= db.now() {
+		e = storage.NewEntryNoExtra(key, nil, Hash, HashHClear)
+	}
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	if e.GetMark() == HashHClear {
+		db.discardHashKey(string(key))
+		db.hashIndex.indexes.HClear(string(key))
+		delete(db.expires[Hash], string(key))
+		delete(db.fieldExpires, string(key))
+		return
+	}
+	db.expires[Hash][string(key)] = deadline
+	return
+}
+
+// HPersist remove the expired time of a hash key.
+func (db *KVDB) HPersist(key []byte) (err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
 	db.hashIndex.mu.Lock()
 	defer db.hashIndex.mu.Unlock()
 
-	deadline := time.Now().Unix() + duration
-	e := storage.NewEntryWithExpire(key, nil, deadline, Hash, HashHExpire)
-	if err := db.store(e); err != nil {
-		return err
+	if db.checkExpired(key, Hash) || !db.hashIndex.indexes.HKeyExists(string(key)) {
+		return ErrKeyNotExist
+	}
+	if _, exist := db.expires[Hash][string(key)]; !exist {
+		return
 	}
 
-	db.expires[Hash][string(key)] = deadline
+	e := storage.NewEntryNoExtra(key, nil, Hash, HashHPersist)
+	if err = db.store(e); err != nil {
+		return
+	}
+	delete(db.expires[Hash], string(key))
 	return
 }
 
@@ -259,7 +682,23 @@ func (db *KVDB) HTTL(key []byte) (ttl int64) {
 	db.hashIndex.mu.RLock()
 	defer db.hashIndex.mu.RUnlock()
 
-	if db.checkExpired(key, Hash) {
+	if db.keyExpired(Hash, string(key)) {
+		return
+	}
+
+	deadline, exist := db.expires[Hash][string(key)]
+	if !exist {
+		return
+	}
+	return db.ttl(deadline)
+}
+
+// HPTTL return time to live for the key in milliseconds.
+func (db *KVDB) HPTTL(key []byte) (ttl int64) {
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	if db.keyExpired(Hash, string(key)) {
 		return
 	}
 
@@ -267,5 +706,387 @@ func (db *KVDB) HTTL(key []byte) (ttl int64) {
 	if !exist {
 		return
 	}
-	return deadline - time.Now().Unix()
-}
\ No newline at end of file
+	return deadline - db.now()
+}
+
+// HFieldExpire set the expired time of a field in the hash stored at key, duration is in seconds.
+// The field is removed after the duration, and the time to live is discarded if the field is set again.
+func (db *KVDB) HFieldExpire(key, field []byte, duration int64) (err error) {
+	if duration <= 0 {
+		return ErrInvalidTTL
+	}
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	if db.checkExpired(key, Hash) || db.checkFieldExpired(key, field) ||
+		db.hashIndex.indexes.HExists(string(key), string(field)) != 0 {
+		return ErrKeyNotExist
+	}
+	return db.hFieldExpireAt(key, field, db.now()+duration*1000)
+}
+
+// HFieldPExpireAt set the deadline of a field in the hash stored at key, timestamp is the unix time in milliseconds.
+// The field is removed at once if the timestamp is passed.
+func (db *KVDB) HFieldPExpireAt(key, field []byte, timestamp int64) (err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	if db.checkExpired(key, Hash) || db.checkFieldExpired(key, field) ||
+		db.hashIndex.indexes.HExists(string(key), string(field)) != 0 {
+		return ErrKeyNotExist
+	}
+	return db.hFieldExpireAt(key, field, timestamp)
+}
+
+// HFieldPersist remove the expired time of a field in the hash stored at key.
+func (db *KVDB) HFieldPersist(key, field []byte) (err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	if db.checkExpired(key, Hash) || db.checkFieldExpired(key, field) ||
+		db.hashIndex.indexes.HExists(string(key), string(field)) != 0 {
+		return ErrKeyNotExist
+	}
+	if _, exist := db.fieldExpires[string(key)][string(field)]; !exist {
+		return
+	}
+	return db.hFieldPersist(key, field)
+}
+
+// HFieldsPExpireAt set the deadline of the fields in the hash stored at key if the condition is met,
+// timestamp is the unix time in milliseconds. The fields are checked and set under the same lock, so it is atomic.
+// The result of every field is FieldNotExist, FieldCondNotMet, FieldTTLUpdated,
+// or FieldDeleted if the field is removed since the timestamp is passed.
+func (db *KVDB) HFieldsPExpireAt(key []byte, timestamp int64, cond FieldExpireCond, fields ...[]byte) (res []int, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	expired := db.checkExpired(key, Hash)
+	for _, field := range fields {
+		if expired || db.checkFieldExpired(key, field) || db.hashIndex.indexes.HExists(string(key), string(field)) != 0 {
+			res = append(res, FieldNotExist)
+			continue
+		}
+		deadline, hasTTL := db.fieldExpires[string(key)][string(field)]
+		if (cond == FieldExpireNX && hasTTL) || (cond == FieldExpireXX && !hasTTL) ||
+			(cond == FieldExpireGT && (!hasTTL || timestamp <= deadline)) || (cond == FieldExpireLT && hasTTL && timestamp >= deadline) {
+			res = append(res, FieldCondNotMet)
+			continue
+		}
+
+		deleted := timestamp <= db.now()
+		if err = db.hFieldExpireAt(key, field, timestamp); err != nil {
+			return nil, err
+		}
+		if deleted {
+			res = append(res, FieldDeleted)
+		} else {
+			res = append(res, FieldTTLUpdated)
+		}
+	}
+	return
+}
+
+// HFieldsPersist remove the expired time of the fields in the hash stored at key atomically.
+// The result of every field is FieldNotExist, FieldNoTTL, or FieldTTLUpdated if the expired time is removed.
+func (db *KVDB) HFieldsPersist(key []byte, fields ...[]byte) (res []int, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.hashIndex.mu.Lock()
+	defer db.hashIndex.mu.Unlock()
+
+	expired := db.checkExpired(key, Hash)
+	for _, field := range fields {
+		if expired || db.checkFieldExpired(key, field) || db.hashIndex.indexes.HExists(string(key), string(field)) != 0 {
+			res = append(res, FieldNotExist)
+			continue
+		}
+		if _, exist := db.fieldExpires[string(key)][string(field)]; !exist {
+			res = append(res, FieldNoTTL)
+			continue
+		}
+		if err = db.hFieldPersist(key, field); err != nil {
+			return nil, err
+		}
+		res = append(res, FieldTTLUpdated)
+	}
+	return
+}
+
+// HFieldsPTTL return time to live in milliseconds for the fields in the hash stored at key, they are read under the same lock.
+// The result of every field is FieldNotExist, FieldNoTTL, or its time to live.
+func (db *KVDB) HFieldsPTTL(key []byte, fields ...[]byte) []int64 {
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	res := make([]int64, 0, len(fields))
+	expired := db.keyExpired(Hash, string(key))
+	for _, field := range fields {
+		if expired || db.fieldExpired(string(key), string(field)) ||
+			db.hashIndex.indexes.HExists(string(key), string(field)) != 0 {
+			res = append(res, FieldNotExist)
+			continue
+		}
+		deadline, exist := db.fieldExpires[string(key)][string(field)]
+		if !exist {
+			res = append(res, FieldNoTTL)
+			continue
+		}
+		res = append(res, deadline-db.now())
+	}
+	return res
+}
+
+// HFieldTTL return time to live for a field in the hash stored at key.
+func (db *KVDB) HFieldTTL(key, field []byte) (ttl int64) {
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	if db.keyExpired(Hash, string(key)) || db.fieldExpired(string(key), string(field)) {
+		return
+	}
+
+	deadline, exist := db.fieldExpires[string(key)][string(field)]
+	if !exist {
+		return
+	}
+	return db.ttl(deadline)
+}
+
+// hSet writes the value of field, the time to live of field will be discarded if keepTTL is false.
+func (db *KVDB) hSet(key, field, value []byte, keepTTL bool) (res int, err error) {
+	if !keepTTL {
+		if _, exist := db.fieldExpires[string(key)][string(field)]; exist {
+			if err = db.hFieldPersist(key, field); err != nil {
+				return
+			}
+		}
+	}
+
+	e := storage.NewEntry(key, value, field, Hash, HashHSet)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	idx, err := db.newIndexer(e)
+	if err != nil {
+		return
+	}
+	db.discardHashField(string(key), string(field))
+	res = db.hashIndex.indexes.HSet(string(key), string(field), idx)
+	return
+}
+
+// hUpdate replaces the value of field with the one returned by update, which gets nil if the field does not exist.
+// The caller must hold the lock of hash, so the value can't be changed by others between reading and writing.
+func (db *KVDB) hUpdate(key, field []byte, update func(val []byte) ([]byte, error)) error {
+	var val []byte
+	if !db.checkExpired(key, Hash) && !db.checkFieldExpired(key, field) {
+		if idx, _ := db.hashIndex.indexes.HGet(string(key), string(field)); idx != nil {
+			var err error
+			if val, err = db.getHashVal(idx); err != nil {
+				return err
+			}
+			// an empty value is not a number either.
+			if val == nil {
+				val = []byte{}
+			}
+		}
+	}
+
+	newVal, err := update(val)
+	if err != nil {
+		return err
+	}
+	if err = db.checkKeyValue(key, newVal); err != nil {
+		return err
+	}
+	_, err = db.hSet(key, field, newVal, true)
+	return err
+}
+
+// HFieldPTTL return time to live for a field in the hash stored at key in milliseconds.
+func (db *KVDB) HFieldPTTL(key, field []byte) (ttl int64) {
+	db.hashIndex.mu.RLock()
+	defer db.hashIndex.mu.RUnlock()
+
+	if db.keyExpired(Hash, string(key)) || db.fieldExpired(string(key), string(field)) {
+		return
+	}
+
+	deadline, exist := db.fieldExpires[string(key)][string(field)]
+	if !exist {
+		return
+	}
+	return deadline - db.now()
+}
+
+// hFieldExpireAt set the deadline of an existing field in milliseconds, the field is removed if the deadline is passed.
+func (db *KVDB) hFieldExpireAt(key, field []byte, deadline int64) (err error) {
+	if deadline <= db.now() {
+		return db.hDelField(key, field)
+	}
+
+	// the extra is the field, so the time when it is written is saved in the value, see writtenAfterAsOf.
+	e := storage.NewEntry(key, []byte(strconv.FormatInt(time.Now().UnixNano(), 10)), field, Hash, HashHFieldExpire)
+	e.Timestamp = uint64(deadline)
+	if err = db.store(e); err != nil {
+		return
+	}
+	db.setFieldExpire(string(key), string(field), deadline)
+	return
+}
+
+// hDelField removes an existing field with its time to live.
+func (db *KVDB) hDelField(key, field []byte) (err error) {
+	e := storage.NewEntry(key, nil, field, Hash, HashHDel)
+	if err = db.store(e); err != nil {
+		return
+	}
+	db.discardHashField(string(key), string(field))
+	db.hashIndex.indexes.HDel(string(key), string(field))
+	db.deleteFieldExpire(string(key), string(field))
+	return
+}
+
+func (db *KVDB) hFieldPersist(key, field []byte) (err error) {
+	e := storage.NewEntry(key, nil, field, Hash, HashHFieldPersist)
+	if err = db.store(e); err != nil {
+		return
+	}
+	db.deleteFieldExpire(string(key), string(field))
+	return
+}
+
+// checkFieldExpired removes the field if it is expired, the caller must hold the lock of hash.
+func (db *KVDB) checkFieldExpired(key, field []byte) (expired bool) {
+	deadline, exist := db.fieldExpires[string(key)][string(field)]
+	if !exist || db.now() <= deadline {
+		return
+	}
+
+	if err := db.hDelField(key, field); err != nil {
+		log.Println("checkFieldExpired: store entry err: ", err)
+		return
+	}
+	return true
+}
+
+// fieldExpired reports whether the field is expired without removing it, the caller must hold the read lock of hash.
+func (db *KVDB) fieldExpired(key, field string) bool {
+	deadline, exist := db.fieldExpires[key][field]
+	return exist && db.now() > deadline
+}
+
+// expiredFields returns the expired fields of key which are not removed yet, the caller must hold the read lock of hash.
+// They are still in the index, and skipped by the reads.
+func (db *KVDB) expiredFields(key string) map[string]struct{} {
+	var expired map[string]struct{}
+	for field := range db.fieldExpires[key] {
+		if db.fieldExpired(key, field) {
+			if expired == nil {
+				expired = make(map[string]struct{})
+			}
+			expired[field] = struct{}{}
+		}
+	}
+	return expired
+}
+
+// hRandField returns the index info of random fields which are not expired, see HRandField.
+func (db *KVDB) hRandField(key string, count int) []*index.Indexer {
+	expired := db.expiredFields(key)
+	if len(expired) == 0 {
+		return db.hashIndex.indexes.HRandField(key, count)
+	}
+
+	var res []*index.Indexer
+	if count > 0 {
+		for _, idx := range db.hashIndex.indexes.HRandField(key, count+len(expired)) {
+			if _, ok := expired[string(idx.Meta.Extra)]; !ok && len(res) < count {
+				res = append(res, idx)
+			}
+		}
+		return res
+	}
+
+	// the same field may be returned more than once, so draw again for an expired one.
+	if db.hashIndex.indexes.HLen(key) == len(expired) {
+		return res
+	}
+	for len(res) < -count {
+		idx := db.hashIndex.indexes.HRandField(key, -1)[0]
+		if _, ok := expired[string(idx.Meta.Extra)]; !ok {
+			res = append(res, idx)
+		}
+	}
+	return res
+}
+
+func (db *KVDB) setFieldExpire(key, field string, deadline int64) {
+	if db.fieldExpires[key] == nil {
+		db.fieldExpires[key] = make(map[string]int64)
+	}
+	db.fieldExpires[key][field] = deadline
+}
+
+func (db *KVDB) deleteFieldExpire(key, field string) {
+	delete(db.fieldExpires[key], field)
+	if len(db.fieldExpires[key]) == 0 {
+		delete(db.fieldExpires, key)
+	}
+}
+
+// hGet returns the value of field without locking, the caller must hold the lock of hash.
+func (db *KVDB) hGet(key, field []byte) []byte {
+	if db.keyExpired(Hash, string(key)) || db.fieldExpired(string(key), string(field)) {
+		return nil
+	}
+
+	idx, _ := db.hashIndex.indexes.HGet(string(key), string(field))
+	res, err := db.getHashVal(idx)
+	if err != nil {
+		return nil
+	}
+	// an empty value is told from a missing field.
+	if res == nil {
+		res = []byte{}
+	}
+	return res
+}
+
+// getHashVal returns the value of a field according to its index info.
+// In KeyValueMemMode the value is kept in memory, otherwise it will be read from the db file.
+func (db *KVDB) getHashVal(idx *index.Indexer) ([]byte, error) {
+	if idx == nil {
+		return nil, ErrKeyNotExist
+	}
+
+	if db.config.IdxMode == KeyValueMemMode {
+		return idx.Meta.Value, nil
+	}
+	return db.readValue(Hash, idx)
+}
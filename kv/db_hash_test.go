@@ -0,0 +1,856 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHashKeyOnlyMemMode(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.IdxMode = KeyOnlyMemMode
+		cfg.BlockSize = 4 * 1024
+		cfg.ReclaimThreshold = 2
+	})
+
+	key := []byte("blobs")
+	value := func(i int) []byte {
+		return bytes.Repeat([]byte{byte('a' + i%26)}, 512)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := db.HSet(key, []byte(fmt.Sprintf("f%02d", i)), value(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// overwrite half of the fields, so the old values are garbage to reclaim.
+	for i := 0; i < 10; i++ {
+		if _, err := db.HSet(key, []byte(fmt.Sprintf("f%02d", i)), value(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(db *KVDB) {
+		t.Helper()
+		for i := 0; i < 20; i++ {
+			field := fmt.Sprintf("f%02d", i)
+			idx, _ := db.hashIndex.indexes.HGet(string(key), field)
+			if idx == nil || idx.Meta.Value != nil {
+				t.Fatalf("the value of %s is kept in memory", field)
+			}
+
+			want := value(i)
+			if i < 10 {
+				want = value(i + 1)
+			}
+			assertBytes(t, db.HGet(key, []byte(field)), string(want))
+		}
+		if all := db.HGetAll(key); len(all) != 40 {
+			t.Fatalf("got %d fields and values, want 40", len(all))
+		}
+		if vals := db.HVals(key); len(vals) != 20 || len(vals[0]) != 512 {
+			t.Fatalf("got %d values, want 20", len(vals))
+		}
+	}
+
+	check(db)
+	if len(db.archFiles[Hash]) < 2 {
+		t.Fatalf("got %d archived files, want at least 2", len(db.archFiles[Hash]))
+	}
+
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+
+	db = reopenTestDB(t, db)
+	check(db)
+}
+
+func TestHashTTL(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	key := []byte("hash")
+	if _, err := db.HSet(key, []byte("f"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HPExpire(key, 0); err != ErrInvalidTTL {
+		t.Fatalf("HPExpire with 0: got %v, want ErrInvalidTTL", err)
+	}
+	if err := db.HPExpire([]byte("missing"), 1000); err != ErrKeyNotExist {
+		t.Fatalf("HPExpire a missing key: got %v, want ErrKeyNotExist", err)
+	}
+	if err := db.HPExpire(key, 100500); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := db.HPTTL(key); ttl <= 100000 || ttl > 100500 {
+		t.Fatalf("HPTTL: got %d, want about 100500", ttl)
+	}
+	if ttl := db.HTTL(key); ttl < 100 || ttl > 101 {
+		t.Fatalf("HTTL: got %d, want about 101", ttl)
+	}
+
+	// the deadline in milliseconds is replayed from the db files.
+	db = reopenTestDB(t, db)
+	if ttl := db.HPTTL(key); ttl <= 100000 || ttl > 100500 {
+		t.Fatalf("HPTTL after reopening: got %d, want about 100500", ttl)
+	}
+
+	if err := db.HPersist(key); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if ttl := db.HPTTL(key); ttl != 0 {
+		t.Fatalf("HPTTL after persisting: got %d, want 0", ttl)
+	}
+	assertBytes(t, db.HGet(key, []byte("f")), "v")
+
+	deadline := time.Now().Add(time.Hour).Unix()
+	if err := db.HExpireAt(key, deadline); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := db.HTTL(key); ttl < 3599 || ttl > 3600 {
+		t.Fatalf("HTTL after HExpireAt: got %d, want 3600", ttl)
+	}
+	// the key is removed at once if the deadline has passed.
+	if err := db.HExpireAt(key, time.Now().Add(-time.Second).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if db.HLen(key) != 0 || db.HGet(key, []byte("f")) != nil {
+		t.Fatal("the hash with a past deadline is not removed")
+	}
+	db = reopenTestDB(t, db)
+	if db.HLen(key) != 0 {
+		t.Fatal("the hash with a past deadline is back after reopening")
+	}
+
+	// the deadline has millisecond precision.
+	if _, err := db.HSet(key, []byte("f"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HPExpire(key, 30); err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, db.HGet(key, []byte("f")), "v")
+	time.Sleep(50 * time.Millisecond)
+	if db.HGet(key, []byte("f")) != nil || db.HPTTL(key) != 0 {
+		t.Fatal("the hash is not expired after its deadline in milliseconds")
+	}
+}
+
+// The expire, persist and clear entries of hash are replayed from the db files and kept by Reclaim.
+func TestHashTTLReclaim(t *testing.T) {
+	for name, update := range testConfigs() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				update(cfg)
+				smallFiles(cfg)
+			})
+
+			for i := 0; i < 30; i++ {
+				key := []byte(fmt.Sprintf("h%02d", i))
+				if _, err := db.HSet(key, []byte("f"), []byte(fmt.Sprintf("v%d", i))); err != nil {
+					t.Fatal(err)
+				}
+				if err := db.HPExpire(key, 500000); err != nil {
+					t.Fatal(err)
+				}
+				switch i % 3 {
+				case 0:
+					if err := db.HPersist(key); err != nil {
+						t.Fatal(err)
+					}
+				case 1:
+					if err := db.HExpireAt(key, time.Now().Add(time.Hour).Unix()); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := db.HExpireAt([]byte("h02"), time.Now().Add(-time.Second).Unix()); err != nil {
+				t.Fatal(err)
+			}
+
+			check := func(db *KVDB) {
+				t.Helper()
+				for i := 0; i < 30; i++ {
+					key := []byte(fmt.Sprintf("h%02d", i))
+					if i == 2 {
+						if db.HLen(key) != 0 {
+							t.Fatal("the cleared hash is back")
+						}
+						continue
+					}
+					assertBytes(t, db.HGet(key, []byte("f")), fmt.Sprintf("v%d", i))
+
+					ttl := db.HTTL(key)
+					switch i % 3 {
+					case 0:
+						if ttl != 0 {
+							t.Fatalf("HTTL of the persisted %s: got %d, want 0", key, ttl)
+						}
+					case 1:
+						if ttl < 3590 || ttl > 3600 {
+							t.Fatalf("HTTL of %s: got %d, want 3600", key, ttl)
+						}
+					case 2:
+						if ttl < 490 || ttl > 500 {
+							t.Fatalf("HTTL of %s: got %d, want 500", key, ttl)
+						}
+					}
+				}
+			}
+			check(db)
+
+			if err := db.Reclaim(); err != nil {
+				t.Fatal(err)
+			}
+			check(db)
+			db = reopenTestDB(t, db)
+			check(db)
+		})
+	}
+}
+
+func TestHashFieldTTL(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	key := []byte("hash")
+	for _, field := range []string{"a", "b", "c"} {
+		if _, err := db.HSet(key, []byte(field), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.HFieldExpire(key, []byte("a"), 0); err != ErrInvalidTTL {
+		t.Fatalf("HFieldExpire with 0: got %v, want ErrInvalidTTL", err)
+	}
+	if err := db.HFieldExpire(key, []byte("missing"), 100); err != ErrKeyNotExist {
+		t.Fatalf("HFieldExpire a missing field: got %v, want ErrKeyNotExist", err)
+	}
+	if err := db.HFieldExpire(key, []byte("a"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HFieldPExpireAt(key, []byte("b"), time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := db.HFieldTTL(key, []byte("a")); ttl < 99 || ttl > 100 {
+		t.Fatalf("HFieldTTL: got %d, want 100", ttl)
+	}
+	if ttl := db.HFieldPTTL(key, []byte("b")); ttl <= 3590000 || ttl > 3600000 {
+		t.Fatalf("HFieldPTTL: got %d, want about 3600000", ttl)
+	}
+	if ttl := db.HFieldTTL(key, []byte("c")); ttl != 0 {
+		t.Fatalf("HFieldTTL of a field without time to live: got %d", ttl)
+	}
+
+	// the deadlines of fields are replayed from the db files.
+	db = reopenTestDB(t, db)
+	if ttl := db.HFieldTTL(key, []byte("a")); ttl < 99 || ttl > 100 {
+		t.Fatalf("HFieldTTL after reopening: got %d, want 100", ttl)
+	}
+
+	// the time to live is discarded by persisting or setting the field again.
+	if err := db.HFieldPersist(key, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HSet(key, []byte("b"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if db.HFieldTTL(key, []byte("a")) != 0 || db.HFieldTTL(key, []byte("b")) != 0 {
+		t.Fatal("the time to live of field is kept")
+	}
+
+	// the field is removed at once if the deadline has passed.
+	if err := db.HFieldPExpireAt(key, []byte("c"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if db.HGet(key, []byte("c")) != nil || db.HLen(key) != 2 {
+		t.Fatal("the field with a past deadline is not removed")
+	}
+
+	// the deadline has millisecond precision.
+	if err := db.HFieldPExpireAt(key, []byte("a"), time.Now().Add(30*time.Millisecond).UnixNano()/int64(time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, db.HGet(key, []byte("a")), "v")
+	time.Sleep(50 * time.Millisecond)
+	if db.HGet(key, []byte("a")) != nil || db.HLen(key) != 1 {
+		t.Fatal("the field is not expired after its deadline in milliseconds")
+	}
+	// the write removes the expired field before writing.
+	if res, err := db.HSetNx(key, []byte("a"), []byte("new")); err != nil || res != 1 {
+		t.Fatalf("HSetNx the expired field: got %d, %v", res, err)
+	}
+	db = reopenTestDB(t, db)
+	assertBytes(t, db.HGet(key, []byte("a")), "new")
+	if db.HFieldTTL(key, []byte("a")) != 0 {
+		t.Fatal("the time to live of the expired field is kept")
+	}
+}
+
+// The expire and persist entries of fields are replayed from the db files and kept by Reclaim.
+func TestHashFieldTTLReclaim(t *testing.T) {
+	for name, update := range testConfigs() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				update(cfg)
+				smallFiles(cfg)
+			})
+
+			key := []byte("hash")
+			for i := 0; i < 30; i++ {
+				field := []byte(fmt.Sprintf("f%02d", i))
+				if _, err := db.HSet(key, field, []byte(fmt.Sprintf("v%d", i))); err != nil {
+					t.Fatal(err)
+				}
+				if err := db.HFieldExpire(key, field, 1000); err != nil {
+					t.Fatal(err)
+				}
+				switch i % 3 {
+				case 0:
+					if err := db.HFieldPersist(key, field); err != nil {
+						t.Fatal(err)
+					}
+				case 1:
+					if err := db.HFieldExpire(key, field, 500); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			// the field expired but not removed is dropped by reclaim.
+			expireFieldNow(db, "hash", "f02")
+
+			check := func(db *KVDB) {
+				t.Helper()
+				if n := db.HLen(key); n != 29 {
+					t.Fatalf("HLen: got %d, want 29", n)
+				}
+				for i := 0; i < 30; i++ {
+					field := []byte(fmt.Sprintf("f%02d", i))
+					if i == 2 {
+						if db.HGet(key, field) != nil {
+							t.Fatal("the expired field is back")
+						}
+						continue
+					}
+					assertBytes(t, db.HGet(key, field), fmt.Sprintf("v%d", i))
+
+					ttl := db.HFieldTTL(key, field)
+					want := map[int]int64{0: 0, 1: 500, 2: 1000}[i%3]
+					if ttl < want-10 || ttl > want {
+						t.Fatalf("HFieldTTL of %s: got %d, want %d", field, ttl, want)
+					}
+				}
+			}
+			check(db)
+
+			if err := db.Reclaim(); err != nil {
+				t.Fatal(err)
+			}
+			check(db)
+			db = reopenTestDB(t, db)
+			check(db)
+		})
+	}
+}
+
+// hScanAll runs a whole scan of the hash, and returns the fields found with their values.
+func hScanAll(t *testing.T, db *KVDB, key, match string, count int) map[string]string {
+	t.Helper()
+
+	res := make(map[string]string)
+	var cursor uint64
+	for calls := 0; ; calls++ {
+		if calls > 100000 {
+			t.Fatal("the scan does not end")
+		}
+		pairs, next, err := db.HScan([]byte(key), cursor, match, count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pairs) > 4*count {
+			t.Fatalf("got %d fields by a call of count %d", len(pairs)/2, count)
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			res[string(pairs[i])] = string(pairs[i+1])
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	return res
+}
+
+func TestHScan(t *testing.T) {
+	for name, ordered := range map[string]bool{"Unordered": false, "Ordered": true} {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				cfg.HashOrderedFields = ordered
+			})
+
+			want := make(map[string]string)
+			for i := 0; i < 50; i++ {
+				field, val := fmt.Sprintf("f%d", i), fmt.Sprintf("v%d", i)
+				if _, err := db.HSet([]byte("hash"), []byte(field), []byte(val)); err != nil {
+					t.Fatal(err)
+				}
+				want[field] = val
+			}
+			for _, count := range []int{1, 7, 100} {
+				if got := hScanAll(t, db, "hash", "", count); fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Fatalf("count %d: got %v, want %v", count, got, want)
+				}
+			}
+			got := hScanAll(t, db, "hash", "f1?", 3)
+			if len(got) != 10 || got["f10"] != "v10" || got["f19"] != "v19" {
+				t.Fatalf("got the matched fields %v", got)
+			}
+
+			// the deleted and expired fields are skipped.
+			if _, err := db.HDel([]byte("hash"), []byte("f0"), []byte("f1")); err != nil {
+				t.Fatal(err)
+			}
+			expireFieldNow(db, "hash", "f2")
+			delete(want, "f0")
+			delete(want, "f1")
+			delete(want, "f2")
+			if got := hScanAll(t, db, "hash", "", 4); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+
+			if got := hScanAll(t, db, "missing", "", 4); len(got) != 0 {
+				t.Fatalf("HScan a missing key: got %v", got)
+			}
+			if err := db.HClear([]byte("hash")); err != nil {
+				t.Fatal(err)
+			}
+			if got := hScanAll(t, db, "hash", "", 4); len(got) != 0 {
+				t.Fatalf("HScan a cleared key: got %v", got)
+			}
+		})
+	}
+}
+
+// The fields selected for an unordered hash are the same as the ones kept in order of their hashes.
+func TestHScanUnorderedSelection(t *testing.T) {
+	var dbs []*KVDB
+	for _, ordered := range []bool{false, true} {
+		db := openTestDB(t, func(cfg *Config) {
+			cfg.HashOrderedFields = ordered
+		})
+		for i := 0; i < 50; i++ {
+			if _, err := db.HSet([]byte("hash"), []byte(fmt.Sprintf("f%d", i)), []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dbs = append(dbs, db)
+	}
+
+	var cursor uint64
+	for {
+		unordered, next, err := dbs[0].HScan([]byte("hash"), cursor, "", 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ordered, orderedNext, err := dbs[1].HScan([]byte("hash"), cursor, "", 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(unordered) != fmt.Sprint(ordered) || next != orderedNext {
+			t.Fatalf("cursor %d: got %q and %d, want %q and %d", cursor, unordered, next, ordered, orderedNext)
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+}
+
+// The fields existing during the whole scan are returned while the others are added and removed, run it with -race.
+func TestHScanConcurrentWrites(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	for i := 0; i < 200; i++ {
+		if _, err := db.HSet([]byte("hash"), []byte(fmt.Sprintf("stable%d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			field := []byte(fmt.Sprintf("temp%d", i%50))
+			if _, err := db.HSet([]byte("hash"), field, []byte("v")); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := db.HDel([]byte("hash"), field); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for n := 0; n < 5; n++ {
+		got := hScanAll(t, db, "hash", "stable*", 7)
+		if len(got) != 200 {
+			t.Fatalf("got %d stable fields, want 200", len(got))
+		}
+	}
+	close(stop)
+	<-done
+}
+
+// The order of fields is rebuilt when the db is reopened and reclaimed.
+func TestHScanReopenReclaim(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	want := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		field := fmt.Sprintf("f%d", i)
+		if _, err := db.HSet([]byte("hash"), []byte(field), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			want[field] = "v"
+		} else if _, err := db.HDel([]byte("hash"), []byte(field)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(db *KVDB) {
+		t.Helper()
+		if got := hScanAll(t, db, "hash", "", 6); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	check(db)
+
+	db = reopenTestDB(t, db)
+	check(db)
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	check(reopenTestDB(t, db))
+}
+
+func TestHIncrBy(t *testing.T) {
+	for name, update := range testConfigs() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, update)
+			key, field := []byte("counters"), []byte("n")
+
+			for _, tt := range []struct {
+				incr, want int64
+			}{{5, 5}, {-7, -2}, {0, -2}} {
+				if got, err := db.HIncrBy(key, field, tt.incr); err != nil || got != tt.want {
+					t.Fatalf("HIncrBy %d: got %d, %v, want %d", tt.incr, got, err, tt.want)
+				}
+			}
+			assertBytes(t, db.HGet(key, field), "-2")
+
+			// an empty value is set for a missing field, and it is not a number.
+			if _, err := db.HSet(key, []byte("empty"), []byte{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.HIncrBy(key, []byte("empty"), 1); err != ErrWrongValueType {
+				t.Fatalf("HIncrBy an empty value: got %v, want ErrWrongValueType", err)
+			}
+			if n := db.HLen(key); n != 2 {
+				t.Fatalf("HLen: got %d, want 2", n)
+			}
+
+			for val, want := range map[string]error{
+				"abc": ErrWrongValueType, "1.5": ErrWrongValueType, "": ErrWrongValueType,
+				"9223372036854775807": ErrIntegerOverflow,
+			} {
+				if _, err := db.HSet(key, []byte("f"), []byte(val)); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.HIncrBy(key, []byte("f"), 1); err != want {
+					t.Fatalf("HIncrBy %q: got %v, want %v", val, err, want)
+				}
+				if got := db.HGet(key, []byte("f")); string(got) != val {
+					t.Fatalf("the value is changed by a failed HIncrBy: got %q, want %q", got, val)
+				}
+			}
+			if _, err := db.HSet(key, []byte("f"), []byte("-9223372036854775808")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.HIncrBy(key, []byte("f"), -1); err != ErrIntegerOverflow {
+				t.Fatalf("HIncrBy the min int64: got %v, want ErrIntegerOverflow", err)
+			}
+
+			// the time to live of field is kept, and an expired field starts from 0.
+			if err := db.HFieldExpire(key, field, 100); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.HIncrBy(key, field, 1); err != nil {
+				t.Fatal(err)
+			}
+			if ttl := db.HFieldTTL(key, field); ttl < 99 || ttl > 100 {
+				t.Fatalf("HFieldTTL after HIncrBy: got %d, want about 100", ttl)
+			}
+			expireFieldNow(db, string(key), string(field))
+			if got, err := db.HIncrBy(key, field, 3); err != nil || got != 3 {
+				t.Fatalf("HIncrBy an expired field: got %d, %v, want 3", got, err)
+			}
+
+			db = reopenTestDB(t, db)
+			assertBytes(t, db.HGet(key, field), "3")
+			if ttl := db.HFieldTTL(key, field); ttl != 0 {
+				t.Fatalf("HFieldTTL of the field expired before HIncrBy: got %d, want 0", ttl)
+			}
+		})
+	}
+}
+
+func TestHIncrByFloat(t *testing.T) {
+	db := openTestDB(t, nil)
+	key, field := []byte("counters"), []byte("x")
+
+	for _, tt := range []struct {
+		incr, want float64
+	}{{1.5, 1.5}, {-0.25, 1.25}, {1e3, 1001.25}} {
+		if got, err := db.HIncrByFloat(key, field, tt.incr); err != nil || got != tt.want {
+			t.Fatalf("HIncrByFloat %v: got %v, %v, want %v", tt.incr, got, err, tt.want)
+		}
+	}
+	assertBytes(t, db.HGet(key, field), "1001.25")
+	// the integers are floats too.
+	if _, err := db.HSet(key, field, []byte("10")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.HIncrByFloat(key, field, 0.5); err != nil || got != 10.5 {
+		t.Fatalf("HIncrByFloat an integer: got %v, %v, want 10.5", got, err)
+	}
+
+	for val, want := range map[string]error{
+		"abc": ErrWrongFloatType, "": ErrWrongFloatType, "NaN": ErrWrongFloatType, "inf": ErrWrongFloatType,
+		"1.7976931348623157e308": ErrFloatOverflow,
+	} {
+		if _, err := db.HSet(key, []byte("f"), []byte(val)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.HIncrByFloat(key, []byte("f"), 1.7976931348623157e308); err != want {
+			t.Fatalf("HIncrByFloat %q: got %v, want %v", val, err, want)
+		}
+		if got := db.HGet(key, []byte("f")); string(got) != val {
+			t.Fatalf("the value is changed by a failed HIncrByFloat: got %q, want %q", got, val)
+		}
+	}
+
+	db = reopenTestDB(t, db)
+	assertBytes(t, db.HGet(key, field), "10.5")
+}
+
+// The increments of concurrent clients are not lost, run it with -race.
+func TestHIncrByConcurrently(t *testing.T) {
+	db := openTestDB(t, nil)
+	key := []byte("counters")
+
+	runConcurrently(8, func() {
+		for i := 0; i < 50; i++ {
+			if _, err := db.HIncrBy(key, []byte("n"), 1); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := db.HIncrByFloat(key, []byte("x"), 0.5); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+	assertBytes(t, db.HGet(key, []byte("n")), "400")
+	assertBytes(t, db.HGet(key, []byte("x")), "200")
+}
+
+// The values incremented are kept by reclaim.
+func TestHIncrByReclaim(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+	key := []byte("counters")
+
+	for i := 0; i < 100; i++ {
+		if _, err := db.HIncrBy(key, []byte(fmt.Sprintf("n%d", i%5)), int64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(db *KVDB) {
+		t.Helper()
+		for i := 0; i < 5; i++ {
+			// n0 is the sum of 0, 5, ..., 95, and so on.
+			assertBytes(t, db.HGet(key, []byte(fmt.Sprintf("n%d", i))), fmt.Sprint(950+20*i))
+		}
+	}
+	check(db)
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	check(reopenTestDB(t, db))
+}
+
+func TestHFieldsPExpireAt(t *testing.T) {
+	db := openTestDB(t, nil)
+	key := []byte("hash")
+	for _, f := range []string{"a", "b", "c"} {
+		if _, err := db.HSet(key, []byte(f), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	assertResults := func(got []int, err error, want ...int) {
+		t.Helper()
+		if err != nil || fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("got %v, %v, want %v", got, err, want)
+		}
+	}
+
+	fields := [][]byte{[]byte("a"), []byte("missing")}
+	res, err := db.HFieldsPExpireAt(key, now+100000, FieldExpireXX, fields...)
+	assertResults(res, err, FieldCondNotMet, FieldNotExist)
+	res, err = db.HFieldsPExpireAt(key, now+100000, FieldExpireNX, fields...)
+	assertResults(res, err, FieldTTLUpdated, FieldNotExist)
+	res, err = db.HFieldsPExpireAt(key, now+200000, FieldExpireNX, fields...)
+	assertResults(res, err, FieldCondNotMet, FieldNotExist)
+	// a field without expired time lives forever.
+	res, err = db.HFieldsPExpireAt(key, now+200000, FieldExpireGT, []byte("a"), []byte("b"))
+	assertResults(res, err, FieldTTLUpdated, FieldCondNotMet)
+	res, err = db.HFieldsPExpireAt(key, now+150000, FieldExpireGT, []byte("a"))
+	assertResults(res, err, FieldCondNotMet)
+	res, err = db.HFieldsPExpireAt(key, now+150000, FieldExpireLT, []byte("a"), []byte("b"))
+	assertResults(res, err, FieldTTLUpdated, FieldTTLUpdated)
+	res, err = db.HFieldsPExpireAt(key, now+300000, FieldExpireXX, []byte("b"))
+	assertResults(res, err, FieldTTLUpdated)
+
+	if got := db.HFieldsPTTL(key, []byte("a"), []byte("b"), []byte("c"), []byte("missing")); len(got) != 4 ||
+		got[0] < 140000 || got[0] > 150000 || got[1] < 290000 || got[1] > 300000 || got[2] != FieldNoTTL || got[3] != FieldNotExist {
+		t.Fatalf("HFieldsPTTL: got %v", got)
+	}
+
+	res, err = db.HFieldsPersist(key, []byte("a"), []byte("c"), []byte("missing"))
+	assertResults(res, err, FieldTTLUpdated, FieldNoTTL, FieldNotExist)
+	// the passed deadline removes the field.
+	res, err = db.HFieldsPExpireAt(key, now-1, FieldExpireAlways, []byte("c"), []byte("c"))
+	assertResults(res, err, FieldDeleted, FieldNotExist)
+
+	db = reopenTestDB(t, db)
+	if got := db.HFieldsPTTL(key, []byte("a"), []byte("b"), []byte("c")); len(got) != 3 ||
+		got[0] != FieldNoTTL || got[1] < 290000 || got[1] > 300000 || got[2] != FieldNotExist {
+		t.Fatalf("HFieldsPTTL after reopening: got %v", got)
+	}
+	expireNow(db, Hash, "hash")
+	res, err = db.HFieldsPersist(key, []byte("b"))
+	assertResults(res, err, FieldNotExist)
+}
+
+// Only one of the concurrent NX calls sets the expired time, since the condition is checked under the same lock.
+func TestHFieldsPExpireAtConcurrently(t *testing.T) {
+	db := openTestDB(t, nil)
+	key := []byte("hash")
+	if _, err := db.HSet(key, []byte("f"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	var updated int32
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	runConcurrently(8, func() {
+		for i := 0; i < 20; i++ {
+			res, err := db.HFieldsPExpireAt(key, now+100000, FieldExpireNX, []byte("f"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res[0] == FieldTTLUpdated {
+				atomic.AddInt32(&updated, 1)
+			}
+			db.HFieldsPTTL(key, []byte("f"))
+		}
+	})
+	if updated != 1 {
+		t.Fatalf("the expired time is set %d times, want once", updated)
+	}
+}
+
+func TestHExists(t *testing.T) {
+	db := openTestDB(t, nil)
+	key := []byte("hash")
+	for _, f := range []string{"a", "b"} {
+		if _, err := db.HSet(key, []byte(f), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expireFieldNow(db, "hash", "b")
+
+	for _, tt := range []struct {
+		key, field string
+		want       int
+	}{{"hash", "a", 0}, {"hash", "b", 1}, {"hash", "missing", 1}, {"missing", "a", 1}, {"", "a", 1}} {
+		if got := db.HExists([]byte(tt.key), []byte(tt.field)); got != tt.want {
+			t.Fatalf("HExists %q %q: got %d, want %d", tt.key, tt.field, got, tt.want)
+		}
+	}
+	expireNow(db, Hash, "hash")
+	if got := db.HExists(key, []byte("a")); got != 1 {
+		t.Fatalf("HExists in an expired hash: got %d, want 1", got)
+	}
+}
+
+func TestHSetPairs(t *testing.T) {
+	db := openTestDB(t, nil)
+	key := []byte("hash")
+
+	for _, tt := range []struct {
+		field string
+		want  int
+	}{{"a", 1}, {"a", 0}, {"b", 1}} {
+		if got, err := db.HSet(key, []byte(tt.field), []byte("v")); err != nil || got != tt.want {
+			t.Fatalf("HSet %q: got %d, %v, want %d", tt.field, got, err, tt.want)
+		}
+	}
+	expireFieldNow(db, "hash", "b")
+
+	// the expired field is added again, and a field given twice is added once.
+	added, err := db.HSetPairs(key, []byte("a"), []byte("x"), []byte("b"), []byte("x"), []byte("c"), []byte("1"),
+		[]byte("c"), []byte("2"))
+	if err != nil || added != 2 {
+		t.Fatalf("HSetPairs: got %d, %v, want 2", added, err)
+	}
+	assertBytes(t, db.HGet(key, []byte("c")), "2")
+	if _, err := db.HSetPairs(key, []byte("a")); err != ErrWrongNumberOfArgs {
+		t.Fatalf("HSetPairs without value: got %v, want ErrWrongNumberOfArgs", err)
+	}
+}
+
+func TestHGetEmptyValue(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.IdxMode = KeyOnlyMemMode
+	})
+	key := []byte("hash")
+	if _, err := db.HSet(key, []byte("empty"), []byte{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if val := db.HGet(key, []byte("empty")); val == nil || len(val) != 0 {
+		t.Fatalf("HGet an empty value: got %v", val)
+	}
+	if val := db.HGet(key, []byte("missing")); val != nil {
+		t.Fatalf("HGet a missing field: got %v, want nil", val)
+	}
+	// the field with an empty value is removed too.
+	if vals, err := db.HGetDel(key, []byte("empty")); err != nil || len(vals) != 1 || vals[0] == nil {
+		t.Fatalf("HGetDel an empty value: got %v, %v", vals, err)
+	}
+	if n := db.HLen(key); n != 0 {
+		t.Fatalf("got %d fields after HGetDel, want 0", n)
+	}
+}
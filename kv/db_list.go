@@ -0,0 +1,372 @@
+package kv
+
+import (
+	"MetaDB/kv/ds/list"
+	"MetaDB/kv/storage"
+
+	"bytes"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ListIdx the list index.
+type ListIdx struct {
+	mu      *sync.RWMutex
+	indexes *list.List
+}
+
+func newListIdx() *ListIdx {
+	return &ListIdx{indexes: list.New(), mu: new(sync.RWMutex)}
+}
+
+// LPush insert all the specified values at the head of the list stored at key.
+// If key does not exist, it is created as empty list before performing the push operations.
+// Return the length of the list after the push operations.
+func (db *KVDB) LPush(key []byte, values ...[]byte) (res int, err error) {
+	return db.push(key, ListLPush, values...)
+}
+
+// RPush insert all the specified values at the tail of the list stored at key.
+// If key does not exist, it is created as empty list before performing the push operation.
+// Return the length of the list after the push operations.
+func (db *KVDB) RPush(key []byte, values ...[]byte) (res int, err error) {
+	return db.push(key, ListRPush, values...)
+}
+
+// LPop removes and returns the first elements of the list stored at key.
+func (db *KVDB) LPop(key []byte) ([]byte, error) {
+	return db.pop(key, ListLPop)
+}
+
+// RPop Removes and returns the last elements of the list stored at key.
+func (db *KVDB) RPop(key []byte) ([]byte, error) {
+	return db.pop(key, ListRPop)
+}
+
+// LIndex returns the element at index index in the list stored at key.
+// The index is zero-based, so 0 means the first element, 1 the second element and so on.
+// Negative indices can be used to designate elements starting at the tail of the list. Here, -1 means the last element, -2 means the penultimate and so forth.
+func (db *KVDB) LIndex(key []byte, idx int) []byte {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil
+	}
+
+	db.listIndex.mu.RLock()
+	defer db.listIndex.mu.RUnlock()
+
+	if db.keyExpired(List, string(key)) {
+		return nil
+	}
+	return db.listIndex.indexes.LIndex(string(key), idx)
+}
+
+// LSet sets the list element at index to value.
+// Return false if the index is out of range.
+func (db *KVDB) LSet(key []byte, idx int, val []byte) (ok bool, err error) {
+	if err = db.checkKeyValue(key, val); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	if db.checkExpired(key, List) {
+		return
+	}
+
+	if ok = db.listIndex.indexes.LSet(string(key), idx, val); ok {
+		e := storage.NewEntry(key, val, []byte(strconv.Itoa(idx)), List, ListLSet)
+		if err = db.store(e); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// LRange returns the specified elements of the list stored at key.
+// The offsets start and stop are zero-based indexes, with 0 being the first element of the list (the head of the list), 1 being the next element and so on.
+// These offsets can also be negative numbers indicating offsets starting at the end of the list.
+// For example, -1 is the last element of the list, -2 the penultimate, and so on.
+func (db *KVDB) LRange(key []byte, start, end int) ([][]byte, error) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil, err
+	}
+
+	db.listIndex.mu.RLock()
+	defer db.listIndex.mu.RUnlock()
+
+	if db.keyExpired(List, string(key)) {
+		return nil, ErrKeyExpired
+	}
+	return db.listIndex.indexes.LRange(string(key), start, end), nil
+}
+
+// LTrim trim an existing list so that it will contain only the specified range of elements specified.
+// Both start and stop are zero-based indexes, where 0 is the first element of the list (the head), 1 the next element and so on.
+func (db *KVDB) LTrim(key []byte, start, end int) (err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	if db.checkExpired(key, List) {
+		return ErrKeyExpired
+	}
+
+	if ok := db.listIndex.indexes.LTrim(string(key), start, end); ok {
+		extra := strconv.Itoa(start) + ExtraSeparator + strconv.Itoa(end)
+		e := storage.NewEntry(key, nil, []byte(extra), List, ListLTrim)
+		if err = db.store(e); err != nil {
+			return
+		}
+		if !db.listIndex.indexes.LKeyExists(string(key)) {
+			delete(db.expires[List], string(key))
+		}
+	}
+	return
+}
+
+// LRem removes the first count occurrences of elements equal to element from the list stored at key.
+// The count argument influences the operation in the following ways:
+// count > 0: Remove elements equal to element moving from head to tail.
+// count < 0: Remove elements equal to element moving from tail to head.
+// count = 0: Remove all elements equal to element.
+// Return the number of removed elements.
+func (db *KVDB) LRem(key, value []byte, count int) (res int, err error) {
+	if err = db.checkKeyValue(key, value); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	if db.checkExpired(key, List) {
+		return
+	}
+
+	if res = db.listIndex.indexes.LRem(string(key), value, count); res > 0 {
+		e := storage.NewEntry(key, value, []byte(strconv.Itoa(count)), List, ListLRem)
+		if err = db.store(e); err != nil {
+			return
+		}
+		if !db.listIndex.indexes.LKeyExists(string(key)) {
+			delete(db.expires[List], string(key))
+		}
+	}
+	return
+}
+
+// LInsert inserts element in the list stored at key either before or after the reference value pivot.
+// Return the length of the list after the insert operation, or -1 when the value pivot was not found.
+func (db *KVDB) LInsert(key []byte, option list.InsertOption, pivot, val []byte) (count int, err error) {
+	if err = db.checkKeyValue(key, val); err != nil {
+		return
+	}
+
+	if bytes.Contains(pivot, []byte(ExtraSeparator)) {
+		return 0, ErrExtraContainsSeparator
+	}
+
+	defer db.waitSync(&err)
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	if db.checkExpired(key, List) {
+		return -1, nil
+	}
+
+	if count = db.listIndex.indexes.LInsert(string(key), option, pivot, val); count != -1 {
+		var buf bytes.Buffer
+		buf.Write(pivot)
+		buf.Write([]byte(ExtraSeparator))
+		opt := strconv.Itoa(int(option))
+		buf.Write([]byte(opt))
+
+		e := storage.NewEntry(key, val, buf.Bytes(), List, ListLInsert)
+		if err = db.store(e); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// LLen returns the length of the list stored at key.
+// If key does not exist, it is interpreted as an empty list and 0 is returned.
+func (db *KVDB) LLen(key []byte) int {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return 0
+	}
+
+	db.listIndex.mu.RLock()
+	defer db.listIndex.mu.RUnlock()
+
+	if db.keyExpired(List, string(key)) {
+		return 0
+	}
+	return db.listIndex.indexes.LLen(string(key))
+}
+
+// LKeyExists check if the key of a List exists.
+func (db *KVDB) LKeyExists(key []byte) (ok bool) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	db.listIndex.mu.RLock()
+	defer db.listIndex.mu.RUnlock()
+
+	if db.keyExpired(List, string(key)) {
+		return false
+	}
+	return db.listIndex.indexes.LKeyExists(string(key))
+}
+
+// LClear clear a specified key for List.
+func (db *KVDB) LClear(key []byte) (err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	if !db.LKeyExists(key) {
+		return ErrKeyNotExist
+	}
+
+	defer db.waitSync(&err)
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	e := storage.NewEntryNoExtra(key, nil, List, ListLClear)
+	if err = db.store(e); err != nil {
+		return err
+	}
+
+	db.listIndex.indexes.LClear(string(key))
+	delete(db.expires[List], string(key))
+	return
+}
+
+// LExpire set expired time for a specified key of List.
+func (db *KVDB) LExpire(key []byte, duration int64) (err error) {
+	if duration <= 0 {
+		return ErrInvalidTTL
+	}
+	if !db.LKeyExists(key) {
+		return ErrKeyNotExist
+	}
+
+	defer db.waitSync(&err)
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	deadline := time.Now().Unix() + duration
+	e := storage.NewEntryWithExpire(key, nil, deadline, List, ListLExpire)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	db.expires[List][string(key)] = expireDeadline(e)
+	return
+}
+
+// LPersist remove the expired time of the key in list.
+func (db *KVDB) LPersist(key []byte) (err error) {
+	if !db.LKeyExists(key) {
+		return ErrKeyNotExist
+	}
+
+	defer db.waitSync(&err)
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	if _, exist := db.expires[List][string(key)]; !exist {
+		return
+	}
+	e := storage.NewEntryNoExtra(key, nil, List, ListLPersist)
+	if err = db.store(e); err != nil {
+		return
+	}
+	delete(db.expires[List], string(key))
+	return
+}
+
+// LTTL return time to live.
+func (db *KVDB) LTTL(key []byte) (ttl int64) {
+	db.listIndex.mu.RLock()
+	defer db.listIndex.mu.RUnlock()
+
+	if db.keyExpired(List, string(key)) {
+		return
+	}
+
+	deadline, exist := db.expires[List][string(key)]
+	if !exist {
+		return
+	}
+	return db.ttl(deadline)
+}
+
+func (db *KVDB) push(key []byte, mark uint16, values ...[]byte) (res int, err error) {
+	if err = db.checkKeyValue(key, values...); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	// the expired list will be cleared before pushing.
+	db.checkExpired(key, List)
+
+	for _, val := range values {
+		e := storage.NewEntryNoExtra(key, val, List, mark)
+		if err = db.store(e); err != nil {
+			return
+		}
+
+		if mark == ListLPush {
+			res = db.listIndex.indexes.LPush(string(key), val)
+		} else {
+			res = db.listIndex.indexes.RPush(string(key), val)
+		}
+	}
+	return
+}
+
+func (db *KVDB) pop(key []byte, mark uint16) (val []byte, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.listIndex.mu.Lock()
+	defer db.listIndex.mu.Unlock()
+
+	if db.checkExpired(key, List) {
+		return nil, ErrKeyExpired
+	}
+
+	if db.listIndex.indexes.LLen(string(key)) == 0 {
+		return
+	}
+
+	e := storage.NewEntryNoExtra(key, nil, List, mark)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	if mark == ListLPop {
+		val = db.listIndex.indexes.LPop(string(key))
+	} else {
+		val = db.listIndex.indexes.RPop(string(key))
+	}
+	if !db.listIndex.indexes.LKeyExists(string(key)) {
+		delete(db.expires[List], string(key))
+	}
+	return
+}
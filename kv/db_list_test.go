@@ -0,0 +1,228 @@
+package kv
+
+import (
+	"MetaDB/kv/ds/list"
+
+	"fmt"
+	"testing"
+)
+
+func assertList(t *testing.T, db *KVDB, key string, want ...string) {
+	t.Helper()
+
+	vals, err := db.LRange([]byte(key), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, vals, want...)
+}
+
+func TestListOperations(t *testing.T) {
+	db := openTestDB(t, nil)
+	key := []byte("l")
+
+	if n, err := db.RPush(key, []byte("b"), []byte("c")); err != nil || n != 2 {
+		t.Fatalf("RPush: got %d, %v", n, err)
+	}
+	if n, err := db.LPush(key, []byte("a")); err != nil || n != 3 {
+		t.Fatalf("LPush: got %d, %v", n, err)
+	}
+	assertList(t, db, "l", "a", "b", "c")
+	assertBytes(t, db.LIndex(key, -1), "c")
+
+	if ok, err := db.LSet(key, 1, []byte("B")); err != nil || !ok {
+		t.Fatalf("LSet: got %v, %v", ok, err)
+	}
+	if ok, _ := db.LSet(key, 10, []byte("x")); ok {
+		t.Fatal("LSet out of range succeeded")
+	}
+	if n, err := db.LInsert(key, list.After, []byte("B"), []byte("b2")); err != nil || n != 4 {
+		t.Fatalf("LInsert: got %d, %v", n, err)
+	}
+	if _, err := db.RPush(key, []byte("a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.LRem(key, []byte("a"), -2); err != nil || n != 2 {
+		t.Fatalf("LRem: got %d, %v", n, err)
+	}
+	assertList(t, db, "l", "a", "B", "b2", "c")
+
+	if err := db.LTrim(key, 1, -1); err != nil {
+		t.Fatal(err)
+	}
+	val, err := db.LPop(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "B")
+	if val, err = db.RPop(key); err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "c")
+	if n := db.LLen(key); n != 1 {
+		t.Fatalf("LLen: got %d, want 1", n)
+	}
+
+	if err := db.LExpire(key, 100); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	assertList(t, db, "l", "b2")
+	if ttl := db.LTTL(key); ttl <= 0 {
+		t.Fatalf("LTTL after reopening: got %d", ttl)
+	}
+	if err := db.LPersist(key); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if ttl := db.LTTL(key); ttl != 0 {
+		t.Fatalf("LTTL after persisting: got %d", ttl)
+	}
+
+	if err := db.LClear(key); err != nil {
+		t.Fatal(err)
+	}
+	if db.LKeyExists(key) {
+		t.Fatal("the cleared list exists")
+	}
+	db = reopenTestDB(t, db)
+	if db.LKeyExists(key) {
+		t.Fatal("the cleared list exists after reopening")
+	}
+}
+
+func listFileBytes(db *KVDB) (n int64) {
+	for _, f := range db.archFiles[List] {
+		n += f.Offset - f.DataOffset()
+	}
+	return
+}
+
+// A queue keeps only a few elements, its history of pushes and pops is dropped by Reclaim.
+func TestListReclaimSnapshot(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+	queue := []byte("queue")
+
+	var window []string
+	for i := 0; i < 300; i++ {
+		item := fmt.Sprintf("item-%03d", i)
+		if _, err := db.RPush(queue, []byte(item)); err != nil {
+			t.Fatal(err)
+		}
+		window = append(window, item)
+		if len(window) > 5 {
+			if _, err := db.LPop(queue); err != nil {
+				t.Fatal(err)
+			}
+			window = window[1:]
+		}
+	}
+	// every operation of list is in the history.
+	if _, err := db.RPush([]byte("ops"), []byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LInsert([]byte("ops"), list.Before, []byte("b"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LSet([]byte("ops"), 0, []byte("A")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LRem([]byte("ops"), []byte("a"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RPop([]byte("ops")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LTrim([]byte("ops"), 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LExpire([]byte("ops"), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RPush([]byte("expired"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LExpire([]byte("expired"), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RPush([]byte("cleared"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.LClear([]byte("cleared")); err != nil {
+		t.Fatal(err)
+	}
+	// fill the active file, so the entries above are archived.
+	for i := 0; i < 40; i++ {
+		if _, err := db.RPush([]byte("filler"), []byte("f")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expireNow(db, List, "expired")
+
+	check := func(db *KVDB) {
+		t.Helper()
+		assertList(t, db, "queue", window...)
+		assertList(t, db, "ops", "A", "x", "b")
+		if ttl := db.LTTL([]byte("ops")); ttl <= 0 {
+			t.Fatalf("LTTL: got %d", ttl)
+		}
+		if db.LKeyExists([]byte("cleared")) || db.LKeyExists([]byte("expired")) {
+			t.Fatal("the removed lists exist")
+		}
+		if n := db.LLen([]byte("filler")); n != 40 {
+			t.Fatalf("LLen: got %d, want 40", n)
+		}
+	}
+	check(db)
+
+	before := listFileBytes(db)
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	after := listFileBytes(db)
+	if after*4 > before {
+		t.Fatalf("the history is not dropped: %d bytes before reclaim, %d after", before, after)
+	}
+	check(db)
+
+	// the entries in the active file are replayed on the snapshot.
+	if _, err := db.RPush(queue, []byte("next")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LPop(queue); err != nil {
+		t.Fatal(err)
+	}
+	window = append(window[1:], "next")
+	db = reopenTestDB(t, db)
+	check(db)
+}
+
+// The commit marker of a transaction coordinated by list is kept, so the entries of other types stay committed.
+func TestListReclaimKeepsCommitMarkers(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	err := db.Txn(func(tx *Tx) error {
+		if err := tx.RPush([]byte("l"), []byte("a")); err != nil {
+			return err
+		}
+		_, err := tx.SAdd([]byte("s"), []byte("m"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 60; i++ {
+		if _, err := db.RPush([]byte("filler"), []byte("f")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	assertList(t, db, "l", "a")
+	if !db.SIsMember([]byte("s"), []byte("m")) {
+		t.Fatal("the set member of the transaction is lost")
+	}
+}
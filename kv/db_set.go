@@ -0,0 +1,402 @@
+package kv
+
+import (
+	"MetaDB/kv/ds/set"
+	"MetaDB/kv/storage"
+
+	"sync"
+	"time"
+)
+
+// SetIdx the set index.
+type SetIdx struct {
+	mu      *sync.RWMutex
+	indexes *set.Set
+}
+
+func newSetIdx() *SetIdx {
+	return &SetIdx{indexes: set.New(), mu: new(sync.RWMutex)}
+}
+
+// SAdd add the specified members to the set stored at key.
+// Specified members that are already a member of this set are ignored.
+// If key does not exist, a new set is created before adding the specified members.
+// Return the number of members that were added to the set.
+func (db *KVDB) SAdd(key []byte, members ...[]byte) (res int, err error) {
+	if err = db.checkKeyValue(key, members...); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.setIndex.mu.Lock()
+	defer db.setIndex.mu.Unlock()
+
+	db.checkExpired(key, Set)
+
+	for _, m := range members {
+		if db.setIndex.indexes.SIsMember(string(key), m) {
+			continue
+		}
+
+		e := storage.NewEntryNoExtra(key, m, Set, SetSAdd)
+		if err = db.store(e); err != nil {
+			return
+		}
+		res += db.setIndex.indexes.SAdd(string(key), m)
+	}
+	return
+}
+
+// SPop removes and returns one or more random members from the set value store at key.
+func (db *KVDB) SPop(key []byte, count int) (values [][]byte, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.setIndex.mu.Lock()
+	defer db.setIndex.mu.Unlock()
+
+	if db.checkExpired(key, Set) {
+		return nil, ErrKeyExpired
+	}
+
+	values = db.setIndex.indexes.SPop(string(key), count)
+	for _, v := range values {
+		e := storage.NewEntryNoExtra(key, v, Set, SetSRem)
+		if err = db.store(e); err != nil {
+			return
+		}
+	}
+	if !db.setIndex.indexes.SKeyExists(string(key)) {
+		delete(db.expires[Set], string(key))
+	}
+	return
+}
+
+// SIsMember returns if member is a member of the set stored at key.
+func (db *KVDB) SIsMember(key, member []byte) bool {
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	if db.keyExpired(Set, string(key)) {
+		return false
+	}
+	return db.setIndex.indexes.SIsMember(string(key), member)
+}
+
+// SRandMember returns a random element from the set value stored at key.
+// count > 0: if count less than set`s card, returns an array containing count different elements. if count greater than set`s card, the entire set will be returned.
+// count < 0: the command is allowed to return the same element multiple times, and in this case, the number of returned elements is the absolute value of the specified count.
+func (db *KVDB) SRandMember(key []byte, count int) [][]byte {
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	if db.keyExpired(Set, string(key)) {
+		return nil
+	}
+	return db.setIndex.indexes.SRandMember(string(key), count)
+}
+
+// SRem remove the specified members from the set stored at key.
+// Specified members that are not a member of this set are ignored.
+// If key does not exist, it is treated as an empty set and this command returns 0.
+func (db *KVDB) SRem(key []byte, members ...[]byte) (res int, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.setIndex.mu.Lock()
+	defer db.setIndex.mu.Unlock()
+
+	if db.checkExpired(key, Set) {
+		return
+	}
+
+	for _, m := range members {
+		if ok := db.setIndex.indexes.SRem(string(key), m); ok {
+			e := storage.NewEntryNoExtra(key, m, Set, SetSRem)
+			if err = db.store(e); err != nil {
+				return
+			}
+			res++
+		}
+	}
+	if !db.setIndex.indexes.SKeyExists(string(key)) {
+		delete(db.expires[Set], string(key))
+	}
+	return
+}
+
+// SMove move member from the set at source to the set at destination.
+// Return true if the member was moved.
+func (db *KVDB) SMove(src, dst, member []byte) (ok bool, err error) {
+	if err = db.checkKeyValue(src, member); err != nil {
+		return
+	}
+	if err = db.checkKeyValue(dst, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.setIndex.mu.Lock()
+	defer db.setIndex.mu.Unlock()
+
+	if db.checkExpired(src, Set) {
+		return
+	}
+	db.checkExpired(dst, Set)
+
+	if !db.setIndex.indexes.SIsMember(string(src), member) {
+		return
+	}
+
+	e := storage.NewEntry(src, member, dst, Set, SetSMove)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	ok = db.setIndex.indexes.SMove(string(src), string(dst), member)
+	if !db.setIndex.indexes.SKeyExists(string(src)) {
+		delete(db.expires[Set], string(src))
+	}
+	return
+}
+
+// SCard returns the set cardinality (number of elements) of the set stored at key.
+func (db *KVDB) SCard(key []byte) int {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return 0
+	}
+
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	if db.keyExpired(Set, string(key)) {
+		return 0
+	}
+	return db.setIndex.indexes.SCard(string(key))
+}
+
+// SMembers returns all the members of the set value stored at key.
+func (db *KVDB) SMembers(key []byte) (val [][]byte) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	if db.keyExpired(Set, string(key)) {
+		return
+	}
+	return db.setIndex.indexes.SMembers(string(key))
+}
+
+// SUnion returns the members of the set resulting from the union of all the given sets.
+func (db *KVDB) SUnion(keys ...[]byte) (val [][]byte) {
+	if keys == nil || len(keys) == 0 {
+		return
+	}
+
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	return db.setIndex.indexes.SUnion(db.liveSetKeys(keys...)...)
+}
+
+// SDiff returns the members of the set resulting from the difference between the first set and all the successive sets.
+func (db *KVDB) SDiff(keys ...[]byte) (val [][]byte) {
+	if keys == nil || len(keys) == 0 {
+		return
+	}
+
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	return db.setIndex.indexes.SDiff(db.liveSetKeys(keys...)...)
+}
+
+// SInter returns the members of the set resulting from the intersection of all the given sets.
+func (db *KVDB) SInter(keys ...[]byte) (val [][]byte) {
+	if keys == nil || len(keys) == 0 {
+		return
+	}
+
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	return db.setIndex.indexes.SInter(db.liveSetKeys(keys...)...)
+}
+
+// SUnionStore is equal to SUnion, but instead of returning the resulting set, it is stored in destination.
+// If destination already exists, it is overwritten.
+// Return the number of elements in the resulting set.
+func (db *KVDB) SUnionStore(dst []byte, keys ...[]byte) (int, error) {
+	return db.setStore(dst, func(keys ...string) [][]byte {
+		return db.setIndex.indexes.SUnion(keys...)
+	}, keys...)
+}
+
+// SDiffStore is equal to SDiff, but instead of returning the resulting set, it is stored in destination.
+// If destination already exists, it is overwritten.
+// Return the number of elements in the resulting set.
+func (db *KVDB) SDiffStore(dst []byte, keys ...[]byte) (int, error) {
+	return db.setStore(dst, func(keys ...string) [][]byte {
+		return db.setIndex.indexes.SDiff(keys...)
+	}, keys...)
+}
+
+// SInterStore is equal to SInter, but instead of returning the resulting set, it is stored in destination.
+// If destination already exists, it is overwritten.
+// Return the number of elements in the resulting set.
+func (db *KVDB) SInterStore(dst []byte, keys ...[]byte) (int, error) {
+	return db.setStore(dst, func(keys ...string) [][]byte {
+		return db.setIndex.indexes.SInter(keys...)
+	}, keys...)
+}
+
+// SKeyExists returns if the key exists.
+func (db *KVDB) SKeyExists(key []byte) (ok bool) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	if db.keyExpired(Set, string(key)) {
+		return
+	}
+	return db.setIndex.indexes.SKeyExists(string(key))
+}
+
+// SClear clear the specified key in set.
+func (db *KVDB) SClear(key []byte) (err error) {
+	if !db.SKeyExists(key) {
+		return ErrKeyNotExist
+	}
+
+	defer db.waitSync(&err)
+	db.setIndex.mu.Lock()
+	defer db.setIndex.mu.Unlock()
+
+	return db.doSClear(key)
+}
+
+// SExpire set expired time for the key in set.
+func (db *KVDB) SExpire(key []byte, duration int64) (err error) {
+	if duration <= 0 {
+		return ErrInvalidTTL
+	}
+	if !db.SKeyExists(key) {
+		return ErrKeyNotExist
+	}
+
+	defer db.waitSync(&err)
+	db.setIndex.mu.Lock()
+	defer db.setIndex.mu.Unlock()
+
+	deadline := time.Now().Unix() + duration
+	e := storage.NewEntryWithExpire(key, nil, deadline, Set, SetSExpire)
+	if err = db.store(e); err != nil {
+		return
+	}
+	db.expires[Set][string(key)] = expireDeadline(e)
+	return
+}
+
+// SPersist remove the expired time of the key in set.
+func (db *KVDB) SPersist(key []byte) (err error) {
+	if !db.SKeyExists(key) {
+		return ErrKeyNotExist
+	}
+
+	defer db.waitSync(&err)
+	db.setIndex.mu.Lock()
+	defer db.setIndex.mu.Unlock()
+
+	if _, exist := db.expires[Set][string(key)]; !exist {
+		return
+	}
+	e := storage.NewEntryNoExtra(key, nil, Set, SetSPersist)
+	if err = db.store(e); err != nil {
+		return
+	}
+	delete(db.expires[Set], string(key))
+	return
+}
+
+// STTL return time to live for the key in set.
+func (db *KVDB) STTL(key []byte) (ttl int64) {
+	db.setIndex.mu.RLock()
+	defer db.setIndex.mu.RUnlock()
+
+	if db.keyExpired(Set, string(key)) {
+		return
+	}
+
+	deadline, exist := db.expires[Set][string(key)]
+	if !exist {
+		return
+	}
+	return db.ttl(deadline)
+}
+
+// setStore saves the result of a set operation to dst, the old members of dst will be cleared.
+func (db *KVDB) setStore(dst []byte, op func(keys ...string) [][]byte, keys ...[]byte) (res int, err error) {
+	if err = db.checkKeyValue(dst, nil); err != nil {
+		return
+	}
+	if len(keys) == 0 {
+		return 0, ErrWrongNumberOfArgs
+	}
+
+	defer db.waitSync(&err)
+	db.setIndex.mu.Lock()
+	defer db.setIndex.mu.Unlock()
+
+	members := op(db.liveSetKeys(keys...)...)
+
+	db.checkExpired(dst, Set)
+	if db.setIndex.indexes.SKeyExists(string(dst)) {
+		if err = db.doSClear(dst); err != nil {
+			return
+		}
+	}
+
+	for _, m := range members {
+		e := storage.NewEntryNoExtra(dst, m, Set, SetSAdd)
+		if err = db.store(e); err != nil {
+			return
+		}
+		res += db.setIndex.indexes.SAdd(string(dst), m)
+	}
+	return
+}
+
+func (db *KVDB) doSClear(key []byte) (err error) {
+	e := storage.NewEntryNoExtra(key, nil, Set, SetSClear)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	db.setIndex.indexes.SClear(string(key))
+	delete(db.expires[Set], string(key))
+	return
+}
+
+// liveSetKeys converts the keys to string, the caller may hold the read lock.
+// The expired keys are replaced by the empty key, which never holds a set, so they are treated as empty sets.
+func (db *KVDB) liveSetKeys(keys ...[]byte) []string {
+	var res []string
+	for _, key := range keys {
+		if db.keyExpired(Set, string(key)) {
+			res = append(res, "")
+			continue
+		}
+		res = append(res, string(key))
+	}
+	return res
+}
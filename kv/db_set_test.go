@@ -0,0 +1,224 @@
+package kv
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// assertMembers checks the members of the set in any order.
+func assertMembers(t *testing.T, got [][]byte, want ...string) {
+	t.Helper()
+
+	sort.Slice(got, func(i, j int) bool {
+		return string(got[i]) < string(got[j])
+	})
+	sort.Strings(want)
+	assertStrings(t, got, want...)
+}
+
+func members(vals ...string) [][]byte {
+	var res [][]byte
+	for _, v := range vals {
+		res = append(res, []byte(v))
+	}
+	return res
+}
+
+func TestSetOperations(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	if n, err := db.SAdd([]byte("s1"), members("a", "b", "c", "a")...); err != nil || n != 3 {
+		t.Fatalf("SAdd: got %d, %v", n, err)
+	}
+	if _, err := db.SAdd([]byte("s2"), members("b", "c", "d")...); err != nil {
+		t.Fatal(err)
+	}
+	if !db.SIsMember([]byte("s1"), []byte("a")) || db.SIsMember([]byte("s1"), []byte("d")) {
+		t.Fatal("SIsMember")
+	}
+	if n := db.SCard([]byte("s1")); n != 3 {
+		t.Fatalf("SCard: got %d, want 3", n)
+	}
+	if vals := db.SRandMember([]byte("s1"), -5); len(vals) != 5 {
+		t.Fatalf("SRandMember with a negative count: got %d members, want 5", len(vals))
+	}
+
+	assertMembers(t, db.SUnion([]byte("s1"), []byte("s2")), "a", "b", "c", "d")
+	assertMembers(t, db.SInter([]byte("s1"), []byte("s2")), "b", "c")
+	assertMembers(t, db.SDiff([]byte("s1"), []byte("s2")), "a")
+	assertMembers(t, db.SInter([]byte("s1"), []byte("missing")))
+
+	if n, err := db.SUnionStore([]byte("union"), []byte("s1"), []byte("s2")); err != nil || n != 4 {
+		t.Fatalf("SUnionStore: got %d, %v", n, err)
+	}
+	if n, err := db.SInterStore([]byte("inter"), []byte("s1"), []byte("s2")); err != nil || n != 2 {
+		t.Fatalf("SInterStore: got %d, %v", n, err)
+	}
+	// the old members of the destination are replaced.
+	if n, err := db.SDiffStore([]byte("union"), []byte("s2"), []byte("s1")); err != nil || n != 1 {
+		t.Fatalf("SDiffStore: got %d, %v", n, err)
+	}
+	if _, err := db.SUnionStore([]byte("dst")); err != ErrWrongNumberOfArgs {
+		t.Fatalf("SUnionStore without keys: got %v", err)
+	}
+
+	if ok, err := db.SMove([]byte("s1"), []byte("moved"), []byte("a")); err != nil || !ok {
+		t.Fatalf("SMove: got %v, %v", ok, err)
+	}
+	if ok, _ := db.SMove([]byte("s1"), []byte("moved"), []byte("a")); ok {
+		t.Fatal("SMove a missing member succeeded")
+	}
+	if n, err := db.SRem([]byte("s2"), members("d", "x")...); err != nil || n != 1 {
+		t.Fatalf("SRem: got %d, %v", n, err)
+	}
+	popped, err := db.SPop([]byte("inter"), 2)
+	if err != nil || len(popped) != 2 {
+		t.Fatalf("SPop: got %q, %v", popped, err)
+	}
+	if db.SKeyExists([]byte("inter")) {
+		t.Fatal("the popped set exists")
+	}
+
+	if err := db.SExpire([]byte("s1"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SExpire([]byte("s2"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SPersist([]byte("s2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SClear([]byte("moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SClear([]byte("moved")); err != ErrKeyNotExist {
+		t.Fatalf("SClear twice: got %v", err)
+	}
+
+	check := func(db *KVDB) {
+		t.Helper()
+		assertMembers(t, db.SMembers([]byte("s1")), "b", "c")
+		assertMembers(t, db.SMembers([]byte("s2")), "b", "c")
+		assertMembers(t, db.SMembers([]byte("union")), "d")
+		if db.SKeyExists([]byte("inter")) || db.SKeyExists([]byte("moved")) {
+			t.Fatal("the removed sets exist")
+		}
+		if ttl := db.STTL([]byte("s1")); ttl <= 0 {
+			t.Fatalf("STTL: got %d", ttl)
+		}
+		if ttl := db.STTL([]byte("s2")); ttl != 0 {
+			t.Fatalf("STTL after persisting: got %d", ttl)
+		}
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
+
+// Every operation of set is replayed from the db files and kept by Reclaim.
+func TestSetReclaim(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	for i := 0; i < 20; i++ {
+		if _, err := db.SAdd([]byte("churn"), []byte(fmt.Sprintf("m%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if _, err := db.SRem([]byte("churn"), []byte(fmt.Sprintf("m%02d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := db.SAdd([]byte("src"), members("a", "b")...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SMove([]byte("src"), []byte("dst"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	// the source set expires, the member moved out of it is kept.
+	if err := db.SExpire([]byte("src"), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd([]byte("ttl"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SExpire([]byte("ttl"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SExpire([]byte("ttl"), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd([]byte("persisted"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SExpire([]byte("persisted"), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SPersist([]byte("persisted")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd([]byte("cleared"), members("a", "b")...); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SClear([]byte("cleared")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SUnionStore([]byte("store"), []byte("churn"), []byte("dst")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SPop([]byte("store"), 1); err != nil {
+		t.Fatal(err)
+	}
+	// fill the active file, so the entries above are archived.
+	for i := 0; i < 40; i++ {
+		if _, err := db.SAdd([]byte("filler"), []byte(fmt.Sprintf("f%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expireNow(db, Set, "src")
+
+	var churn []string
+	for i := 1; i < 20; i += 2 {
+		churn = append(churn, fmt.Sprintf("m%02d", i))
+	}
+	store := db.SMembers([]byte("store"))
+	check := func(db *KVDB) {
+		t.Helper()
+		assertMembers(t, db.SMembers([]byte("churn")), churn...)
+		assertMembers(t, db.SMembers([]byte("dst")), "a")
+		assertMembers(t, db.SMembers([]byte("ttl")), "a")
+		assertMembers(t, db.SMembers([]byte("persisted")), "a")
+		if len(store) != 10 || db.SCard([]byte("store")) != 10 {
+			t.Fatalf("SCard: got %d, want 10", db.SCard([]byte("store")))
+		}
+		for _, m := range store {
+			if !db.SIsMember([]byte("store"), m) {
+				t.Fatalf("%s is not a member of the stored set", m)
+			}
+		}
+		if db.SKeyExists([]byte("src")) || db.SKeyExists([]byte("cleared")) {
+			t.Fatal("the removed sets exist")
+		}
+		if ttl := db.STTL([]byte("ttl")); ttl <= 10 {
+			t.Fatalf("STTL: got %d, want the latest one", ttl)
+		}
+		if ttl := db.STTL([]byte("persisted")); ttl != 0 {
+			t.Fatalf("STTL after persisting: got %d", ttl)
+		}
+		if n := db.SCard([]byte("filler")); n != 40 {
+			t.Fatalf("SCard: got %d, want 40", n)
+		}
+	}
+	check(db)
+
+	if len(db.archFiles[Set]) < 2 {
+		t.Fatalf("got %d archived files, want at least 2", len(db.archFiles[Set]))
+	}
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
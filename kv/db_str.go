@@ -0,0 +1,427 @@
+package kv
+
+import (
+	"MetaDB/kv/index"
+	"MetaDB/kv/storage"
+
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StrIdx string index.
+type StrIdx struct {
+	mu      *sync.RWMutex
+	indexes map[string]*index.Indexer
+	keys    *index.Keys // the keys in the order of their hashes, for Scan.
+}
+
+func newStrIdx() *StrIdx {
+	return &StrIdx{indexes: make(map[string]*index.Indexer), keys: index.NewKeys(), mu: new(sync.RWMutex)}
+}
+
+func (s *StrIdx) set(key string, idx *index.Indexer) {
+	if _, exist := s.indexes[key]; !exist {
+		s.keys.Add(key)
+	}
+	s.indexes[key] = idx
+}
+
+func (s *StrIdx) remove(key string) {
+	delete(s.indexes, key)
+	s.keys.Remove(key)
+}
+
+// Set set key to hold the string value. If key already holds a value, it is overwritten.
+// Any previous time to live associated with the key is discarded on successful Set operation.
+func (db *KVDB) Set(key, value []byte) (err error) {
+	if err := db.checkKeyValue(key, value); err != nil {
+		return err
+	}
+
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	return db.doSet(key, value, false)
+}
+
+// SetNx is short for "Set if not exists", set key to hold string value if key does not exist.
+// In that case, it is equal to Set. When key already holds a value, no operation is performed.
+// Return 1 if the key was set, otherwise 0.
+func (db *KVDB) SetNx(key, value []byte) (res int, err error) {
+	if err = db.checkKeyValue(key, value); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if db.strExists(key) {
+		return
+	}
+	if err = db.doSet(key, value, false); err == nil {
+		res = 1
+	}
+	return
+}
+
+// SetEx set key to hold the string value and set key to timeout after a given number of seconds.
+func (db *KVDB) SetEx(key, value []byte, duration int64) (err error) {
+	if duration <= 0 {
+		return ErrInvalidTTL
+	}
+	if err = db.checkKeyValue(key, value); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if err = db.doSet(key, value, false); err != nil {
+		return
+	}
+	return db.doExpire(key, duration)
+}
+
+// Get get the value of key. If the key does not exist an error is returned.
+func (db *KVDB) Get(key []byte) ([]byte, error) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil, err
+	}
+
+	db.strIndex.mu.RLock()
+	defer db.strIndex.mu.RUnlock()
+
+	return db.getVal(key)
+}
+
+// GetSet set key to value and returns the old value stored at key.
+// If the key not exist, the old value is nil.
+func (db *KVDB) GetSet(key, val []byte) (res []byte, err error) {
+	if err = db.checkKeyValue(key, val); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if res, err = db.getVal(key); err != nil && err != ErrKeyNotExist && err != ErrKeyExpired {
+		return
+	}
+	err = db.doSet(key, val, false)
+	return
+}
+
+// MSet set multiple keys to multiple values, the keys and values are given in pairs.
+// MSet is atomic, so all given keys are set at once.
+func (db *KVDB) MSet(values ...[]byte) (err error) {
+	if len(values)%2 != 0 {
+		return ErrWrongNumberOfArgs
+	}
+	for i := 0; i < len(values); i += 2 {
+		if err := db.checkKeyValue(values[i], values[i+1]); err != nil {
+			return err
+		}
+	}
+
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	for i := 0; i < len(values); i += 2 {
+		if err := db.doSet(values[i], values[i+1], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MGet get the values of all the given keys.
+// For every key that does not hold a string value or does not exist, nil is returned.
+func (db *KVDB) MGet(keys ...[]byte) ([][]byte, error) {
+	for _, key := range keys {
+		if err := db.checkKeyValue(key, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	db.strIndex.mu.RLock()
+	defer db.strIndex.mu.RUnlock()
+
+	var values [][]byte
+	for _, key := range keys {
+		val, err := db.getVal(key)
+		if err != nil && err != ErrKeyNotExist && err != ErrKeyExpired {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+	return values, nil
+}
+
+// Append if key already exists and is a string, this command appends the value at the end of the string.
+// If key does not exist it is created and set as an empty string, so Append will be similar to Set in this special case.
+// Return the length of the string after the append operation.
+func (db *KVDB) Append(key, value []byte) (length int, err error) {
+	if err = db.checkKeyValue(key, value); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	existVal, err := db.getVal(key)
+	if err != nil && err != ErrKeyNotExist && err != ErrKeyExpired {
+		return
+	}
+	appendExist := err == nil
+
+	newVal := make([]byte, 0, len(existVal)+len(value))
+	newVal = append(append(newVal, existVal...), value...)
+	if err = db.checkKeyValue(key, newVal); err != nil {
+		return
+	}
+	// keep the time to live if the key exists.
+	if err = db.doSet(key, newVal, appendExist); err != nil {
+		return
+	}
+	length = len(newVal)
+	return
+}
+
+// StrLen returns the length of the string value stored at key.
+func (db *KVDB) StrLen(key []byte) int {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return 0
+	}
+
+	db.strIndex.mu.RLock()
+	defer db.strIndex.mu.RUnlock()
+
+	val, err := db.getVal(key)
+	if err != nil {
+		return 0
+	}
+	return len(val)
+}
+
+// Incr increments the number stored at key by one. If the key does not exist, it is set to 0 before performing the operation.
+// An error is returned if the key contains a value of the wrong type or contains a string that can not be represented as integer.
+func (db *KVDB) Incr(key []byte) (int64, error) {
+	return db.IncrBy(key, 1)
+}
+
+// IncrBy increments the number stored at key by incr. If the key does not exist, it is set to 0 before performing the operation.
+// The time to live of the key will be kept.
+func (db *KVDB) IncrBy(key []byte, incr int64) (res int64, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	val, err := db.getVal(key)
+	if err != nil && err != ErrKeyNotExist && err != ErrKeyExpired {
+		return
+	}
+	keepTTL := err == nil
+	err = nil
+
+	if len(val) > 0 {
+		if res, err = strconv.ParseInt(string(val), 10, 64); err != nil {
+			return 0, ErrWrongValueType
+		}
+	}
+	if (incr < 0 && res < math.MinInt64-incr) || (incr > 0 && res > math.MaxInt64-incr) {
+		return 0, ErrIntegerOverflow
+	}
+
+	res += incr
+	err = db.doSet(key, []byte(strconv.FormatInt(res, 10)), keepTTL)
+	return
+}
+
+// Decr decrements the number stored at key by one. If the key does not exist, it is set to 0 before performing the operation.
+func (db *KVDB) Decr(key []byte) (int64, error) {
+	return db.DecrBy(key, 1)
+}
+
+// DecrBy decrements the number stored at key by decr. If the key doesn't exist, it is set to 0 before performing the operation.
+func (db *KVDB) DecrBy(key []byte, decr int64) (int64, error) {
+	if decr == math.MinInt64 {
+		return 0, ErrIntegerOverflow
+	}
+	return db.IncrBy(key, -decr)
+}
+
+// StrExists check whether the key exists.
+func (db *KVDB) StrExists(key []byte) bool {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return false
+	}
+
+	db.strIndex.mu.RLock()
+	defer db.strIndex.mu.RUnlock()
+
+	return db.strExists(key)
+}
+
+// Remove remove the value stored at key.
+func (db *KVDB) Remove(key []byte) (err error) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return err
+	}
+
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if !db.strExists(key) {
+		return ErrKeyNotExist
+	}
+
+	e := storage.NewEntryNoExtra(key, nil, String, StringRem)
+	if err := db.store(e); err != nil {
+		return err
+	}
+
+	db.discardStr(string(key))
+	db.strIndex.remove(string(key))
+	delete(db.expires[String], string(key))
+	return nil
+}
+
+// Expire set the expiration time of the key.
+func (db *KVDB) Expire(key []byte, duration int64) (err error) {
+	if duration <= 0 {
+		return ErrInvalidTTL
+	}
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if !db.strExists(key) {
+		return ErrKeyNotExist
+	}
+	return db.doExpire(key, duration)
+}
+
+// Persist clear expiration time.
+func (db *KVDB) Persist(key []byte) (err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.strIndex.mu.Lock()
+	defer db.strIndex.mu.Unlock()
+
+	if !db.strExists(key) {
+		return ErrKeyNotExist
+	}
+	return db.doPersist(key)
+}
+
+// TTL Time to live.
+func (db *KVDB) TTL(key []byte) (ttl int64) {
+	db.strIndex.mu.RLock()
+	defer db.strIndex.mu.RUnlock()
+
+	if db.keyExpired(String, string(key)) {
+		return
+	}
+
+	deadline, exist := db.expires[String][string(key)]
+	if !exist {
+		return
+	}
+	return db.ttl(deadline)
+}
+
+// doSet writes the value of key, the time to live will be discarded if keepTTL is false.
+func (db *KVDB) doSet(key, value []byte, keepTTL bool) (err error) {
+	if !keepTTL {
+		if _, exist := db.expires[String][string(key)]; exist {
+			if err = db.doPersist(key); err != nil {
+				return
+			}
+		}
+	}
+
+	e := storage.NewEntryNoExtra(key, value, String, StringSet)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	idx, err := db.newIndexer(e)
+	if err != nil {
+		return
+	}
+	db.discardStr(string(key))
+	db.strIndex.set(string(key), idx)
+	return
+}
+
+func (db *KVDB) doExpire(key []byte, duration int64) (err error) {
+	deadline := time.Now().Unix() + duration
+	e := storage.NewEntryWithExpire(key, nil, deadline, String, StringExpire)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	db.expires[String][string(key)] = expireDeadline(e)
+	return
+}
+
+func (db *KVDB) doPersist(key []byte) (err error) {
+	e := storage.NewEntryNoExtra(key, nil, String, StringPersist)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	delete(db.expires[String], string(key))
+	return
+}
+
+// getVal returns the value of key, it only reads the index, so the caller may hold the read lock.
+// The expired key is reported as expired but not removed, a write will overwrite or remove it.
+func (db *KVDB) getVal(key []byte) ([]byte, error) {
+	idx, exist := db.strIndex.indexes[string(key)]
+	if !exist {
+		return nil, ErrKeyNotExist
+	}
+
+	if db.keyExpired(String, string(key)) {
+		return nil, ErrKeyExpired
+	}
+
+	// In KeyValueMemMode, the value will be stored in memory.
+	// So get the value from the index info.
+	if db.config.IdxMode == KeyValueMemMode {
+		return idx.Meta.Value, nil
+	}
+
+	// In KeyOnlyMemMode, the value not in memory.
+	// So get the value from the db file at the offset.
+	return db.readValue(String, idx)
+}
+
+// strExists checks whether the key exists and is not expired, the caller may hold the read lock.
+func (db *KVDB) strExists(key []byte) bool {
+	if _, exist := db.strIndex.indexes[string(key)]; !exist {
+		return false
+	}
+	return !db.keyExpired(String, string(key))
+}
@@ -0,0 +1,240 @@
+package kv
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestStrOperations(t *testing.T) {
+	for name, update := range testConfigs() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, update)
+
+			if _, err := db.Get([]byte("k")); err != ErrKeyNotExist {
+				t.Fatalf("got %v, want ErrKeyNotExist", err)
+			}
+			if err := db.Set([]byte("k"), []byte("v1")); err != nil {
+				t.Fatal(err)
+			}
+			if res, err := db.SetNx([]byte("k"), []byte("v2")); err != nil || res != 0 {
+				t.Fatalf("SetNx an existing key: got %d, %v", res, err)
+			}
+			if res, err := db.SetNx([]byte("nx"), []byte("v")); err != nil || res != 1 {
+				t.Fatalf("SetNx a new key: got %d, %v", res, err)
+			}
+
+			old, err := db.GetSet([]byte("k"), []byte("v3"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, old, "v1")
+			if old, err = db.GetSet([]byte("new"), []byte("v")); err != nil || old != nil {
+				t.Fatalf("GetSet a new key: got %q, %v", old, err)
+			}
+
+			if n, err := db.Append([]byte("k"), []byte("-tail")); err != nil || n != 7 {
+				t.Fatalf("Append: got %d, %v", n, err)
+			}
+			if n := db.StrLen([]byte("k")); n != 7 {
+				t.Fatalf("StrLen: got %d, want 7", n)
+			}
+
+			if err := db.MSet([]byte("a"), []byte("1"), []byte("b"), []byte("2")); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.MSet([]byte("a")); err != ErrWrongNumberOfArgs {
+				t.Fatalf("MSet odd args: got %v", err)
+			}
+			vals, err := db.MGet([]byte("a"), []byte("missing"), []byte("b"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(vals) != 3 || string(vals[0]) != "1" || vals[1] != nil || string(vals[2]) != "2" {
+				t.Fatalf("MGet: got %q", vals)
+			}
+
+			if err := db.Remove([]byte("a")); err != nil {
+				t.Fatal(err)
+			}
+			if db.StrExists([]byte("a")) {
+				t.Fatal("the removed key exists")
+			}
+			if err := db.Remove([]byte("a")); err != ErrKeyNotExist {
+				t.Fatalf("Remove twice: got %v", err)
+			}
+
+			db = reopenTestDB(t, db)
+			val, err := db.Get([]byte("k"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, val, "v3-tail")
+			if db.StrExists([]byte("a")) || !db.StrExists([]byte("b")) {
+				t.Fatal("the keys are not restored after reopening")
+			}
+		})
+	}
+}
+
+func TestStrIncr(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	if res, err := db.Incr([]byte("n")); err != nil || res != 1 {
+		t.Fatalf("Incr a new key: got %d, %v", res, err)
+	}
+	if res, err := db.IncrBy([]byte("n"), 41); err != nil || res != 42 {
+		t.Fatalf("IncrBy: got %d, %v", res, err)
+	}
+	if res, err := db.DecrBy([]byte("n"), 50); err != nil || res != -8 {
+		t.Fatalf("DecrBy: got %d, %v", res, err)
+	}
+	if res, err := db.Decr([]byte("n")); err != nil || res != -9 {
+		t.Fatalf("Decr: got %d, %v", res, err)
+	}
+
+	if err := db.Set([]byte("s"), []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Incr([]byte("s")); err != ErrWrongValueType {
+		t.Fatalf("Incr a string: got %v, want ErrWrongValueType", err)
+	}
+
+	if err := db.Set([]byte("max"), []byte(strconv.FormatInt(math.MaxInt64, 10))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Incr([]byte("max")); err != ErrIntegerOverflow {
+		t.Fatalf("Incr overflows: got %v, want ErrIntegerOverflow", err)
+	}
+	if _, err := db.DecrBy([]byte("n"), math.MinInt64); err != ErrIntegerOverflow {
+		t.Fatalf("DecrBy MinInt64: got %v, want ErrIntegerOverflow", err)
+	}
+
+	// the time to live is kept by Incr, but discarded by Set.
+	if err := db.Expire([]byte("n"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Incr([]byte("n")); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := db.TTL([]byte("n")); ttl <= 0 {
+		t.Fatalf("the time to live is discarded by Incr, got %d", ttl)
+	}
+	if err := db.Set([]byte("n"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := db.TTL([]byte("n")); ttl != 0 {
+		t.Fatalf("the time to live is kept by Set, got %d", ttl)
+	}
+}
+
+func TestStrTTL(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	if err := db.SetEx([]byte("k"), []byte("v"), 0); err != ErrInvalidTTL {
+		t.Fatalf("SetEx with 0: got %v, want ErrInvalidTTL", err)
+	}
+	if err := db.SetEx([]byte("k"), []byte("v"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := db.TTL([]byte("k")); ttl < 99 || ttl > 100 {
+		t.Fatalf("TTL: got %d, want 100", ttl)
+	}
+	if err := db.Expire([]byte("missing"), 10); err != ErrKeyNotExist {
+		t.Fatalf("Expire a missing key: got %v", err)
+	}
+
+	db = reopenTestDB(t, db)
+	if ttl := db.TTL([]byte("k")); ttl < 99 || ttl > 100 {
+		t.Fatalf("TTL after reopening: got %d, want 100", ttl)
+	}
+
+	if err := db.Persist([]byte("k")); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if ttl := db.TTL([]byte("k")); ttl != 0 {
+		t.Fatalf("TTL after persisting: got %d, want 0", ttl)
+	}
+
+	expireNow(db, String, "k")
+	if _, err := db.Get([]byte("k")); err != ErrKeyExpired {
+		t.Fatalf("Get an expired key: got %v, want ErrKeyExpired", err)
+	}
+	if _, err := db.Append([]byte("k"), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	val, err := db.Get([]byte("k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "x")
+}
+
+// Every operation of string is replayed from the db files and kept by Reclaim.
+func TestStrReclaim(t *testing.T) {
+	for name, update := range testConfigs() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				update(cfg)
+				smallFiles(cfg)
+			})
+
+			for i := 0; i < 30; i++ {
+				key := []byte(fmt.Sprintf("k%02d", i))
+				if err := db.Set(key, []byte(fmt.Sprintf("old%d", i))); err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Set(key, []byte(fmt.Sprintf("v%d", i))); err != nil {
+					t.Fatal(err)
+				}
+				switch i % 3 {
+				case 0:
+					if err := db.Remove(key); err != nil {
+						t.Fatal(err)
+					}
+				case 1:
+					if err := db.Expire(key, 1000); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := db.Expire([]byte("k02"), 1000); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Persist([]byte("k02")); err != nil {
+				t.Fatal(err)
+			}
+
+			check := func(db *KVDB) {
+				t.Helper()
+				for i := 0; i < 30; i++ {
+					key := []byte(fmt.Sprintf("k%02d", i))
+					val, err := db.Get(key)
+					if i%3 == 0 {
+						if err != ErrKeyNotExist {
+							t.Fatalf("Get %s: got %v, want ErrKeyNotExist", key, err)
+						}
+						continue
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					assertBytes(t, val, fmt.Sprintf("v%d", i))
+					if ttl := db.TTL(key); (i%3 == 1) != (ttl > 0) {
+						t.Fatalf("TTL %s: got %d", key, ttl)
+					}
+				}
+			}
+			check(db)
+
+			if err := db.Reclaim(); err != nil {
+				t.Fatal(err)
+			}
+			check(db)
+			db = reopenTestDB(t, db)
+			check(db)
+		})
+	}
+}
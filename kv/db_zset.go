@@ -0,0 +1,338 @@
+package kv
+
+import (
+	"MetaDB/kv/ds/zset"
+	"MetaDB/kv/storage"
+
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ZsetIdx the zset index.
+type ZsetIdx struct {
+	mu      *sync.RWMutex
+	indexes *zset.SortedSet
+}
+
+func newZsetIdx() *ZsetIdx {
+	return &ZsetIdx{indexes: zset.New(), mu: new(sync.RWMutex)}
+}
+
+// ZAdd adds the specified member with the specified score to the sorted set stored at key.
+// Return 1 if the member is new, 0 if the score of an existing member is updated.
+func (db *KVDB) ZAdd(key []byte, score float64, member []byte) (res int, err error) {
+	if math.IsNaN(score) {
+		return 0, ErrInvalidScore
+	}
+	if err = db.checkKeyValue(key, member); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	db.checkExpired(key, ZSet)
+
+	// if the score is not changed, nothing will be done.
+	if oldScore, exist := db.zsetIndex.indexes.ZScore(string(key), string(member)); exist && oldScore == score {
+		return
+	}
+
+	e := storage.NewEntry(key, member, formatScore(score), ZSet, ZSetZAdd)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	res = db.zsetIndex.indexes.ZAdd(string(key), score, string(member))
+	return
+}
+
+// ZScore returns the score of member in the sorted set at key.
+func (db *KVDB) ZScore(key, member []byte) (score float64, ok bool) {
+	if err := db.checkKeyValue(key, member); err != nil {
+		return
+	}
+
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.keyExpired(ZSet, string(key)) {
+		return
+	}
+	return db.zsetIndex.indexes.ZScore(string(key), string(member))
+}
+
+// ZCard returns the sorted set cardinality (number of elements) of the sorted set stored at key.
+func (db *KVDB) ZCard(key []byte) int {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return 0
+	}
+
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.keyExpired(ZSet, string(key)) {
+		return 0
+	}
+	return db.zsetIndex.indexes.ZCard(string(key))
+}
+
+// ZRank returns the rank of member in the sorted set stored at key, with the scores ordered from low to high.
+// The rank (or index) is 0-based, which means that the member with the lowest score has rank 0.
+// -1 is returned if the member not exists.
+func (db *KVDB) ZRank(key []byte, member []byte) int64 {
+	if err := db.checkKeyValue(key, member); err != nil {
+		return -1
+	}
+
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.keyExpired(ZSet, string(key)) {
+		return -1
+	}
+	return db.zsetIndex.indexes.ZRank(string(key), string(member))
+}
+
+// ZRevRank returns the rank of member in the sorted set stored at key, with the scores ordered from high to low.
+// The rank (or index) is 0-based, which means that the member with the highest score has rank 0.
+// -1 is returned if the member not exists.
+func (db *KVDB) ZRevRank(key []byte, member []byte) int64 {
+	if err := db.checkKeyValue(key, member); err != nil {
+		return -1
+	}
+
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.keyExpired(ZSet, string(key)) {
+		return -1
+	}
+	return db.zsetIndex.indexes.ZRevRank(string(key), string(member))
+}
+
+// ZIncrBy increments the score of member in the sorted set stored at key by increment.
+// If member does not exist in the sorted set, it is added with increment as its score (as if its previous score was 0.0).
+// If key does not exist, a new sorted set with the specified member as its sole member is created.
+// Return the new score of member.
+func (db *KVDB) ZIncrBy(key []byte, increment float64, member []byte) (res float64, err error) {
+	if err = db.checkKeyValue(key, member); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	db.checkExpired(key, ZSet)
+
+	res = increment
+	if score, exist := db.zsetIndex.indexes.ZScore(string(key), string(member)); exist {
+		res += score
+	}
+	if math.IsNaN(res) {
+		return 0, ErrInvalidScore
+	}
+
+	e := storage.NewEntry(key, member, formatScore(res), ZSet, ZSetZAdd)
+	if err = db.store(e); err != nil {
+		return
+	}
+
+	db.zsetIndex.indexes.ZAdd(string(key), res, string(member))
+	return
+}
+
+// ZRange returns the specified range of elements in the sorted set stored at key, with their scores.
+// The elements are considered to be ordered from the lowest to the highest score.
+func (db *KVDB) ZRange(key []byte, start, stop int) []zset.Pair {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil
+	}
+
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.keyExpired(ZSet, string(key)) {
+		return nil
+	}
+	return db.zsetIndex.indexes.ZRange(string(key), start, stop)
+}
+
+// ZRevRange returns the specified range of elements in the sorted set stored at key, with their scores.
+// The elements are considered to be ordered from the highest to the lowest score.
+func (db *KVDB) ZRevRange(key []byte, start, stop int) []zset.Pair {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil
+	}
+
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.keyExpired(ZSet, string(key)) {
+		return nil
+	}
+	return db.zsetIndex.indexes.ZRevRange(string(key), start, stop)
+}
+
+// ZRangeByScore returns all the elements in the sorted set at key with a score between min and max (inclusive).
+// The offset and count limit the range of the returned elements, a negative count returns all the elements from the offset.
+func (db *KVDB) ZRangeByScore(key []byte, min, max float64, offset, count int) []zset.Pair {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return nil
+	}
+
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.keyExpired(ZSet, string(key)) {
+		return nil
+	}
+	return db.zsetIndex.indexes.ZRangeByScore(string(key), min, max, offset, count)
+}
+
+// ZCount returns the number of elements in the sorted set at key with a score between min and max (inclusive).
+func (db *KVDB) ZCount(key []byte, min, max float64) int {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return 0
+	}
+
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.keyExpired(ZSet, string(key)) {
+		return 0
+	}
+	return db.zsetIndex.indexes.ZCount(string(key), min, max)
+}
+
+// ZRem removes the specified members from the sorted set stored at key. Non existing members are ignored.
+// Return the number of members removed from the sorted set.
+func (db *KVDB) ZRem(key []byte, members ...[]byte) (res int, err error) {
+	if err = db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	defer db.waitSync(&err)
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	if db.checkExpired(key, ZSet) {
+		return
+	}
+
+	for _, m := range members {
+		if ok := db.zsetIndex.indexes.ZRem(string(key), string(m)); ok {
+			e := storage.NewEntryNoExtra(key, m, ZSet, ZSetZRem)
+			if err = db.store(e); err != nil {
+				return
+			}
+			res++
+		}
+	}
+	if !db.zsetIndex.indexes.ZKeyExists(string(key)) {
+		delete(db.expires[ZSet], string(key))
+	}
+	return
+}
+
+// ZKeyExists check if the key exists in zset.
+func (db *KVDB) ZKeyExists(key []byte) (ok bool) {
+	if err := db.checkKeyValue(key, nil); err != nil {
+		return
+	}
+
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.keyExpired(ZSet, string(key)) {
+		return
+	}
+	return db.zsetIndex.indexes.ZKeyExists(string(key))
+}
+
+// ZClear clear the specified key in zset.
+func (db *KVDB) ZClear(key []byte) (err error) {
+	if !db.ZKeyExists(key) {
+		return ErrKeyNotExist
+	}
+
+	defer db.waitSync(&err)
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	e := storage.NewEntryNoExtra(key, nil, ZSet, ZSetZClear)
+	if err = db.store(e); err != nil {
+		return
+	}
+	db.zsetIndex.indexes.ZClear(string(key))
+	delete(db.expires[ZSet], string(key))
+	return
+}
+
+// ZExpire set expired time for the key in zset.
+func (db *KVDB) ZExpire(key []byte, duration int64) (err error) {
+	if duration <= 0 {
+		return ErrInvalidTTL
+	}
+	if !db.ZKeyExists(key) {
+		return ErrKeyNotExist
+	}
+
+	defer db.waitSync(&err)
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	deadline := time.Now().Unix() + duration
+	e := storage.NewEntryWithExpire(key, nil, deadline, ZSet, ZSetZExpire)
+	if err = db.store(e); err != nil {
+		return
+	}
+	db.expires[ZSet][string(key)] = expireDeadline(e)
+	return
+}
+
+// ZPersist remove the expired time of the key in zset.
+func (db *KVDB) ZPersist(key []byte) (err error) {
+	if !db.ZKeyExists(key) {
+		return ErrKeyNotExist
+	}
+
+	defer db.waitSync(&err)
+	db.zsetIndex.mu.Lock()
+	defer db.zsetIndex.mu.Unlock()
+
+	if _, exist := db.expires[ZSet][string(key)]; !exist {
+		return
+	}
+	e := storage.NewEntryNoExtra(key, nil, ZSet, ZSetZPersist)
+	if err = db.store(e); err != nil {
+		return
+	}
+	delete(db.expires[ZSet], string(key))
+	return
+}
+
+// ZTTL return time to live of the key in zset.
+func (db *KVDB) ZTTL(key []byte) (ttl int64) {
+	db.zsetIndex.mu.RLock()
+	defer db.zsetIndex.mu.RUnlock()
+
+	if db.keyExpired(ZSet, string(key)) {
+		return
+	}
+
+	deadline, exist := db.expires[ZSet][string(key)]
+	if !exist {
+		return
+	}
+	return db.ttl(deadline)
+}
+
+// formatScore saves the score in the extra of entry.
+func formatScore(score float64) []byte {
+	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
+}
@@ -0,0 +1,204 @@
+package kv
+
+import (
+	"MetaDB/kv/ds/zset"
+
+	"fmt"
+	"math"
+	"testing"
+)
+
+func assertPairs(t *testing.T, got []zset.Pair, want ...interface{}) {
+	t.Helper()
+
+	if len(got)*2 != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i, p := range got {
+		if string(p.Member) != want[2*i].(string) || p.Score != want[2*i+1].(float64) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestZSetOperations(t *testing.T) {
+	db := openTestDB(t, nil)
+	key := []byte("z")
+
+	for i, m := range []string{"a", "b", "c", "d"} {
+		if n, err := db.ZAdd(key, float64(i+1), []byte(m)); err != nil || n != 1 {
+			t.Fatalf("ZAdd: got %d, %v", n, err)
+		}
+	}
+	if n, err := db.ZAdd(key, 10, []byte("a")); err != nil || n != 0 {
+		t.Fatalf("ZAdd an existing member: got %d, %v", n, err)
+	}
+	if _, err := db.ZAdd(key, math.NaN(), []byte("a")); err != ErrInvalidScore {
+		t.Fatalf("ZAdd NaN: got %v, want ErrInvalidScore", err)
+	}
+	if score, err := db.ZIncrBy(key, 0.5, []byte("b")); err != nil || score != 2.5 {
+		t.Fatalf("ZIncrBy: got %v, %v", score, err)
+	}
+	if score, ok := db.ZScore(key, []byte("a")); !ok || score != 10 {
+		t.Fatalf("ZScore: got %v, %v", score, ok)
+	}
+	if n := db.ZCard(key); n != 4 {
+		t.Fatalf("ZCard: got %d, want 4", n)
+	}
+	if rank := db.ZRank(key, []byte("a")); rank != 3 {
+		t.Fatalf("ZRank: got %d, want 3", rank)
+	}
+	if rank := db.ZRevRank(key, []byte("a")); rank != 0 {
+		t.Fatalf("ZRevRank: got %d, want 0", rank)
+	}
+	if rank := db.ZRank(key, []byte("missing")); rank != -1 {
+		t.Fatalf("ZRank a missing member: got %d, want -1", rank)
+	}
+
+	assertPairs(t, db.ZRange(key, 0, 1), "b", 2.5, "c", 3.0)
+	assertPairs(t, db.ZRevRange(key, 0, 0), "a", 10.0)
+	assertPairs(t, db.ZRangeByScore(key, 2, 4, 1, -1), "c", 3.0, "d", 4.0)
+	if n := db.ZCount(key, 3, 10); n != 3 {
+		t.Fatalf("ZCount: got %d, want 3", n)
+	}
+
+	if n, err := db.ZRem(key, []byte("c"), []byte("missing")); err != nil || n != 1 {
+		t.Fatalf("ZRem: got %d, %v", n, err)
+	}
+	if err := db.ZExpire(key, 100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd([]byte("persisted"), 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ZExpire([]byte("persisted"), 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ZPersist([]byte("persisted")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd([]byte("cleared"), 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ZClear([]byte("cleared")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ZClear([]byte("cleared")); err != ErrKeyNotExist {
+		t.Fatalf("ZClear twice: got %v", err)
+	}
+
+	check := func(db *KVDB) {
+		t.Helper()
+		assertPairs(t, db.ZRange(key, 0, -1), "b", 2.5, "d", 4.0, "a", 10.0)
+		if ttl := db.ZTTL(key); ttl <= 0 {
+			t.Fatalf("ZTTL: got %d", ttl)
+		}
+		if ttl := db.ZTTL([]byte("persisted")); ttl != 0 {
+			t.Fatalf("ZTTL after persisting: got %d", ttl)
+		}
+		if db.ZKeyExists([]byte("cleared")) {
+			t.Fatal("the cleared zset exists")
+		}
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
+
+// Every operation of zset is replayed from the db files and kept by Reclaim.
+func TestZSetReclaim(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+	key := []byte("scores")
+
+	for i := 0; i < 20; i++ {
+		member := []byte(fmt.Sprintf("m%02d", i))
+		if _, err := db.ZAdd(key, float64(i), member); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ZIncrBy(key, 100, member); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if _, err := db.ZRem(key, member); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// the score goes back to an old one.
+	if _, err := db.ZAdd(key, 1, []byte("back")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd(key, 2, []byte("back")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd(key, 1, []byte("back")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ZExpire(key, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ZExpire(key, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd([]byte("persisted"), 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ZExpire([]byte("persisted"), 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ZPersist([]byte("persisted")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd([]byte("cleared"), 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ZClear([]byte("cleared")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd([]byte("expired"), 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ZExpire([]byte("expired"), 1000); err != nil {
+		t.Fatal(err)
+	}
+	// fill the active file, so the entries above are archived.
+	for i := 0; i < 40; i++ {
+		if _, err := db.ZAdd([]byte("filler"), float64(i), []byte(fmt.Sprintf("f%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expireNow(db, ZSet, "expired")
+
+	var want []interface{}
+	want = append(want, "back", 1.0)
+	for i := 1; i < 20; i += 2 {
+		want = append(want, fmt.Sprintf("m%02d", i), float64(i+100))
+	}
+	check := func(db *KVDB) {
+		t.Helper()
+		assertPairs(t, db.ZRange(key, 0, -1), want...)
+		if ttl := db.ZTTL(key); ttl <= 10 {
+			t.Fatalf("ZTTL: got %d, want the latest one", ttl)
+		}
+		if ttl := db.ZTTL([]byte("persisted")); ttl != 0 || !db.ZKeyExists([]byte("persisted")) {
+			t.Fatalf("ZTTL after persisting: got %d", ttl)
+		}
+		if db.ZKeyExists([]byte("cleared")) || db.ZKeyExists([]byte("expired")) {
+			t.Fatal("the removed zsets exist")
+		}
+		if n := db.ZCard([]byte("filler")); n != 40 {
+			t.Fatalf("ZCard: got %d, want 40", n)
+		}
+	}
+	check(db)
+
+	if len(db.archFiles[ZSet]) < 2 {
+		t.Fatalf("got %d archived files, want at least 2", len(db.archFiles[ZSet]))
+	}
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
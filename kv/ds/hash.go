@@ -1,57 +1,103 @@
 package hash
 
+import (
+	"MetaDB/kv/index"
+
+	"container/heap"
+	"sort"
+
+	"github.com/tidwall/btree"
+)
+
 type (
 	Hash struct {
 		record Record
+		keys   *index.Keys
+		// the fields of every key in the order of their hashes, so they can be scanned incrementally.
+		// It is kept only if the hash is ordered, otherwise the fields are selected in every scan.
+		scanFields map[string]*index.Keys
+		// the fields of every key in lexicographic order, it is kept only if the hash is ordered.
+		fields map[string]*btree.BTree
 	}
 
-	Record map[string]map[string][]byte
+	hashedField struct {
+		hash  uint64
+		field string
+	}
+
+	// fieldHeap a max heap of the fields in the order of their hashes.
+	fieldHeap []hashedField
+
+	// Record saves the index info of every field, the value is kept in Indexer.Meta.Value
+	// only when the values are stored in memory.
+	Record map[string]map[string]*index.Indexer
 )
 
 func New() *Hash {
-	return &Hash{make(Record)}
+	return &Hash{record: make(Record), keys: index.NewKeys()}
+}
+
+// NewOrdered returns a hash which keeps the fields of every key in lexicographic order,
+// so HKeys, HVals, HGetAll and HRange return the fields in order, at the cost of more memory and slower writes.
+func NewOrdered() *Hash {
+	return &Hash{
+		record:     make(Record),
+		keys:       index.NewKeys(),
+		scanFields: make(map[string]*index.Keys),
+		fields:     make(map[string]*btree.BTree),
+	}
 }
 
-func (h *Hash) HSet(key string, field string, value []byte) int {
+// HSet returns 1 if field is a new field in the hash, otherwise 0.
+func (h *Hash) HSet(key string, field string, idx *index.Indexer) (res int) {
 	if !h.exist(key) {
-		h.record[key] = make(map[string][]byte)
+		h.record[key] = make(map[string]*index.Indexer)
+		h.keys.Add(key)
 	}
 
-	h.record[key][field] = value
-	return 0
+	if _, exist := h.record[key][field]; !exist {
+		h.addField(key, field)
+		res = 1
+	}
+	h.record[key][field] = idx
+	return
 }
 
-func (h *Hash) HSetNx(key string, field string, value []byte) int {
+func (h *Hash) HSetNx(key string, field string, idx *index.Indexer) int {
 	if !h.exist(key) {
-		h.record[key] = make(map[string][]byte)
+		h.record[key] = make(map[string]*index.Indexer)
+		h.keys.Add(key)
 	}
 
 	if _, exist := h.record[key][field]; !exist {
-		h.record[key][field] = value
+		h.record[key][field] = idx
+		h.addField(key, field)
+		return 1
 	}
 	return 0
 }
 
-func (h *Hash) HGet(key string, field string) ([]byte, int) {
+func (h *Hash) HGet(key string, field string) (*index.Indexer, int) {
 	if !h.exist(key) {
-		return []byte{}, 1
+		return nil, 1
 	}
 
 	if _, exist := h.record[key][field]; !exist {
-		return []byte{}, 1
+		return nil, 1
 	}
 	return h.record[key][field], 0
 }
 
-func (h *Hash) HGetAll(key string) ([][]byte, int) {
+// HGetAll returns the index info of all fields, the field name is saved in Indexer.Meta.Extra.
+func (h *Hash) HGetAll(key string) ([]*index.Indexer, int) {
 	if !h.exist(key) {
-		return [][]byte{}, 1
+		return []*index.Indexer{}, 1
 	}
 
-	res := [][]byte{}
-	for k, v := range h.record[key] {
-		res = append(res, []byte(k), v)
-	}
+	res := []*index.Indexer{}
+	h.iterate(key, func(field string, idx *index.Indexer) {
+		res = append(res, idx)
+	})
 	return res, 0
 }
 
@@ -64,6 +110,10 @@ func (h *Hash) HDel (key, field string) int {
 		return 1
 	}
 	delete(h.record[key], field)
+	if h.fields != nil {
+		h.scanFields[key].Remove(field)
+		h.fields[key].Delete(field)
+	}
 	return 0
 }
 func (h *Hash) HKeyExists(key string) bool {
@@ -95,22 +145,91 @@ func (h *Hash) HKeys(key string) ([]string, int) {
 	}
 
 	res := []string{}
-	for k := range h.record[key] {
-		res = append(res, k)
+	h.iterate(key, func(field string, idx *index.Indexer) {
+		res = append(res, field)
+	})
+	return res, 0
+}
+
+func (h *Hash) HVals(key string) ([]*index.Indexer, int) {
+	if !h.exist(key) {
+		return []*index.Indexer{}, 1
 	}
+
+	res := []*index.Indexer{}
+	h.iterate(key, func(field string, idx *index.Indexer) {
+		res = append(res, idx)
+	})
 	return res, 0
 }
 
-func (h *Hash) HVals(key string) ([][]byte, int) {
+// HRandField returns count random fields of key like SRandMember of set, the field name is saved in Indexer.Meta.Extra.
+// The fields are distinct if count is positive, otherwise the same field may be returned more than once.
+func (h *Hash) HRandField(key string, count int) []*index.Indexer {
+	res := []*index.Indexer{}
+	if len(h.record[key]) == 0 || count == 0 {
+		return res
+	}
+
+	if count > 0 {
+		for _, idx := range h.record[key] {
+			res = append(res, idx)
+			if len(res) == count {
+				break
+			}
+		}
+		return res
+	}
+
+	count = -count
+	for len(res) < count {
+		// the iteration order of map is random, so take the first one every time.
+		for _, idx := range h.record[key] {
+			res = append(res, idx)
+			break
+		}
+	}
+	return res
+}
+
+// HRange returns the index info of the fields in [start, end] in lexicographic order, an empty end means no upper bound.
+// At most limit fields are returned if limit is positive.
+// If the hash is not ordered, the fields are sorted in every call, it is slow for the large hashes.
+func (h *Hash) HRange(key, start, end string, limit int) []*index.Indexer {
+	res := []*index.Indexer{}
 	if !h.exist(key) {
-		return [][]byte{}, 1
+		return res
+	}
+	inRange := func(field string) bool {
+		return field >= start && (end == "" || field <= end)
 	}
 
-	res := [][]byte{}
-	for _, v := range h.record[key] {
-		res = append(res, v)
+	if h.fields != nil {
+		h.fields[key].Ascend(start, func(item interface{}) bool {
+			field := item.(string)
+			if !inRange(field) {
+				return false
+			}
+			res = append(res, h.record[key][field])
+			return limit <= 0 || len(res) < limit
+		})
+		return res
 	}
-	return res, 0
+
+	var fields []string
+	for field := range h.record[key] {
+		if inRange(field) {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	if limit > 0 && len(fields) > limit {
+		fields = fields[:limit]
+	}
+	for _, field := range fields {
+		res = append(res, h.record[key][field])
+	}
+	return res
 }
 
 func (h *Hash) HClear(key string) int {
@@ -118,10 +237,148 @@ func (h *Hash) HClear(key string) int {
 		return 1
 	}
 	delete(h.record, key)
+	h.keys.Remove(key)
+	if h.fields != nil {
+		delete(h.scanFields, key)
+		delete(h.fields, key)
+	}
 	return 0
 }
 
+// ScanKeys calls fn for the keys from the hash cursor in the order of their hashes, until fn returns false.
+// The keys whose fields are all deleted are included.
+func (h *Hash) ScanKeys(cursor uint64, fn func(hash uint64, key string) bool) {
+	h.keys.Ascend(cursor, fn)
+}
+
+// ScanFields returns the index info of at least count fields of key from the hash cursor in the order of their hashes,
+// and the hash of the next field, which is 0 if there are no more fields.
+// The fields with the same hash are returned together, since the cursor can't point to one of them.
+// If the hash is not ordered, the fields are selected from all fields of key in every call, but not sorted.
+func (h *Hash) ScanFields(key string, cursor uint64, count int) (res []*index.Indexer, next uint64) {
+	if !h.exist(key) {
+		return
+	}
+	if h.fields == nil {
+		return h.selectFields(key, cursor, count)
+	}
+
+	var last uint64
+	h.scanFields[key].Ascend(cursor, func(hash uint64, field string) bool {
+		if len(res) >= count && hash != last {
+			next = hash
+			return false
+		}
+		res, last = append(res, h.record[key][field]), hash
+		return true
+	})
+	return
+}
+
+// selectFields returns the fields like ScanFields for the hash which is not ordered,
+// the count fields with the smallest hashes from cursor are kept in a max heap.
+func (h *Hash) selectFields(key string, cursor uint64, count int) (res []*index.Indexer, next uint64) {
+	selected := make(fieldHeap, 0, count)
+	for field := range h.record[key] {
+		f := hashedField{hash: index.KeyHash(field), field: field}
+		if f.hash < cursor {
+			continue
+		}
+		if len(selected) < count {
+			heap.Push(&selected, f)
+		} else if f.less(selected[0]) {
+			selected[0] = f
+			heap.Fix(&selected, 0)
+		}
+	}
+	if len(selected) == 0 {
+		return
+	}
+
+	// the other fields with the same hash as the last one are added, and the next is the smallest hash after it.
+	last := selected[0].hash
+	fields := []hashedField(selected)
+	for field := range h.record[key] {
+		hash := index.KeyHash(field)
+		if hash == last && !selected.contains(field) {
+			fields = append(fields, hashedField{hash: hash, field: field})
+		}
+		if hash > last && (next == 0 || hash < next) {
+			next = hash
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].less(fields[j])
+	})
+	for _, f := range fields {
+		res = append(res, h.record[key][f.field])
+	}
+	return
+}
+
+// iterate calls fn for every field of key, in lexicographic order if the hash is ordered.
+func (h *Hash) iterate(key string, fn func(field string, idx *index.Indexer)) {
+	if h.fields == nil {
+		for field, idx := range h.record[key] {
+			fn(field, idx)
+		}
+		return
+	}
+	h.fields[key].Ascend(nil, func(item interface{}) bool {
+		fn(item.(string), h.record[key][item.(string)])
+		return true
+	})
+}
+
+func (h *Hash) addField(key, field string) {
+	if h.fields == nil {
+		return
+	}
+	if h.scanFields[key] == nil {
+		h.scanFields[key] = index.NewKeys()
+	}
+	h.scanFields[key].Add(field)
+
+	if h.fields[key] == nil {
+		h.fields[key] = btree.NewNonConcurrent(func(a, b interface{}) bool {
+			return a.(string) < b.(string)
+		})
+	}
+	h.fields[key].Set(field)
+}
+
 func (h *Hash) exist(key string) bool {
 	_, exist := h.record[key]
 	return exist
 }
+
+func (f hashedField) less(other hashedField) bool {
+	if f.hash != other.hash {
+		return f.hash < other.hash
+	}
+	return f.field < other.field
+}
+
+func (h fieldHeap) Len() int           { return len(h) }
+func (h fieldHeap) Less(i, j int) bool { return h[j].less(h[i]) }
+func (h fieldHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *fieldHeap) Push(x interface{}) {
+	*h = append(*h, x.(hashedField))
+}
+
+func (h *fieldHeap) Pop() interface{} {
+	old := *h
+	f := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return f
+}
+
+func (h fieldHeap) contains(field string) bool {
+	for _, f := range h {
+		if f.field == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,335 @@
+package list
+
+import (
+	"MetaDB/kv/index"
+
+	"bytes"
+	"container/list"
+)
+
+// InsertOption insert option for LInsert.
+type InsertOption uint8
+
+const (
+	// Before insert before pivot.
+	Before InsertOption = iota
+	// After insert after pivot.
+	After
+)
+
+type (
+	List struct {
+		record Record
+		keys   *index.Keys
+	}
+
+	Record map[string]*list.List
+)
+
+func New() *List {
+	return &List{record: make(Record), keys: index.NewKeys()}
+}
+
+// LPush insert all the specified values at the head of the list stored at key.
+// Return the length of the list after the push operations.
+func (l *List) LPush(key string, val ...[]byte) int {
+	return l.push(true, key, val...)
+}
+
+// RPush insert all the specified values at the tail of the list stored at key.
+// Return the length of the list after the push operations.
+func (l *List) RPush(key string, val ...[]byte) int {
+	return l.push(false, key, val...)
+}
+
+// LPop removes and returns the first element of the list stored at key.
+func (l *List) LPop(key string) []byte {
+	return l.pop(true, key)
+}
+
+// RPop removes and returns the last element of the list stored at key.
+func (l *List) RPop(key string) []byte {
+	return l.pop(false, key)
+}
+
+// LIndex returns the element at index in the list stored at key.
+// Negative indices can be used to designate elements starting at the tail of the list.
+func (l *List) LIndex(key string, index int) []byte {
+	ok, newIndex := l.validIndex(key, index)
+	if !ok {
+		return nil
+	}
+
+	e := l.index(key, newIndex)
+	if e == nil {
+		return nil
+	}
+	return e.Value.([]byte)
+}
+
+// LSet sets the list element at index to val.
+// Return false if the index is out of range.
+func (l *List) LSet(key string, index int, val []byte) bool {
+	ok, newIndex := l.validIndex(key, index)
+	if !ok {
+		return false
+	}
+
+	e := l.index(key, newIndex)
+	if e == nil {
+		return false
+	}
+	e.Value = val
+	return true
+}
+
+// LRange returns the specified elements of the list stored at key.
+// The offsets start and end are zero-based indexes, and they can also be negative numbers.
+func (l *List) LRange(key string, start, end int) [][]byte {
+	var res [][]byte
+	item := l.record[key]
+	if item == nil || item.Len() <= 0 {
+		return res
+	}
+
+	start, end = l.handleIndex(item.Len(), start, end)
+	if start > end || start >= item.Len() {
+		return res
+	}
+
+	e := item.Front()
+	for i := 0; i < start; i++ {
+		e = e.Next()
+	}
+	for i := start; i <= end && e != nil; i++ {
+		res = append(res, e.Value.([]byte))
+		e = e.Next()
+	}
+	return res
+}
+
+// LTrim trims an existing list so that it will contain only the specified range of elements specified.
+// The list will be removed if the range is empty.
+func (l *List) LTrim(key string, start, end int) bool {
+	item := l.record[key]
+	if item == nil || item.Len() <= 0 {
+		return false
+	}
+
+	start, end = l.handleIndex(item.Len(), start, end)
+	if start > end || start >= item.Len() {
+		l.remove(key)
+		return true
+	}
+
+	startEle, endEle := l.index(key, start), l.index(key, end)
+	for e := item.Front(); e != startEle; {
+		next := e.Next()
+		item.Remove(e)
+		e = next
+	}
+	for e := endEle.Next(); e != nil; {
+		next := e.Next()
+		item.Remove(e)
+		e = next
+	}
+	return true
+}
+
+// LRem removes the first count occurrences of elements equal to val from the list stored at key.
+// count > 0: remove elements equal to val moving from head to tail.
+// count < 0: remove elements equal to val moving from tail to head.
+// count = 0: remove all elements equal to val.
+// Return the number of removed elements.
+func (l *List) LRem(key string, val []byte, count int) int {
+	item := l.record[key]
+	if item == nil {
+		return 0
+	}
+
+	var ele []*list.Element
+	if count == 0 {
+		for p := item.Front(); p != nil; p = p.Next() {
+			if bytes.Equal(p.Value.([]byte), val) {
+				ele = append(ele, p)
+			}
+		}
+	}
+	if count > 0 {
+		for p := item.Front(); p != nil && len(ele) < count; p = p.Next() {
+			if bytes.Equal(p.Value.([]byte), val) {
+				ele = append(ele, p)
+			}
+		}
+	}
+	if count < 0 {
+		for p := item.Back(); p != nil && len(ele) < -count; p = p.Prev() {
+			if bytes.Equal(p.Value.([]byte), val) {
+				ele = append(ele, p)
+			}
+		}
+	}
+
+	for _, e := range ele {
+		item.Remove(e)
+	}
+	if item.Len() == 0 {
+		l.remove(key)
+	}
+	return len(ele)
+}
+
+// LInsert inserts val in the list stored at key either before or after the reference value pivot.
+// Return the length of the list after the insert operation, or -1 when the value pivot was not found.
+func (l *List) LInsert(key string, option InsertOption, pivot, val []byte) int {
+	e := l.find(key, pivot)
+	if e == nil {
+		return -1
+	}
+
+	item := l.record[key]
+	if option == Before {
+		item.InsertBefore(val, e)
+	}
+	if option == After {
+		item.InsertAfter(val, e)
+	}
+	return item.Len()
+}
+
+// LKeyExists check if the key of a List exists.
+func (l *List) LKeyExists(key string) bool {
+	_, exist := l.record[key]
+	return exist
+}
+
+// Keys returns all the keys of List in random order.
+func (l *List) Keys() []string {
+	res := make([]string, 0, len(l.record))
+	for key := range l.record {
+		res = append(res, key)
+	}
+	return res
+}
+
+// ScanKeys calls fn for the keys of List from the hash cursor in the order of their hashes, until fn returns false.
+func (l *List) ScanKeys(cursor uint64, fn func(hash uint64, key string) bool) {
+	l.keys.Ascend(cursor, fn)
+}
+
+// LLen returns the length of the list stored at key.
+func (l *List) LLen(key string) int {
+	if l.record[key] == nil {
+		return 0
+	}
+	return l.record[key].Len()
+}
+
+// LClear clear a specified key for List.
+func (l *List) LClear(key string) {
+	l.remove(key)
+}
+
+func (l *List) push(front bool, key string, val ...[]byte) int {
+	if l.record[key] == nil {
+		l.record[key] = list.New()
+		l.keys.Add(key)
+	}
+
+	for _, v := range val {
+		if front {
+			l.record[key].PushFront(v)
+		} else {
+			l.record[key].PushBack(v)
+		}
+	}
+	return l.record[key].Len()
+}
+
+func (l *List) remove(key string) {
+	delete(l.record, key)
+	l.keys.Remove(key)
+}
+
+func (l *List) pop(front bool, key string) []byte {
+	item := l.record[key]
+	if item == nil || item.Len() <= 0 {
+		return nil
+	}
+
+	var e *list.Element
+	if front {
+		e = item.Front()
+	} else {
+		e = item.Back()
+	}
+	val := item.Remove(e).([]byte)
+
+	// the key will be removed when the list is empty.
+	if item.Len() == 0 {
+		l.remove(key)
+	}
+	return val
+}
+
+func (l *List) find(key string, val []byte) *list.Element {
+	item := l.record[key]
+	if item == nil {
+		return nil
+	}
+
+	for p := item.Front(); p != nil; p = p.Next() {
+		if bytes.Equal(p.Value.([]byte), val) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (l *List) index(key string, index int) *list.Element {
+	item := l.record[key]
+	if index < item.Len()>>1 {
+		e := item.Front()
+		for i := 0; i < index; i++ {
+			e = e.Next()
+		}
+		return e
+	}
+
+	e := item.Back()
+	for i := item.Len() - 1; i > index; i-- {
+		e = e.Prev()
+	}
+	return e
+}
+
+// validIndex check if the index is in range, a negative index will be converted.
+func (l *List) validIndex(key string, index int) (bool, int) {
+	item := l.record[key]
+	if item == nil || item.Len() <= 0 {
+		return false, index
+	}
+
+	length := item.Len()
+	if index < 0 {
+		index += length
+	}
+	return index >= 0 && index < length, index
+}
+
+// handleIndex converts the negative start and end, and limits them within the length.
+func (l *List) handleIndex(length int, start, end int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	return start, end
+}
@@ -0,0 +1,230 @@
+package set
+
+import (
+	"MetaDB/kv/index"
+)
+
+var existFlag = struct{}{}
+
+type (
+	Set struct {
+		record Record
+		keys   *index.Keys
+	}
+
+	Record map[string]map[string]struct{}
+)
+
+func New() *Set {
+	return &Set{record: make(Record), keys: index.NewKeys()}
+}
+
+// SAdd add the specified member to the set stored at key.
+// Return 1 if the member is added, 0 if it is already a member of the set.
+func (s *Set) SAdd(key string, member []byte) int {
+	if !s.exist(key) {
+		s.record[key] = make(map[string]struct{})
+		s.keys.Add(key)
+	}
+
+	if _, exist := s.record[key][string(member)]; exist {
+		return 0
+	}
+	s.record[key][string(member)] = existFlag
+	return 1
+}
+
+// SPop removes and returns one or more random members from the set value store at key.
+func (s *Set) SPop(key string, count int) [][]byte {
+	var val [][]byte
+	if !s.exist(key) || count <= 0 {
+		return val
+	}
+
+	for k := range s.record[key] {
+		delete(s.record[key], k)
+		val = append(val, []byte(k))
+
+		count--
+		if count == 0 {
+			break
+		}
+	}
+
+	if len(s.record[key]) == 0 {
+		s.remove(key)
+	}
+	return val
+}
+
+// SIsMember Returns if member is a member of the set stored at key.
+func (s *Set) SIsMember(key string, member []byte) bool {
+	if !s.exist(key) {
+		return false
+	}
+	_, exist := s.record[key][string(member)]
+	return exist
+}
+
+// SRandMember returns random members of the set stored at key.
+// When called with a positive count, return an array of distinct members.
+// If the provided count argument is negative, the same member can be returned multiple times, and the length is the absolute value of count.
+func (s *Set) SRandMember(key string, count int) [][]byte {
+	var val [][]byte
+	if !s.exist(key) || count == 0 {
+		return val
+	}
+
+	if count > 0 {
+		for k := range s.record[key] {
+			val = append(val, []byte(k))
+			if len(val) == count {
+				break
+			}
+		}
+		return val
+	}
+
+	count = -count
+	for len(val) < count {
+		// the iteration order of map is random, so take the first one every time.
+		for k := range s.record[key] {
+			val = append(val, []byte(k))
+			break
+		}
+	}
+	return val
+}
+
+// SRem remove the specified member from the set stored at key.
+// Return true if the member is removed.
+func (s *Set) SRem(key string, member []byte) bool {
+	if !s.exist(key) {
+		return false
+	}
+
+	if _, exist := s.record[key][string(member)]; !exist {
+		return false
+	}
+	delete(s.record[key], string(member))
+
+	if len(s.record[key]) == 0 {
+		s.remove(key)
+	}
+	return true
+}
+
+// SMove move member from the set at source to the set at destination.
+// Return false if the member is not a member of source.
+func (s *Set) SMove(src, dst string, member []byte) bool {
+	if !s.SRem(src, member) {
+		return false
+	}
+	s.SAdd(dst, member)
+	return true
+}
+
+// SCard returns the set cardinality (number of elements) of the set stored at key.
+func (s *Set) SCard(key string) int {
+	if !s.exist(key) {
+		return 0
+	}
+	return len(s.record[key])
+}
+
+// SMembers returns all the members of the set value stored at key.
+func (s *Set) SMembers(key string) [][]byte {
+	var val [][]byte
+	if !s.exist(key) {
+		return val
+	}
+
+	for k := range s.record[key] {
+		val = append(val, []byte(k))
+	}
+	return val
+}
+
+// SUnion returns the members of the set resulting from the union of all the given sets.
+func (s *Set) SUnion(keys ...string) [][]byte {
+	var val [][]byte
+	m := make(map[string]struct{})
+	for _, key := range keys {
+		for k := range s.record[key] {
+			if _, exist := m[k]; !exist {
+				m[k] = existFlag
+				val = append(val, []byte(k))
+			}
+		}
+	}
+	return val
+}
+
+// SDiff returns the members of the set resulting from the difference between the first set and all the successive sets.
+func (s *Set) SDiff(keys ...string) [][]byte {
+	var val [][]byte
+	if len(keys) == 0 || !s.exist(keys[0]) {
+		return val
+	}
+
+	for k := range s.record[keys[0]] {
+		flag := true
+		for i := 1; i < len(keys); i++ {
+			if s.SIsMember(keys[i], []byte(k)) {
+				flag = false
+				break
+			}
+		}
+		if flag {
+			val = append(val, []byte(k))
+		}
+	}
+	return val
+}
+
+// SInter returns the members of the set resulting from the intersection of all the given sets.
+func (s *Set) SInter(keys ...string) [][]byte {
+	var val [][]byte
+	if len(keys) == 0 || !s.exist(keys[0]) {
+		return val
+	}
+
+	for k := range s.record[keys[0]] {
+		flag := true
+		for i := 1; i < len(keys); i++ {
+			if !s.SIsMember(keys[i], []byte(k)) {
+				flag = false
+				break
+			}
+		}
+		if flag {
+			val = append(val, []byte(k))
+		}
+	}
+	return val
+}
+
+// SKeyExists returns if the key exists.
+func (s *Set) SKeyExists(key string) bool {
+	return s.exist(key)
+}
+
+// ScanKeys calls fn for the keys from the hash cursor in the order of their hashes, until fn returns false.
+func (s *Set) ScanKeys(cursor uint64, fn func(hash uint64, key string) bool) {
+	s.keys.Ascend(cursor, fn)
+}
+
+// SClear clear the specified key in set.
+func (s *Set) SClear(key string) {
+	s.remove(key)
+}
+
+func (s *Set) remove(key string) {
+	delete(s.record, key)
+	s.keys.Remove(key)
+}
+
+func (s *Set) exist(key string) bool {
+	_, exist := s.record[key]
+	return exist
+}
@@ -0,0 +1,475 @@
+package zset
+
+import (
+	"MetaDB/kv/index"
+
+	"math/rand"
+)
+
+const (
+	// the max level of the skip list, it is enough for 2^64 elements.
+	maxLevel    = 32
+	probability = 0.25
+)
+
+type (
+	// SortedSet sorted set, every key holds a skip list sorted by score.
+	SortedSet struct {
+		record map[string]*SortedSetNode
+		keys   *index.Keys
+	}
+
+	// SortedSetNode node of sorted set, the dict is used to find the score of a member quickly.
+	SortedSetNode struct {
+		dict map[string]*sklNode
+		skl  *skipList
+	}
+
+	// Pair a member with its score.
+	Pair struct {
+		Member []byte
+		Score  float64
+	}
+
+	sklLevel struct {
+		forward *sklNode
+		span    uint64
+	}
+
+	sklNode struct {
+		member   string
+		score    float64
+		backward *sklNode
+		level    []*sklLevel
+	}
+
+	skipList struct {
+		head   *sklNode
+		tail   *sklNode
+		length int64
+		level  int16
+	}
+)
+
+func New() *SortedSet {
+	return &SortedSet{record: make(map[string]*SortedSetNode), keys: index.NewKeys()}
+}
+
+// ZAdd adds the specified member with the specified score to the sorted set stored at key.
+// Return 1 if the member is new, 0 if the score of an existing member is updated.
+func (z *SortedSet) ZAdd(key string, score float64, member string) int {
+	if !z.exist(key) {
+		z.record[key] = &SortedSetNode{
+			dict: make(map[string]*sklNode),
+			skl:  newSkipList(),
+		}
+		z.keys.Add(key)
+	}
+
+	item := z.record[key]
+	v, exist := item.dict[member]
+	if exist {
+		if score != v.score {
+			item.skl.delete(v.score, member)
+			item.dict[member] = item.skl.insert(score, member)
+		}
+		return 0
+	}
+
+	item.dict[member] = item.skl.insert(score, member)
+	return 1
+}
+
+// ZScore returns the score of member in the sorted set at key.
+func (z *SortedSet) ZScore(key string, member string) (float64, bool) {
+	if !z.exist(key) {
+		return 0, false
+	}
+
+	node, exist := z.record[key].dict[member]
+	if !exist {
+		return 0, false
+	}
+	return node.score, true
+}
+
+// ZCard returns the sorted set cardinality (number of elements) of the sorted set stored at key.
+func (z *SortedSet) ZCard(key string) int {
+	if !z.exist(key) {
+		return 0
+	}
+	return len(z.record[key].dict)
+}
+
+// ZRank returns the rank of member in the sorted set stored at key, with the scores ordered from low to high.
+// The rank (or index) is 0-based, -1 is returned if the member not exists.
+func (z *SortedSet) ZRank(key, member string) int64 {
+	return z.getRank(key, member, false)
+}
+
+// ZRevRank returns the rank of member in the sorted set stored at key, with the scores ordered from high to low.
+// The rank (or index) is 0-based, -1 is returned if the member not exists.
+func (z *SortedSet) ZRevRank(key, member string) int64 {
+	return z.getRank(key, member, true)
+}
+
+// ZIncrBy increments the score of member in the sorted set stored at key by increment.
+// If member does not exist in the sorted set, it is added with increment as its score.
+// Return the new score of member.
+func (z *SortedSet) ZIncrBy(key string, increment float64, member string) float64 {
+	if score, exist := z.ZScore(key, member); exist {
+		increment += score
+	}
+	z.ZAdd(key, increment, member)
+	return increment
+}
+
+// ZRange returns the specified range of elements in the sorted set stored at key, ordered from the lowest to the highest score.
+// The start and stop are 0-based indexes, and they can also be negative numbers indicating offsets from the end of the sorted set.
+func (z *SortedSet) ZRange(key string, start, stop int) []Pair {
+	return z.findRange(key, int64(start), int64(stop), false)
+}
+
+// ZRevRange returns the specified range of elements in the sorted set stored at key, ordered from the highest to the lowest score.
+func (z *SortedSet) ZRevRange(key string, start, stop int) []Pair {
+	return z.findRange(key, int64(start), int64(stop), true)
+}
+
+// ZRangeByScore returns all the elements in the sorted set at key with a score between min and max (including elements with score equal to min or max).
+// The elements are considered to be ordered from low to high scores.
+// The offset and count are used like SELECT LIMIT offset, count in SQL, a negative count returns all the elements from the offset.
+func (z *SortedSet) ZRangeByScore(key string, min, max float64, offset, count int) []Pair {
+	var res []Pair
+	if !z.exist(key) || min > max || offset < 0 || count == 0 {
+		return res
+	}
+
+	p := z.record[key].skl.firstInRange(min)
+	for ; p != nil && offset > 0 && p.score <= max; offset-- {
+		p = p.level[0].forward
+	}
+	for ; p != nil && p.score <= max; p = p.level[0].forward {
+		res = append(res, Pair{Member: []byte(p.member), Score: p.score})
+		if count > 0 && len(res) == count {
+			break
+		}
+	}
+	return res
+}
+
+// ZCount returns the number of elements in the sorted set at key with a score between min and max.
+func (z *SortedSet) ZCount(key string, min, max float64) int {
+	if !z.exist(key) || min > max {
+		return 0
+	}
+
+	skl := z.record[key].skl
+	first := skl.firstInRange(min)
+	if first == nil || first.score > max {
+		return 0
+	}
+	last := skl.lastInRange(max)
+	return int(skl.getRank(last.score, last.member) - skl.getRank(first.score, first.member) + 1)
+}
+
+// ZRem removes the specified member from the sorted set stored at key.
+// Return true if the member is removed.
+func (z *SortedSet) ZRem(key, member string) bool {
+	if !z.exist(key) {
+		return false
+	}
+
+	item := z.record[key]
+	v, exist := item.dict[member]
+	if !exist {
+		return false
+	}
+	delete(item.dict, member)
+	item.skl.delete(v.score, member)
+
+	if len(item.dict) == 0 {
+		z.remove(key)
+	}
+	return true
+}
+
+// ZKeyExists check if the key exists in zset.
+func (z *SortedSet) ZKeyExists(key string) bool {
+	return z.exist(key)
+}
+
+// ScanKeys calls fn for the keys in zset from the hash cursor in the order of their hashes, until fn returns false.
+func (z *SortedSet) ScanKeys(cursor uint64, fn func(hash uint64, key string) bool) {
+	z.keys.Ascend(cursor, fn)
+}
+
+// ZClear clear the key in zset.
+func (z *SortedSet) ZClear(key string) {
+	z.remove(key)
+}
+
+func (z *SortedSet) remove(key string) {
+	delete(z.record, key)
+	z.keys.Remove(key)
+}
+
+func (z *SortedSet) exist(key string) bool {
+	_, exist := z.record[key]
+	return exist
+}
+
+func (z *SortedSet) getRank(key, member string, reverse bool) int64 {
+	if !z.exist(key) {
+		return -1
+	}
+
+	item := z.record[key]
+	v, exist := item.dict[member]
+	if !exist {
+		return -1
+	}
+
+	rank := item.skl.getRank(v.score, member)
+	if reverse {
+		return item.skl.length - rank
+	}
+	return rank - 1
+}
+
+func (z *SortedSet) findRange(key string, start, stop int64, reverse bool) []Pair {
+	var res []Pair
+	if !z.exist(key) {
+		return res
+	}
+
+	skl := z.record[key].skl
+	length := skl.length
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return res
+	}
+
+	var p *sklNode
+	if reverse {
+		p = skl.getNodeByRank(uint64(length - start))
+	} else {
+		p = skl.getNodeByRank(uint64(start + 1))
+	}
+	for span := stop - start + 1; span > 0 && p != nil; span-- {
+		res = append(res, Pair{Member: []byte(p.member), Score: p.score})
+		if reverse {
+			p = p.backward
+		} else {
+			p = p.level[0].forward
+		}
+	}
+	return res
+}
+
+func newSklNode(level int16, score float64, member string) *sklNode {
+	node := &sklNode{
+		score:  score,
+		member: member,
+		level:  make([]*sklLevel, level),
+	}
+
+	for i := range node.level {
+		node.level[i] = new(sklLevel)
+	}
+	return node
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		level: 1,
+		head:  newSklNode(maxLevel, 0, ""),
+	}
+}
+
+func randomLevel() int16 {
+	var level int16 = 1
+	for float32(rand.Int31()&0xFFFF) < (probability * 0xFFFF) {
+		level++
+	}
+
+	if level < maxLevel {
+		return level
+	}
+	return maxLevel
+}
+
+// less reports whether the node is ordered before the score and member.
+func (node *sklNode) less(score float64, member string) bool {
+	return node.score < score || (node.score == score && node.member < member)
+}
+
+func (skl *skipList) insert(score float64, member string) *sklNode {
+	updates := make([]*sklNode, maxLevel)
+	rank := make([]uint64, maxLevel)
+
+	p := skl.head
+	for i := skl.level - 1; i >= 0; i-- {
+		if i == skl.level-1 {
+			rank[i] = 0
+		} else {
+			rank[i] = rank[i+1]
+		}
+
+		for p.level[i].forward != nil && p.level[i].forward.less(score, member) {
+			rank[i] += p.level[i].span
+			p = p.level[i].forward
+		}
+		updates[i] = p
+	}
+
+	level := randomLevel()
+	if level > skl.level {
+		for i := skl.level; i < level; i++ {
+			rank[i] = 0
+			updates[i] = skl.head
+			updates[i].level[i].span = uint64(skl.length)
+		}
+		skl.level = level
+	}
+
+	p = newSklNode(level, score, member)
+	for i := int16(0); i < level; i++ {
+		p.level[i].forward = updates[i].level[i].forward
+		updates[i].level[i].forward = p
+
+		p.level[i].span = updates[i].level[i].span - (rank[0] - rank[i])
+		updates[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// increment span for untouched levels.
+	for i := level; i < skl.level; i++ {
+		updates[i].level[i].span++
+	}
+
+	if updates[0] == skl.head {
+		p.backward = nil
+	} else {
+		p.backward = updates[0]
+	}
+
+	if p.level[0].forward != nil {
+		p.level[0].forward.backward = p
+	} else {
+		skl.tail = p
+	}
+
+	skl.length++
+	return p
+}
+
+func (skl *skipList) deleteNode(p *sklNode, updates []*sklNode) {
+	for i := int16(0); i < skl.level; i++ {
+		if updates[i].level[i].forward == p {
+			updates[i].level[i].span += p.level[i].span - 1
+			updates[i].level[i].forward = p.level[i].forward
+		} else {
+			updates[i].level[i].span--
+		}
+	}
+
+	if p.level[0].forward != nil {
+		p.level[0].forward.backward = p.backward
+	} else {
+		skl.tail = p.backward
+	}
+
+	for skl.level > 1 && skl.head.level[skl.level-1].forward == nil {
+		skl.level--
+	}
+	skl.length--
+}
+
+func (skl *skipList) delete(score float64, member string) {
+	updates := make([]*sklNode, maxLevel)
+	p := skl.head
+
+	for i := skl.level - 1; i >= 0; i-- {
+		for p.level[i].forward != nil && p.level[i].forward.less(score, member) {
+			p = p.level[i].forward
+		}
+		updates[i] = p
+	}
+
+	p = p.level[0].forward
+	if p != nil && score == p.score && p.member == member {
+		skl.deleteNode(p, updates)
+	}
+}
+
+// getRank returns the 1-based rank of the element, 0 is returned if it is not found.
+func (skl *skipList) getRank(score float64, member string) int64 {
+	var rank uint64 = 0
+	p := skl.head
+
+	for i := skl.level - 1; i >= 0; i-- {
+		for p.level[i].forward != nil &&
+			(p.level[i].forward.less(score, member) || (p.level[i].forward.score == score && p.level[i].forward.member == member)) {
+
+			rank += p.level[i].span
+			p = p.level[i].forward
+		}
+
+		if p != skl.head && p.member == member {
+			return int64(rank)
+		}
+	}
+	return 0
+}
+
+// getNodeByRank returns the element at the 1-based rank.
+func (skl *skipList) getNodeByRank(rank uint64) *sklNode {
+	var traversed uint64 = 0
+
+	p := skl.head
+	for i := skl.level - 1; i >= 0; i-- {
+		for p.level[i].forward != nil && (traversed+p.level[i].span) <= rank {
+			traversed += p.level[i].span
+			p = p.level[i].forward
+		}
+		if traversed == rank {
+			return p
+		}
+	}
+	return nil
+}
+
+// firstInRange returns the first element whose score is greater than or equal to min.
+func (skl *skipList) firstInRange(min float64) *sklNode {
+	p := skl.head
+	for i := skl.level - 1; i >= 0; i-- {
+		for p.level[i].forward != nil && p.level[i].forward.score < min {
+			p = p.level[i].forward
+		}
+	}
+	return p.level[0].forward
+}
+
+// lastInRange returns the last element whose score is less than or equal to max.
+func (skl *skipList) lastInRange(max float64) *sklNode {
+	p := skl.head
+	for i := skl.level - 1; i >= 0; i-- {
+		for p.level[i].forward != nil && p.level[i].forward.score <= max {
+			p = p.level[i].forward
+		}
+	}
+	if p == skl.head {
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,102 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidKeyFile the content of key file is invalid.
+	ErrInvalidKeyFile = errors.New("rosedb: invalid encryption key file")
+)
+
+// KeyProvider provides the keys to encrypt db files.
+// To rotate the key, provide a new key with a greater id and keep the old ones,
+// then the archived files encrypted with the old keys will be re-encrypted by Reclaim.
+// The old keys can be dropped after the active files written with them are archived and reclaimed.
+type KeyProvider interface {
+	// Keys returns all keys by id, and the id of the key to encrypt new entries.
+	Keys() (keys map[uint32][]byte, current uint32, err error)
+}
+
+// fileKeyProvider reads the keys from a file, each line is the id and the hex encoded key separated by space.
+// The key with the greatest id is used to encrypt new entries, the lines starting with # are ignored.
+type fileKeyProvider struct {
+	path string
+}
+
+func (p fileKeyProvider) Keys() (map[uint32][]byte, uint32, error) {
+	file, err := os.Open(p.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var current uint32
+	keys := make(map[uint32][]byte)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, 0, fmt.Errorf("line %d: %w", line, ErrInvalidKeyFile)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: %w", line, ErrInvalidKeyFile)
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: %w", line, ErrInvalidKeyFile)
+		}
+		if _, ok := keys[uint32(id)]; ok {
+			return nil, 0, fmt.Errorf("line %d: duplicate key id: %w", line, ErrInvalidKeyFile)
+		}
+		keys[uint32(id)] = key
+		if len(keys) == 1 || uint32(id) > current {
+			current = uint32(id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(keys) == 0 {
+		return nil, 0, ErrInvalidKeyFile
+	}
+	return keys, current, nil
+}
+
+// newEncryptor returns the encryptor of db files according to the config, it is nil if encryption is disabled.
+func newEncryptor(config Config) (*storage.Encryptor, error) {
+	provider := config.KeyProvider
+	if provider == nil && config.EncryptionKeyFile != "" {
+		provider = fileKeyProvider{path: config.EncryptionKeyFile}
+	}
+	if provider == nil {
+		return nil, nil
+	}
+
+	keys, current, err := provider.Keys()
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewEncryptor(keys, current)
+}
+
+// setupDBFile sets the compression, encryption and syncing of db file.
+func (db *KVDB) setupDBFile(df *storage.DBFile) {
+	df.SetCompression(db.codec, db.config.CompressThreshold)
+	df.SetEncryptor(db.encryptor)
+	df.SetDataSync(db.config.DataSync)
+}
@@ -0,0 +1,236 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+type testKeyProvider struct {
+	keys    map[uint32][]byte
+	current uint32
+}
+
+func (p testKeyProvider) Keys() (map[uint32][]byte, uint32, error) {
+	return p.keys, p.current, nil
+}
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// assertNotInFiles checks that the plaintext is not in any file of the dir.
+func assertNotInFiles(t *testing.T, dir string, plaintext string) {
+	t.Helper()
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		if bytes.Contains(b, []byte(plaintext)) {
+			t.Fatalf("%q is found in %s", plaintext, name)
+		}
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	for name, update := range testConfigs() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				update(cfg)
+				smallFiles(cfg)
+				cfg.KeyProvider = testKeyProvider{keys: map[uint32][]byte{1: testKey(1)}, current: 1}
+			})
+
+			for i := 0; i < 40; i++ {
+				if err := db.Set([]byte(fmt.Sprintf("secret-key-%02d", i)), []byte(fmt.Sprintf("secret-value-%02d", i))); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.SAdd([]byte("secret-set"), []byte(fmt.Sprintf("secret-member-%02d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			check := func(db *KVDB) {
+				t.Helper()
+				for i := 0; i < 40; i++ {
+					val, err := db.Get([]byte(fmt.Sprintf("secret-key-%02d", i)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					assertBytes(t, val, fmt.Sprintf("secret-value-%02d", i))
+				}
+				if n := db.SCard([]byte("secret-set")); n != 40 {
+					t.Fatalf("SCard: got %d, want 40", n)
+				}
+			}
+			check(db)
+
+			// the db files and hint files are encrypted.
+			closeTestDB(t, db)
+			for _, s := range []string{"secret-key", "secret-value", "secret-member", "secret-set"} {
+				assertNotInFiles(t, db.config.DirPath, s)
+			}
+			db = openTestConfig(t, db.config)
+			check(db)
+		})
+	}
+}
+
+func TestEncryptionWrongKey(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.KeyProvider = testKeyProvider{keys: map[uint32][]byte{1: testKey(1)}, current: 1}
+	})
+	if err := db.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	closeTestDB(t, db)
+
+	cfg := db.config
+	cfg.KeyProvider = nil
+	if _, err := Open(cfg); !errors.Is(err, storage.ErrEncryptionKeyRequired) {
+		t.Fatalf("open without key: got %v, want ErrEncryptionKeyRequired", err)
+	}
+	cfg.KeyProvider = testKeyProvider{keys: map[uint32][]byte{1: testKey(2)}, current: 1}
+	if _, err := Open(cfg); !errors.Is(err, storage.ErrWrongEncryptionKey) {
+		t.Fatalf("open with a wrong key: got %v, want ErrWrongEncryptionKey", err)
+	}
+	cfg.KeyProvider = testKeyProvider{keys: map[uint32][]byte{2: testKey(2)}, current: 2}
+	if _, err := Open(cfg); !errors.Is(err, storage.ErrEncryptionKeyNotFound) {
+		t.Fatalf("open without the key of db file: got %v, want ErrEncryptionKeyNotFound", err)
+	}
+	cfg.KeyProvider = testKeyProvider{keys: map[uint32][]byte{1: []byte("short")}, current: 1}
+	if _, err := Open(cfg); !errors.Is(err, storage.ErrInvalidKeySize) {
+		t.Fatalf("open with a short key: got %v, want ErrInvalidKeySize", err)
+	}
+}
+
+// The archived files encrypted with the old key are re-encrypted by Reclaim, then the old key can be dropped.
+func TestEncryptionKeyRotation(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		smallFiles(cfg)
+		cfg.ReclaimThreshold = 1000
+		cfg.KeyProvider = testKeyProvider{keys: map[uint32][]byte{1: testKey(1)}, current: 1}
+	})
+	for i := 0; i < 40; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("old%02d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closeTestDB(t, db)
+	cfg := db.config
+	cfg.KeyProvider = testKeyProvider{keys: map[uint32][]byte{1: testKey(1), 2: testKey(2)}, current: 2}
+	db = openTestConfig(t, cfg)
+	// archive the active file written with the old key.
+	for i := 0; i < 40; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("new%02d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !db.needReclaim(String) {
+		t.Fatal("the files encrypted with the old key don't need reclaim")
+	}
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	for id, f := range db.archFiles[String] {
+		if keyId, ok := f.KeyId(); !ok || keyId != 2 {
+			t.Fatalf("the db file %d is encrypted with key %d after reclaim", id, keyId)
+		}
+	}
+
+	closeTestDB(t, db)
+	cfg.KeyProvider = testKeyProvider{keys: map[uint32][]byte{2: testKey(2)}, current: 2}
+	db = openTestConfig(t, cfg)
+	for i := 0; i < 40; i++ {
+		for _, prefix := range []string{"old", "new"} {
+			if _, err := db.Get([]byte(fmt.Sprintf("%s%02d", prefix, i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestEncryptionKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "keys")
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	key1 := fmt.Sprintf("%x", testKey(1))
+	key2 := fmt.Sprintf("%x", testKey(2))
+	keys, current, err := fileKeyProvider{path: write("# comment\n\n2 " + key2 + "\n1 " + key1 + "\n")}.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != 2 || len(keys) != 2 || !bytes.Equal(keys[1], testKey(1)) {
+		t.Fatalf("got keys %x, current %d", keys, current)
+	}
+
+	for _, content := range []string{"", "1\n", "x " + key1 + "\n", "1 zz\n", "1 " + key1 + "\n1 " + key2 + "\n"} {
+		if _, _, err := (fileKeyProvider{path: write(content)}).Keys(); !errors.Is(err, ErrInvalidKeyFile) {
+			t.Fatalf("parse %q: got %v, want ErrInvalidKeyFile", content, err)
+		}
+	}
+}
+
+// The encrypted entries are larger, so Reclaim writes more db files than the plaintext ones it replaces.
+func TestEncryptionReclaimMoreFiles(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.BlockSize = 4096
+		cfg.ReclaimThreshold = 1000
+		// the values are read from the moved active file by the index.
+		cfg.IdxMode = KeyOnlyMemMode
+	})
+	for i := 0; i < 300; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("k%03d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.HSet([]byte("h"), []byte(fmt.Sprintf("f%03d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closeTestDB(t, db)
+	cfg := db.config
+	cfg.KeyProvider = testKeyProvider{keys: map[uint32][]byte{1: testKey(1)}, current: 1}
+	db = openTestConfig(t, cfg)
+	before := len(db.archFiles[String]) + len(db.archFiles[Hash])
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	if after := len(db.archFiles[String]) + len(db.archFiles[Hash]); after <= before {
+		t.Fatalf("got %d archived files after reclaim, %d before", after, before)
+	}
+
+	check := func(db *KVDB) {
+		t.Helper()
+		for i := 0; i < 300; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("k%03d", i)))
+			if err != nil {
+				t.Fatalf("get k%03d: %v", i, err)
+			}
+			assertBytes(t, val, fmt.Sprintf("v%d", i))
+			if val = db.HGet([]byte("h"), []byte(fmt.Sprintf("f%03d", i))); string(val) != fmt.Sprintf("v%d", i) {
+				t.Fatalf("got the field f%03d %q", i, val)
+			}
+		}
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
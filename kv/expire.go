@@ -0,0 +1,133 @@
+package kv
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// startExpirer starts the active expiration, it is stopped in Close.
+// The expired keys are removed lazily by the writes to them, and the reads only skip them,
+// so the expired keys never written are removed by it.
+func (db *KVDB) startExpirer() {
+	if db.config.ExpireInterval <= 0 {
+		return
+	}
+
+	interval := time.Duration(db.config.ExpireInterval) * time.Millisecond
+	db.expireStop = make(chan struct{})
+	db.expireWg.Add(1)
+	go func() {
+		defer db.expireWg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-db.expireStop:
+				return
+			case <-ticker.C:
+				// a cycle takes at most a quarter of the interval, so the writes are not blocked for long.
+				db.activeExpire(time.Now().Add(interval / 4))
+			}
+		}
+	}()
+}
+
+func (db *KVDB) stopExpirer() {
+	if db.expireStop == nil {
+		return
+	}
+	close(db.expireStop)
+	db.expireWg.Wait()
+	db.expireStop = nil
+}
+
+// activeExpire removes the expired keys of all data types like the active expire cycle of Redis.
+// The keys with expire info are sampled, and it goes on sampling if more than a quarter of them are expired,
+// until the deadline of cycle.
+func (db *KVDB) activeExpire(deadline time.Time) {
+	samples := db.config.ExpireSamples
+	if samples <= 0 {
+		samples = DefaultExpireSamples
+	}
+
+	for i := 0; i < DataStructureNum; i++ {
+		dType := DataType(i)
+		for {
+			if atomic.LoadUint32(&db.closed) == 1 {
+				return
+			}
+			sampled, expired, err := db.expireSample(dType, samples)
+			if err != nil {
+				log.Println("active expiration err: ", err)
+				return
+			}
+			if expired*4 <= sampled || time.Now().After(deadline) {
+				break
+			}
+		}
+	}
+
+	// the fields of hash with time to live are sampled in the same way.
+	for atomic.LoadUint32(&db.closed) == 0 {
+		sampled, expired, err := db.expireFieldSample(samples)
+		if err != nil {
+			log.Println("active expiration err: ", err)
+			return
+		}
+		if expired*4 <= sampled || time.Now().After(deadline) {
+			break
+		}
+	}
+}
+
+// expireSample removes the expired ones of at most n keys with expire info, the keys are picked randomly.
+// The removals are persisted like the other writes.
+func (db *KVDB) expireSample(dType DataType, n int) (sampled, expired int, err error) {
+	defer db.waitSync(&err)
+	unlock := db.lockMgr.Lock(dType)
+	defer unlock()
+
+	// the iteration order of map is random.
+	var keys []string
+	for key := range db.expires[dType] {
+		if len(keys) == n {
+			break
+		}
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if db.checkExpired([]byte(key), dType) {
+			expired++
+		}
+	}
+	return len(keys), expired, nil
+}
+
+// expireFieldSample removes the expired ones of at most n hash fields with time to live, the fields are picked randomly.
+func (db *KVDB) expireFieldSample(n int) (sampled, expired int, err error) {
+	defer db.waitSync(&err)
+	unlock := db.lockMgr.Lock(Hash)
+	defer unlock()
+
+	type keyField struct{ key, field string }
+	var fields []keyField
+	for key, expires := range db.fieldExpires {
+		for field := range expires {
+			if len(fields) == n {
+				break
+			}
+			fields = append(fields, keyField{key, field})
+		}
+		if len(fields) == n {
+			break
+		}
+	}
+	for _, f := range fields {
+		if db.checkFieldExpired([]byte(f.key), []byte(f.field)) {
+			expired++
+		}
+	}
+	return len(fields), expired, nil
+}
@@ -0,0 +1,345 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// expireNow makes the key of data type expired at once without writing any entry.
+func expireNow(db *KVDB, dType DataType, key string) {
+	unlock := db.lockMgr.Lock(dType)
+	defer unlock()
+	db.expires[dType][key] = db.now() - 1
+}
+
+// runConcurrently runs fn in n goroutines, and waits for all of them.
+func runConcurrently(n int, fn func()) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	wg.Wait()
+}
+
+// The reads of expired keys hold only the read lock, so they must not remove the keys, run it with -race.
+func TestReadExpiredKeysConcurrently(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	if err := db.Set([]byte("str"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HSet([]byte("hash"), []byte("f"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RPush([]byte("list"), []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd([]byte("set"), []byte("a"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd([]byte("zset"), 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	expireNow(db, String, "str")
+	expireNow(db, Hash, "hash")
+	expireNow(db, List, "list")
+	expireNow(db, Set, "set")
+	expireNow(db, ZSet, "zset")
+
+	runConcurrently(8, func() {
+		for i := 0; i < 50; i++ {
+			if _, err := db.Get([]byte("str")); err != ErrKeyExpired {
+				t.Errorf("Get: got %v, want ErrKeyExpired", err)
+			}
+			if vals, _ := db.MGet([]byte("str")); len(vals) != 1 || vals[0] != nil {
+				t.Errorf("MGet: got %q", vals)
+			}
+			if db.StrLen([]byte("str")) != 0 || db.StrExists([]byte("str")) || db.TTL([]byte("str")) != 0 {
+				t.Error("the expired string is visible")
+			}
+
+			if db.HGet([]byte("hash"), []byte("f")) != nil || db.HLen([]byte("hash")) != 0 ||
+				db.HKeyExists([]byte("hash")) || db.HGetAll([]byte("hash")) != nil {
+				t.Error("the expired hash is visible")
+			}
+
+			if db.LIndex([]byte("list"), 0) != nil || db.LLen([]byte("list")) != 0 || db.LKeyExists([]byte("list")) {
+				t.Error("the expired list is visible")
+			}
+			if vals, _ := db.LRange([]byte("list"), 0, -1); len(vals) != 0 {
+				t.Errorf("LRange: got %q", vals)
+			}
+
+			if db.SIsMember([]byte("set"), []byte("a")) || db.SCard([]byte("set")) != 0 ||
+				len(db.SRandMember([]byte("set"), 1)) != 0 || len(db.SUnion([]byte("set"))) != 0 {
+				t.Error("the expired set is visible")
+			}
+
+			if _, ok := db.ZScore([]byte("zset"), []byte("a")); ok {
+				t.Error("ZScore: the expired zset is visible")
+			}
+			if db.ZCard([]byte("zset")) != 0 || db.ZRank([]byte("zset"), []byte("a")) != -1 {
+				t.Error("the expired zset is visible")
+			}
+		}
+	})
+
+	// the writes remove the expired keys before writing.
+	if err := db.Set([]byte("str"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	val, err := db.Get([]byte("str"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "new")
+	if db.TTL([]byte("str")) != 0 {
+		t.Fatal("the time to live of the expired string is kept")
+	}
+
+	if _, err := db.HSet([]byte("hash"), []byte("g"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, db.HGetAll([]byte("hash")), "g", "v")
+
+	if _, err := db.SAdd([]byte("set"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	assertStrings(t, db.SMembers([]byte("set")), "c")
+}
+
+// The read-only transactions hold the read locks, so they must not remove the expired keys either.
+func TestTxnViewExpiredKeysConcurrently(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	if _, err := db.SAdd([]byte("set"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd([]byte("zset"), 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.HSet([]byte("hash"), []byte("f"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	expireNow(db, Set, "set")
+	expireNow(db, ZSet, "zset")
+	expireNow(db, Hash, "hash")
+
+	runConcurrently(4, func() {
+		err := db.TxnView(func(tx *Tx) error {
+			if tx.SIsMember([]byte("set"), []byte("a")) {
+				t.Error("the expired set is visible")
+			}
+			if _, ok := tx.ZScore([]byte("zset"), []byte("a")); ok {
+				t.Error("the expired zset is visible")
+			}
+			if tx.HExists([]byte("hash"), []byte("f")) || tx.HGet([]byte("hash"), []byte("f")) != nil {
+				t.Error("the expired hash is visible")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// The active expiration removes the expired keys which are only read.
+func TestActiveExpireRemovesReadKeys(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	if err := db.Set([]byte("str"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	expireNow(db, String, "str")
+	if _, err := db.Get([]byte("str")); err != ErrKeyExpired {
+		t.Fatalf("got %v, want ErrKeyExpired", err)
+	}
+	if _, ok := db.strIndex.indexes["str"]; !ok {
+		t.Fatal("the expired key is removed by a read")
+	}
+
+	if sampled, expired, err := db.expireSample(String, 10); err != nil || sampled != 1 || expired != 1 {
+		t.Fatalf("got %d sampled and %d expired, want 1 and 1: %v", sampled, expired, err)
+	}
+	if _, ok := db.strIndex.indexes["str"]; ok {
+		t.Fatal("the expired key is not removed by active expiration")
+	}
+
+	// the key stays removed after reopening.
+	db = reopenTestDB(t, db)
+	if _, err := db.Get([]byte("str")); err != ErrKeyNotExist {
+		t.Fatalf("got %v, want ErrKeyNotExist", err)
+	}
+}
+
+// expireKeys returns the number of keys of data type with expire info.
+func expireKeys(db *KVDB, dType DataType) int {
+	unlock := db.lockMgr.RLock(dType)
+	defer unlock()
+	return len(db.expires[dType])
+}
+
+// The active expiration removes the expired keys of every data type in background, even if they are never read.
+func TestActiveExpire(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.ExpireInterval = 10
+		cfg.ExpireSamples = 5
+	})
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("expired%02d", i)
+		if err := db.Set([]byte(key), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.HSet([]byte(key), []byte("f"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.RPush([]byte(key), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SAdd([]byte(key), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.ZAdd([]byte(key), 1, []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		for dType := DataType(0); dType < DataStructureNum; dType++ {
+			expireNow(db, dType, key)
+		}
+	}
+	// the deadline of hash in milliseconds passes without any write.
+	if _, err := db.HSet([]byte("short"), []byte("f"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HPExpire([]byte("short"), 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetEx([]byte("live"), []byte("v"), 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	for start := time.Now(); ; time.Sleep(5 * time.Millisecond) {
+		var left int
+		for dType := DataType(0); dType < DataStructureNum; dType++ {
+			left += expireKeys(db, dType)
+		}
+		// only the live key is left.
+		if left == 1 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("%d keys with expire info are left", left)
+		}
+	}
+
+	if n := db.HLen([]byte("short")); n != 0 {
+		t.Fatalf("HLen of the expired hash: got %d", n)
+	}
+	if ttl := db.TTL([]byte("live")); ttl <= 0 {
+		t.Fatalf("the live key is removed, TTL: %d", ttl)
+	}
+	unlock := db.lockMgr.RLock(Hash, String)
+	_, str := db.strIndex.indexes["expired00"]
+	hash := db.hashIndex.indexes.HKeyExists("short")
+	unlock()
+	if str || hash {
+		t.Fatal("the expired keys are still in the indexes")
+	}
+}
+
+// expireFieldNow makes the field of hash expired at once without writing any entry.
+func expireFieldNow(db *KVDB, key, field string) {
+	unlock := db.lockMgr.Lock(Hash)
+	defer unlock()
+	db.setFieldExpire(key, field, db.now()-1)
+}
+
+// The reads of expired fields hold only the read lock, so they must skip the fields without removing them, run it with -race.
+func TestReadExpiredFieldsConcurrently(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	key := []byte("hash")
+	for _, field := range []string{"a", "b", "c"} {
+		if _, err := db.HSet(key, []byte(field), []byte("v"+field)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.HFieldExpire(key, []byte("c"), 1000); err != nil {
+		t.Fatal(err)
+	}
+	expireFieldNow(db, "hash", "a")
+
+	runConcurrently(8, func() {
+		for i := 0; i < 50; i++ {
+			if db.HGet(key, []byte("a")) != nil || db.HExists(key, []byte("a")) == 0 || db.HStrLen(key, []byte("a")) != 0 {
+				t.Error("the expired field is visible")
+			}
+			if db.HFieldTTL(key, []byte("a")) != 0 || db.HFieldPTTL(key, []byte("a")) != 0 {
+				t.Error("the expired field has time to live")
+			}
+			if n := db.HLen(key); n != 2 {
+				t.Errorf("HLen: got %d, want 2", n)
+			}
+			if vals := db.HMGet(key, []byte("a"), []byte("b")); len(vals) != 2 || vals[0] != nil || string(vals[1]) != "vb" {
+				t.Errorf("HMGet: got %q", vals)
+			}
+			if all := db.HGetAll(key); len(all) != 4 {
+				t.Errorf("HGetAll: got %q", all)
+			}
+			if fields := db.HKeys(key); len(fields) != 2 {
+				t.Errorf("HKeys: got %q", fields)
+			}
+			if vals := db.HVals(key); len(vals) != 2 {
+				t.Errorf("HVals: got %q", vals)
+			}
+			if res, _, err := db.HScan(key, 0, "", 10); err != nil || len(res) != 4 {
+				t.Errorf("HScan: got %q, %v", res, err)
+			}
+			if res := db.HRangeByField(key, nil, nil, 1); len(res) != 2 || string(res[0]) != "b" {
+				t.Errorf("HRangeByField: got %q", res)
+			}
+			if res := db.HRandField(key, 3, false); len(res) != 2 {
+				t.Errorf("HRandField: got %q", res)
+			}
+			for _, field := range db.HRandField(key, -5, false) {
+				if string(field) == "a" {
+					t.Error("HRandField: got the expired field")
+				}
+			}
+			err := db.TxnView(func(tx *Tx) error {
+				if tx.HExists(key, []byte("a")) || tx.HGet(key, []byte("a")) != nil {
+					t.Error("the expired field is visible in transaction")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	})
+
+	unlock := db.lockMgr.RLock(Hash)
+	removed := db.hashIndex.indexes.HExists("hash", "a") != 0
+	unlock()
+	if removed {
+		t.Fatal("the expired field is removed by a read")
+	}
+
+	// the expired field is removed by active expiration, and stays removed after reopening.
+	if sampled, expired, err := db.expireFieldSample(10); err != nil || sampled != 2 || expired != 1 {
+		t.Fatalf("got %d sampled and %d expired, want 2 and 1: %v", sampled, expired, err)
+	}
+	db = reopenTestDB(t, db)
+	if n := db.HLen(key); n != 2 {
+		t.Fatalf("HLen after reopening: got %d, want 2", n)
+	}
+	assertBytes(t, db.HGet(key, []byte("b")), "vb")
+	assertBytes(t, db.HGet(key, []byte("c")), "vc")
+}
@@ -0,0 +1,93 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+)
+
+// writeV1File writes the entries to a db file in V1, which has no header and the crc32 only covers the value.
+func writeV1File(t *testing.T, dir string, dType DataType, fileId uint32, entries ...*storage.Entry) {
+	t.Helper()
+
+	var data []byte
+	for _, e := range entries {
+		buf, err := e.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(e.Meta.Value))
+		data = append(data, buf...)
+	}
+	name := dir + storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dType], fileId)
+	if err := ioutil.WriteFile(name, data, storage.FilePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The db files in V1 are loaded, and rewritten in the current format by reclaim.
+func TestUpgradeV1Files(t *testing.T) {
+	dir := t.TempDir()
+	writeV1File(t, dir, String, 0,
+		storage.NewEntryNoExtra([]byte("a"), []byte("old"), String, StringSet),
+		storage.NewEntryNoExtra([]byte("a"), []byte("1"), String, StringSet),
+	)
+	writeV1File(t, dir, String, 1,
+		storage.NewEntryNoExtra([]byte("b"), []byte("2"), String, StringSet),
+	)
+	writeV1File(t, dir, Set, 0,
+		storage.NewEntryNoExtra([]byte("s"), []byte("m"), Set, SetSAdd),
+	)
+
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.DirPath = dir
+	})
+	check := func(db *KVDB) {
+		t.Helper()
+		val, err := db.Get([]byte("a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, "1")
+		if val, err = db.Get([]byte("b")); err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, "2")
+		if !db.SIsMember([]byte("s"), []byte("m")) {
+			t.Fatal("the set member in V1 file is lost")
+		}
+	}
+	check(db)
+
+	// the active files in V1 are archived, new entries are written in the current format.
+	for _, dType := range []DataType{String, Set} {
+		activeFile, err := db.getActiveFile(dType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if activeFile.Version() != storage.CurrentVersion {
+			t.Fatalf("got the active file in version %d", activeFile.Version())
+		}
+		if !db.needReclaim(dType) {
+			t.Fatalf("the V1 files of type %d don't need reclaim", dType)
+		}
+	}
+
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	for dType, files := range db.archFiles {
+		for id, f := range files {
+			if f.Version() != storage.CurrentVersion {
+				t.Fatalf("the db file(type %d, id %d) is in version %d after reclaim", dType, id, f.Version())
+			}
+		}
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
@@ -0,0 +1,110 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"io"
+	"log"
+)
+
+// readEntries returns all the valid entries in the db file with their offsets.
+// The hint file is used for an archived file, and the db file is scanned only when its hint file is missing or corrupt.
+// If a corrupt entry is found, the tail of active file is truncated, and the tail of archived file is quarantined.
+func (db *KVDB) readEntries(dType DataType, df *storage.DBFile, archived bool) ([]*storage.HintItem, error) {
+	if archived {
+		if items, err := df.ReadHint(dType); err == nil {
+			return db.fillHintValues(df, items)
+		}
+	}
+
+	var items, hints []*storage.HintItem
+	offset := df.DataOffset()
+	for offset <= db.config.BlockSize {
+		e, err := df.Read(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			if !isCorrupt(err) {
+				return nil, err
+			}
+
+			log.Printf("corrupt entry in db file(type %d, id %d) at offset %d: %v", dType, df.Id, offset, err)
+			if archived {
+				err = db.quarantine(dType, df, offset, err)
+			} else {
+				err = db.truncateTail(dType, df, offset, err)
+			}
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		// an entry without key is never written, it is the unused space of mmap file.
+		if len(e.Meta.Key) == 0 {
+			break
+		}
+		items = append(items, &storage.HintItem{Entry: e, Offset: offset})
+		hints = append(hints, newHintItem(e, offset))
+		offset += int64(e.Size())
+	}
+
+	// the hint items of active file are saved when it is archived.
+	if !archived {
+		db.hintItems[dType] = hints
+		return items, nil
+	}
+	// the hint file is not written by a read-only db.
+	if db.asOf != 0 {
+		return items, nil
+	}
+	if err := df.WriteHint(dType, hints); err != nil {
+		log.Println("write hint file err: ", err)
+	}
+	return items, nil
+}
+
+// fillHintValues reads the values omitted by the hint file, they are kept in memory in KeyValueMemMode.
+func (db *KVDB) fillHintValues(df *storage.DBFile, items []*storage.HintItem) ([]*storage.HintItem, error) {
+	if db.config.IdxMode == KeyOnlyMemMode {
+		return items, nil
+	}
+
+	for _, item := range items {
+		if !omitHintValue(item.Entry) {
+			continue
+		}
+		e, err := df.Read(item.Offset)
+		if err != nil {
+			return nil, err
+		}
+		item.Entry = e
+	}
+	return items, nil
+}
+
+// newHintItem returns the hint item of the entry at offset.
+func newHintItem(e *storage.Entry, offset int64) *storage.HintItem {
+	meta := *e.Meta
+	if omitHintValue(e) {
+		meta.Value = nil
+	}
+
+	entry := *e
+	entry.Meta = &meta
+	return &storage.HintItem{Entry: &entry, Offset: offset}
+}
+
+// omitHintValue reports whether the value of entry is omitted in hint file.
+// The values of hash and string are not needed to build the index in KeyOnlyMemMode,
+// others such as the members of set are part of the index, so they are always saved.
+func omitHintValue(e *storage.Entry) bool {
+	switch e.GetType() {
+	case Hash:
+		return e.GetMark() == HashHSet
+	case String:
+		return e.GetMark() == StringSet
+	}
+	return false
+}
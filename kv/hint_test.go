@@ -0,0 +1,144 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"fmt"
+	"os"
+	"testing"
+)
+
+// flipByte changes a byte of the file at offset.
+func flipByte(t *testing.T, path string, offset int64) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xFF
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func hintPath(db *KVDB, dType DataType, fileId uint32) string {
+	return db.config.DirPath + storage.HintName(fileId, dType)
+}
+
+func TestHintFiles(t *testing.T) {
+	for name, update := range testConfigs() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				update(cfg)
+				smallFiles(cfg)
+			})
+
+			for i := 0; i < 60; i++ {
+				if err := db.Set([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.SAdd([]byte("set"), []byte(fmt.Sprintf("m%02d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			check := func(db *KVDB) {
+				t.Helper()
+				for i := 0; i < 60; i++ {
+					val, err := db.Get([]byte(fmt.Sprintf("k%02d", i)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					assertBytes(t, val, fmt.Sprintf("v%d", i))
+				}
+				if n := db.SCard([]byte("set")); n != 60 {
+					t.Fatalf("SCard: got %d, want 60", n)
+				}
+			}
+
+			// every archived file has a valid hint file.
+			for _, dType := range []DataType{String, Set} {
+				if len(db.archFiles[dType]) == 0 {
+					t.Fatalf("no archived file of type %d", dType)
+				}
+				for id, df := range db.archFiles[dType] {
+					if _, err := df.ReadHint(dType); err != nil {
+						t.Fatalf("read hint of file %d: %v", id, err)
+					}
+				}
+			}
+
+			// the set members are loaded from the hint file, so a corrupt entry in the db file isn't read.
+			var setFile *storage.DBFile
+			for _, df := range db.archFiles[Set] {
+				setFile = df
+				break
+			}
+			closeTestDB(t, db)
+			flipByte(t, setFile.Name(Set), setFile.Offset-1)
+			db = openTestConfig(t, db.config)
+			check(db)
+			if db.RecoveryReport().Recovered() {
+				t.Fatal("the db file is scanned though its hint file is valid")
+			}
+
+			// a missing or corrupt hint file is rebuilt from its db file.
+			var strFile *storage.DBFile
+			for _, df := range db.archFiles[String] {
+				strFile = df
+				break
+			}
+			closeTestDB(t, db)
+			if err := os.Remove(hintPath(db, String, strFile.Id)); err != nil {
+				t.Fatal(err)
+			}
+			db = openTestConfig(t, db.config)
+			check(db)
+			if _, err := os.Stat(hintPath(db, String, strFile.Id)); err != nil {
+				t.Fatalf("the missing hint file is not rebuilt: %v", err)
+			}
+
+			closeTestDB(t, db)
+			info, err := os.Stat(hintPath(db, String, strFile.Id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			flipByte(t, hintPath(db, String, strFile.Id), info.Size()/2)
+			db = openTestConfig(t, db.config)
+			check(db)
+			if _, err := db.archFiles[String][strFile.Id].ReadHint(String); err != nil {
+				t.Fatalf("the corrupt hint file is not rebuilt: %v", err)
+			}
+		})
+	}
+}
+
+// The files written by reclaim have their hint files too.
+func TestHintFilesAfterReclaim(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	for i := 0; i < 100; i++ {
+		if _, err := db.HSet([]byte("hash"), []byte(fmt.Sprintf("f%02d", i%20)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	for id, df := range db.archFiles[Hash] {
+		if _, err := df.ReadHint(Hash); err != nil {
+			t.Fatalf("read hint of reclaimed file %d: %v", id, err)
+		}
+	}
+
+	db = reopenTestDB(t, db)
+	for i := 80; i < 100; i++ {
+		assertBytes(t, db.HGet([]byte("hash"), []byte(fmt.Sprintf("f%02d", i%20))), fmt.Sprintf("v%d", i))
+	}
+}
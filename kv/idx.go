@@ -1,13 +1,12 @@
 package kv
 
 import (
+	"MetaDB/kv/ds/list"
 	"MetaDB/kv/storage"
 	"MetaDB/kv/index"
 
-	"sync"
-	"io"
-	"log"
-	"time"
+	"strconv"
+	"strings"
 	"sort"
 )
 
@@ -15,6 +14,10 @@ type DataType = uint16
 
 const (
 	Hash DataType = iota
+	String
+	List
+	Set
+	ZSet
 )
 
 // The operation of Hash
@@ -22,42 +25,251 @@ const (
 	HashHSet uint16 = iota
 	HashHDel
 	HashHClear
-	HashHExpire
+	HashHExpire  // the deadline is in seconds, it is only written by the old versions.
+	HashHPExpire // the deadline is in milliseconds.
+	HashHPersist
+	HashHFieldExpire  // the field is in the extra, and the deadline is in milliseconds.
+	HashHFieldPersist // the field is in the extra.
 )
 
-func (db *KVDB) buildHashIndex(entry *storage.Entry) {
+// The operation of String
+const (
+	StringSet uint16 = iota
+	StringRem
+	StringExpire
+	StringPersist
+)
+
+// The operation of List
+const (
+	ListLPush uint16 = iota
+	ListRPush
+	ListLPop
+	ListRPop
+	ListLRem
+	ListLInsert
+	ListLSet
+	ListLTrim
+	ListLClear
+	ListLExpire
+	ListLPersist
+)
+
+// The operation of Set
+const (
+	SetSAdd uint16 = iota
+	SetSRem
+	SetSMove
+	SetSClear
+	SetSExpire
+	SetSPersist
+)
+
+// The operation of Sorted Set
+const (
+	ZSetZAdd uint16 = iota
+	ZSetZRem
+	ZSetZClear
+	ZSetZExpire
+	ZSetZPersist
+)
+
+// The markers of transaction, they are shared by all data types and never conflict with the operations above.
+const (
+	TxBegin uint16 = 0xFD + iota
+	TxCommit
+	TxRollback
+)
+
+func (db *KVDB) buildHashIndex(entry *storage.Entry, idx *index.Indexer) {
 	if db.hashIndex == nil || entry == nil {
 		return
 	}
 	key := string(entry.Meta.Key)
 	switch entry.GetMark() {
 	case HashHSet:
-		db.hashIndex.indexes.HSet(key, string(entry.Meta.Extra), entry.Meta.Value)
+		if db.config.IdxMode == KeyOnlyMemMode {
+			idx.Meta.Value = nil
+		}
+		db.discardHashField(key, string(entry.Meta.Extra))
+		db.hashIndex.indexes.HSet(key, string(entry.Meta.Extra), idx)
 	case HashHDel:
+		db.discardHashField(key, string(entry.Meta.Extra))
 		db.hashIndex.indexes.HDel(key, string(entry.Meta.Extra))
+		db.deleteFieldExpire(key, string(entry.Meta.Extra))
 	case HashHClear:
+		db.discardHashKey(key)
 		db.hashIndex.indexes.HClear(key)
-	case HashHExpire:
-		if entry.Timestamp < uint64(time.Now().Unix()) {
-			db.hashIndex.indexes.HClear(key)
-		} else {
-			db.expires[Hash][key] = int64(entry.Timestamp)
+		delete(db.expires[Hash], key)
+		delete(db.fieldExpires, key)
+	case HashHExpire, HashHPExpire:
+		// the expired key will be removed when it is accessed, it may be persisted by a later entry.
+		db.expires[Hash][key] = expireDeadline(entry)
+	case HashHPersist:
+		delete(db.expires[Hash], key)
+	case HashHFieldExpire:
+		// the expired field will be removed when it is accessed.
+		db.setFieldExpire(key, string(entry.Meta.Extra), expireDeadline(entry))
+	case HashHFieldPersist:
+		db.deleteFieldExpire(key, string(entry.Meta.Extra))
+	}
+}
+
+func (db *KVDB) buildStringIndex(entry *storage.Entry, idx *index.Indexer) {
+	if db.strIndex == nil || entry == nil {
+		return
+	}
+	key := string(entry.Meta.Key)
+	switch entry.GetMark() {
+	case StringSet:
+		if db.config.IdxMode == KeyOnlyMemMode {
+			idx.Meta.Value = nil
 		}
+		db.discardStr(key)
+		db.strIndex.set(key, idx)
+	case StringRem:
+		db.discardStr(key)
+		db.strIndex.remove(key)
+		delete(db.expires[String], key)
+	case StringExpire:
+		// the expired key will be removed when it is accessed.
+		db.expires[String][key] = expireDeadline(entry)
+	case StringPersist:
+		delete(db.expires[String], key)
+	}
+}
+
+func (db *KVDB) buildListIndex(entry *storage.Entry) {
+	if db.listIndex == nil || entry == nil {
+		return
+	}
+	applyListEntry(db.listIndex.indexes, db.expires[List], entry)
+}
+
+// applyListEntry applies an entry of list to the lists and their expire info.
+// It is shared by loading the index and the list snapshot of reclaim.
+func applyListEntry(lists *list.List, expires map[string]int64, entry *storage.Entry) {
+	key := string(entry.Meta.Key)
+	switch entry.GetMark() {
+	case ListLPush:
+		lists.LPush(key, entry.Meta.Value)
+	case ListLPop:
+		lists.LPop(key)
+	case ListRPush:
+		lists.RPush(key, entry.Meta.Value)
+	case ListRPop:
+		lists.RPop(key)
+	case ListLRem:
+		if count, err := strconv.Atoi(string(entry.Meta.Extra)); err == nil {
+			lists.LRem(key, entry.Meta.Value, count)
+		}
+	case ListLInsert:
+		extra := string(entry.Meta.Extra)
+		s := strings.Split(extra, ExtraSeparator)
+		if len(s) == 2 {
+			pivot := []byte(s[0])
+			if opt, err := strconv.Atoi(s[1]); err == nil {
+				lists.LInsert(key, list.InsertOption(opt), pivot, entry.Meta.Value)
+			}
+		}
+	case ListLSet:
+		if i, err := strconv.Atoi(string(entry.Meta.Extra)); err == nil {
+			lists.LSet(key, i, entry.Meta.Value)
+		}
+	case ListLTrim:
+		extra := string(entry.Meta.Extra)
+		s := strings.Split(extra, ExtraSeparator)
+		if len(s) == 2 {
+			start, _ := strconv.Atoi(s[0])
+			end, _ := strconv.Atoi(s[1])
+			lists.LTrim(key, start, end)
+		}
+	case ListLClear:
+		lists.LClear(key)
+	case ListLExpire:
+		// the expired key will be removed when it is accessed.
+		expires[key] = expireDeadline(entry)
+	case ListLPersist:
+		delete(expires, key)
+	}
+
+	// an empty list is removed, so is its expire info.
+	if !lists.LKeyExists(key) {
+		delete(expires, key)
+	}
+}
+
+func (db *KVDB) buildSetIndex(entry *storage.Entry) {
+	if db.setIndex == nil || entry == nil {
+		return
+	}
+
+	key := string(entry.Meta.Key)
+	switch entry.GetMark() {
+	case SetSAdd:
+		db.setIndex.indexes.SAdd(key, entry.Meta.Value)
+	case SetSRem:
+		db.setIndex.indexes.SRem(key, entry.Meta.Value)
+	case SetSMove:
+		// the member may be not in the source set after reclaiming, it is always moved to the destination.
+		dst := string(entry.Meta.Extra)
+		db.setIndex.indexes.SRem(key, entry.Meta.Value)
+		db.setIndex.indexes.SAdd(dst, entry.Meta.Value)
+	case SetSClear:
+		db.setIndex.indexes.SClear(key)
+	case SetSExpire:
+		// the expired key will be removed when it is accessed.
+		db.expires[Set][key] = expireDeadline(entry)
+	case SetSPersist:
+		delete(db.expires[Set], key)
+	}
+
+	// an empty set is removed, so is its expire info.
+	if !db.setIndex.indexes.SKeyExists(key) {
+		delete(db.expires[Set], key)
+	}
+}
+
+func (db *KVDB) buildZsetIndex(entry *storage.Entry) {
+	if db.zsetIndex == nil || entry == nil {
+		return
+	}
+
+	key := string(entry.Meta.Key)
+	switch entry.GetMark() {
+	case ZSetZAdd:
+		if score, err := strconv.ParseFloat(string(entry.Meta.Extra), 64); err == nil {
+			db.zsetIndex.indexes.ZAdd(key, score, string(entry.Meta.Value))
+		}
+	case ZSetZRem:
+		db.zsetIndex.indexes.ZRem(key, string(entry.Meta.Value))
+	case ZSetZClear:
+		db.zsetIndex.indexes.ZClear(key)
+	case ZSetZExpire:
+		// the expired key will be removed when it is accessed.
+		db.expires[ZSet][key] = expireDeadline(entry)
+	case ZSetZPersist:
+		delete(db.expires[ZSet], key)
+	}
+
+	// an empty zset is removed, so is its expire info.
+	if !db.zsetIndex.indexes.ZKeyExists(key) {
+		delete(db.expires[ZSet], key)
 	}
 }
 
 // 把磁盘中的所有文件读到内存中
-// load Hash from db files.
+// load the indexes of all data structures from db files.
+// The commit marker of a transaction is saved in the db file of the smallest data type it writes,
+// so the data types are loaded in order, and the entries of uncommitted transactions are discarded.
 func (db *KVDB) loadIdxFromFiles() error {
 	if db.archFiles == nil && db.activeFile == nil {
 		return nil
 	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(DataStructureNum)
-	dataType := 0
-	go func(dType uint16) {
-		defer wg.Done()
+	committed := make(map[uint64]struct{})
+	for dataType := 0; dataType < DataStructureNum; dataType++ {
+		dType := uint16(dataType)
 
 		// archived files
 		var fileIds []int
@@ -66,47 +278,120 @@ func (db *KVDB) loadIdxFromFiles() error {
 			dbFile[k] = v
 			fileIds = append(fileIds, int(k))
 		}
-		
+
 		// active file
 		activeFile, err := db.getActiveFile(dType)
 		if err != nil {
-			log.Fatalf("active file is nil, the db can not open.[%+v]", err)
-			return
+			return err
 		}
 		dbFile[activeFile.Id] = activeFile
 		fileIds = append(fileIds, int(activeFile.Id))
-		
+
+		var (
+			block     txnBlock
+			blockFile uint32      // the db file of the TxBegin marker.
+			pending   []*txnEntry // entries of transaction waiting for the commit marker.
+			writeTime int64       // the write time of the previous entry.
+		)
+		// the transaction is not committed if its commit marker doesn't follow its entries.
+		abortPending := func() {
+			if pending != nil {
+				db.abortedTxns[block.id] = struct{}{}
+				for _, p := range pending {
+					db.garbage.discard(dType, p.idx.FileId, int64(p.entry.Size()))
+				}
+				pending = nil
+			}
+		}
+
 		// load the db files in a specified order.
 		sort.Ints(fileIds)
 		for i := 0; i < len(fileIds); i++ {
 			fid := uint32(fileIds[i])
 			df := dbFile[fid]
-			var offset int64 = 0
-		
-			for offset <= db.config.BlockSize {
-				if e, err := df.Read(offset); err == nil {
-					idx := &index.Indexer{
-						Meta:   e.Meta,
-						FileId: fid,
-						Offset: offset,
+
+			// the entries of archived files are read from their hint files if possible.
+			items, err := db.readEntries(dType, df, fid != activeFile.Id)
+			if err != nil {
+				return err
+			}
+
+			for _, item := range items {
+				e := item.Entry
+				idx := &index.Indexer{
+					Meta:   e.Meta,
+					FileId: fid,
+					Offset: item.Offset,
+				}
+
+				db.garbage.add(e, fid)
+				// the entries written after the snapshot of OpenAt are skipped, but the markers still delimit the transactions.
+				after := db.writtenAfterAsOf(e, &writeTime)
+				switch e.GetMark() {
+				case TxBegin:
+					abortPending()
+					block = parseTxnBegin(e)
+					blockFile = fid
+					db.resetTxnId(block.id)
+					continue
+				case TxCommit:
+					if after {
+						continue
 					}
-					offset += int64(e.Size())
-		
-					if len(e.Meta.Key) > 0 {
-						// 核心在于调用buildIndex
-						if err := db.buildIndex(e, idx); err != nil {
-							log.Fatalf("a fatal err occurred, the db can not open.[%+v]", err)
+					txId := parseTxnId(e)
+					db.resetTxnId(txId)
+					committed[txId] = struct{}{}
+					if block.id == txId {
+						db.garbage.markTxnSpan(dType, blockFile, fid)
+						for _, p := range pending {
+							db.buildIndex(p.entry, p.idx)
 						}
+						pending = nil
 					}
-				} else {
-					if err == io.EOF {
-						break
+					continue
+				case TxRollback:
+					abortPending()
+					db.abortedTxns[parseTxnId(e)] = struct{}{}
+					continue
+				}
+
+				if block.remaining > 0 {
+					block.remaining--
+					db.garbage.markTxnSpan(dType, blockFile, fid)
+					if after {
+						continue
+					}
+					// wait for the commit marker in the db file of the coordinator.
+					if block.coordinator == dType {
+						pending = append(pending, &txnEntry{entry: e, idx: idx})
+						continue
 					}
-					log.Fatalf("a fatal err occurred, the db can not open.[%+v]", err)
+					if _, ok := committed[block.id]; !ok {
+						db.abortedTxns[block.id] = struct{}{}
+						db.garbage.discard(dType, fid, int64(e.Size()))
+						continue
+					}
+				} else {
+					abortPending()
+				}
+				if after {
+					continue
+				}
+
+				// 核心在于调用buildIndex
+				if err := db.buildIndex(e, idx); err != nil {
+					return err
 				}
 			}
 		}
-	} (uint16(dataType))
-	wg.Wait()
+
+		// the transaction was interrupted, write a rollback marker so that it will never be committed.
+		if pending != nil && db.asOf == 0 {
+			abortPending()
+			if err := db.store(newTxnMarker(block.id, dType, TxRollback, nil, nil)); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,57 @@
+package index
+
+import (
+	"hash/fnv"
+
+	"github.com/tidwall/btree"
+)
+
+type (
+	// Keys keeps the keys of a data structure, or the fields of a hash, in the order of their hashes,
+	// so they can be scanned incrementally from a hash while the keys are added and removed.
+	Keys struct {
+		tree *btree.BTree
+	}
+
+	hashedKey struct {
+		hash uint64
+		key  string
+	}
+)
+
+func NewKeys() *Keys {
+	return &Keys{tree: btree.NewNonConcurrent(func(a, b interface{}) bool {
+		x, y := a.(hashedKey), b.(hashedKey)
+		if x.hash != y.hash {
+			return x.hash < y.hash
+		}
+		return x.key < y.key
+	})}
+}
+
+// KeyHash returns the hash of key which decides its order.
+func KeyHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+func (k *Keys) Add(key string) {
+	k.tree.Set(hashedKey{hash: KeyHash(key), key: key})
+}
+
+func (k *Keys) Remove(key string) {
+	k.tree.Delete(hashedKey{hash: KeyHash(key), key: key})
+}
+
+func (k *Keys) Len() int {
+	return k.tree.Len()
+}
+
+// Ascend calls fn for the keys whose hashes are not less than cursor in order, until fn returns false.
+func (k *Keys) Ascend(cursor uint64, fn func(hash uint64, key string) bool) {
+	k.tree.Ascend(hashedKey{hash: cursor}, func(item interface{}) bool {
+		hk := item.(hashedKey)
+		return fn(hk.hash, hk.key)
+	})
+}
@@ -0,0 +1,60 @@
+package kv
+
+// Types returns the data types holding key, the keys of different data types are independent in rosedb.
+// The expired keys are not included.
+func (db *KVDB) Types(key []byte) []DataType {
+	var dTypes []DataType
+	for i := 0; i < DataStructureNum; i++ {
+		dType := DataType(i)
+		unlock := db.lockMgr.RLock(dType)
+		if !db.keyExpired(dType, string(key)) && db.keyExists(dType, string(key)) {
+			dTypes = append(dTypes, dType)
+		}
+		unlock()
+	}
+	return dTypes
+}
+
+// PTTL returns the time to live of the key of data type in milliseconds like the PTTL command of Redis,
+// it is -2 if the key does not exist, and -1 if the key has no expired time.
+func (db *KVDB) PTTL(dType DataType, key []byte) int64 {
+	if dType >= DataStructureNum {
+		return -2
+	}
+
+	unlock := db.lockMgr.RLock(dType)
+	defer unlock()
+
+	if db.keyExpired(dType, string(key)) || !db.keyExists(dType, string(key)) {
+		return -2
+	}
+	deadline, exist := db.expires[dType][string(key)]
+	if !exist {
+		return -1
+	}
+	return deadline - db.now()
+}
+
+// keyExpired checks whether the key of data type is expired without removing it, the caller must hold the lock of it.
+func (db *KVDB) keyExpired(dType DataType, key string) bool {
+	deadline, exist := db.expires[dType][key]
+	return exist && db.now() > deadline
+}
+
+// keyExists checks whether the key of data type exists, the caller must hold the lock of it.
+func (db *KVDB) keyExists(dType DataType, key string) bool {
+	switch dType {
+	case String:
+		_, exist := db.strIndex.indexes[key]
+		return exist
+	case Hash:
+		return db.hashIndex.indexes.HLen(key) > 0
+	case List:
+		return db.listIndex.indexes.LKeyExists(key)
+	case Set:
+		return db.setIndex.indexes.SKeyExists(key)
+	case ZSet:
+		return db.zsetIndex.indexes.ZKeyExists(key)
+	}
+	return false
+}
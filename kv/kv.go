@@ -7,7 +7,6 @@ import (
 
 	"sync"
 	"errors"
-	"time"
 	"log"
 	"os"
 	"io/ioutil"
@@ -58,8 +57,41 @@ var (
 	// ErrTxIsFinished tx is finished.
 	ErrTxIsFinished = errors.New("rosedb: transaction is finished, create a new one")
 
+	// ErrTxIsReadOnly writes are not allowed in a read-only transaction.
+	ErrTxIsReadOnly = errors.New("rosedb: transaction is read-only")
+
+	// ErrDBIsReadOnly the db opened by OpenAt can`t be written.
+	ErrDBIsReadOnly = errors.New("rosedb: db is read-only")
+
+	// ErrReclaimNotRecovered a committed reclaim is not finished, it can only be recovered by Open.
+	ErrReclaimNotRecovered = errors.New("rosedb: reclaim is not recovered, open the db by Open first")
+
 	// ErrActiveFileIsNil active file is nil.
 	ErrActiveFileIsNil = errors.New("rosedb: active file is nil")
+
+	// ErrDBFileNotExist the db file of the index info is not exist.
+	ErrDBFileNotExist = errors.New("rosedb: db file not exist")
+
+	// ErrWrongNumberOfArgs the number of args is wrong.
+	ErrWrongNumberOfArgs = errors.New("rosedb: wrong number of args")
+
+	// ErrWrongValueType value is not an integer.
+	ErrWrongValueType = errors.New("rosedb: value is not an integer")
+
+	// ErrInvalidScore the score of zset is not a number.
+	ErrInvalidScore = errors.New("rosedb: score is not a number")
+
+	// ErrIntegerOverflow the result of incr or decr overflows.
+	ErrIntegerOverflow = errors.New("rosedb: increment or decrement would overflow")
+
+	// ErrWrongFloatType value is not a float.
+	ErrWrongFloatType = errors.New("rosedb: value is not a valid float")
+
+	// ErrFloatOverflow the result of incr by float is NaN or infinity.
+	ErrFloatOverflow = errors.New("rosedb: increment would produce NaN or Infinity")
+
+	// ErrInvalidDataType the data type is not one of Hash, String, List, Set and ZSet.
+	ErrInvalidDataType = errors.New("rosedb: invalid data type")
 )
 
 
@@ -70,24 +102,49 @@ const (
 	// rosedb reclaim path, a temporary dir, will be removed after reclaim.
 	reclaimPath = string(os.PathSeparator) + "rosedb_reclaim"
 
+	// The manifest of a committed reclaim, it is removed after the new db files are swapped in.
+	reclaimManifestFile = string(os.PathSeparator) + "RECLAIM"
+
+	// The corrupt db files found in recovery are moved to this dir.
+	quarantinePath = string(os.PathSeparator) + "quarantine"
+
 	// Separator of the extra info, some commands can`t contains it.
 	ExtraSeparator = "\\0"
 
 	// DataStructureNum the num of different data structures, there are five now(string, list, hash, set, zset).
-	DataStructureNum = 1
+	DataStructureNum = 5
 )
 
 type (
 	KVDB struct {
-		activeFile         *sync.Map
-		archFiles          ArchivedFiles
-		hashIndex          *HashIdx
-		config             Config
-		mu                 sync.RWMutex
-		expires            Expires
-		isReclaiming       bool
-		lockMgr            *LockMgr
-		closed             uint32
+		activeFile   *sync.Map
+		archFiles    ArchivedFiles
+		hashIndex    *HashIdx
+		strIndex     *StrIdx
+		listIndex    *ListIdx
+		setIndex     *SetIdx
+		zsetIndex    *ZsetIdx
+		config       Config
+		mu           sync.RWMutex
+		expires      Expires
+		fieldExpires map[string]map[string]int64 // the deadlines of hash fields in milliseconds, guarded by the lock of hash.
+		isReclaiming uint32                      // set by Reclaim and SingleReclaim, only one of them can run at a time.
+		lockMgr      *LockMgr
+		closed       uint32
+		txnId        uint64                                // the id of the latest transaction.
+		abortedTxns  map[uint64]struct{}                   // transactions never committed, discarded in reclaim.
+		hintItems    [DataStructureNum][]*storage.HintItem // hint items of active files, saved when archived.
+		recovery     *RecoveryReport
+		garbage      *garbageStats // the dead bytes of db files, used by compaction.
+		compactStop  chan struct{}
+		compactWg    sync.WaitGroup
+		expireStop   chan struct{}
+		expireWg     sync.WaitGroup
+		codec        storage.Codec      // compress the values written to db files.
+		encryptor    *storage.Encryptor // encrypt the entries written to db files.
+		asOf         int64              // the db is read-only as of this time in nanoseconds if it is not 0, see OpenAt.
+		committer    *groupCommitter    // persist the writes in batches in the always sync policy.
+		syncer       *backgroundSyncer  // persist the writes in background in the interval and bytes sync policies.
 	}
 
 	ArchivedFiles map[DataType]map[uint32]*storage.DBFile
@@ -96,16 +153,57 @@ type (
 )
 
 // Open a rosedb instance. You must call Close after using it.
+// The torn tail of active files is truncated and the corrupt tail of archived files is quarantined when opening,
+// see RecoveryReport for the details.
 func Open(config Config) (*KVDB, error) {
-	// create the dir path if not exists.
-	if !utils.Exist(config.DirPath) {
-		if err := os.MkdirAll(config.DirPath, os.ModePerm); err != nil {
+	return open(config, 0)
+}
+
+// open opens the db, it is read-only as of asOf in nanoseconds if asOf is not 0.
+// The read-only db doesn't repair or write anything in the db directory.
+func open(config Config, asOf int64) (*KVDB, error) {
+	readOnly := asOf != 0
+	if readOnly {
+		// the db files are not consistent until the committed reclaim is applied.
+		if utils.Exist(config.DirPath + reclaimManifestFile) {
+			return nil, ErrReclaimNotRecovered
+		}
+	} else {
+		// create the dir path if not exists.
+		if !utils.Exist(config.DirPath) {
+			if err := os.MkdirAll(config.DirPath, os.ModePerm); err != nil {
+				return nil, err
+			}
+		}
+
+		// finish or roll back the reclaim interrupted by a crash.
+		if err := recoverReclaim(config.DirPath); err != nil {
 			return nil, err
 		}
 	}
 
+	codec, err := storage.CodecByName(config.Compression)
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := newEncryptor(config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = syncPolicy(config); err != nil {
+		return nil, err
+	}
+
 	// load the db files from disk.
-	archFiles, activeFileIds, err := storage.Build(config.DirPath, config.RwMethod, config.BlockSize)
+	var (
+		archFiles     map[uint16]map[uint32]*storage.DBFile
+		activeFileIds map[uint16]uint32
+	)
+	if readOnly {
+		archFiles, activeFileIds, err = storage.BuildReadOnly(config.DirPath, config.BlockSize)
+	} else {
+		archFiles, activeFileIds, err = storage.Build(config.DirPath, config.RwMethod, config.BlockSize)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -113,23 +211,62 @@ func Open(config Config) (*KVDB, error) {
 	// set active files for writing.
 	activeFiles := new(sync.Map)
 	for dataType, fileId := range activeFileIds {
+		if readOnly {
+			file, err := storage.OpenDBFile(config.DirPath, fileId, config.BlockSize, dataType)
+			if err != nil {
+				return nil, err
+			}
+			activeFiles.Store(dataType, file)
+			continue
+		}
+
 		file, err := storage.NewDBFile(config.DirPath, fileId, config.RwMethod, config.BlockSize, dataType)
 		if err != nil {
 			return nil, err
 		}
+
+		// new entries are always written in the current format, so the active file in old format is archived.
+		if file.Version() != storage.CurrentVersion {
+			archFiles[dataType][fileId] = file
+			if file, err = storage.NewDBFile(config.DirPath, fileId+1, config.RwMethod, config.BlockSize, dataType); err != nil {
+				return nil, err
+			}
+		}
 		activeFiles.Store(dataType, file)
 	}
 
 	db := &KVDB{
-		activeFile: activeFiles,
-		archFiles:  archFiles,
-		config:     config,
-		hashIndex:  newHashIdx(),
-		expires:    make(Expires),
+		activeFile:   activeFiles,
+		archFiles:    archFiles,
+		config:       config,
+		hashIndex:    newHashIdx(config.HashOrderedFields),
+		strIndex:     newStrIdx(),
+		listIndex:    newListIdx(),
+		setIndex:     newSetIdx(),
+		zsetIndex:    newZsetIdx(),
+		expires:      make(Expires),
+		fieldExpires: make(map[string]map[string]int64),
+		abortedTxns:  make(map[uint64]struct{}),
+		recovery:     new(RecoveryReport),
+		garbage:      newGarbageStats(),
+		codec:        codec,
+		encryptor:    encryptor,
+		asOf:         asOf,
 	}
 	for i := 0; i < DataStructureNum; i++ {
 		db.expires[uint16(i)] = make(map[string]int64)
 	}
+
+	// all db files share the compression and encryption.
+	for _, files := range archFiles {
+		for _, df := range files {
+			db.setupDBFile(df)
+		}
+	}
+	activeFiles.Range(func(key, value interface{}) bool {
+		db.setupDBFile(value.(*storage.DBFile))
+		return true
+	})
 	db.lockMgr = newLockMgr(db)
 
 	// load indexes from db files.
@@ -138,6 +275,20 @@ func Open(config Config) (*KVDB, error) {
 		return nil, err
 	}
 
+	// compact the db files with much garbage in background.
+	if !readOnly {
+		if err := db.startCompactor(); err != nil {
+			return nil, err
+		}
+		if err := db.startSyncer(); err != nil {
+			return nil, err
+		}
+		db.startExpirer()
+		// the active files are preallocated after their torn tails are truncated.
+		if err := db.preallocateActiveFiles(); err != nil {
+			return nil, err
+		}
+	}
 	return db, nil
 }
 
@@ -161,11 +312,19 @@ func Reopen(path string) (*KVDB, error) {
 
 // Close db and save relative configs.
 func (db *KVDB) Close() (err error) {
+	// wait for the running compaction, it holds the lock of db.
+	db.stopCompactor()
+	db.stopExpirer()
+	// the waiting writers are released, and their writes are synced when closing the active files.
+	db.stopSyncer()
+
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if err = db.saveConfig(); err != nil {
-		return err
+	if db.asOf == 0 {
+		if err = db.saveConfig(); err != nil {
+			return err
+		}
 	}
 
 	// close and sync the active file.
@@ -184,7 +343,7 @@ func (db *KVDB) Close() (err error) {
 	// close the archived files.
 	for _, archFile := range db.archFiles {
 		for _, file := range archFile {
-			if err = file.Sync(); err != nil {
+			if err = file.Close(true); err != nil {
 				return err
 			}
 		}
@@ -198,10 +357,19 @@ func (db *KVDB) Close() (err error) {
 // Reclaim operation will read all archived files, iterate all entries and find the valid.
 // Then rewrite the valid entries to new db files.
 // So the time required for reclaim operation depend on the number of entries, you`d better execute it in low peak period.
+// The db files in old format are rewritten in the current format by reclaim.
 func (db *KVDB) Reclaim() (err error) {
+	if db.asOf != 0 {
+		return ErrDBIsReadOnly
+	}
+	if !atomic.CompareAndSwapUint32(&db.isReclaiming, 0, 1) {
+		return ErrDBisReclaiming
+	}
+	defer atomic.StoreUint32(&db.isReclaiming, 0)
+
 	var reclaimable bool
-	for _, archFiles := range db.archFiles {
-		if len(archFiles) >= db.config.ReclaimThreshold {
+	for dType := range db.archFiles {
+		if db.needReclaim(dType) {
 			reclaimable = true
 			break
 		}
@@ -212,21 +380,36 @@ func (db *KVDB) Reclaim() (err error) {
 
 	// create a temporary directory for storing the new db files.
 	reclaimPath := db.config.DirPath + reclaimPath
+	if err := os.RemoveAll(reclaimPath); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(reclaimPath, os.ModePerm); err != nil {
 		return err
 	}
-	defer os.RemoveAll(reclaimPath)
-
-	db.mu.Lock()
+	// the new db files are discarded unless the reclaim is committed.
+	var committed bool
 	defer func() {
-		db.isReclaiming = false
-		db.mu.Unlock()
+		if !committed {
+			os.RemoveAll(reclaimPath)
+		}
 	}()
-	db.isReclaiming = true
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// the positions in index will be changed, so block reads and writes of all data structures.
+	var dTypes []DataType
+	for i := 0; i < DataStructureNum; i++ {
+		dTypes = append(dTypes, uint16(i))
+	}
+	unlock := db.lockMgr.Lock(dTypes...)
+	defer unlock()
 
 	// processing the different types of files in different goroutines.
 	newArchivedFiles := sync.Map{}
 	reclaimedTypes := sync.Map{}
+	reclaimedIndexers := sync.Map{}
+	errCh := make(chan error, DataStructureNum)
 
 	wg := sync.WaitGroup{}
 	wg.Add(DataStructureNum)
@@ -236,7 +419,7 @@ func (db *KVDB) Reclaim() (err error) {
 				wg.Done()
 			}()
 
-			if len(db.archFiles[dType]) < db.config.ReclaimThreshold {
+			if !db.needReclaim(dType) {
 				newArchivedFiles.Store(dType, db.archFiles[dType])
 				return
 			}
@@ -246,22 +429,43 @@ func (db *KVDB) Reclaim() (err error) {
 				fileId    uint32
 				archFiles = make(map[uint32]*storage.DBFile)
 				fileIds   []int
+				indexers  []*index.Indexer
+				hints     = make(map[uint32][]*storage.HintItem)
 			)
+			// the old db files are kept if failed, and the new ones will be removed with the reclaim directory.
+			abort := func(err error) {
+				for _, f := range archFiles {
+					_ = f.Close(false)
+				}
+				errCh <- err
+			}
 
 			for _, file := range db.archFiles[dType] {
 				fileIds = append(fileIds, int(file.Id))
 			}
 			sort.Ints(fileIds)
 
-			for _, fid := range fileIds {
+			// the operations of a list depend on the order of each other, so the lists are rewritten as a snapshot.
+			var snapshot *listSnapshot
+			if dType == List {
+				snapshot = newListSnapshot()
+			}
+
+			// the transaction which is being read, its entries may be saved in several db files.
+			var block txnBlock
+			for i, fid := range fileIds {
 				file := db.archFiles[dType][uint32(fid)]
-				var offset int64 = 0
+				offset := file.DataOffset()
 				var reclaimEntries []*storage.Entry
 
 				// read all entries in db file, and find the valid entry.
 				for {
 					if e, err := file.Read(offset); err == nil {
-						if db.validEntry(e, offset, file.Id) {
+						if snapshot != nil {
+							if snapshot.add(db, e, &block) {
+								reclaimEntries = append(reclaimEntries, e)
+							}
+						} else if db.validTxnEntry(e, offset, file.Id, &block) {
 							reclaimEntries = append(reclaimEntries, e)
 						}
 						offset += int64(e.Size())
@@ -269,83 +473,211 @@ func (db *KVDB) Reclaim() (err error) {
 						if err == io.EOF {
 							break
 						}
-						log.Fatalf("err occurred when read the entry: %+v", err)
+						abort(fmt.Errorf("read entry from db file %d: %w", file.Id, err))
 						return
 					}
 				}
 
+				if i == len(fileIds)-1 && snapshot != nil {
+					reclaimEntries = append(reclaimEntries, snapshot.entries(db)...)
+				}
+				// the rest entries of the transaction are in the active file, so keep the begin marker for them.
+				if i == len(fileIds)-1 && block.remaining > 0 {
+					reclaimEntries = append(reclaimEntries, block.marker(dType))
+				}
+
 				// rewrite the valid entries to new db file.
 				for _, entry := range reclaimEntries {
-					if df == nil || int64(entry.Size())+df.Offset > db.config.BlockSize {
-						df, err = storage.NewDBFile(reclaimPath, fileId, db.config.RwMethod, db.config.BlockSize, dType)
+					if df == nil || int64(entry.MaxSize())+df.Offset > db.config.BlockSize {
+						newFile, err := storage.NewDBFile(reclaimPath, fileId, db.config.RwMethod, db.config.BlockSize, dType)
 						if err != nil {
-							log.Fatalf("err occurred when create new db file: %+v", err)
+							abort(err)
 							return
 						}
+						df = newFile
+						db.setupDBFile(df)
 						archFiles[fileId] = df
 						fileId += 1
 					}
 
-					if err = df.Write(entry); err != nil {
-						log.Fatalf("err occurred when write the entry: %+v", err)
+					if err := df.Write(entry); err != nil {
+						abort(err)
 						return
 					}
+					offset := df.Offset - int64(entry.Size())
+					hints[df.Id] = append(hints[df.Id], newHintItem(entry, offset))
+					if !isIndexed(entry) {
+						continue
+					}
+					indexers = append(indexers, &index.Indexer{
+						Meta:   entry.Meta,
+						FileId: df.Id,
+						Offset: offset,
+					})
 				}
 			}
+
+			// the new db files will be reopened from the db directory after swapping.
+			for id, df := range archFiles {
+				if err := df.WriteHint(dType, hints[id]); err != nil {
+					log.Println("write hint file err: ", err)
+				}
+				if err := df.Close(true); err != nil {
+					abort(err)
+					return
+				}
+			}
+
+			reclaimedIndexers.Store(dType, indexers)
 			reclaimedTypes.Store(dType, struct{}{})
 			newArchivedFiles.Store(dType, archFiles)
 		}(uint16(i))
 	}
 	wg.Wait()
 
-	dbArchivedFiles := make(ArchivedFiles)
+	// nothing is changed if any type of data failed to reclaim.
+	close(errCh)
+	if err = <-errCh; err != nil {
+		return
+	}
+	if err = utils.SyncDir(reclaimPath); err != nil {
+		return
+	}
+
+	// the new db files are numbered from 0, the active file is moved if they need its id.
 	for i := 0; i < DataStructureNum; i++ {
 		dType := uint16(i)
-		value, ok := newArchivedFiles.Load(dType)
-		if !ok {
-			log.Printf("one type of data(%d) is missed after reclaiming.", dType)
-			return
+		if _, ok := reclaimedTypes.Load(dType); !ok {
+			continue
+		}
+		newFiles, _ := newArchivedFiles.Load(dType)
+		count := uint32(len(newFiles.(map[uint32]*storage.DBFile)))
+		activeFile, err := db.getActiveFile(dType)
+		if err != nil {
+			return err
+		}
+		if activeFile.Id < count {
+			if err = db.moveActiveFile(dType, count); err != nil {
+				return err
+			}
 		}
-		dbArchivedFiles[dType] = value.(map[uint32]*storage.DBFile)
 	}
 
-	// delete the old db files.
-	for dataType, files := range db.archFiles {
-		if _, exist := reclaimedTypes.Load(dataType); exist {
-			for _, f := range files {
-				// close file before remove it.
-				if err = f.File.Close(); err != nil {
-					log.Println("close old db file err: ", err)
-					return
-				}
-				if err = os.Remove(f.File.Name()); err != nil {
-					log.Println("remove old db file err: ", err)
-					return
-				}
-			}
+	// commit the reclaim by the manifest, an interrupted swapping will be finished when db is opened.
+	manifest := &reclaimManifest{Files: make(map[DataType]reclaimFiles)}
+	reclaimedTypes.Range(func(key, value interface{}) bool {
+		dType := key.(DataType)
+		var files reclaimFiles
+		for id := range db.archFiles[dType] {
+			files.Old = append(files.Old, id)
 		}
+		newFiles, _ := newArchivedFiles.Load(dType)
+		for id := range newFiles.(map[uint32]*storage.DBFile) {
+			files.New = append(files.New, id)
+		}
+		manifest.Files[dType] = files
+		return true
+	})
+	if err = writeReclaimManifest(db.config.DirPath, manifest); err != nil {
+		return
 	}
+	committed = true
 
-	// copy the temporary reclaim directory as new db files.
-	for dataType, files := range dbArchivedFiles {
-		if _, exist := reclaimedTypes.Load(dataType); exist {
-			for _, f := range files {
-				name := storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dataType], f.Id)
-				os.Rename(reclaimPath+name, db.config.DirPath+name)
+	// the old db files must be closed before they are removed.
+	for dType := range manifest.Files {
+		for _, f := range db.archFiles[dType] {
+			if err := f.Close(false); err != nil {
+				log.Println("close old db file err: ", err)
 			}
 		}
 	}
+	if err = applyReclaimManifest(db.config.DirPath, manifest); err != nil {
+		return
+	}
+
+	dbArchivedFiles := make(ArchivedFiles)
+	for dType, files := range db.archFiles {
+		if _, ok := manifest.Files[dType]; !ok {
+			dbArchivedFiles[dType] = files
+			continue
+		}
 
+		dbArchivedFiles[dType] = make(map[uint32]*storage.DBFile)
+		for _, id := range manifest.Files[dType].New {
+			df, err := storage.NewDBFile(db.config.DirPath, id, db.config.RwMethod, db.config.BlockSize, dType)
+			if err != nil {
+				return err
+			}
+			db.setupDBFile(df)
+			dbArchivedFiles[dType][id] = df
+		}
+		// the reclaimed db files have only valid entries.
+		for _, id := range manifest.Files[dType].Old {
+			db.garbage.remove(dType, id)
+		}
+		for id, df := range dbArchivedFiles[dType] {
+			db.garbage.reset(dType, id, df.Offset-df.DataOffset())
+		}
+	}
 	db.archFiles = dbArchivedFiles
+
+	// the index must point to the new positions, values are read from them in KeyOnlyMemMode.
+	reclaimedIndexers.Range(func(key, value interface{}) bool {
+		for _, idx := range value.([]*index.Indexer) {
+			db.resetIndexer(key.(DataType), idx)
+		}
+		return true
+	})
 	return
 }
 
-// Backup copy the database directory for backup.
-func (db *KVDB) Backup(dir string) (err error) {
-	if utils.Exist(db.config.DirPath) {
-		err = utils.CopyDir(db.config.DirPath, dir)
+// SingleReclaim reclaims the redundant space of an archived file of dType.
+// The valid entries of the db file are rewritten to the active file in batches, and then the db file is removed,
+// so the reads and writes are only blocked while a batch is being rewritten.
+// For list, set and zset, the keys written in the db file are rewritten with their current values instead,
+// so more bytes than the db file has may be written for large keys.
+// ErrInvalidDataType is returned if dType is not a data type of db.
+// It can't be executed with Reclaim at the same time, ErrDBisReclaiming is returned if another reclaim is running.
+func (db *KVDB) SingleReclaim(dType DataType, fileId uint32) (err error) {
+	if db.asOf != 0 {
+		return ErrDBIsReadOnly
 	}
-	return
+	if dType >= DataStructureNum {
+		return ErrInvalidDataType
+	}
+	if !atomic.CompareAndSwapUint32(&db.isReclaiming, 0, 1) {
+		return ErrDBisReclaiming
+	}
+	defer atomic.StoreUint32(&db.isReclaiming, 0)
+
+	// wait for the running background compaction.
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if atomic.LoadUint32(&db.closed) == 1 {
+		return ErrDBIsClosed
+	}
+	return db.compactFile(dType, fileId, nil, nil)
+}
+
+// needReclaim reports whether the archived files of dType should be reclaimed.
+// The files in old format are always reclaimed, so that they are upgraded to the current format,
+// and so are the files not encrypted with the current key.
+func (db *KVDB) needReclaim(dType DataType) bool {
+	if len(db.archFiles[dType]) >= db.config.ReclaimThreshold {
+		return true
+	}
+	for _, f := range db.archFiles[dType] {
+		if f.Version() != storage.CurrentVersion {
+			return true
+		}
+		if db.encryptor != nil {
+			if id, ok := f.KeyId(); !ok || id != db.encryptor.Current() {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // Persist the db files.
@@ -390,12 +722,98 @@ func (db *KVDB) saveConfig() error {
 // build the indexes for different data structures.
 func (db *KVDB) buildIndex(entry *storage.Entry, idx *index.Indexer) (err error) {
 	switch entry.GetType() {
-	case storage.Hash:
-		db.buildHashIndex(entry)
+	case Hash:
+		db.buildHashIndex(entry, idx)
+	case String:
+		db.buildStringIndex(entry, idx)
+	case List:
+		db.buildListIndex(entry)
+	case Set:
+		db.buildSetIndex(entry)
+	case ZSet:
+		db.buildZsetIndex(entry)
 	}
 	return
 }
 
+// newIndexer returns the index info of an entry which has just been written to the active file.
+// The value is dropped in KeyOnlyMemMode.
+func (db *KVDB) newIndexer(e *storage.Entry) (*index.Indexer, error) {
+	activeFile, err := db.getActiveFile(e.GetType())
+	if err != nil {
+		return nil, err
+	}
+
+	meta := *e.Meta
+	if db.config.IdxMode == KeyOnlyMemMode {
+		meta.Value = nil
+	}
+	return &index.Indexer{
+		Meta:   &meta,
+		FileId: activeFile.Id,
+		Offset: activeFile.Offset - int64(e.Size()),
+	}, nil
+}
+
+// isIndexed reports whether the index info points to the entry, only the entries holding values are indexed.
+// The other entries of a key, such as its expire info, must not move the index info when they are reclaimed.
+func isIndexed(e *storage.Entry) bool {
+	switch e.GetType() {
+	case Hash:
+		return e.GetMark() == HashHSet
+	case String:
+		return e.GetMark() == StringSet
+	}
+	return false
+}
+
+// resetIndexer points the index info of a reclaimed entry to its new position, see isIndexed.
+func (db *KVDB) resetIndexer(dType DataType, idx *index.Indexer) {
+	switch dType {
+	case Hash:
+		key, field := string(idx.Meta.Key), string(idx.Meta.Extra)
+		if old, _ := db.hashIndex.indexes.HGet(key, field); old != nil {
+			old.FileId = idx.FileId
+			old.Offset = idx.Offset
+		}
+	case String:
+		if old, ok := db.strIndex.indexes[string(idx.Meta.Key)]; ok {
+			old.FileId = idx.FileId
+			old.Offset = idx.Offset
+		}
+	}
+}
+
+// readValue reads the value from db file according to the index info.
+func (db *KVDB) readValue(dType DataType, idx *index.Indexer) ([]byte, error) {
+	df, err := db.getDBFile(dType, idx.FileId)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := df.Read(idx.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return e.Meta.Value, nil
+}
+
+func (db *KVDB) getDBFile(dType DataType, fileId uint32) (*storage.DBFile, error) {
+	activeFile, err := db.getActiveFile(dType)
+	if err != nil {
+		return nil, err
+	}
+	if activeFile.Id == fileId {
+		return activeFile, nil
+	}
+
+	df, ok := db.archFiles[dType][fileId]
+	if !ok {
+		return nil, ErrDBFileNotExist
+	}
+	return df, nil
+}
+
 func (db *KVDB) getActiveFile(dType DataType) (file *storage.DBFile, err error) {
 	value, ok := db.activeFile.Load(dType)
 	if !ok || value == nil {
@@ -429,22 +847,33 @@ func (db *KVDB) checkKeyValue(key []byte, value ...[]byte) error {
 	return nil
 }
 
+// checkExpired removes the key if it is expired, the caller must hold the write lock of dType.
+// The reads only check the deadline by keyExpired, since removing the key writes the index and the active file.
 func (db *KVDB) checkExpired(key []byte, dType DataType) (expired bool) {
 	deadline, exist := db.expires[dType][string(key)]
 	if !exist {
 		return
 	}
 
-	if time.Now().Unix() > deadline {
+	if db.now() > deadline {
 		expired = true
 
-		var e *storage.Entry
 		switch dType {
 		case Hash:
-			e = storage.NewEntryNoExtra(key, nil, Hash, HashHClear)
+			db.discardHashKey(string(key))
 			db.hashIndex.indexes.HClear(string(key))
+			delete(db.fieldExpires, string(key))
+		case String:
+			db.discardStr(string(key))
+			db.strIndex.remove(string(key))
+		case List:
+			db.listIndex.indexes.LClear(string(key))
+		case Set:
+			db.setIndex.indexes.SClear(string(key))
+		case ZSet:
+			db.zsetIndex.indexes.ZClear(string(key))
 		}
-		if err := db.store(e); err != nil {
+		if err := db.store(newClearEntry(key, dType)); err != nil {
 			log.Println("checkExpired: store entry err: ", err)
 			return
 		}
@@ -454,8 +883,27 @@ func (db *KVDB) checkExpired(key []byte, dType DataType) (expired bool) {
 	return
 }
 
+// newClearEntry returns the entry which removes the key of dType.
+func newClearEntry(key []byte, dType DataType) *storage.Entry {
+	var mark uint16
+	switch dType {
+	case Hash:
+		mark = HashHClear
+	case String:
+		mark = StringRem
+	case List:
+		mark = ListLClear
+	case Set:
+		mark = SetSClear
+	case ZSet:
+		mark = ZSetZClear
+	}
+	return storage.NewEntryNoExtra(key, nil, dType, mark)
+}
+
 // validEntry check whether entry is valid(contains add and update types of operations).
-// expired entry will be filtered.
+// expired entry will be filtered. The lists are rewritten as a snapshot by reclaim instead, see listSnapshot.
+// The caller must hold the lock of the entry's data type.
 func (db *KVDB) validEntry(e *storage.Entry, offset int64, fileId uint32) bool {
 	if e == nil {
 		return false
@@ -464,22 +912,91 @@ func (db *KVDB) validEntry(e *storage.Entry, offset int64, fileId uint32) bool {
 	mark := e.GetMark()
 	switch e.GetType() {
 	case Hash:
-		if mark == HashHExpire {
-			deadline, exist := db.expires[Hash][string(e.Meta.Key)]
-			if exist && deadline > time.Now().Unix() {
-				return true
-			}
+		deadline, exist := db.expires[Hash][string(e.Meta.Key)]
+		if exist && db.now() > deadline {
+			return false
+		}
+
+		// only the latest expire entry is valid, the old ones may be moved after it in compaction.
+		if (mark == HashHExpire || mark == HashHPExpire) && exist {
+			return expireDeadline(e) == deadline
+		}
+
+		// the field is dropped with its expire entry if it is expired.
+		fieldDeadline, fieldExist := db.fieldExpires[string(e.Meta.Key)][string(e.Meta.Extra)]
+		if fieldExist && db.now() > fieldDeadline {
+			return false
+		}
+		if mark == HashHFieldExpire {
+			return fieldExist && expireDeadline(e) == fieldDeadline
 		}
 		if mark == HashHSet {
-			if val := db.HGet(e.Meta.Key, e.Meta.Extra); string(val) == string(e.Meta.Value) {
-				return true
+			idx, _ := db.hashIndex.indexes.HGet(string(e.Meta.Key), string(e.Meta.Extra))
+			if idx == nil {
+				return false
+			}
+			// only the entry which the index points to is valid.
+			return idx.FileId == fileId && idx.Offset == offset
+		}
+	case String:
+		deadline, exist := db.expires[String][string(e.Meta.Key)]
+		if exist && db.now() > deadline {
+			return false
+		}
+
+		if mark == StringExpire && exist {
+			return expireDeadline(e) == deadline
+		}
+		if mark == StringSet {
+			idx, ok := db.strIndex.indexes[string(e.Meta.Key)]
+			if !ok {
+				return false
 			}
+			return idx.FileId == fileId && idx.Offset == offset
+		}
+	case Set:
+		// the member is moved to the destination, it is kept even if the source set is expired.
+		if mark == SetSMove {
+			dst := string(e.Meta.Extra)
+			if deadline, exist := db.expires[Set][dst]; exist && db.now() > deadline {
+				return false
+			}
+			return db.setIndex.indexes.SIsMember(dst, e.Meta.Value)
+		}
+
+		deadline, exist := db.expires[Set][string(e.Meta.Key)]
+		if exist && db.now() > deadline {
+			return false
+		}
+
+		if mark == SetSExpire && exist {
+			return expireDeadline(e) == deadline
+		}
+		if mark == SetSAdd {
+			return db.setIndex.indexes.SIsMember(string(e.Meta.Key), e.Meta.Value)
+		}
+	case ZSet:
+		deadline, exist := db.expires[ZSet][string(e.Meta.Key)]
+		if exist && db.now() > deadline {
+			return false
+		}
+
+		if mark == ZSetZExpire && exist {
+			return expireDeadline(e) == deadline
+		}
+		if mark == ZSetZAdd {
+			score, ok := db.zsetIndex.indexes.ZScore(string(e.Meta.Key), string(e.Meta.Value))
+			return ok && string(formatScore(score)) == string(e.Meta.Extra)
 		}
 	}
 	return false
 }
 
 func (db *KVDB) store(e *storage.Entry) error {
+	if db.asOf != 0 {
+		return ErrDBIsReadOnly
+	}
+
 	// sync the db file if file size is not enough, and open a new db file.
 	config := db.config
 	activeFile, err := db.getActiveFile(e.GetType())
@@ -487,19 +1004,35 @@ func (db *KVDB) store(e *storage.Entry) error {
 		return err
 	}
 
-	if activeFile.Offset+int64(e.Size()) > config.BlockSize {
+	if activeFile.Offset+int64(e.MaxSize()) > config.BlockSize {
 		if err := activeFile.Sync(); err != nil {
 			return err
 		}
+		// the preallocated space is not used by the archived file.
+		if err := activeFile.Trim(); err != nil {
+			return err
+		}
 
 		// save the old db file as arched file.
 		activeFileId := activeFile.Id
 		db.archFiles[e.GetType()][activeFileId] = activeFile
 
+		// the index of archived file will be loaded from its hint file when db is opened.
+		if err := activeFile.WriteHint(e.GetType(), db.hintItems[e.GetType()]); err != nil {
+			log.Println("store: write hint file err: ", err)
+		}
+		db.hintItems[e.GetType()] = nil
+
 		newDbFile, err := storage.NewDBFile(config.DirPath, activeFileId+1, config.RwMethod, config.BlockSize, e.GetType())
 		if err != nil {
 			return err
 		}
+		db.setupDBFile(newDbFile)
+		if db.config.Preallocate {
+			if err := newDbFile.Preallocate(config.BlockSize); err != nil {
+				return err
+			}
+		}
 		activeFile = newDbFile
 	}
 
@@ -507,13 +1040,16 @@ func (db *KVDB) store(e *storage.Entry) error {
 	if err := activeFile.Write(e); err != nil {
 		return err
 	}
+	db.hintItems[e.GetType()] = append(db.hintItems[e.GetType()], newHintItem(e, activeFile.Offset-int64(e.Size())))
+	db.garbage.add(e, activeFile.Id)
 	db.activeFile.Store(e.GetType(), activeFile)
 
-	// persist db file according to the config.
-	if config.Sync {
-		if err := activeFile.Sync(); err != nil {
-			return err
-		}
+	// the entry is persisted by group commit after the writer releases the lock, see waitSync.
+	if db.committer != nil {
+		db.committer.appended()
+	}
+	if db.syncer != nil {
+		db.syncer.appended(int64(e.Size()))
 	}
 	return nil
 }
\ No newline at end of file
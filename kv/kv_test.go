@@ -0,0 +1,101 @@
+package kv
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// openTestDB opens a db in a temporary dir, the config can be changed by update. The db is closed after the test.
+// The background compaction and active expiration are disabled unless update enables them.
+func openTestDB(t *testing.T, update func(cfg *Config)) *KVDB {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	cfg.CompactInterval = 0
+	cfg.ExpireInterval = 0
+	if update != nil {
+		update(&cfg)
+	}
+	return openTestConfig(t, cfg)
+}
+
+// openTestConfig opens a db with the config, the db is closed after the test.
+func openTestConfig(t *testing.T, cfg Config) *KVDB {
+	t.Helper()
+
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	t.Cleanup(func() {
+		closeTestDB(t, db)
+	})
+	return db
+}
+
+// reopenTestDB closes the db and opens it again with the same config.
+func reopenTestDB(t *testing.T, db *KVDB) *KVDB {
+	t.Helper()
+
+	closeTestDB(t, db)
+	return openTestConfig(t, db.config)
+}
+
+func closeTestDB(t *testing.T, db *KVDB) {
+	t.Helper()
+
+	if atomic.LoadUint32(&db.closed) == 1 {
+		return
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("close db: %v", err)
+	}
+}
+
+// testConfigs returns the configs of both index modes, the tests run with each of them.
+func testConfigs() map[string]func(cfg *Config) {
+	return map[string]func(cfg *Config){
+		"KeyValueMemMode": func(cfg *Config) {
+			cfg.IdxMode = KeyValueMemMode
+		},
+		"KeyOnlyMemMode": func(cfg *Config) {
+			cfg.IdxMode = KeyOnlyMemMode
+		},
+	}
+}
+
+func assertBytes(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	if got == nil || string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func assertNil(t *testing.T, got []byte) {
+	t.Helper()
+
+	if got != nil {
+		t.Fatalf("got %q, want nil", got)
+	}
+}
+
+func assertStrings(t *testing.T, got [][]byte, want ...string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range got {
+		if string(got[i]) != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+// smallFiles makes the db files small, so that a few writes archive some of them and they can be reclaimed.
+func smallFiles(cfg *Config) {
+	cfg.BlockSize = 1024
+	cfg.ReclaimThreshold = 2
+}
@@ -12,6 +12,10 @@ func newLockMgr(db *KVDB) *LockMgr {
 	locks := make(map[DataType]*sync.RWMutex)
 	// store the lock of different data types.
 	locks[Hash] = db.hashIndex.mu
+	locks[String] = db.strIndex.mu
+	locks[List] = db.listIndex.mu
+	locks[Set] = db.setIndex.mu
+	locks[ZSet] = db.zsetIndex.mu
 
 	return &LockMgr{locks: locks}
 }
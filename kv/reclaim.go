@@ -0,0 +1,256 @@
+package kv
+
+import (
+	"MetaDB/kv/ds/list"
+	"MetaDB/kv/storage"
+	"MetaDB/kv/utils"
+
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+type (
+	// reclaimManifest records the db files swapped by a committed reclaim.
+	reclaimManifest struct {
+		Files map[DataType]reclaimFiles `json:"files"`
+	}
+
+	// reclaimFiles the old db files in db directory are replaced with the new ones in reclaim directory.
+	reclaimFiles struct {
+		Old []uint32 `json:"old"`
+		New []uint32 `json:"new"`
+	}
+
+	// listSnapshot replays the entries of list in the archived files, so Reclaim writes the lists as of the end of them
+	// instead of their whole history. The entries in the active file are replayed on the snapshot when db is opened.
+	listSnapshot struct {
+		lists         *list.List
+		expires       map[string]int64
+		expireEntries map[string]*storage.Entry // the latest expire entry of every list.
+		written       map[string]uint64         // the time when every list is written last.
+	}
+)
+
+func newListSnapshot() *listSnapshot {
+	return &listSnapshot{
+		lists:         list.New(),
+		expires:       make(map[string]int64),
+		expireEntries: make(map[string]*storage.Entry),
+		written:       make(map[string]uint64),
+	}
+}
+
+// add replays an entry of the archived files, and reports whether it is a commit marker which must be kept.
+func (s *listSnapshot) add(db *KVDB, e *storage.Entry, block *txnBlock) bool {
+	if valid, marker := db.checkTxnEntry(e, block); marker || !valid {
+		return marker && valid
+	}
+
+	key := string(e.Meta.Key)
+	if isExpireEntry(e) {
+		s.expireEntries[key] = e
+	} else {
+		s.written[key] = e.Timestamp
+	}
+	applyListEntry(s.lists, s.expires, e)
+	return false
+}
+
+// entries returns the entries pushing the elements of every list, followed by its expire entry.
+// The lists expired now are dropped, the caller must hold the lock of list.
+// The entries are written at the time of the latest entry of the list, so OpenAt after it still sees the list.
+func (s *listSnapshot) entries(db *KVDB) []*storage.Entry {
+	keys := s.lists.Keys()
+	sort.Strings(keys)
+
+	var res []*storage.Entry
+	for _, key := range keys {
+		if db.keyExpired(List, key) {
+			continue
+		}
+		for _, val := range s.lists.LRange(key, 0, -1) {
+			e := storage.NewEntryNoExtra([]byte(key), val, List, ListRPush)
+			e.Timestamp = s.written[key]
+			res = append(res, e)
+		}
+		if _, exist := s.expires[key]; exist {
+			res = append(res, s.expireEntries[key])
+		}
+	}
+	return res
+}
+
+// moveActiveFile gives the active file of dType a larger id, so the new db files of reclaim can take the ids below it.
+// The active file must have the largest id of its type, because it is found by the id when db is opened.
+// The caller must hold the lock of dType.
+func (db *KVDB) moveActiveFile(dType DataType, fileId uint32) error {
+	activeFile, err := db.getActiveFile(dType)
+	if err != nil {
+		return err
+	}
+	if err = activeFile.Sync(); err != nil {
+		return err
+	}
+
+	oldId := activeFile.Id
+	if err = activeFile.Rename(fileId, dType); err != nil {
+		return err
+	}
+	if err = utils.SyncDir(db.config.DirPath); err != nil {
+		return err
+	}
+
+	// the index must point to the new id, the values of hash and string are read by it.
+	switch dType {
+	case Hash:
+		db.hashIndex.indexes.ScanKeys(0, func(hash uint64, key string) bool {
+			indexers, _ := db.hashIndex.indexes.HGetAll(key)
+			for _, idx := range indexers {
+				if idx.FileId == oldId {
+					idx.FileId = fileId
+				}
+			}
+			return true
+		})
+	case String:
+		for _, idx := range db.strIndex.indexes {
+			if idx.FileId == oldId {
+				idx.FileId = fileId
+			}
+		}
+	}
+	db.garbage.move(dType, oldId, fileId)
+	return nil
+}
+
+// writeReclaimManifest saves the manifest atomically, it is the commit point of reclaim.
+func writeReclaimManifest(dirPath string, m *reclaimManifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	path := dirPath + reclaimManifestFile
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(b); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return utils.SyncDir(dirPath)
+}
+
+// applyReclaimManifest removes the old db files and moves the new ones into db directory.
+// It can be executed again after interrupted, so the new db files are moved only after all the old ones are removed,
+// because they may have the same names.
+func applyReclaimManifest(dirPath string, m *reclaimManifest) error {
+	reclaimDir := dirPath + reclaimPath
+
+	// if any new db file has been moved, all the old ones were removed.
+	var moving bool
+	for dType, files := range m.Files {
+		for _, id := range files.New {
+			if !utils.Exist(reclaimDir + dbFileName(id, dType)) {
+				moving = true
+			}
+		}
+	}
+
+	if !moving {
+		for dType, files := range m.Files {
+			for _, id := range files.Old {
+				if err := removeIfExist(dirPath + dbFileName(id, dType)); err != nil {
+					return err
+				}
+				if err := removeIfExist(dirPath + storage.HintName(id, dType)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := utils.SyncDir(dirPath); err != nil {
+			return err
+		}
+	}
+
+	for dType, files := range m.Files {
+		obsolete := make(map[uint32]bool)
+		for _, id := range files.Old {
+			obsolete[id] = true
+		}
+		for _, id := range files.New {
+			for _, name := range []string{dbFileName(id, dType), storage.HintName(id, dType)} {
+				if !utils.Exist(reclaimDir + name) {
+					continue
+				}
+				// only the old db files can be replaced, any other one may be the active file.
+				if !obsolete[id] && utils.Exist(dirPath+name) {
+					return fmt.Errorf("rosedb: reclaim can't replace the file %s which is not reclaimed", name)
+				}
+				if err := os.Rename(reclaimDir+name, dirPath+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := utils.SyncDir(dirPath); err != nil {
+		return err
+	}
+
+	if err := os.Remove(dirPath + reclaimManifestFile); err != nil {
+		return err
+	}
+	if err := utils.SyncDir(dirPath); err != nil {
+		return err
+	}
+	return os.RemoveAll(reclaimDir)
+}
+
+// recoverReclaim finishes the reclaim if it was committed, otherwise the new db files are discarded.
+func recoverReclaim(dirPath string) error {
+	// the manifest is not committed.
+	if err := removeIfExist(dirPath + reclaimManifestFile + ".tmp"); err != nil {
+		return err
+	}
+
+	path := dirPath + reclaimManifestFile
+	if !utils.Exist(path) {
+		return os.RemoveAll(dirPath + reclaimPath)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var m reclaimManifest
+	if err = json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("rosedb: invalid reclaim manifest: %w", err)
+	}
+	return applyReclaimManifest(dirPath, &m)
+}
+
+func dbFileName(fileId uint32, dType DataType) string {
+	return storage.PathSeparator + fmt.Sprintf(storage.DBFileFormatNames[dType], fileId)
+}
+
+func removeIfExist(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
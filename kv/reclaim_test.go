@@ -0,0 +1,224 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+	"MetaDB/kv/utils"
+
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// copyFile copies the file if it exists.
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(src)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, b, storage.FilePerm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// prepareCommittedReclaim writes some string keys, and leaves a committed reclaim of the archived files which isn't applied.
+// The new db files are the copies of the old ones, so the keys are the same whether the reclaim is applied or not.
+func prepareCommittedReclaim(t *testing.T) (*KVDB, []uint32) {
+	t.Helper()
+
+	db := openTestDB(t, smallFiles)
+	for i := 0; i < 100; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []uint32
+	for id := range db.archFiles[String] {
+		ids = append(ids, id)
+	}
+	if len(ids) < 2 {
+		t.Fatalf("got %d archived files, want at least 2", len(ids))
+	}
+	closeTestDB(t, db)
+
+	dir := db.config.DirPath
+	if err := os.MkdirAll(dir+reclaimPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		for _, name := range []string{dbFileName(id, String), storage.HintName(id, String)} {
+			copyFile(t, dir+name, dir+reclaimPath+name)
+		}
+	}
+	m := &reclaimManifest{Files: map[DataType]reclaimFiles{String: {Old: ids, New: ids}}}
+	if err := writeReclaimManifest(dir, m); err != nil {
+		t.Fatal(err)
+	}
+	return db, ids
+}
+
+func checkReclaimed(t *testing.T, db *KVDB) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		val, err := db.Get([]byte(fmt.Sprintf("k%02d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, fmt.Sprintf("v%d", i))
+	}
+	dir := db.config.DirPath
+	if utils.Exist(dir+reclaimManifestFile) || utils.Exist(dir+reclaimPath) {
+		t.Fatal("the reclaim is not finished")
+	}
+}
+
+func TestRecoverReclaim(t *testing.T) {
+	t.Run("Committed", func(t *testing.T) {
+		db, _ := prepareCommittedReclaim(t)
+		db = openTestConfig(t, db.config)
+		checkReclaimed(t, db)
+	})
+
+	// the db crashed after some of the old files were removed.
+	t.Run("Removing", func(t *testing.T) {
+		db, ids := prepareCommittedReclaim(t)
+		if err := os.Remove(db.config.DirPath + dbFileName(ids[0], String)); err != nil {
+			t.Fatal(err)
+		}
+		db = openTestConfig(t, db.config)
+		checkReclaimed(t, db)
+	})
+
+	// the db crashed after some of the new files were moved.
+	t.Run("Moving", func(t *testing.T) {
+		db, ids := prepareCommittedReclaim(t)
+		dir := db.config.DirPath
+		for _, id := range ids {
+			if err := os.Remove(dir + dbFileName(id, String)); err != nil {
+				t.Fatal(err)
+			}
+			os.Remove(dir + storage.HintName(id, String))
+		}
+		if err := os.Rename(dir+reclaimPath+dbFileName(ids[0], String), dir+dbFileName(ids[0], String)); err != nil {
+			t.Fatal(err)
+		}
+		db = openTestConfig(t, db.config)
+		checkReclaimed(t, db)
+	})
+
+	// the db crashed before the manifest was committed, the new files are discarded.
+	t.Run("Uncommitted", func(t *testing.T) {
+		db, ids := prepareCommittedReclaim(t)
+		dir := db.config.DirPath
+		if err := os.Rename(dir+reclaimManifestFile, dir+reclaimManifestFile+".tmp"); err != nil {
+			t.Fatal(err)
+		}
+		// the new file is garbage, it must not be moved.
+		if err := ioutil.WriteFile(dir+reclaimPath+dbFileName(ids[0], String), []byte("garbage"), storage.FilePerm); err != nil {
+			t.Fatal(err)
+		}
+		db = openTestConfig(t, db.config)
+		checkReclaimed(t, db)
+		if utils.Exist(dir + reclaimManifestFile + ".tmp") {
+			t.Fatal("the uncommitted manifest is not removed")
+		}
+	})
+
+	// the new file has the id of the active file, which must not be overwritten.
+	t.Run("ActiveFile", func(t *testing.T) {
+		db, ids := prepareCommittedReclaim(t)
+		dir := db.config.DirPath
+		active := ids[0]
+		for _, id := range ids {
+			if id > active {
+				active = id
+			}
+		}
+		active++
+		copyFile(t, dir+reclaimPath+dbFileName(ids[0], String), dir+reclaimPath+dbFileName(active, String))
+		m := &reclaimManifest{Files: map[DataType]reclaimFiles{String: {Old: ids, New: append(ids, active)}}}
+		if err := writeReclaimManifest(dir, m); err != nil {
+			t.Fatal(err)
+		}
+		want, err := ioutil.ReadFile(dir + dbFileName(active, String))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(db.config); err == nil {
+			t.Fatal("open db with a reclaim replacing the active file succeeded")
+		}
+		got, err := ioutil.ReadFile(dir + dbFileName(active, String))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(want) {
+			t.Fatal("the active file is overwritten")
+		}
+	})
+
+	t.Run("InvalidManifest", func(t *testing.T) {
+		db, _ := prepareCommittedReclaim(t)
+		if err := ioutil.WriteFile(db.config.DirPath+reclaimManifestFile, []byte("{"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(db.config); err == nil {
+			t.Fatal("open db with an invalid manifest succeeded")
+		}
+	})
+}
+
+// Reclaim swaps the db files through the manifest, no file of it is left.
+func TestReclaimSwap(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	for i := 0; i < 100; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("k%02d", i%50)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("v%d", i+50))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Set([]byte("k00"), []byte("v0")); err != nil {
+		t.Fatal(err)
+	}
+
+	before := len(db.archFiles[String])
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	if after := len(db.archFiles[String]); after >= before {
+		t.Fatalf("got %d archived files after reclaim, %d before", after, before)
+	}
+
+	check := func(db *KVDB) {
+		t.Helper()
+		val, err := db.Get([]byte("k00"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, "v0")
+		for i := 1; i < 50; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("k%02d", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, val, fmt.Sprintf("v%d", i+50))
+		}
+		dir := db.config.DirPath
+		if utils.Exist(dir+reclaimManifestFile) || utils.Exist(dir+reclaimPath) {
+			t.Fatal("the files of reclaim are left")
+		}
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
@@ -0,0 +1,139 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+	"MetaDB/kv/utils"
+
+	"os"
+	"path/filepath"
+)
+
+type (
+	// RecoveryReport describes the db files repaired when db is opened.
+	// The db opened by OpenAt is never repaired, the damaged db files are only skipped in memory and reported.
+	RecoveryReport struct {
+		// Truncated the active files whose torn tails were dropped.
+		Truncated []FileRecovery
+
+		// Quarantined the archived files whose corrupt tails were moved to the quarantine directory,
+		// the valid entries before the corrupt one are still loaded.
+		Quarantined []FileRecovery
+	}
+
+	// FileRecovery a db file repaired in recovery.
+	FileRecovery struct {
+		DataType DataType
+		FileId   uint32
+		Offset   int64  // the end of the valid entries in the db file.
+		Path     string // the path of db file, it is the file of the corrupt tail in the quarantine directory for a quarantined file.
+		Err      error  // the error found in the db file.
+		Dropped  int    // the number of entries dropped, the entries after a torn header can't be counted.
+	}
+)
+
+// Recovered reports whether any db file was repaired.
+func (r *RecoveryReport) Recovered() bool {
+	return len(r.Truncated) > 0 || len(r.Quarantined) > 0
+}
+
+// RecoveryReport returns what was repaired when db was opened, the db files are never removed in recovery.
+func (db *KVDB) RecoveryReport() *RecoveryReport {
+	return db.recovery
+}
+
+// truncateTail drops the torn tail of active file after the last valid entry.
+// It is only ignored by a read-only db, the db file is not changed.
+func (db *KVDB) truncateTail(dType DataType, df *storage.DBFile, offset int64, cause error) error {
+	dropped := countDropped(df, offset)
+	if db.asOf != 0 {
+		df.Offset = offset
+	} else if err := df.Truncate(offset); err != nil {
+		return err
+	}
+
+	db.recovery.Truncated = append(db.recovery.Truncated, FileRecovery{
+		DataType: dType,
+		FileId:   df.Id,
+		Offset:   offset,
+		Path:     df.Name(dType),
+		Err:      cause,
+		Dropped:  dropped,
+	})
+	return nil
+}
+
+// quarantine moves the tail of archived file from the corrupt entry to the quarantine directory, and truncates it,
+// so the valid entries before the corrupt one are still loaded like the active file.
+// A read-only db only skips the tail, the db file is not changed.
+func (db *KVDB) quarantine(dType DataType, df *storage.DBFile, offset int64, cause error) error {
+	dropped := countDropped(df, offset)
+	path := df.Name(dType)
+	if db.asOf != 0 {
+		df.Offset = offset
+	} else {
+		dir := db.config.DirPath + quarantinePath
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+		tail, err := df.ReadTail(offset)
+		if err != nil {
+			return err
+		}
+		// the tail must be persisted before it is dropped from the db file.
+		path = dir + storage.PathSeparator + filepath.Base(df.Name(dType))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err = file.Write(tail); err != nil {
+			file.Close()
+			return err
+		}
+		if err = file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+		if err = file.Close(); err != nil {
+			return err
+		}
+		if err = utils.SyncDir(dir); err != nil {
+			return err
+		}
+		if err = df.Truncate(offset); err != nil {
+			return err
+		}
+	}
+
+	db.recovery.Quarantined = append(db.recovery.Quarantined, FileRecovery{
+		DataType: dType,
+		FileId:   df.Id,
+		Offset:   offset,
+		Path:     path,
+		Err:      cause,
+		Dropped:  dropped,
+	})
+	return nil
+}
+
+// countDropped counts the entries from the corrupt one to the end of db file, the corrupt entries are skipped by their headers.
+// The corrupt entry is counted even if its header is torn.
+func countDropped(df *storage.DBFile, offset int64) int {
+	var count int
+	for {
+		next, err := df.Skip(offset)
+		if err != nil {
+			break
+		}
+		count++
+		offset = next
+	}
+	if count == 0 {
+		count = 1
+	}
+	return count
+}
+
+// isCorrupt reports whether the error is caused by a corrupt entry rather than the io.
+func isCorrupt(err error) bool {
+	return err == storage.ErrInvalidCrc || err == storage.ErrIncompleteEntry
+}
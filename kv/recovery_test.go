@@ -0,0 +1,254 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecoverTornTail(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	if err := db.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	activeFile, err := db.getActiveFile(String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := activeFile.Offset
+	if err := db.Set([]byte("c"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	name, torn := activeFile.Name(String), activeFile.Offset-3
+
+	// the last entry is partly written when the db crashed.
+	closeTestDB(t, db)
+	if err := os.Truncate(name, torn); err != nil {
+		t.Fatal(err)
+	}
+	db = openTestConfig(t, db.config)
+
+	report := db.RecoveryReport()
+	if len(report.Truncated) != 1 || report.Truncated[0].Offset != end || len(report.Quarantined) != 0 {
+		t.Fatalf("got the recovery report %+v", report)
+	}
+	val, err := db.Get([]byte("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "2")
+	if _, err := db.Get([]byte("c")); err != ErrKeyNotExist {
+		t.Fatalf("Get the torn entry: got %v, want ErrKeyNotExist", err)
+	}
+
+	// the new entries are written after the last valid one.
+	if err := db.Set([]byte("c"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	if db.RecoveryReport().Recovered() {
+		t.Fatal("the db files are repaired again")
+	}
+	val, err = db.Get([]byte("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "new")
+}
+
+func TestRecoverCorruptEntry(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	if err := db.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Set([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	activeFile, err := db.getActiveFile(String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, last := activeFile.Name(String), activeFile.Offset-1
+
+	closeTestDB(t, db)
+	flipByte(t, name, last)
+	db = openTestConfig(t, db.config)
+
+	if report := db.RecoveryReport(); len(report.Truncated) != 1 || report.Truncated[0].Err != storage.ErrInvalidCrc {
+		t.Fatalf("got the recovery report %+v", report)
+	}
+	val, err := db.Get([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "1")
+	if _, err := db.Get([]byte("b")); err != ErrKeyNotExist {
+		t.Fatalf("Get the corrupt entry: got %v, want ErrKeyNotExist", err)
+	}
+}
+
+// The tail of a corrupt archived file is moved to the quarantine dir, the entries before it are still loaded.
+func TestRecoverQuarantine(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	for i := 0; i < 120; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(db.archFiles[String]) < 2 {
+		t.Fatalf("got %d archived files, want at least 2", len(db.archFiles[String]))
+	}
+	var corrupt *storage.DBFile
+	for _, df := range db.archFiles[String] {
+		if corrupt == nil || df.Id > corrupt.Id {
+			corrupt = df
+		}
+	}
+	name, id, size := corrupt.Name(String), corrupt.Id, corrupt.Offset
+
+	var (
+		keys    []string
+		offsets []int64
+	)
+	for offset := corrupt.DataOffset(); offset < size; {
+		e, err := corrupt.Read(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, string(e.Meta.Key))
+		offsets = append(offsets, offset)
+		offset += int64(e.Size())
+	}
+	if len(keys) < 3 {
+		t.Fatalf("got %d entries in the corrupt file, want at least 3", len(keys))
+	}
+
+	// the db file is scanned without its hint file.
+	closeTestDB(t, db)
+	if err := os.Remove(hintPath(db, String, id)); err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, name, offsets[2]+20)
+	db = openTestConfig(t, db.config)
+
+	report := db.RecoveryReport()
+	if len(report.Quarantined) != 1 || report.Quarantined[0].FileId != id || report.Quarantined[0].Offset != offsets[2] {
+		t.Fatalf("got the recovery report %+v", report)
+	}
+	if dropped := report.Quarantined[0].Dropped; dropped != len(keys)-2 {
+		t.Fatalf("got %d dropped entries, want %d", dropped, len(keys)-2)
+	}
+	if info, err := os.Stat(name); err != nil || info.Size() != offsets[2] {
+		t.Fatalf("the corrupt file is not truncated: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(db.config.DirPath, quarantinePath, filepath.Base(name)))
+	if err != nil {
+		t.Fatalf("the corrupt tail is not in the quarantine dir: %v", err)
+	}
+	if info.Size() != size-offsets[2] {
+		t.Fatalf("got the quarantined tail of %d bytes, want %d", info.Size(), size-offsets[2])
+	}
+
+	// the keys before the corrupt entry and in the other files are kept.
+	for _, key := range []string{keys[0], keys[1], "k000", "k119"} {
+		val, err := db.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("Get %s: %v", key, err)
+		}
+		assertBytes(t, val, "v")
+	}
+	if _, err := db.Get([]byte(keys[2])); err != ErrKeyNotExist {
+		t.Fatalf("Get the corrupt entry: got %v, want ErrKeyNotExist", err)
+	}
+
+	db = reopenTestDB(t, db)
+	if db.RecoveryReport().Recovered() {
+		t.Fatal("the quarantined tail is loaded again")
+	}
+	val, err := db.Get([]byte(keys[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "v")
+}
+
+// The mapped db files of a crashed db are not trimmed, the entries in them and past the mapping are all loaded.
+func TestRecoverMMapCrash(t *testing.T) {
+	for name, update := range testConfigs() {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				update(cfg)
+				smallFiles(cfg)
+				cfg.RwMethod = storage.MMap
+			})
+
+			large := strings.Repeat("x", 3000)
+			for i := 0; i < 60; i++ {
+				if err := db.Set([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := db.Set([]byte("large"), []byte(large)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.HSet([]byte("hash"), []byte("f"), []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+
+			// copy the db files while the db is running, as they are left by a crash.
+			crashed := t.TempDir()
+			names, err := filepath.Glob(filepath.Join(db.config.DirPath, "*.data.*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range names {
+				copyFile(t, name, filepath.Join(crashed, filepath.Base(name)))
+			}
+			cfg := db.config
+			cfg.DirPath = crashed
+			check := func(db *KVDB) {
+				t.Helper()
+				if db.RecoveryReport().Recovered() {
+					t.Fatalf("got the recovery report %+v", db.RecoveryReport())
+				}
+				for i := 0; i < 60; i++ {
+					val, err := db.Get([]byte(fmt.Sprintf("k%02d", i)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					assertBytes(t, val, fmt.Sprintf("v%d", i))
+				}
+				val, err := db.Get([]byte("large"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				assertBytes(t, val, large)
+				assertBytes(t, db.HGet([]byte("hash"), []byte("f")), "v")
+			}
+			db = openTestConfig(t, cfg)
+			check(db)
+
+			// the new entries are written after the last one.
+			if err := db.Set([]byte("new"), []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+			db = reopenTestDB(t, db)
+			check(db)
+			val, err := db.Get([]byte("new"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertBytes(t, val, "v")
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package kv
+
+import (
+	"MetaDB/kv/utils"
+
+	"sort"
+)
+
+const (
+	// DefaultScanCount the number of keys visited by a Scan or HScan call if count is not positive.
+	DefaultScanCount = 10
+)
+
+type (
+	// scanKey a key visited by Scan.
+	scanKey struct {
+		hash  uint64
+		key   string
+		dType DataType
+		found bool // the key exists, is not expired and matches the pattern.
+	}
+)
+
+// Scan iterates the keys incrementally, a scan starts with cursor 0 and ends when the returned cursor is 0.
+// About count keys are visited by a call, and only the ones matching the glob-style pattern are returned,
+// so a call may return no keys before the scan ends. If dTypes are given, only the keys of them are visited.
+//
+// The keys of every data type are kept in the order of their hashes, and the cursor is the hash to continue from,
+// so the cursor stays valid while the db is modified. Like Redis, a key existing during the whole scan is returned
+// at least once, and a key added or removed during the scan may be returned or not.
+// Every data type is walked from the cursor under its own read lock, so a call only holds it for about count keys.
+// The same key of different data types is returned only once in a call.
+func (db *KVDB) Scan(cursor uint64, match string, count int, dTypes ...DataType) ([][]byte, uint64, error) {
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	if len(dTypes) == 0 {
+		for i := 0; i < DataStructureNum; i++ {
+			dTypes = append(dTypes, DataType(i))
+		}
+	}
+	for _, dType := range dTypes {
+		if dType >= DataStructureNum {
+			return nil, 0, ErrInvalidDataType
+		}
+	}
+
+	var keys []scanKey
+	for _, dType := range dTypes {
+		keys = append(keys, db.scanType(dType, cursor, count, match)...)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].hash != keys[j].hash {
+			return keys[i].hash < keys[j].hash
+		}
+		if keys[i].key != keys[j].key {
+			return keys[i].key < keys[j].key
+		}
+		return keys[i].dType < keys[j].dType
+	})
+
+	// the keys with the same hash are visited in the same call, since the cursor can't point to one of them.
+	// Every data type has visited more hashes than a call takes if it has more keys,
+	// so the first key left is the smallest one not visited yet.
+	i := 0
+	for ; i < len(keys); i++ {
+		if i >= count && keys[i].hash != keys[i-1].hash {
+			break
+		}
+	}
+	var next uint64
+	if i < len(keys) {
+		next = keys[i].hash
+	}
+
+	var res [][]byte
+	returned := make(map[string]bool)
+	for _, k := range keys[:i] {
+		if k.found && !returned[k.key] {
+			res = append(res, []byte(k.key))
+			returned[k.key] = true
+		}
+	}
+	return res, next, nil
+}
+
+// scanType walks the keys of the data type from the cursor under its read lock,
+// until the keys of count+1 different hashes are visited.
+func (db *KVDB) scanType(dType DataType, cursor uint64, count int, match string) []scanKey {
+	unlock := db.lockMgr.RLock(dType)
+	defer unlock()
+
+	var keys []scanKey
+	hashes := 0
+	db.ascendKeys(dType, cursor, func(hash uint64, key string) bool {
+		if len(keys) == 0 || hash != keys[len(keys)-1].hash {
+			if hashes == count+1 {
+				return false
+			}
+			hashes++
+		}
+		found := (match == "" || utils.GlobMatch(match, key)) && !db.keyExpired(dType, key) && db.keyExists(dType, key)
+		keys = append(keys, scanKey{hash: hash, key: key, dType: dType, found: found})
+		return true
+	})
+	return keys
+}
+
+// ascendKeys calls fn for the keys of the data type from the hash cursor in the order of their hashes,
+// until fn returns false. The lock of the data type must be held.
+func (db *KVDB) ascendKeys(dType DataType, cursor uint64, fn func(hash uint64, key string) bool) {
+	switch dType {
+	case String:
+		db.strIndex.keys.Ascend(cursor, fn)
+	case Hash:
+		db.hashIndex.indexes.ScanKeys(cursor, fn)
+	case List:
+		db.listIndex.indexes.ScanKeys(cursor, fn)
+	case Set:
+		db.setIndex.indexes.ScanKeys(cursor, fn)
+	case ZSet:
+		db.zsetIndex.indexes.ScanKeys(cursor, fn)
+	}
+}
@@ -0,0 +1,203 @@
+package kv
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"testing"
+)
+
+// scanAll runs a whole scan, and returns the keys found.
+func scanAll(t *testing.T, db *KVDB, match string, count int, dTypes ...DataType) []string {
+	t.Helper()
+
+	var res []string
+	var cursor uint64
+	for calls := 0; ; calls++ {
+		if calls > 100000 {
+			t.Fatal("the scan does not end")
+		}
+		keys, next, err := db.Scan(cursor, match, count, dTypes...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count > 0 && len(keys) > 2*count {
+			t.Fatalf("got %d keys by a call of count %d", len(keys), count)
+		}
+		for _, key := range keys {
+			res = append(res, string(key))
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	sort.Strings(res)
+	return res
+}
+
+func assertKeys(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	sort.Strings(want)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got the keys %q, want %q", got, want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	var all, hashes []string
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("str%d", i)
+		if err := db.Set([]byte(key), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, key)
+	}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("hash%d", i)
+		if _, err := db.HSet([]byte(key), []byte("f"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		all, hashes = append(all, key), append(hashes, key)
+	}
+	if _, err := db.RPush([]byte("list"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd([]byte("set"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd([]byte("zset"), 1, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	all = append(all, "list", "set", "zset")
+	// the same key of different data types is returned once.
+	if _, err := db.SAdd([]byte("str0"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, count := range []int{0, 1, 3, 100} {
+		assertKeys(t, scanAll(t, db, "", count), all...)
+	}
+	assertKeys(t, scanAll(t, db, "", 5, Hash), hashes...)
+	assertKeys(t, scanAll(t, db, "str1*", 4), "str1", "str10", "str11", "str12", "str13", "str14",
+		"str15", "str16", "str17", "str18", "str19")
+	assertKeys(t, scanAll(t, db, "", 2, List, Set, ZSet), "list", "set", "str0", "zset")
+	if _, _, err := db.Scan(0, "", 10, DataStructureNum); err != ErrInvalidDataType {
+		t.Fatalf("Scan an invalid data type: got %v, want ErrInvalidDataType", err)
+	}
+
+	// the removed, expired and empty keys are skipped.
+	if err := db.Remove([]byte("str1")); err != nil {
+		t.Fatal(err)
+	}
+	expireNow(db, Hash, "hash1")
+	if _, err := db.HDel([]byte("hash2"), []byte("f")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LPop([]byte("list")); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, key := range all {
+		if key != "str1" && key != "hash1" && key != "hash2" && key != "list" && key != "set" && key != "zset" {
+			want = append(want, key)
+		}
+	}
+	assertKeys(t, scanAll(t, db, "", 3, String, Hash, List), want...)
+}
+
+// The keys existing during the whole scan are returned while the others are added and removed, run it with -race.
+func TestScanConcurrentWrites(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	var stable []string
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("stable%d", i)
+		if _, err := db.HSet([]byte(key), []byte("f"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		stable = append(stable, key)
+	}
+
+	var stop int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; atomic.LoadInt32(&stop) == 0; i++ {
+			key := []byte(fmt.Sprintf("temp%d", i%50))
+			if _, err := db.HSet(key, []byte("f"), []byte("v")); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := db.Set(key, []byte("v")); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := db.HDel(key, []byte("f")); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := db.HClear(key); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := db.Remove(key); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for n := 0; n < 5; n++ {
+		found := make(map[string]bool)
+		for _, key := range scanAll(t, db, "stable*", 7) {
+			found[key] = true
+		}
+		for _, key := range stable {
+			if !found[key] {
+				t.Fatalf("the key %s is not returned", key)
+			}
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
+	<-done
+}
+
+// The order of keys is rebuilt when the db is reopened and reclaimed.
+func TestScanReopenReclaim(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	var want []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if err := db.Set([]byte(key), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SAdd([]byte("s"+key), []byte("a")); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			want = append(want, key, "s"+key)
+			continue
+		}
+		if err := db.Remove([]byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SRem([]byte("s"+key), []byte("a")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertKeys(t, scanAll(t, db, "", 6), want...)
+
+	db = reopenTestDB(t, db)
+	assertKeys(t, scanAll(t, db, "", 6), want...)
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	assertKeys(t, scanAll(t, db, "", 6), want...)
+	db = reopenTestDB(t, db)
+	assertKeys(t, scanAll(t, db, "", 6), want...)
+}
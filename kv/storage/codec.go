@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	// ErrUnknownCodec the compression codec is not supported.
+	ErrUnknownCodec = errors.New("storage/codec: unknown compression codec")
+)
+
+// The ids of compression codecs, the id is saved as the first byte of a compressed value.
+const (
+	FlateCodecId byte = iota + 1
+	GzipCodecId
+	ZlibCodecId
+)
+
+type (
+	// Codec compresses the values of entries.
+	Codec interface {
+		Id() byte
+		Compress(src []byte) ([]byte, error)
+		Decompress(src []byte) ([]byte, error)
+	}
+
+	flateCodec struct{}
+	gzipCodec  struct{}
+	zlibCodec  struct{}
+)
+
+var codecs = map[byte]Codec{
+	FlateCodecId: flateCodec{},
+	GzipCodecId:  gzipCodec{},
+	ZlibCodecId:  zlibCodec{},
+}
+
+var codecNames = map[string]byte{
+	"flate": FlateCodecId,
+	"gzip":  GzipCodecId,
+	"zlib":  ZlibCodecId,
+}
+
+// CodecByName returns the codec of name, it is nil if name is empty, which means no compression.
+func CodecByName(name string) (Codec, error) {
+	if name == "" {
+		return nil, nil
+	}
+	id, ok := codecNames[name]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return codecs[id], nil
+}
+
+func (flateCodec) Id() byte {
+	return FlateCodecId
+}
+
+func (flateCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	return compress(&buf, w, src)
+}
+
+func (flateCodec) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (gzipCodec) Id() byte {
+	return GzipCodecId
+}
+
+func (gzipCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	return compress(&buf, gzip.NewWriter(&buf), src)
+}
+
+func (gzipCodec) Decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (zlibCodec) Id() byte {
+	return ZlibCodecId
+}
+
+func (zlibCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	return compress(&buf, zlib.NewWriter(&buf), src)
+}
+
+func (zlibCodec) Decompress(src []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func compress(buf *bytes.Buffer, w io.WriteCloser, src []byte) ([]byte, error) {
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress decodes a compressed value, its first byte is the id of codec.
+func decompress(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, ErrUnknownCodec
+	}
+	codec, ok := codecs[value[0]]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return codec.Decompress(value[1:])
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrEncryptionKeyRequired the entry is encrypted, but no key is set.
+	ErrEncryptionKeyRequired = errors.New("storage/crypto: db file is encrypted, an encryption key is required")
+
+	// ErrEncryptionKeyNotFound the key which the entry is encrypted with is not provided.
+	ErrEncryptionKeyNotFound = errors.New("storage/crypto: the encryption key of db file is not found")
+
+	// ErrWrongEncryptionKey the entry can't be decrypted by the key, but its checksum is right.
+	ErrWrongEncryptionKey = errors.New("storage/crypto: wrong encryption key")
+
+	// ErrInvalidKeySize the key of AES must be 16, 24 or 32 bytes.
+	ErrInvalidKeySize = errors.New("storage/crypto: invalid key size, must be 16, 24 or 32 bytes")
+)
+
+const (
+	// the id of key 4 bytes, the nonce of AES-GCM 12 bytes, and the tag 16 bytes.
+	// 4 + 12 + 16 = 32
+	keyIdSize        = 4
+	nonceSize        = 12
+	encryptOverhead  = 32
+	hintEncryptMagic = 0x4D444248 // "MDBH", the hint file is encrypted.
+)
+
+// Encryptor encrypts the entries with AES-GCM.
+// The new entries are encrypted with the current key, and the id of key is saved with them,
+// so the entries encrypted with the old keys can still be read until they are rewritten by reclaim.
+type Encryptor struct {
+	current uint32
+	aeads   map[uint32]cipher.AEAD
+}
+
+// NewEncryptor returns an encryptor of the keys by id, current is the id of key to encrypt new entries.
+func NewEncryptor(keys map[uint32][]byte, current uint32) (*Encryptor, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, ErrEncryptionKeyNotFound
+	}
+
+	enc := &Encryptor{current: current, aeads: make(map[uint32]cipher.AEAD)}
+	for id, key := range keys {
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("key %d: %w", id, ErrInvalidKeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		enc.aeads[id] = aead
+	}
+	return enc, nil
+}
+
+// Current returns the id of the key to encrypt new entries.
+func (enc *Encryptor) Current() uint32 {
+	return enc.current
+}
+
+// seal encrypts the plaintext with the current key, the result is key id + nonce + ciphertext.
+// The additional data is authenticated but not encrypted.
+func (enc *Encryptor) seal(plaintext, additional []byte) ([]byte, error) {
+	buf := make([]byte, keyIdSize+nonceSize, keyIdSize+nonceSize+len(plaintext)+encryptOverhead)
+	binary.BigEndian.PutUint32(buf[:keyIdSize], enc.current)
+	nonce := buf[keyIdSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return enc.aeads[enc.current].Seal(buf, nonce, plaintext, additional), nil
+}
+
+// open decrypts the result of seal.
+func (enc *Encryptor) open(data, additional []byte) ([]byte, error) {
+	if enc == nil {
+		return nil, ErrEncryptionKeyRequired
+	}
+	if len(data) < encryptOverhead {
+		return nil, ErrWrongEncryptionKey
+	}
+
+	id := binary.BigEndian.Uint32(data[:keyIdSize])
+	aead, ok := enc.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("key %d: %w", id, ErrEncryptionKeyNotFound)
+	}
+	nonce := data[keyIdSize : keyIdSize+nonceSize]
+	plaintext, err := aead.Open(nil, nonce, data[keyIdSize+nonceSize:], additional)
+	if err != nil {
+		return nil, fmt.Errorf("key %d: %w", id, ErrWrongEncryptionKey)
+	}
+	return plaintext, nil
+}
@@ -3,7 +3,9 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"encoding/binary"
 	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"strconv"
@@ -18,17 +20,47 @@ const (
 	PathSeparator = string(os.PathSeparator)
 )
 
+// The formats of db file.
+const (
+	// V1 db file has no header, and the crc32 of an entry only covers its value.
+	V1 uint16 = iota + 1
+
+	// V2 db file starts with a header, and the crc32 of an entry covers the whole record.
+	V2
+
+	// CurrentVersion the format of new db files.
+	CurrentVersion = V2
+)
+
+const (
+	// fileMagic marks the header of db file, it is "MDBF".
+	fileMagic uint32 = 0x4D444246
+
+	// magic 4 bytes, version 2 bytes, and 2 bytes reserved.
+	fileHeaderSize = 8
+)
+
 var (
 	DBFileFormatNames = map[uint16]string{
 		0: "%09d.data.hash",
+		1: "%09d.data.str",
+		2: "%09d.data.list",
+		3: "%09d.data.set",
+		4: "%09d.data.zset",
 	}
 
-	DBFileSuffixName = []string{"hash"}
+	DBFileSuffixName = []string{"hash", "str", "list", "set", "zset"}
 )
 
 var (
 	// ErrEmptyEntry the entry is empty.
 	ErrEmptyEntry = errors.New("storage/db_file: entry or the Key of entry is empty")
+
+	// ErrIncompleteEntry the entry exceeds the end of db file, it is usually torn by a crash.
+	ErrIncompleteEntry = errors.New("storage/db_file: incomplete entry")
+
+	// ErrReadOnlyFile the db file opened by OpenDBFile can't be written.
+	ErrReadOnlyFile = errors.New("storage/db_file: db file is read-only")
 )
 
 type FileRWMethod uint8
@@ -39,12 +71,20 @@ const (
 )
 
 type DBFile struct {
-	Id     uint32
-	path   string
-	File   *os.File
-	mmap   mmap.MMap
-	Offset int64
-	method FileRWMethod
+	Id      uint32
+	path    string
+	File    *os.File
+	mmap    mmap.MMap
+	Offset  int64
+	method  FileRWMethod
+	version uint16
+
+	codec             Codec // compress the values written to db file, nil means no compression.
+	compressThreshold int
+	enc               *Encryptor // encrypt the entries written to db file and decrypt the encrypted ones.
+	dataSync          bool       // flush the data without the unneeded metadata when syncing.
+	fileSize          int64      // the size of file on disk, it is larger than Offset if the tail is mapped or preallocated.
+	readOnly          bool       // the db file is opened by OpenDBFile, nothing is written to it.
 }
 
 func NewDBFile(path string, fileId uint32, method FileRWMethod, blockSize int64, eType uint16) (*DBFile, error) {
@@ -59,26 +99,217 @@ func NewDBFile(path string, fileId uint32, method FileRWMethod, blockSize int64,
 		return nil, err
 	}
 
-	df := &DBFile{Id: fileId, path: path, Offset: stat.Size(), method: method}
-	
-	if method == FileIO {
-		df.File = file
-	} else {
-		if err = file.Truncate(blockSize); err != nil {
-			return nil, err
+	df := &DBFile{Id: fileId, path: path, File: file, Offset: stat.Size(), method: method, fileSize: stat.Size()}
+
+	if method == MMap {
+		// the file is extended to the block size to be mapped, and it is trimmed to its data when closed.
+		mapSize := blockSize
+		if stat.Size() > mapSize {
+			mapSize = stat.Size()
+		}
+		if stat.Size() < mapSize {
+			if err = file.Truncate(mapSize); err != nil {
+				file.Close()
+				return nil, err
+			}
 		}
 		m, err := mmap.Map(file, os.O_RDWR, 0)
 		if err != nil {
+			file.Close()
 			return nil, err
 		}
 		df.mmap = m
+		df.fileSize = mapSize
+	}
+
+	if err = df.initHeader(); err != nil {
+		df.Close(false)
+		return nil, err
+	}
+	if df.Offset > df.fileSize {
+		df.fileSize = df.Offset
+	}
+	// the mapped or preallocated file is not trimmed if db is not closed normally, find the end of data in it.
+	if stat.Size() >= blockSize {
+		df.Offset = df.dataEnd(stat.Size())
 	}
 	return df, nil
 }
 
+// OpenDBFile opens the db file read-only, it is never created, extended, trimmed or synced.
+// A missing or empty db file is opened as an empty one in the current format.
+func OpenDBFile(path string, fileId uint32, blockSize int64, eType uint16) (*DBFile, error) {
+	df := &DBFile{Id: fileId, path: path, method: FileIO, readOnly: true}
+
+	file, err := os.Open(df.Name(eType))
+	if os.IsNotExist(err) {
+		df.Offset, df.version = fileHeaderSize, CurrentVersion
+		return df, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	df.File, df.fileSize = file, stat.Size()
+	if stat.Size() == 0 {
+		df.Offset, df.version = fileHeaderSize, CurrentVersion
+		return df, nil
+	}
+
+	df.Offset = stat.Size()
+	if err = df.initHeader(); err != nil {
+		df.Close(false)
+		return nil, err
+	}
+	// the tail of a db file not closed normally is zero filled.
+	if stat.Size() >= blockSize {
+		df.Offset = df.dataEnd(stat.Size())
+	}
+	return df, nil
+}
+
+// dataEnd returns the end of the entries in a mapped or preallocated file whose tail is zero filled.
+// An entry always has a key, so the zero header marks the end.
+func (df *DBFile) dataEnd(fileSize int64) int64 {
+	offset := df.DataOffset()
+	for offset+entryHeaderSize <= fileSize {
+		buf, err := df.ReadBuf(offset, entryHeaderSize)
+		if err != nil {
+			break
+		}
+		e, _ := Decode(buf)
+		if e.Meta.KeySize == 0 || offset+int64(e.Size()) > fileSize {
+			break
+		}
+		offset += int64(e.Size())
+	}
+	return offset
+}
+
+// SetCompression sets the codec to compress the values not less than threshold when writing.
+// The compressed values are always readable, no matter which codec is set.
+func (df *DBFile) SetCompression(codec Codec, threshold int) {
+	df.codec = codec
+	df.compressThreshold = threshold
+}
+
+// SetDataSync sets whether to flush the data by fdatasync, the metadata such as modification time is not flushed.
+// It is the same as fsync on the systems without fdatasync.
+func (df *DBFile) SetDataSync(enabled bool) {
+	df.dataSync = enabled
+}
+
+// Preallocate allocates the disk space of db file up to size, so the file size is not changed by the writes after it,
+// and syncing them doesn't flush the metadata of file. The unused tail is trimmed when the file is closed.
+// The mmap file is always allocated to the block size, it is not changed.
+func (df *DBFile) Preallocate(size int64) error {
+	if df.method == MMap || df.fileSize >= size {
+		return nil
+	}
+	if err := fallocate(df.File, size); err != nil {
+		return err
+	}
+	df.fileSize = size
+	return nil
+}
+
+// Trim drops the unused tail of the preallocated file, the mapped file can only be trimmed after it is closed.
+func (df *DBFile) Trim() error {
+	if df.readOnly || df.mmap != nil || df.fileSize <= df.Offset {
+		return nil
+	}
+	if err := df.File.Truncate(df.Offset); err != nil {
+		return err
+	}
+	df.fileSize = df.Offset
+	return nil
+}
+
+// SetEncryptor sets the encryptor of db file, nil means the new entries are not encrypted.
+func (df *DBFile) SetEncryptor(enc *Encryptor) {
+	df.enc = enc
+}
+
+// KeyId returns the id of key which the first entry of db file is encrypted with, ok is false if it is not encrypted.
+// The entries are appended in order, so the first one is encrypted with the oldest key in db file.
+func (df *DBFile) KeyId() (id uint32, ok bool) {
+	offset := df.DataOffset()
+	if df.version == V1 || offset+entryHeaderSize+keyIdSize > df.size() {
+		return 0, false
+	}
+	buf, err := df.ReadBuf(offset, entryHeaderSize)
+	if err != nil {
+		return 0, false
+	}
+	e, _ := Decode(buf)
+	if e.State&FlagEncrypted == 0 {
+		return 0, false
+	}
+	if buf, err = df.ReadBuf(offset+entryHeaderSize, keyIdSize); err != nil {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(buf), true
+}
+
+// Version returns the format of db file.
+func (df *DBFile) Version() uint16 {
+	return df.version
+}
+
+// DataOffset returns the offset of the first entry in db file.
+func (df *DBFile) DataOffset() int64 {
+	if df.version == V1 {
+		return 0
+	}
+	return fileHeaderSize
+}
+
+// initHeader writes the header for a new db file, or reads the format of an existing one.
+// The db file without a header is in V1.
+func (df *DBFile) initHeader() error {
+	if df.Offset == 0 {
+		buf := make([]byte, fileHeaderSize)
+		binary.BigEndian.PutUint32(buf[0:4], fileMagic)
+		binary.BigEndian.PutUint16(buf[4:6], CurrentVersion)
+		if df.method == MMap {
+			copy(df.mmap, buf)
+		} else if _, err := df.File.WriteAt(buf, 0); err != nil {
+			return err
+		}
+		df.Offset = fileHeaderSize
+		df.version = CurrentVersion
+		return nil
+	}
+
+	df.version = V1
+	if df.size() < fileHeaderSize {
+		return nil
+	}
+	buf, err := df.ReadBuf(0, fileHeaderSize)
+	if err != nil {
+		return err
+	}
+	version := binary.BigEndian.Uint16(buf[4:6])
+	if binary.BigEndian.Uint32(buf[0:4]) == fileMagic && version > V1 && version <= CurrentVersion {
+		df.version = version
+	}
+	return nil
+}
+
 func (df *DBFile) Read(offset int64) (e *Entry, err error) {
 	var buf []byte
 
+	size := df.size()
+	if offset >= size {
+		return nil, io.EOF
+	}
+	if offset+entryHeaderSize > size {
+		return nil, ErrIncompleteEntry
+	}
 	if buf, err = df.ReadBuf(offset, int64(entryHeaderSize)); err != nil {
 		return
 	}
@@ -86,6 +317,18 @@ func (df *DBFile) Read(offset int64) (e *Entry, err error) {
 	if e, err = Decode(buf); err != nil {
 		return
 	}
+	header := buf
+	// check the size before reading, a torn header may have a huge size.
+	if offset+int64(e.Size()) > size {
+		return nil, ErrIncompleteEntry
+	}
+
+	if e.State&FlagEncrypted != 0 {
+		if err = df.readEncrypted(e, header, offset); err != nil {
+			return nil, err
+		}
+		return e, e.decompress()
+	}
 
 	offset += entryHeaderSize
 	if e.Meta.KeySize > 0 {
@@ -114,28 +357,90 @@ func (df *DBFile) Read(offset int64) (e *Entry, err error) {
 		e.Meta.Extra = val
 	}
 
-	checkCrc := crc32.ChecksumIEEE(e.Meta.Value)
-	if checkCrc != e.Crc32 {
+	if e.State&FlagCompressed != 0 {
+		e.stored = e.Meta.Value
+	}
+	if e.checksum(header, df.version) != e.Crc32 {
 		return nil, ErrInvalidCrc
 	}
+	return e, e.decompress()
+}
 
-	return
+// Skip returns the offset of the next entry, only the header of entry at offset is read,
+// so a corrupt entry can be skipped if its header is intact.
+func (df *DBFile) Skip(offset int64) (int64, error) {
+	size := df.size()
+	if offset >= size {
+		return 0, io.EOF
+	}
+	if offset+entryHeaderSize > size {
+		return 0, ErrIncompleteEntry
+	}
+	buf, err := df.ReadBuf(offset, int64(entryHeaderSize))
+	if err != nil {
+		return 0, err
+	}
+	e, err := Decode(buf)
+	if err != nil {
+		return 0, err
+	}
+	next := offset + int64(e.Size())
+	if e.Meta.KeySize == 0 || next > size {
+		return 0, ErrIncompleteEntry
+	}
+	return next, nil
+}
+
+// ReadTail returns the bytes from offset to the end of db file.
+func (df *DBFile) ReadTail(offset int64) ([]byte, error) {
+	if offset >= df.size() {
+		return nil, nil
+	}
+	return df.ReadBuf(offset, df.size()-offset)
+}
+
+// readEncrypted reads the encrypted key, value and extra of entry.
+// The checksum is verified before decrypting, so a failure of decrypting means the key is wrong.
+func (df *DBFile) readEncrypted(e *Entry, header []byte, offset int64) error {
+	body, err := df.ReadBuf(offset+entryHeaderSize, int64(e.Size()-entryHeaderSize))
+	if err != nil {
+		return err
+	}
+	crc := crc32.ChecksumIEEE(header[4:entryHeaderSize])
+	if crc32.Update(crc, crc32.IEEETable, body) != e.Crc32 {
+		return ErrInvalidCrc
+	}
+
+	plaintext, err := df.enc.open(body, header[4:entryHeaderSize])
+	if err != nil {
+		return err
+	}
+	ks, vs := e.Meta.KeySize, e.Meta.ValueSize
+	e.Meta.Key = plaintext[:ks]
+	if vs > 0 {
+		e.Meta.Value = plaintext[ks : ks+vs]
+	}
+	if e.Meta.ExtraSize > 0 {
+		e.Meta.Extra = plaintext[ks+vs:]
+	}
+	if e.State&FlagCompressed != 0 {
+		e.stored = e.Meta.Value
+	}
+	return nil
 }
 
 func (df *DBFile) ReadBuf(offset int64, n int64) ([]byte, error) {
 	buf := make([]byte, n)
 
-	if df.method == FileIO {
-		_, err := df.File.ReadAt(buf, offset)
-		if err != nil {
-			return nil, err
-		}
+	// the file may be larger than the mapping if a big entry is written past it.
+	if df.method == MMap && offset+n <= int64(len(df.mmap)) {
+		copy(buf, df.mmap[offset:offset+n])
+		return buf, nil
 	}
 
-	if df.method == MMap && offset <= int64(len(df.mmap)) {
-		copy(buf, df.mmap[offset:])
+	if _, err := df.File.ReadAt(buf, offset); err != nil {
+		return nil, err
 	}
-
 	return buf, nil
 }
 
@@ -143,47 +448,118 @@ func (df *DBFile) Write(e *Entry) error {
 	if e == nil || e.Meta.KeySize == 0 {
 		return ErrEmptyEntry
 	}
+	if df.readOnly {
+		return ErrReadOnlyFile
+	}
 
 	method := df.method
 	writeOff := df.Offset
-	encVal, err := e.Encode()
+	if err := e.compress(df.codec, df.compressThreshold); err != nil {
+		return err
+	}
+	encVal, err := e.encode(df.version, df.enc)
 	if err != nil {
 		return err
 	}
 
-	if method == FileIO {
+	// the entry which exceeds the mapping is written to the file directly.
+	if method == MMap && writeOff+int64(len(encVal)) <= int64(len(df.mmap)) {
+		copy(df.mmap[writeOff:], encVal)
+	} else {
 		if _, err := df.File.WriteAt(encVal, writeOff); err != nil {
 			return err
 		}
 	}
-	if method == MMap {
-		copy(df.mmap[writeOff:], encVal)
-	}
 	df.Offset += int64(e.Size())
+	if df.Offset > df.fileSize {
+		df.fileSize = df.Offset
+	}
+	return nil
+}
+
+// Truncate discards the data after offset, it is used to drop the torn tail of db file.
+func (df *DBFile) Truncate(offset int64) (err error) {
+	if df.readOnly {
+		return ErrReadOnlyFile
+	}
+	size := offset
+	// the mapped part of file can't be truncated, so fill its tail with zero.
+	if df.method == MMap && offset < int64(len(df.mmap)) {
+		tail := df.mmap[offset:]
+		for i := range tail {
+			tail[i] = 0
+		}
+		size = int64(len(df.mmap))
+	}
+	if err = df.File.Truncate(size); err != nil {
+		return
+	}
+	df.fileSize = size
+
+	df.Offset = offset
+	return df.Sync()
+}
+
+// Name returns the path of db file.
+func (df *DBFile) Name(eType uint16) string {
+	return df.path + PathSeparator + fmt.Sprintf(DBFileFormatNames[eType], df.Id)
+}
+
+// Rename gives db file a new id, and renames it on disk, the caller must sync the directory.
+func (df *DBFile) Rename(fileId uint32, eType uint16) error {
+	if df.readOnly {
+		return ErrReadOnlyFile
+	}
+	if err := os.Rename(df.Name(eType), df.path+PathSeparator+fmt.Sprintf(DBFileFormatNames[eType], fileId)); err != nil {
+		return err
+	}
+	df.Id = fileId
 	return nil
 }
 
+// size returns the end of data in db file, the tail of mmap file after it is unused.
+func (df *DBFile) size() int64 {
+	return df.Offset
+}
+
 func (df *DBFile) Close(sync bool) (err error) {
 	if sync {
 		err = df.Sync()
 	}
 
-	if df.File != nil {
-		err = df.File.Close()
-	}
 	if df.mmap != nil {
-		err = df.mmap.Unmap()
+		if e := df.mmap.Unmap(); e != nil && err == nil {
+			err = e
+		}
+		df.mmap = nil
+	}
+	// trim the unused tail, so the end of data is known when it is opened again.
+	if e := df.Trim(); e != nil && err == nil {
+		err = e
+	}
+	if df.File != nil {
+		if e := df.File.Close(); e != nil && err == nil {
+			err = e
+		}
 	}
 	return
 }
 
 func (df *DBFile) Sync() (err error) {
-	if df.File != nil {
-		err = df.File.Sync()
+	if df.readOnly {
+		return nil
 	}
-
+	// the mapping is flushed before the file, since the big entries are written to the file directly.
 	if df.mmap != nil {
-		err = df.mmap.Flush()
+		if err = df.mmap.Flush(); err != nil {
+			return
+		}
+	}
+
+	if df.File != nil && df.dataSync {
+		err = fdatasync(df.File)
+	} else if df.File != nil {
+		err = df.File.Sync()
 	}
 	return
 }
@@ -191,6 +567,19 @@ func (df *DBFile) Sync() (err error) {
 // Build load all db files from disk.
 // 返回的第一个参数是每种数据类型对应的文件id对应的文件，返回的第二个参数是每种数据类型对应的active file id
 func Build(path string, method FileRWMethod, blockSize int64) (map[uint16]map[uint32]*DBFile, map[uint16]uint32, error) {
+	return build(path, func(fileId uint32, eType uint16) (*DBFile, error) {
+		return NewDBFile(path, fileId, method, blockSize, eType)
+	})
+}
+
+// BuildReadOnly loads all db files from disk like Build, but they are opened read-only by OpenDBFile.
+func BuildReadOnly(path string, blockSize int64) (map[uint16]map[uint32]*DBFile, map[uint16]uint32, error) {
+	return build(path, func(fileId uint32, eType uint16) (*DBFile, error) {
+		return OpenDBFile(path, fileId, blockSize, eType)
+	})
+}
+
+func build(path string, open func(fileId uint32, eType uint16) (*DBFile, error)) (map[uint16]map[uint32]*DBFile, map[uint16]uint32, error) {
 	// 读取目录中的文件
 	dir, err := ioutil.ReadDir(path)
 	if err != nil {
@@ -204,9 +593,10 @@ func Build(path string, method FileRWMethod, blockSize int64) (map[uint16]map[ui
 			splitNames := strings.Split(d.Name(), ".")
 			id, _ := strconv.Atoi(splitNames[0])
 
-			switch splitNames[2] {
-			case DBFileSuffixName[0]:
-				fileIdsMap[0] = append(fileIdsMap[0], id)
+			for dType, suffix := range DBFileSuffixName {
+				if splitNames[2] == suffix {
+					fileIdsMap[uint16(dType)] = append(fileIdsMap[uint16(dType)], id)
+				}
 			}
 		}
 	}
@@ -214,27 +604,29 @@ func Build(path string, method FileRWMethod, blockSize int64) (map[uint16]map[ui
 	// load all the db files.
 	activeFileIds := make(map[uint16]uint32)
 	archFiles := make(map[uint16]map[uint32]*DBFile)
-	var dataType uint16 = 0
-	fileIDs := fileIdsMap[dataType]
-	sort.Ints(fileIDs)
-	files := make(map[uint32]*DBFile)
-	var activeFileId uint32 = 0
-
-	if len(fileIDs) > 0 {
-		// active fileid 是最大的
-		activeFileId = uint32(fileIDs[len(fileIDs)-1])
-
-		for i := 0; i < len(fileIDs)-1; i++ {
-			id := fileIDs[i]
-
-			file, err := NewDBFile(path, uint32(id), method, blockSize, dataType)
-			if err != nil {
-				return nil, nil, err
+	for dType := range DBFileSuffixName {
+		dataType := uint16(dType)
+		fileIDs := fileIdsMap[dataType]
+		sort.Ints(fileIDs)
+		files := make(map[uint32]*DBFile)
+		var activeFileId uint32 = 0
+
+		if len(fileIDs) > 0 {
+			// active fileid 是最大的
+			activeFileId = uint32(fileIDs[len(fileIDs)-1])
+
+			for i := 0; i < len(fileIDs)-1; i++ {
+				id := fileIDs[i]
+
+				file, err := open(uint32(id), dataType)
+				if err != nil {
+					return nil, nil, err
+				}
+				files[uint32(id)] = file
 			}
-			files[uint32(id)] = file
 		}
+		archFiles[dataType] = files
+		activeFileIds[dataType] = activeFileId
 	}
-	archFiles[dataType] = files
-	activeFileIds[dataType] = activeFileId
 	return archFiles, activeFileIds, nil
 }
\ No newline at end of file
@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func openTestFile(t *testing.T, dir string, method FileRWMethod, blockSize int64) *DBFile {
+	t.Helper()
+
+	df, err := NewDBFile(dir, 0, method, blockSize, String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		df.Close(false)
+	})
+	return df
+}
+
+func writeEntries(t *testing.T, df *DBFile, entries ...*Entry) []int64 {
+	t.Helper()
+
+	var offsets []int64
+	for _, e := range entries {
+		offsets = append(offsets, df.Offset)
+		if err := df.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return offsets
+}
+
+func assertEntry(t *testing.T, df *DBFile, offset int64, want *Entry) {
+	t.Helper()
+
+	e, err := df.Read(offset)
+	if err != nil {
+		t.Fatalf("read entry at %d: %v", offset, err)
+	}
+	if !bytes.Equal(e.Meta.Key, want.Meta.Key) || !bytes.Equal(e.Meta.Value, want.Meta.Value) ||
+		!bytes.Equal(e.Meta.Extra, want.Meta.Extra) || e.State != want.State || e.Timestamp != want.Timestamp {
+		t.Fatalf("got entry %+v %+v, want %+v %+v", e, e.Meta, want, want.Meta)
+	}
+}
+
+// The crc32 of V2 covers the whole record, so a corrupt header, key or extra is found too.
+func TestEntryChecksum(t *testing.T) {
+	e := NewEntry([]byte("key"), []byte("value"), []byte("extra"), String, 1)
+	buf, err := e.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := map[string]int{
+		"state": 17,
+		"key":   entryHeaderSize,
+		"value": entryHeaderSize + 3,
+		"extra": len(buf) - 1,
+	}
+	for name, pos := range parts {
+		t.Run(name, func(t *testing.T) {
+			df := openTestFile(t, t.TempDir(), FileIO, 1024)
+			offsets := writeEntries(t, df, e)
+
+			corrupt := make([]byte, len(buf))
+			copy(corrupt, buf)
+			corrupt[pos] ^= 0xFF
+			if _, err := df.File.WriteAt(corrupt, offsets[0]); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := df.Read(offsets[0]); err != ErrInvalidCrc {
+				t.Fatalf("got %v, want ErrInvalidCrc", err)
+			}
+		})
+	}
+}
+
+// The db file without a header is read in V1, and new entries are written in the same format.
+func TestDBFileV1(t *testing.T) {
+	dir := t.TempDir()
+	entries := []*Entry{
+		NewEntryNoExtra([]byte("a"), []byte("1"), String, 0),
+		NewEntry([]byte("b"), []byte("2"), []byte("x"), String, 0),
+	}
+
+	df := openTestFile(t, dir, FileIO, 1024)
+	if df.Version() != CurrentVersion || df.DataOffset() != fileHeaderSize {
+		t.Fatalf("a new db file: got version %d, data offset %d", df.Version(), df.DataOffset())
+	}
+	df.Close(false)
+
+	// write the entries of V1 without the header.
+	var v1 []byte
+	for _, e := range entries {
+		buf, err := e.encode(V1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v1 = append(v1, buf...)
+	}
+	df = openTestFile(t, dir, FileIO, 1024)
+	if _, err := df.File.WriteAt(v1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := df.File.Truncate(int64(len(v1))); err != nil {
+		t.Fatal(err)
+	}
+	df.Close(false)
+
+	df = openTestFile(t, dir, FileIO, 1024)
+	if df.Version() != V1 || df.DataOffset() != 0 {
+		t.Fatalf("got version %d, data offset %d, want V1", df.Version(), df.DataOffset())
+	}
+	assertEntry(t, df, 0, entries[0])
+	assertEntry(t, df, int64(entries[0].Size()), entries[1])
+
+	e := NewEntryNoExtra([]byte("c"), []byte("3"), String, 0)
+	offset := writeEntries(t, df, e)[0]
+	assertEntry(t, df, offset, e)
+	if _, err := df.Read(df.Offset); err != io.EOF {
+		t.Fatalf("read the end: got %v, want io.EOF", err)
+	}
+}
+
+// The db file opened by OpenDBFile is never changed, the unused tail of a mapped file is not trimmed.
+func TestOpenDBFile(t *testing.T) {
+	dir := t.TempDir()
+	df, err := OpenDBFile(dir, 0, 1024, String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := df.Read(df.DataOffset()); err != io.EOF {
+		t.Fatalf("read the missing file: got %v, want io.EOF", err)
+	}
+	if err := df.Close(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(df.Name(String)); !os.IsNotExist(err) {
+		t.Fatalf("the missing file is created: %v", err)
+	}
+
+	// the mapped file is not closed, so its tail is zero filled.
+	w := openTestFile(t, dir, MMap, 1024)
+	e := NewEntryNoExtra([]byte("key"), []byte("value"), String, 1)
+	offsets := writeEntries(t, w, e)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if df, err = OpenDBFile(dir, 0, 1024, String); err != nil {
+		t.Fatal(err)
+	}
+	assertEntry(t, df, offsets[0], e)
+	if df.Offset != w.Offset {
+		t.Fatalf("got the end of data %d, want %d", df.Offset, w.Offset)
+	}
+	if err := df.Write(e); err != ErrReadOnlyFile {
+		t.Fatalf("write: got %v, want ErrReadOnlyFile", err)
+	}
+	if err := df.Truncate(df.DataOffset()); err != ErrReadOnlyFile {
+		t.Fatalf("truncate: got %v, want ErrReadOnlyFile", err)
+	}
+	if err := df.Close(true); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(df.Name(String)); err != nil || stat.Size() != 1024 {
+		t.Fatalf("the mapped file is trimmed: %v", err)
+	}
+}
+
+// crash drops the mapping and closes the file without trimming its tail, as if the process was killed.
+func crash(t *testing.T, df *DBFile) {
+	t.Helper()
+
+	if err := df.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := df.mmap.Unmap(); err != nil {
+		t.Fatal(err)
+	}
+	df.mmap = nil
+	if err := df.File.Close(); err != nil {
+		t.Fatal(err)
+	}
+	df.File = nil
+	df.fileSize = df.Offset
+}
+
+func TestMMapReopen(t *testing.T) {
+	dir := t.TempDir()
+	entries := []*Entry{
+		NewEntryNoExtra([]byte("a"), []byte("1"), String, 0),
+		NewEntry([]byte("b"), []byte("2"), []byte("x"), String, 0),
+	}
+
+	df := openTestFile(t, dir, MMap, 1024)
+	if stat, err := df.File.Stat(); err != nil || stat.Size() != 1024 {
+		t.Fatalf("the file is not extended to the mapping: %v", err)
+	}
+	offsets := writeEntries(t, df, entries...)
+	end := df.Offset
+	if err := df.Close(true); err != nil {
+		t.Fatal(err)
+	}
+	// the unused tail is trimmed when closed.
+	if stat, err := os.Stat(df.Name(String)); err != nil || stat.Size() != end {
+		t.Fatalf("the file is not trimmed to %d: %v", end, err)
+	}
+
+	df = openTestFile(t, dir, MMap, 1024)
+	if df.Offset != end {
+		t.Fatalf("got offset %d, want %d", df.Offset, end)
+	}
+	for i, e := range entries {
+		assertEntry(t, df, offsets[i], e)
+	}
+	e := NewEntryNoExtra([]byte("c"), []byte("3"), String, 0)
+	if offset := writeEntries(t, df, e)[0]; offset != end {
+		t.Fatalf("the new entry is written at %d, want %d", offset, end)
+	}
+	assertEntry(t, df, end, e)
+}
+
+// The mapped file is not trimmed in a crash, the end of data is found in its zero filled tail.
+func TestMMapCrash(t *testing.T) {
+	dir := t.TempDir()
+	entries := []*Entry{
+		NewEntryNoExtra([]byte("a"), []byte("1"), String, 0),
+		NewEntry([]byte("b"), []byte("2"), []byte("x"), String, 0),
+	}
+
+	df := openTestFile(t, dir, MMap, 1024)
+	offsets := writeEntries(t, df, entries...)
+	end := df.Offset
+	crash(t, df)
+	if stat, err := os.Stat(df.Name(String)); err != nil || stat.Size() != 1024 {
+		t.Fatalf("the file is trimmed in the crash: %v", err)
+	}
+
+	for _, method := range []FileRWMethod{MMap, FileIO} {
+		df = openTestFile(t, dir, method, 1024)
+		if df.Offset != end {
+			t.Fatalf("got offset %d, want %d", df.Offset, end)
+		}
+		for i, e := range entries {
+			assertEntry(t, df, offsets[i], e)
+		}
+		if _, err := df.Read(end); err != io.EOF {
+			t.Fatalf("read the end: got %v, want io.EOF", err)
+		}
+		df.Close(false)
+	}
+}
+
+// The entry exceeding the mapping is written to the file directly, and read back from it.
+func TestMMapLargeEntry(t *testing.T) {
+	dir := t.TempDir()
+	small := NewEntryNoExtra([]byte("small"), []byte("v"), String, 0)
+	large := NewEntryNoExtra([]byte("large"), bytes.Repeat([]byte("x"), 2000), String, 0)
+	after := NewEntryNoExtra([]byte("after"), []byte("v"), String, 0)
+
+	df := openTestFile(t, dir, MMap, 1024)
+	offsets := writeEntries(t, df, small, large, after)
+	if df.Offset <= 1024 {
+		t.Fatalf("the entries end at %d, within the mapping", df.Offset)
+	}
+	for i, e := range []*Entry{small, large, after} {
+		assertEntry(t, df, offsets[i], e)
+	}
+	end := df.Offset
+
+	check := func(df *DBFile) {
+		t.Helper()
+		if df.Offset != end {
+			t.Fatalf("got offset %d, want %d", df.Offset, end)
+		}
+		for i, e := range []*Entry{small, large, after} {
+			assertEntry(t, df, offsets[i], e)
+		}
+	}
+	// the whole file is mapped when it is opened again.
+	if err := df.Close(true); err != nil {
+		t.Fatal(err)
+	}
+	df = openTestFile(t, dir, MMap, 1024)
+	check(df)
+	if int64(len(df.mmap)) != end {
+		t.Fatalf("got mapping size %d, want %d", len(df.mmap), end)
+	}
+
+	crash(t, df)
+	df = openTestFile(t, dir, MMap, 1024)
+	check(df)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"hash/crc32"
+	"strconv"
 	"time"
 )
 
@@ -17,8 +18,26 @@ const (
 	// Timestamp 8 bytes, state 2 bytes.
 	// 4 * 4 + 8 + 2 = 26
 	entryHeaderSize = 26
+)
+
+// The flags of entry are saved in the high bits of state, they are not part of the data type.
+const (
+	// FlagCompressed the value is compressed, the first byte of the saved value is the id of codec.
+	FlagCompressed uint16 = 1 << 15
+
+	// FlagEncrypted the key, value and extra are encrypted together, see Encryptor.
+	FlagEncrypted uint16 = 1 << 14
 
+	flagMask = FlagCompressed | FlagEncrypted
+)
+
+// The data types of entry, must be consistent with the DataType in kv.
+const (
 	Hash uint16 = iota
+	String
+	List
+	Set
+	ZSet
 )
 
 type (
@@ -28,6 +47,8 @@ type (
 		State     uint16 // state represents two fields, high 8 bits is the data type, low 8 bits is operation mark.
 		Crc32     uint32 // Check sum.
 		Timestamp uint64 // Timestamp is the time when entry was written.
+
+		stored []byte // the value saved in db file if it is compressed, the Value is always uncompressed.
 	}
 
 	// Meta meta info.
@@ -36,7 +57,7 @@ type (
 		Value     []byte
 		Extra     []byte // Extra info that operates the entry.
 		KeySize   uint32
-		ValueSize uint32
+		ValueSize uint32 // the size of value saved in db file, it is less than the length of Value if compressed.
 		ExtraSize uint32
 	}
 )
@@ -64,13 +85,16 @@ func NewEntry(key, value, extra []byte, t, mark uint16) *Entry {
 	return newInternal(key, value, extra, state, uint64(time.Now().UnixNano()))
 }
 
+// NewEntryWithExpire returns an entry whose Timestamp is the deadline,
+// so the time when it is written is saved in the extra as a decimal of nanoseconds.
 func NewEntryWithExpire(key, value []byte, deadline int64, t, mark uint16) *Entry {
 	var state uint16 = 0
 	// set type and mark.
 	state = state | (t << 8)
 	state = state | mark
 
-	return newInternal(key, value, nil, state, uint64(deadline))
+	extra := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+	return newInternal(key, value, extra, state, uint64(deadline))
 }
 
 func NewEntryNoExtra(key, value []byte, t, mark uint16) *Entry {
@@ -78,17 +102,37 @@ func NewEntryNoExtra(key, value []byte, t, mark uint16) *Entry {
 }
 
 func (e *Entry) Size() uint32 {
-	return entryHeaderSize + e.Meta.KeySize + e.Meta.ValueSize + e.Meta.ExtraSize
+	size := entryHeaderSize + e.Meta.KeySize + e.Meta.ValueSize + e.Meta.ExtraSize
+	if e.State&FlagEncrypted != 0 {
+		size += encryptOverhead
+	}
+	return size
+}
+
+// MaxSize returns the size of entry if its value is not compressed and it is encrypted,
+// the entry never takes more space in db file.
+func (e *Entry) MaxSize() uint32 {
+	return entryHeaderSize + e.Meta.KeySize + uint32(len(e.Meta.Value)) + e.Meta.ExtraSize + encryptOverhead
 }
 
+// Encode encodes the entry in the current format.
 func (e *Entry) Encode() ([]byte, error) {
+	return e.encode(CurrentVersion, nil)
+}
+
+// encode encodes the entry, it is encrypted if enc is not nil.
+func (e *Entry) encode(version uint16, enc *Encryptor) ([]byte, error) {
 	if e == nil || e.Meta.KeySize == 0 {
 		return nil, ErrInvalidEntry
 	}
 
+	e.State &^= FlagEncrypted
+	if enc != nil {
+		e.State |= FlagEncrypted
+	}
 	ks, vs := e.Meta.KeySize, e.Meta.ValueSize
 	es := e.Meta.ExtraSize
-	buf := make([]byte, e.Size())
+	buf := make([]byte, entryHeaderSize+ks+vs+es)
 
 	binary.BigEndian.PutUint32(buf[4:8], ks)
 	binary.BigEndian.PutUint32(buf[8:12], vs)
@@ -96,17 +140,41 @@ func (e *Entry) Encode() ([]byte, error) {
 	binary.BigEndian.PutUint16(buf[16:18], e.State)
 	binary.BigEndian.PutUint64(buf[18:26], e.Timestamp)
 	copy(buf[entryHeaderSize:entryHeaderSize+ks], e.Meta.Key)
-	copy(buf[entryHeaderSize+ks:(entryHeaderSize+ks+vs)], e.Meta.Value)
+	copy(buf[entryHeaderSize+ks:(entryHeaderSize+ks+vs)], e.storedValue())
 	if es > 0 {
 		copy(buf[(entryHeaderSize+ks+vs):(entryHeaderSize+ks+vs+es)], e.Meta.Extra)
 	}
 
-	crc := crc32.ChecksumIEEE(e.Meta.Value)
+	if enc != nil {
+		// the header is authenticated, so an entry can't be moved to the header of another one.
+		body, err := enc.seal(buf[entryHeaderSize:], buf[4:entryHeaderSize])
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf[:entryHeaderSize], body...)
+		binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+		return buf, nil
+	}
+
+	crc := e.checksum(buf[:entryHeaderSize], version)
 	binary.BigEndian.PutUint32(buf[0:4], crc)
 
 	return buf, nil
 }
 
+// checksum returns the crc32 of entry with its encoded header.
+// In V1, only the value is checked, and in V2, the crc32 covers the header, key, value and extra.
+func (e *Entry) checksum(header []byte, version uint16) uint32 {
+	if version == V1 {
+		return crc32.ChecksumIEEE(e.storedValue())
+	}
+
+	crc := crc32.ChecksumIEEE(header[4:entryHeaderSize])
+	crc = crc32.Update(crc, crc32.IEEETable, e.Meta.Key)
+	crc = crc32.Update(crc, crc32.IEEETable, e.storedValue())
+	return crc32.Update(crc, crc32.IEEETable, e.Meta.Extra)
+}
+
 func Decode(buf []byte) (*Entry, error) {
 	ks := binary.BigEndian.Uint32(buf[4:8])
 	vs := binary.BigEndian.Uint32(buf[8:12])
@@ -127,8 +195,49 @@ func Decode(buf []byte) (*Entry, error) {
 	}, nil
 }
 
+// storedValue returns the value saved in db file.
+func (e *Entry) storedValue() []byte {
+	if e.State&FlagCompressed != 0 {
+		return e.stored
+	}
+	return e.Meta.Value
+}
+
+// decompress restores the value if it is compressed.
+// The ValueSize is still the size in db file, so that the entry can be located.
+func (e *Entry) decompress() (err error) {
+	if e.State&FlagCompressed == 0 {
+		return nil
+	}
+	e.Meta.Value, err = decompress(e.stored)
+	return
+}
+
+// compress compresses the value if it is not less than threshold, and the compressed one is smaller.
+// The entry is always compressed again, since the codec may be changed.
+func (e *Entry) compress(codec Codec, threshold int) error {
+	e.State &^= FlagCompressed
+	e.stored = nil
+	e.Meta.ValueSize = uint32(len(e.Meta.Value))
+	if codec == nil || len(e.Meta.Value) == 0 || len(e.Meta.Value) < threshold {
+		return nil
+	}
+
+	buf, err := codec.Compress(e.Meta.Value)
+	if err != nil {
+		return err
+	}
+	if len(buf)+1 >= len(e.Meta.Value) {
+		return nil
+	}
+	e.stored = append([]byte{codec.Id()}, buf...)
+	e.State |= FlagCompressed
+	e.Meta.ValueSize = uint32(len(e.stored))
+	return nil
+}
+
 func (e *Entry) GetType() uint16 {
-	return e.State >> 8
+	return (e.State &^ flagMask) >> 8
 }
 
 func (e *Entry) GetMark() uint16 {
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	HintFileFormatNames = map[uint16]string{
+		0: "%09d.hint.hash",
+		1: "%09d.hint.str",
+		2: "%09d.hint.list",
+		3: "%09d.hint.set",
+		4: "%09d.hint.zset",
+	}
+)
+
+var (
+	// ErrInvalidHint the hint file is corrupt or doesn't match its db file.
+	ErrInvalidHint = errors.New("storage/hint: invalid hint file")
+)
+
+const (
+	// KeySize, ValueSize, ExtraSize 4 bytes each, state 2 bytes, timestamp and offset 8 bytes each,
+	// and 4 bytes for the length of saved value, which is uncompressed.
+	// 4 * 3 + 2 + 8 * 2 + 4 = 34
+	hintHeaderSize = 34
+
+	// the size of db file 8 bytes, and crc32 of the hint file 4 bytes.
+	hintFooterSize = 12
+)
+
+// HintItem is the index info of an entry in db file, it is saved in the hint file of the db file.
+// The value of entry can be omitted if it is not needed to build the index, but the ValueSize is always kept.
+type HintItem struct {
+	Entry  *Entry
+	Offset int64
+}
+
+// HintName returns the hint file name of the db file.
+func HintName(fileId uint32, eType uint16) string {
+	return PathSeparator + fmt.Sprintf(HintFileFormatNames[eType], fileId)
+}
+
+// WriteHint saves the hint items of the db file, the hint file is replaced atomically.
+func (df *DBFile) WriteHint(eType uint16, items []*HintItem) error {
+	if df.readOnly {
+		return ErrReadOnlyFile
+	}
+	var size int
+	for _, item := range items {
+		size += hintHeaderSize + len(item.Entry.Meta.Key) + len(item.Entry.Meta.Value) + len(item.Entry.Meta.Extra)
+	}
+
+	buf := make([]byte, size+hintFooterSize)
+	var n int
+	for _, item := range items {
+		meta := item.Entry.Meta
+		binary.BigEndian.PutUint32(buf[n:n+4], uint32(len(meta.Key)))
+		binary.BigEndian.PutUint32(buf[n+4:n+8], meta.ValueSize)
+		binary.BigEndian.PutUint32(buf[n+8:n+12], uint32(len(meta.Extra)))
+		binary.BigEndian.PutUint16(buf[n+12:n+14], item.Entry.State)
+		binary.BigEndian.PutUint64(buf[n+14:n+22], item.Entry.Timestamp)
+		binary.BigEndian.PutUint64(buf[n+22:n+30], uint64(item.Offset))
+		binary.BigEndian.PutUint32(buf[n+30:n+34], uint32(len(meta.Value)))
+		n += hintHeaderSize
+		n += copy(buf[n:], meta.Key)
+		n += copy(buf[n:], meta.Value)
+		n += copy(buf[n:], meta.Extra)
+	}
+	binary.BigEndian.PutUint64(buf[n:n+8], uint64(df.Offset))
+	binary.BigEndian.PutUint32(buf[n+8:], crc32.ChecksumIEEE(buf[:n+8]))
+
+	// the keys and values in hint file are encrypted as the db file.
+	if df.enc != nil {
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, hintEncryptMagic)
+		body, err := df.enc.seal(buf, header)
+		if err != nil {
+			return err
+		}
+		buf = append(header, body...)
+	}
+
+	hintPath := df.path + HintName(df.Id, eType)
+	tmpPath := hintPath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePerm)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(buf); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, hintPath)
+}
+
+// ReadHint loads the hint items of the db file.
+// ErrInvalidHint is returned if the hint file is corrupt or it is not written for the current content of the db file.
+func (df *DBFile) ReadHint(eType uint16) ([]*HintItem, error) {
+	buf, err := ioutil.ReadFile(df.path + HintName(df.Id, eType))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) >= 4 && binary.BigEndian.Uint32(buf[:4]) == hintEncryptMagic {
+		if buf, err = df.enc.open(buf[4:], buf[:4]); err != nil {
+			return nil, ErrInvalidHint
+		}
+	}
+	if len(buf) < hintFooterSize {
+		return nil, ErrInvalidHint
+	}
+
+	end := len(buf) - hintFooterSize
+	if crc32.ChecksumIEEE(buf[:end+8]) != binary.BigEndian.Uint32(buf[end+8:]) {
+		return nil, ErrInvalidHint
+	}
+	// the hint is stale if the db file is changed after it is written.
+	if int64(binary.BigEndian.Uint64(buf[end:end+8])) != df.Offset {
+		return nil, ErrInvalidHint
+	}
+
+	var items []*HintItem
+	for n := 0; n < end; {
+		if n+hintHeaderSize > end {
+			return nil, ErrInvalidHint
+		}
+		ks := binary.BigEndian.Uint32(buf[n : n+4])
+		vs := binary.BigEndian.Uint32(buf[n+4 : n+8])
+		es := binary.BigEndian.Uint32(buf[n+8 : n+12])
+		state := binary.BigEndian.Uint16(buf[n+12 : n+14])
+		timestamp := binary.BigEndian.Uint64(buf[n+14 : n+22])
+		offset := int64(binary.BigEndian.Uint64(buf[n+22 : n+30]))
+		valueLen := int64(binary.BigEndian.Uint32(buf[n+30 : n+34]))
+		n += hintHeaderSize
+
+		if int64(n)+int64(ks)+valueLen+int64(es) > int64(end) {
+			return nil, ErrInvalidHint
+		}
+		key := buf[n : n+int(ks)]
+		n += int(ks)
+		var value, extra []byte
+		if valueLen > 0 {
+			value = buf[n : n+int(valueLen)]
+			n += int(valueLen)
+		}
+		if es > 0 {
+			extra = buf[n : n+int(es)]
+			n += int(es)
+		}
+		e := newInternal(key, value, extra, state, timestamp)
+		e.Meta.ValueSize = vs
+		items = append(items, &HintItem{Entry: e, Offset: offset})
+	}
+	return items, nil
+}
+
+// RemoveHint removes the hint file of the db file if it exists.
+func (df *DBFile) RemoveHint(eType uint16) error {
+	err := os.Remove(df.path + HintName(df.Id, eType))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// fdatasync flushes the data of file, the metadata is only flushed if it is needed to read the data, such as the size.
+func fdatasync(f *os.File) error {
+	return syscall.Fdatasync(int(f.Fd()))
+}
+
+// fallocate allocates the disk space of file up to size, and extends the file to it.
+// It falls back to truncating if the file system doesn't support it.
+func fallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP {
+		return f.Truncate(size)
+	}
+	return err
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package storage
+
+import "os"
+
+// fdatasync is not available, so the data and metadata of file are both flushed.
+func fdatasync(f *os.File) error {
+	return f.Sync()
+}
+
+// fallocate extends the file to size, the disk space is allocated when written.
+func fallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
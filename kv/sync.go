@@ -0,0 +1,344 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrInvalidSyncPolicy the sync policy is not always, interval, bytes or no.
+	ErrInvalidSyncPolicy = errors.New("rosedb: invalid sync policy")
+)
+
+// The policies of syncing writes to disk, see Config.SyncPolicy.
+const (
+	SyncPolicyAlways   = "always"
+	SyncPolicyInterval = "interval"
+	SyncPolicyBytes    = "bytes"
+	SyncPolicyNo       = "no"
+)
+
+// batchSizeBuckets the number of buckets of the batch size histogram, the last one counts all larger batches.
+const batchSizeBuckets = 12
+
+type (
+	// groupCommitter persists the writes of concurrent writers with a shared fsync in the always policy.
+	// The writers append the entries under the lock of data type as usual, and wait for the fsync after releasing it,
+	// so the writes appended during an fsync are persisted together by the next one.
+	groupCommitter struct {
+		db       *KVDB
+		maxBatch int
+		maxWait  time.Duration
+		reqs     chan chan error
+		stop     chan struct{}
+		stopOnce sync.Once
+		wg       sync.WaitGroup
+		written  uint64        // the number of entries appended, updated in store.
+		synced   uint64        // the number of entries persisted by the latest fsync.
+		stopped  chan struct{} // closed after the final fsync when it is stopped.
+		err      error         // the error of the final fsync.
+
+		mu    sync.Mutex
+		stats GroupCommitStats
+	}
+
+	// backgroundSyncer syncs the active files in background for the interval and bytes policies.
+	backgroundSyncer struct {
+		db       *KVDB
+		interval time.Duration // sync periodically if it is not 0.
+		bytes    int64         // sync after the bytes written if it is not 0.
+		unsynced int64         // the bytes written since the last sync, updated in store.
+		wake     chan struct{}
+		stop     chan struct{}
+		stopOnce sync.Once
+		wg       sync.WaitGroup
+	}
+
+	// GroupCommitStats the metrics of group commit, a batch is the writes persisted by one fsync.
+	GroupCommitStats struct {
+		Batches      uint64 // the number of batches.
+		Commits      uint64 // the number of writes in all batches.
+		MaxBatchSize int    // the size of the largest batch.
+		// BatchSizes is the histogram of batch sizes, the i-th bucket counts the batches whose size is in (2^(i-1), 2^i].
+		BatchSizes [batchSizeBuckets]uint64
+	}
+)
+
+// AvgBatchSize returns the average number of writes persisted by one fsync.
+func (s GroupCommitStats) AvgBatchSize() float64 {
+	if s.Batches == 0 {
+		return 0
+	}
+	return float64(s.Commits) / float64(s.Batches)
+}
+
+// GroupCommitStats returns the metrics of group commit, it is empty if the sync policy is not always.
+func (db *KVDB) GroupCommitStats() GroupCommitStats {
+	if db.committer == nil {
+		return GroupCommitStats{}
+	}
+	db.committer.mu.Lock()
+	defer db.committer.mu.Unlock()
+	return db.committer.stats
+}
+
+func newGroupCommitter(db *KVDB) *groupCommitter {
+	maxBatch := db.config.GroupCommitMaxBatch
+	if maxBatch <= 0 {
+		maxBatch = DefaultGroupCommitMaxBatch
+	}
+	return &groupCommitter{
+		db:       db,
+		maxBatch: maxBatch,
+		maxWait:  time.Duration(db.config.GroupCommitMaxWait) * time.Microsecond,
+		reqs:     make(chan chan error),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// syncPolicy returns the sync policy of config.
+func syncPolicy(config Config) (string, error) {
+	switch config.SyncPolicy {
+	case "":
+		if config.Sync {
+			return SyncPolicyAlways, nil
+		}
+		return SyncPolicyNo, nil
+	case SyncPolicyAlways, SyncPolicyInterval, SyncPolicyBytes, SyncPolicyNo:
+		return config.SyncPolicy, nil
+	}
+	return "", ErrInvalidSyncPolicy
+}
+
+// startSyncer starts the group commit or the background syncing according to the sync policy, it is stopped in Close.
+func (db *KVDB) startSyncer() error {
+	policy, err := syncPolicy(db.config)
+	if err != nil {
+		return err
+	}
+
+	switch policy {
+	case SyncPolicyAlways:
+		db.committer = newGroupCommitter(db)
+		db.committer.wg.Add(1)
+		go db.committer.run()
+	case SyncPolicyInterval:
+		interval := db.config.SyncInterval
+		if interval <= 0 {
+			interval = DefaultSyncInterval
+		}
+		db.syncer = &backgroundSyncer{db: db, interval: time.Duration(interval) * time.Millisecond}
+	case SyncPolicyBytes:
+		bytes := db.config.SyncBytes
+		if bytes <= 0 {
+			bytes = DefaultSyncBytes
+		}
+		db.syncer = &backgroundSyncer{db: db, bytes: bytes}
+	}
+
+	if db.syncer != nil {
+		db.syncer.wake = make(chan struct{}, 1)
+		db.syncer.stop = make(chan struct{})
+		db.syncer.wg.Add(1)
+		go db.syncer.run()
+	}
+	return nil
+}
+
+// stopSyncer stops the group commit and the background syncing.
+// The waiting writers are released after a final fsync, and the active files are synced again by Close.
+func (db *KVDB) stopSyncer() {
+	if db.committer != nil {
+		db.committer.stopOnce.Do(func() {
+			close(db.committer.stop)
+		})
+		db.committer.wg.Wait()
+	}
+	if db.syncer != nil {
+		db.syncer.stopOnce.Do(func() {
+			close(db.syncer.stop)
+		})
+		db.syncer.wg.Wait()
+	}
+}
+
+// preallocateActiveFiles allocates the disk space of active files to the block size if Preallocate is set.
+func (db *KVDB) preallocateActiveFiles() (err error) {
+	if !db.config.Preallocate {
+		return nil
+	}
+	db.activeFile.Range(func(key, value interface{}) bool {
+		err = value.(*storage.DBFile).Preallocate(db.config.BlockSize)
+		return err == nil
+	})
+	return
+}
+
+// appended records the bytes written to the active files, and wakes up the syncing if they reach the threshold.
+func (s *backgroundSyncer) appended(size int64) {
+	if n := atomic.AddInt64(&s.unsynced, size); s.bytes > 0 && n >= s.bytes {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *backgroundSyncer) run() {
+	defer s.wg.Done()
+
+	var tick <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-tick:
+		case <-s.wake:
+		}
+
+		// the bytes written during syncing are counted for the next one.
+		if atomic.SwapInt64(&s.unsynced, 0) == 0 {
+			continue
+		}
+		if err := s.db.Sync(); err != nil {
+			log.Println("background sync err: ", err)
+		}
+	}
+}
+
+// waitSync waits until the writes before it are persisted in the always policy, the error of fsync is returned by err.
+// It must be deferred before locking the data types, so the lock is released when waiting.
+func (db *KVDB) waitSync(err *error) {
+	if *err != nil || db.committer == nil {
+		return
+	}
+	*err = db.committer.wait()
+}
+
+// appended records an entry appended to the active file, it must be persisted by the next fsync.
+func (c *groupCommitter) appended() {
+	atomic.AddUint64(&c.written, 1)
+}
+
+// wait waits for the fsync of the entries appended before it.
+// If the group commit is stopped, the entries are persisted by the final fsync,
+// and ErrDBIsClosed is returned if they are appended after it.
+func (c *groupCommitter) wait() error {
+	written := atomic.LoadUint64(&c.written)
+	if written == atomic.LoadUint64(&c.synced) {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	select {
+	case c.reqs <- done:
+		return <-done
+	case <-c.stop:
+	}
+
+	<-c.stopped
+	if c.err != nil {
+		return c.err
+	}
+	if atomic.LoadUint64(&c.synced) < written {
+		return ErrDBIsClosed
+	}
+	return nil
+}
+
+func (c *groupCommitter) run() {
+	defer c.wg.Done()
+
+	for {
+		var batch []chan error
+		select {
+		case <-c.stop:
+			// the writes appended before stopping are persisted for the writers not in a batch.
+			c.err = c.sync()
+			close(c.stopped)
+			return
+		case done := <-c.reqs:
+			batch = append(batch, done)
+		}
+
+		batch = c.collect(batch)
+		err := c.sync()
+		for _, done := range batch {
+			done <- err
+		}
+		c.record(len(batch))
+	}
+}
+
+// collect adds the waiting writers to the batch until it is full or maxWait elapses.
+// If maxWait is 0, only the writers which are already waiting are added, they are the ones arrived during the last fsync.
+func (c *groupCommitter) collect(batch []chan error) []chan error {
+	var timeout <-chan time.Time
+	if c.maxWait > 0 {
+		timer := time.NewTimer(c.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for len(batch) < c.maxBatch {
+		if timeout == nil {
+			select {
+			case done := <-c.reqs:
+				batch = append(batch, done)
+			default:
+				return batch
+			}
+			continue
+		}
+
+		select {
+		case done := <-c.reqs:
+			batch = append(batch, done)
+		case <-timeout:
+			return batch
+		case <-c.stop:
+			return batch
+		}
+	}
+	return batch
+}
+
+// sync persists the active files, all the entries appended before it are covered.
+func (c *groupCommitter) sync() error {
+	written := atomic.LoadUint64(&c.written)
+	if written == atomic.LoadUint64(&c.synced) {
+		return nil
+	}
+	if err := c.db.Sync(); err != nil {
+		return err
+	}
+	atomic.StoreUint64(&c.synced, written)
+	return nil
+}
+
+func (c *groupCommitter) record(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Batches++
+	c.stats.Commits += uint64(size)
+	if size > c.stats.MaxBatchSize {
+		c.stats.MaxBatchSize = size
+	}
+	bucket := 0
+	for n := 1; n < size && bucket < batchSizeBuckets-1; n <<= 1 {
+		bucket++
+	}
+	c.stats.BatchSizes[bucket]++
+}
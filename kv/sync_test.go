@@ -0,0 +1,331 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The concurrent writes are persisted in batches, and every write waits for the fsync covering it, run it with -race.
+func TestGroupCommit(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.Sync = true
+		cfg.GroupCommitMaxWait = 2000
+	})
+
+	var n int32
+	runConcurrently(16, func() {
+		for i := 0; i < 20; i++ {
+			key := []byte(fmt.Sprintf("k%d", atomic.AddInt32(&n, 1)))
+			if err := db.Set(key, []byte("v")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+	if atomic.LoadUint64(&db.committer.synced) != atomic.LoadUint64(&db.committer.written) {
+		t.Fatal("the writes are returned before they are synced")
+	}
+
+	stats := db.GroupCommitStats()
+	if stats.Batches == 0 || stats.Commits > 320 || stats.MaxBatchSize < 2 {
+		t.Fatalf("got the stats %+v, want batches of concurrent writes", stats)
+	}
+	var batches uint64
+	for _, count := range stats.BatchSizes {
+		batches += count
+	}
+	if batches != stats.Batches {
+		t.Fatalf("got %d batches in histogram, want %d", batches, stats.Batches)
+	}
+	if avg := stats.AvgBatchSize(); avg != float64(stats.Commits)/float64(stats.Batches) {
+		t.Fatalf("AvgBatchSize: got %f", avg)
+	}
+
+	db = reopenTestDB(t, db)
+	for i := 1; i <= 320; i++ {
+		if _, err := db.Get([]byte(fmt.Sprintf("k%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGroupCommitMaxBatch(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.Sync = true
+		cfg.GroupCommitMaxBatch = 2
+		cfg.GroupCommitMaxWait = 100000
+	})
+
+	runConcurrently(8, func() {
+		for i := 0; i < 5; i++ {
+			if _, err := db.HSet([]byte("hash"), []byte(fmt.Sprintf("f%d", i)), []byte("v")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+	if stats := db.GroupCommitStats(); stats.Batches == 0 || stats.MaxBatchSize > 2 {
+		t.Fatalf("got the stats %+v, want batches of at most 2 writes", stats)
+	}
+}
+
+// Close releases the writers waiting for fsync after a final fsync.
+func TestGroupCommitClose(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.Sync = true
+		cfg.GroupCommitMaxWait = 1000000
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- db.Set([]byte("k"), []byte("v"))
+	}()
+	for atomic.LoadUint64(&db.committer.written) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	closeTestDB(t, db)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadUint64(&db.committer.synced) != atomic.LoadUint64(&db.committer.written) {
+		t.Fatal("the writer is released before its write is synced")
+	}
+
+	db = openTestConfig(t, db.config)
+	val, err := db.Get([]byte("k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "v")
+}
+
+// The writes appended after the final fsync of a stopped group commit are not reported as persisted.
+func TestGroupCommitStopped(t *testing.T) {
+	db := openTestDB(t, nil)
+	c := newGroupCommitter(db)
+	c.wg.Add(1)
+	go c.run()
+	close(c.stop)
+	c.wg.Wait()
+
+	c.appended()
+	if err := c.wait(); err != ErrDBIsClosed {
+		t.Fatalf("wait after stopping: got %v, want ErrDBIsClosed", err)
+	}
+}
+
+// The removals of active expiration wait for the fsync like the other writes.
+func TestGroupCommitActiveExpire(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.Sync = true
+	})
+
+	if err := db.Set([]byte("str"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	expireNow(db, String, "str")
+	if _, expired, err := db.expireSample(String, 10); err != nil || expired != 1 {
+		t.Fatalf("got %d expired: %v", expired, err)
+	}
+	if atomic.LoadUint64(&db.committer.synced) != atomic.LoadUint64(&db.committer.written) {
+		t.Fatal("the removal is returned before it is synced")
+	}
+}
+
+func TestGroupCommitStatsHistogram(t *testing.T) {
+	var c groupCommitter
+	for _, size := range []int{1, 2, 3, 4, 5, 4096} {
+		c.record(size)
+	}
+
+	want := [batchSizeBuckets]uint64{0: 1, 1: 1, 2: 2, 3: 1, batchSizeBuckets - 1: 1}
+	if c.stats.BatchSizes != want {
+		t.Fatalf("got the histogram %v, want %v", c.stats.BatchSizes, want)
+	}
+	if c.stats.Batches != 6 || c.stats.Commits != 4111 || c.stats.MaxBatchSize != 4096 {
+		t.Fatalf("got the stats %+v", c.stats)
+	}
+
+	// there is no group commit without the always policy.
+	db := openTestDB(t, nil)
+	if err := db.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if stats := db.GroupCommitStats(); stats.Batches != 0 {
+		t.Fatalf("got the stats %+v, want empty", stats)
+	}
+}
+
+func TestSyncPolicy(t *testing.T) {
+	tests := []struct {
+		sync   bool
+		policy string
+		want   string
+	}{
+		{false, "", SyncPolicyNo},
+		{true, "", SyncPolicyAlways},
+		{true, SyncPolicyNo, SyncPolicyNo},
+		{false, SyncPolicyAlways, SyncPolicyAlways},
+		{false, SyncPolicyInterval, SyncPolicyInterval},
+		{false, SyncPolicyBytes, SyncPolicyBytes},
+	}
+	for _, tt := range tests {
+		cfg := Config{Sync: tt.sync, SyncPolicy: tt.policy}
+		if got, err := syncPolicy(cfg); err != nil || got != tt.want {
+			t.Fatalf("sync %v, policy %q: got %q, %v, want %q", tt.sync, tt.policy, got, err, tt.want)
+		}
+
+		db := openTestDB(t, func(cfg *Config) {
+			cfg.Sync, cfg.SyncPolicy = tt.sync, tt.policy
+		})
+		if (db.committer != nil) != (tt.want == SyncPolicyAlways) {
+			t.Fatalf("policy %q: the group commit is started: %v", tt.want, db.committer != nil)
+		}
+		if (db.syncer != nil) != (tt.want == SyncPolicyInterval || tt.want == SyncPolicyBytes) {
+			t.Fatalf("policy %q: the background syncing is started: %v", tt.want, db.syncer != nil)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.DirPath = t.TempDir()
+	cfg.SyncPolicy = "everysec"
+	if _, err := Open(cfg); err != ErrInvalidSyncPolicy {
+		t.Fatalf("open with an unknown policy: got %v, want ErrInvalidSyncPolicy", err)
+	}
+}
+
+// waitSynced waits until the background syncing has synced all the bytes written.
+func waitSynced(t *testing.T, db *KVDB) {
+	t.Helper()
+
+	for start := time.Now(); atomic.LoadInt64(&db.syncer.unsynced) != 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the writes are not synced in background")
+		}
+	}
+}
+
+func TestSyncPolicyInterval(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.SyncPolicy = SyncPolicyInterval
+		cfg.SyncInterval = 5
+	})
+
+	for i := 0; i < 3; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("k%d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		waitSynced(t, db)
+	}
+}
+
+func TestSyncPolicyBytes(t *testing.T) {
+	db := openTestDB(t, func(cfg *Config) {
+		cfg.SyncPolicy = SyncPolicyBytes
+		cfg.SyncBytes = 256
+	})
+
+	// the writes below the threshold are not synced.
+	if err := db.Set([]byte("k"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt64(&db.syncer.unsynced) == 0 {
+		t.Fatal("the writes below the threshold are synced")
+	}
+
+	if err := db.Set([]byte("large"), make([]byte, 256)); err != nil {
+		t.Fatal(err)
+	}
+	waitSynced(t, db)
+}
+
+// The active files are preallocated to the block size, the unused tail is trimmed when they are archived or closed,
+// and the end of data is found in the tail left by a crash.
+func TestPreallocate(t *testing.T) {
+	for name, dataSync := range map[string]bool{"fsync": false, "fdatasync": true} {
+		t.Run(name, func(t *testing.T) {
+			db := openTestDB(t, func(cfg *Config) {
+				smallFiles(cfg)
+				cfg.Preallocate = true
+				cfg.DataSync = dataSync
+				cfg.SyncPolicy = SyncPolicyAlways
+			})
+
+			fileSize := func(df *storage.DBFile, dType DataType) int64 {
+				t.Helper()
+				stat, err := os.Stat(df.Name(dType))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return stat.Size()
+			}
+			activeFile, err := db.getActiveFile(String)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := fileSize(activeFile, String); size != db.config.BlockSize {
+				t.Fatalf("got the active file of %d bytes, want %d", size, db.config.BlockSize)
+			}
+
+			for i := 0; i < 60; i++ {
+				if err := db.Set([]byte(fmt.Sprintf("k%02d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(db.archFiles[String]) == 0 {
+				t.Fatal("no archived file")
+			}
+			for _, df := range db.archFiles[String] {
+				if size := fileSize(df, String); size != df.Offset {
+					t.Fatalf("the archived file is not trimmed: got %d bytes, want %d", size, df.Offset)
+				}
+			}
+			if activeFile, err = db.getActiveFile(String); err != nil {
+				t.Fatal(err)
+			}
+			if size := fileSize(activeFile, String); size != db.config.BlockSize {
+				t.Fatalf("got the new active file of %d bytes, want %d", size, db.config.BlockSize)
+			}
+
+			check := func(db *KVDB) {
+				t.Helper()
+				if db.RecoveryReport().Recovered() {
+					t.Fatalf("got the recovery report %+v", db.RecoveryReport())
+				}
+				for i := 0; i < 60; i++ {
+					val, err := db.Get([]byte(fmt.Sprintf("k%02d", i)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					assertBytes(t, val, fmt.Sprintf("v%d", i))
+				}
+			}
+
+			// the db files copied while the db is running are left by a crash.
+			cfg := db.config
+			cfg.DirPath = t.TempDir()
+			names, err := filepath.Glob(filepath.Join(db.config.DirPath, "*.data.*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range names {
+				copyFile(t, name, filepath.Join(cfg.DirPath, filepath.Base(name)))
+			}
+			check(openTestConfig(t, cfg))
+
+			end := activeFile.Offset
+			closeTestDB(t, db)
+			if size := fileSize(activeFile, String); size != end {
+				t.Fatalf("the active file is not trimmed when closed: got %d bytes, want %d", size, end)
+			}
+			check(openTestConfig(t, db.config))
+		})
+	}
+}
@@ -0,0 +1,655 @@
+package kv
+
+import (
+	"MetaDB/kv/index"
+	"MetaDB/kv/storage"
+
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+type (
+	// Tx is a transaction of db, the writes of a transaction are committed atomically.
+	// All data structures are locked until the transaction is finished, so keep it short.
+	Tx struct {
+		db       *KVDB
+		readOnly bool
+		finished bool
+		unlock   func()
+
+		// the entries will be written in order when committing.
+		entries []*storage.Entry
+
+		// the uncommitted writes, so that a transaction can read its own writes.
+		strEntries  map[string]*storage.Entry
+		hashEntries map[string]map[string]*storage.Entry
+		setMembers  map[string]map[string]bool
+		zsetEntries map[string]map[string]*storage.Entry
+
+		// the expired keys cleared by the transaction, see clearExpired.
+		cleared map[DataType]map[string]bool
+	}
+
+	// txnBlock is the entries of a transaction in a db file, which starts with a TxBegin marker.
+	txnBlock struct {
+		id          uint64
+		coordinator DataType // the data type whose db file saves the commit marker.
+		remaining   int      // the number of entries which haven't been read.
+	}
+
+	// txnEntry an entry of transaction with its index info.
+	txnEntry struct {
+		entry *storage.Entry
+		idx   *index.Indexer
+	}
+)
+
+// Txn executes a function within a read-write transaction.
+// If the function returns nil, the transaction will be committed, otherwise all the writes are discarded.
+func (db *KVDB) Txn(fn func(tx *Tx) error) (err error) {
+	if atomic.LoadUint32(&db.closed) == 1 {
+		return ErrDBIsClosed
+	}
+
+	// the commit marker is persisted by group commit after the locks are released.
+	defer db.waitSync(&err)
+	tx := db.newTx(false)
+	defer tx.finish()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// TxnView executes a function within a read-only transaction, any write in it returns ErrTxIsReadOnly.
+func (db *KVDB) TxnView(fn func(tx *Tx) error) error {
+	if atomic.LoadUint32(&db.closed) == 1 {
+		return ErrDBIsClosed
+	}
+
+	tx := db.newTx(true)
+	defer tx.finish()
+
+	return fn(tx)
+}
+
+func (db *KVDB) newTx(readOnly bool) *Tx {
+	tx := &Tx{
+		db:          db,
+		readOnly:    readOnly,
+		strEntries:  make(map[string]*storage.Entry),
+		hashEntries: make(map[string]map[string]*storage.Entry),
+		setMembers:  make(map[string]map[string]bool),
+		zsetEntries: make(map[string]map[string]*storage.Entry),
+		cleared:     make(map[DataType]map[string]bool),
+	}
+
+	var dTypes []DataType
+	for i := 0; i < DataStructureNum; i++ {
+		dTypes = append(dTypes, uint16(i))
+	}
+	if readOnly {
+		tx.unlock = db.lockMgr.RLock(dTypes...)
+	} else {
+		tx.unlock = db.lockMgr.Lock(dTypes...)
+	}
+	return tx
+}
+
+// Set see KVDB.Set.
+func (tx *Tx) Set(key, value []byte) error {
+	if err := tx.checkWrite(key, value); err != nil {
+		return err
+	}
+
+	// the time to live is discarded.
+	if tx.strHasTTL(key) {
+		tx.addEntry(storage.NewEntryNoExtra(key, nil, String, StringPersist))
+	}
+	e := storage.NewEntryNoExtra(key, value, String, StringSet)
+	tx.addEntry(e)
+	tx.strEntries[string(key)] = e
+	return nil
+}
+
+// SetNx see KVDB.SetNx.
+func (tx *Tx) SetNx(key, value []byte) (res int, err error) {
+	if err = tx.checkWrite(key, value); err != nil {
+		return
+	}
+
+	if _, err = tx.Get(key); err == nil {
+		return
+	}
+	if err = tx.Set(key, value); err == nil {
+		res = 1
+	}
+	return
+}
+
+// Get see KVDB.Get.
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if err := tx.checkRead(key); err != nil {
+		return nil, err
+	}
+
+	if e, ok := tx.strEntries[string(key)]; ok {
+		if e.GetMark() == StringRem {
+			return nil, ErrKeyNotExist
+		}
+		return e.Meta.Value, nil
+	}
+	return tx.db.getVal(key)
+}
+
+// Remove see KVDB.Remove.
+func (tx *Tx) Remove(key []byte) error {
+	if err := tx.checkWrite(key, nil); err != nil {
+		return err
+	}
+
+	if _, err := tx.Get(key); err != nil {
+		return ErrKeyNotExist
+	}
+	e := storage.NewEntryNoExtra(key, nil, String, StringRem)
+	tx.addEntry(e)
+	tx.strEntries[string(key)] = e
+	return nil
+}
+
+// HSet see KVDB.HSet.
+func (tx *Tx) HSet(key, field, value []byte) error {
+	if err := tx.checkWrite(key, value); err != nil {
+		return err
+	}
+
+	tx.clearExpired(key, Hash)
+	// the time to live of the field is discarded.
+	if tx.fieldHasTTL(key, field) {
+		tx.addEntry(storage.NewEntry(key, nil, field, Hash, HashHFieldPersist))
+	}
+	e := storage.NewEntry(key, value, field, Hash, HashHSet)
+	tx.addEntry(e)
+	tx.hashEntry(key)[string(field)] = e
+	return nil
+}
+
+// HSetNx see KVDB.HSetNx.
+func (tx *Tx) HSetNx(key, field, value []byte) (res int, err error) {
+	if err = tx.checkWrite(key, value); err != nil {
+		return
+	}
+
+	if tx.HExists(key, field) {
+		return
+	}
+	if err = tx.HSet(key, field, value); err == nil {
+		res = 1
+	}
+	return
+}
+
+// HGet see KVDB.HGet.
+func (tx *Tx) HGet(key, field []byte) []byte {
+	if err := tx.checkRead(key); err != nil {
+		return nil
+	}
+
+	if e, ok := tx.hashEntries[string(key)][string(field)]; ok {
+		if e.GetMark() == HashHDel {
+			return nil
+		}
+		return e.Meta.Value
+	}
+	return tx.db.hGet(key, field)
+}
+
+// HExists returns if field is an existing field in the hash stored at key.
+func (tx *Tx) HExists(key, field []byte) bool {
+	if err := tx.checkRead(key); err != nil {
+		return false
+	}
+
+	if e, ok := tx.hashEntries[string(key)][string(field)]; ok {
+		return e.GetMark() == HashHSet
+	}
+	if tx.expired(key, Hash) || tx.db.fieldExpired(string(key), string(field)) {
+		return false
+	}
+	return tx.db.hashIndex.indexes.HExists(string(key), string(field)) == 0
+}
+
+// HDel see KVDB.HDel.
+func (tx *Tx) HDel(key []byte, fields ...[]byte) (res int, err error) {
+	if err = tx.checkWrite(key, nil); err != nil {
+		return
+	}
+
+	for _, f := range fields {
+		if !tx.HExists(key, f) {
+			continue
+		}
+		e := storage.NewEntry(key, nil, f, Hash, HashHDel)
+		tx.addEntry(e)
+		tx.hashEntry(key)[string(f)] = e
+		res++
+	}
+	return
+}
+
+// LPush see KVDB.LPush.
+func (tx *Tx) LPush(key []byte, values ...[]byte) error {
+	return tx.push(key, ListLPush, values...)
+}
+
+// RPush see KVDB.RPush.
+func (tx *Tx) RPush(key []byte, values ...[]byte) error {
+	return tx.push(key, ListRPush, values...)
+}
+
+// SAdd see KVDB.SAdd.
+func (tx *Tx) SAdd(key []byte, members ...[]byte) (res int, err error) {
+	if err = tx.checkWrite(key, members...); err != nil {
+		return
+	}
+
+	tx.clearExpired(key, Set)
+	for _, m := range members {
+		if tx.SIsMember(key, m) {
+			continue
+		}
+		tx.addEntry(storage.NewEntryNoExtra(key, m, Set, SetSAdd))
+		tx.setMember(key)[string(m)] = true
+		res++
+	}
+	return
+}
+
+// SRem see KVDB.SRem.
+func (tx *Tx) SRem(key []byte, members ...[]byte) (res int, err error) {
+	if err = tx.checkWrite(key, nil); err != nil {
+		return
+	}
+
+	for _, m := range members {
+		if !tx.SIsMember(key, m) {
+			continue
+		}
+		tx.addEntry(storage.NewEntryNoExtra(key, m, Set, SetSRem))
+		tx.setMember(key)[string(m)] = false
+		res++
+	}
+	return
+}
+
+// SIsMember see KVDB.SIsMember.
+func (tx *Tx) SIsMember(key, member []byte) bool {
+	if err := tx.checkRead(key); err != nil {
+		return false
+	}
+
+	if ok, exist := tx.setMembers[string(key)][string(member)]; exist {
+		return ok
+	}
+	if tx.expired(key, Set) {
+		return false
+	}
+	return tx.db.setIndex.indexes.SIsMember(string(key), member)
+}
+
+// ZAdd see KVDB.ZAdd.
+func (tx *Tx) ZAdd(key []byte, score float64, member []byte) error {
+	if math.IsNaN(score) {
+		return ErrInvalidScore
+	}
+	if err := tx.checkWrite(key, member); err != nil {
+		return err
+	}
+
+	tx.clearExpired(key, ZSet)
+	e := storage.NewEntry(key, member, formatScore(score), ZSet, ZSetZAdd)
+	tx.addEntry(e)
+	tx.zsetEntry(key)[string(member)] = e
+	return nil
+}
+
+// ZScore see KVDB.ZScore.
+func (tx *Tx) ZScore(key, member []byte) (score float64, ok bool) {
+	if err := tx.checkRead(key); err != nil {
+		return
+	}
+
+	if e, exist := tx.zsetEntries[string(key)][string(member)]; exist {
+		if e.GetMark() == ZSetZRem {
+			return
+		}
+		score, err := strconv.ParseFloat(string(e.Meta.Extra), 64)
+		return score, err == nil
+	}
+	if tx.expired(key, ZSet) {
+		return
+	}
+	return tx.db.zsetIndex.indexes.ZScore(string(key), string(member))
+}
+
+// ZRem see KVDB.ZRem.
+func (tx *Tx) ZRem(key, member []byte) (ok bool, err error) {
+	if err = tx.checkWrite(key, nil); err != nil {
+		return
+	}
+
+	if _, exist := tx.ZScore(key, member); !exist {
+		return
+	}
+	e := storage.NewEntryNoExtra(key, member, ZSet, ZSetZRem)
+	tx.addEntry(e)
+	tx.zsetEntry(key)[string(member)] = e
+	return true, nil
+}
+
+func (tx *Tx) push(key []byte, mark uint16, values ...[]byte) error {
+	if err := tx.checkWrite(key, values...); err != nil {
+		return err
+	}
+
+	// the expired list will be cleared before pushing.
+	tx.clearExpired(key, List)
+	for _, v := range values {
+		tx.addEntry(storage.NewEntryNoExtra(key, v, List, mark))
+	}
+	return nil
+}
+
+// commit writes the entries of every data type to its active file, starting with a TxBegin marker.
+// After all of them are persisted, a TxCommit marker is written to the db file of the smallest data type,
+// and then the entries are applied to the indexes.
+func (tx *Tx) commit() (err error) {
+	if tx.finished {
+		return ErrTxIsFinished
+	}
+	if len(tx.entries) == 0 {
+		return
+	}
+
+	db := tx.db
+	groups := make(map[DataType][]*storage.Entry)
+	var dTypes []int
+	for _, e := range tx.entries {
+		if _, ok := groups[e.GetType()]; !ok {
+			dTypes = append(dTypes, int(e.GetType()))
+		}
+		groups[e.GetType()] = append(groups[e.GetType()], e)
+	}
+	sort.Ints(dTypes)
+	coordinator := uint16(dTypes[0])
+	txId := atomic.AddUint64(&db.txnId, 1)
+
+	var written []*txnEntry
+	beginFiles := make(map[DataType]uint32)
+	for _, t := range dTypes {
+		dType := uint16(t)
+		entries := groups[dType]
+
+		block := txnBlock{id: txId, coordinator: coordinator, remaining: len(entries)}
+		if err = db.store(block.marker(dType)); err != nil {
+			db.rollbackTxn(txId, coordinator)
+			return
+		}
+		if activeFile, err := db.getActiveFile(dType); err == nil {
+			beginFiles[dType] = activeFile.Id
+		}
+
+		for _, e := range entries {
+			if err = db.store(e); err != nil {
+				db.rollbackTxn(txId, coordinator)
+				return
+			}
+			var idx *index.Indexer
+			if idx, err = db.newIndexer(e); err != nil {
+				db.rollbackTxn(txId, coordinator)
+				return
+			}
+			written = append(written, &txnEntry{entry: e, idx: idx})
+		}
+
+		// the entries must be persisted before the commit marker.
+		var activeFile *storage.DBFile
+		if activeFile, err = db.getActiveFile(dType); err == nil {
+			err = activeFile.Sync()
+		}
+		if err != nil {
+			db.rollbackTxn(txId, coordinator)
+			return
+		}
+	}
+
+	if err = db.store(newTxnMarker(txId, coordinator, TxCommit, nil, nil)); err != nil {
+		db.rollbackTxn(txId, coordinator)
+		return
+	}
+
+	// the db files crossed by the transaction can't be compacted alone.
+	for dType, fileId := range beginFiles {
+		if activeFile, err := db.getActiveFile(dType); err == nil {
+			db.garbage.markTxnSpan(dType, fileId, activeFile.Id)
+		}
+	}
+
+	for _, w := range written {
+		if err = db.buildIndex(w.entry, w.idx); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (tx *Tx) finish() {
+	if tx.finished {
+		return
+	}
+	tx.finished = true
+	tx.unlock()
+}
+
+func (tx *Tx) checkRead(key []byte) error {
+	if tx.finished {
+		return ErrTxIsFinished
+	}
+	return tx.db.checkKeyValue(key, nil)
+}
+
+func (tx *Tx) checkWrite(key []byte, value ...[]byte) error {
+	if tx.finished {
+		return ErrTxIsFinished
+	}
+	if tx.readOnly {
+		return ErrTxIsReadOnly
+	}
+	return tx.db.checkKeyValue(key, value...)
+}
+
+// expired checks whether the key is expired, the db is not changed until the transaction is committed.
+func (tx *Tx) expired(key []byte, dType DataType) bool {
+	return tx.db.keyExpired(dType, string(key))
+}
+
+// clearExpired clears the expired key before the writes to it, the clear entry is one of the writes of transaction,
+// so it is discarded with the others if the transaction is not committed.
+func (tx *Tx) clearExpired(key []byte, dType DataType) {
+	if !tx.expired(key, dType) || tx.cleared[dType][string(key)] {
+		return
+	}
+	if tx.cleared[dType] == nil {
+		tx.cleared[dType] = make(map[string]bool)
+	}
+	tx.cleared[dType][string(key)] = true
+	tx.addEntry(newClearEntry(key, dType))
+}
+
+func (tx *Tx) addEntry(e *storage.Entry) {
+	tx.entries = append(tx.entries, e)
+}
+
+func (tx *Tx) strHasTTL(key []byte) bool {
+	// the time to live is discarded by the writes in transaction.
+	if _, ok := tx.strEntries[string(key)]; ok {
+		return false
+	}
+	_, exist := tx.db.expires[String][string(key)]
+	return exist
+}
+
+func (tx *Tx) fieldHasTTL(key, field []byte) bool {
+	// the time to live is discarded by the writes in transaction.
+	if _, ok := tx.hashEntries[string(key)][string(field)]; ok {
+		return false
+	}
+	_, exist := tx.db.fieldExpires[string(key)][string(field)]
+	return exist
+}
+
+func (tx *Tx) hashEntry(key []byte) map[string]*storage.Entry {
+	if tx.hashEntries[string(key)] == nil {
+		tx.hashEntries[string(key)] = make(map[string]*storage.Entry)
+	}
+	return tx.hashEntries[string(key)]
+}
+
+func (tx *Tx) setMember(key []byte) map[string]bool {
+	if tx.setMembers[string(key)] == nil {
+		tx.setMembers[string(key)] = make(map[string]bool)
+	}
+	return tx.setMembers[string(key)]
+}
+
+func (tx *Tx) zsetEntry(key []byte) map[string]*storage.Entry {
+	if tx.zsetEntries[string(key)] == nil {
+		tx.zsetEntries[string(key)] = make(map[string]*storage.Entry)
+	}
+	return tx.zsetEntries[string(key)]
+}
+
+// rollbackTxn writes a rollback marker for the failed transaction, its entries will be discarded.
+// It is fine if the marker is failed to write, a transaction without commit marker is never committed.
+func (db *KVDB) rollbackTxn(txId uint64, coordinator DataType) {
+	db.abortedTxns[txId] = struct{}{}
+	if err := db.store(newTxnMarker(txId, coordinator, TxRollback, nil, nil)); err != nil {
+		log.Println("rollback transaction: store entry err: ", err)
+	}
+}
+
+// storeTxn writes the entries of dType as a transaction, they are all applied or discarded when db is opened.
+// The indexes are not changed, and the caller must hold the lock of dType.
+func (db *KVDB) storeTxn(dType DataType, entries []*storage.Entry) error {
+	txId := atomic.AddUint64(&db.txnId, 1)
+	block := txnBlock{id: txId, coordinator: dType, remaining: len(entries)}
+	if err := db.store(block.marker(dType)); err != nil {
+		db.rollbackTxn(txId, dType)
+		return err
+	}
+	beginFile, err := db.getActiveFile(dType)
+	if err != nil {
+		db.rollbackTxn(txId, dType)
+		return err
+	}
+	beginId := beginFile.Id
+
+	for _, e := range entries {
+		if err := db.store(e); err != nil {
+			db.rollbackTxn(txId, dType)
+			return err
+		}
+	}
+	// the entries must be persisted before the commit marker.
+	activeFile, err := db.getActiveFile(dType)
+	if err == nil {
+		err = activeFile.Sync()
+	}
+	if err == nil {
+		err = db.store(newTxnMarker(txId, dType, TxCommit, nil, nil))
+	}
+	if err != nil {
+		db.rollbackTxn(txId, dType)
+		return err
+	}
+
+	if activeFile, err = db.getActiveFile(dType); err == nil {
+		db.garbage.markTxnSpan(dType, beginId, activeFile.Id)
+	}
+	return nil
+}
+
+// validTxnEntry checks whether the entry is valid in reclaim.
+// The markers of transaction are dropped except the commit ones, and the entries of aborted transactions are discarded.
+func (db *KVDB) validTxnEntry(e *storage.Entry, offset int64, fileId uint32, block *txnBlock) bool {
+	if valid, marker := db.checkTxnEntry(e, block); marker || !valid {
+		return valid
+	}
+	return db.validEntry(e, offset, fileId)
+}
+
+// checkTxnEntry checks the markers of transaction and the entries of aborted transactions like validTxnEntry,
+// marker reports whether the entry is a marker, otherwise the entry is valid unless it is aborted.
+func (db *KVDB) checkTxnEntry(e *storage.Entry, block *txnBlock) (valid, marker bool) {
+	switch e.GetMark() {
+	case TxBegin:
+		*block = parseTxnBegin(e)
+		return false, true
+	case TxCommit:
+		return true, true
+	case TxRollback:
+		return false, true
+	}
+
+	if block.remaining > 0 {
+		block.remaining--
+		if _, aborted := db.abortedTxns[block.id]; aborted {
+			return false, false
+		}
+	}
+	return true, false
+}
+
+// resetTxnId makes the id of new transaction greater than all the existing ones.
+func (db *KVDB) resetTxnId(txId uint64) {
+	if txId > db.txnId {
+		db.txnId = txId
+	}
+}
+
+// newTxnMarker returns a marker entry of transaction, the key of marker is the transaction id.
+func newTxnMarker(txId uint64, dType DataType, mark uint16, value, extra []byte) *storage.Entry {
+	return storage.NewEntry([]byte(strconv.FormatUint(txId, 10)), value, extra, dType, mark)
+}
+
+func isTxnMarker(e *storage.Entry) bool {
+	mark := e.GetMark()
+	return mark == TxBegin || mark == TxCommit || mark == TxRollback
+}
+
+func parseTxnId(e *storage.Entry) uint64 {
+	txId, _ := strconv.ParseUint(string(e.Meta.Key), 10, 64)
+	return txId
+}
+
+// parseTxnBegin parses the TxBegin marker, the value is the number of entries and the extra is the coordinator.
+func parseTxnBegin(e *storage.Entry) txnBlock {
+	count, _ := strconv.Atoi(string(e.Meta.Value))
+	coordinator, _ := strconv.Atoi(string(e.Meta.Extra))
+	return txnBlock{
+		id:          parseTxnId(e),
+		coordinator: uint16(coordinator),
+		remaining:   count,
+	}
+}
+
+// marker returns the begin marker of the entries which haven't been read in the block.
+func (b txnBlock) marker(dType DataType) *storage.Entry {
+	count := []byte(strconv.Itoa(b.remaining))
+	extra := []byte(strconv.Itoa(int(b.coordinator)))
+	return newTxnMarker(b.id, dType, TxBegin, count, extra)
+}
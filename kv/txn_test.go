@@ -0,0 +1,338 @@
+package kv
+
+import (
+	"MetaDB/kv/storage"
+
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestTxnCommit(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	err := db.Txn(func(tx *Tx) error {
+		if err := tx.Set([]byte("str"), []byte("v")); err != nil {
+			return err
+		}
+		if err := tx.HSet([]byte("hash"), []byte("f"), []byte("v")); err != nil {
+			return err
+		}
+		if err := tx.RPush([]byte("list"), []byte("a"), []byte("b")); err != nil {
+			return err
+		}
+		if _, err := tx.SAdd([]byte("set"), []byte("a")); err != nil {
+			return err
+		}
+		if err := tx.ZAdd([]byte("zset"), 1, []byte("a")); err != nil {
+			return err
+		}
+
+		// the transaction reads its own writes.
+		if val, err := tx.Get([]byte("str")); err != nil || string(val) != "v" {
+			t.Errorf("Get in transaction: got %q, %v", val, err)
+		}
+		if !tx.HExists([]byte("hash"), []byte("f")) || !tx.SIsMember([]byte("set"), []byte("a")) {
+			t.Error("the writes of transaction are not visible to itself")
+		}
+		if score, ok := tx.ZScore([]byte("zset"), []byte("a")); !ok || score != 1 {
+			t.Errorf("ZScore in transaction: got %v, %v", score, ok)
+		}
+		// the writes are not applied before committing.
+		if db.strIndex.indexes["str"] != nil {
+			t.Error("the write is applied before committing")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(db *KVDB) {
+		t.Helper()
+		val, err := db.Get([]byte("str"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, "v")
+		assertBytes(t, db.HGet([]byte("hash"), []byte("f")), "v")
+		assertList(t, db, "list", "a", "b")
+		if !db.SIsMember([]byte("set"), []byte("a")) {
+			t.Fatal("the set member is not committed")
+		}
+		if _, ok := db.ZScore([]byte("zset"), []byte("a")); !ok {
+			t.Fatal("the zset member is not committed")
+		}
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+
+	// the removes and overwrites in one transaction.
+	err = db.Txn(func(tx *Tx) error {
+		if err := tx.Remove([]byte("str")); err != nil {
+			return err
+		}
+		if res, err := tx.SetNx([]byte("str"), []byte("new")); err != nil || res != 1 {
+			return fmt.Errorf("SetNx a removed key: got %d, %v", res, err)
+		}
+		if _, err := tx.HDel([]byte("hash"), []byte("f")); err != nil {
+			return err
+		}
+		if _, err := tx.SRem([]byte("set"), []byte("a")); err != nil {
+			return err
+		}
+		_, err := tx.ZRem([]byte("zset"), []byte("a"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = reopenTestDB(t, db)
+	val, err := db.Get([]byte("str"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBytes(t, val, "new")
+	if db.HLen([]byte("hash")) != 0 || db.SKeyExists([]byte("set")) || db.ZKeyExists([]byte("zset")) {
+		t.Fatal("the removed keys exist")
+	}
+}
+
+func TestTxnRollback(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	if err := db.Set([]byte("str"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	errAbort := errors.New("abort")
+	var finished *Tx
+	err := db.Txn(func(tx *Tx) error {
+		finished = tx
+		if err := tx.Set([]byte("str"), []byte("new")); err != nil {
+			return err
+		}
+		if _, err := tx.SAdd([]byte("set"), []byte("a")); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("got %v, want the error of function", err)
+	}
+	if err := finished.Set([]byte("str"), []byte("v")); err != ErrTxIsFinished {
+		t.Fatalf("write in a finished transaction: got %v, want ErrTxIsFinished", err)
+	}
+
+	check := func(db *KVDB) {
+		t.Helper()
+		val, err := db.Get([]byte("str"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, "old")
+		if db.SKeyExists([]byte("set")) {
+			t.Fatal("the write of rolled back transaction is applied")
+		}
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+
+	err = db.TxnView(func(tx *Tx) error {
+		if err := tx.Set([]byte("str"), []byte("v")); err != ErrTxIsReadOnly {
+			t.Errorf("write in a read-only transaction: got %v, want ErrTxIsReadOnly", err)
+		}
+		val, err := tx.Get([]byte("str"))
+		if err != nil {
+			return err
+		}
+		assertBytes(t, val, "old")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The expired keys are cleared by the writes of transaction, and the clearing is discarded with them if it is rolled back.
+func TestTxnClearExpired(t *testing.T) {
+	db := openTestDB(t, nil)
+
+	if _, err := db.HSet([]byte("hash"), []byte("f"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RPush([]byte("list"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SAdd([]byte("set"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ZAdd([]byte("zset"), 1, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	dTypes := map[DataType]string{Hash: "hash", List: "list", Set: "set", ZSet: "zset"}
+	for dType, key := range dTypes {
+		expireNow(db, dType, key)
+	}
+
+	write := func(tx *Tx) error {
+		if err := tx.HSet([]byte("hash"), []byte("g"), []byte("new")); err != nil {
+			return err
+		}
+		if err := tx.RPush([]byte("list"), []byte("new")); err != nil {
+			return err
+		}
+		if _, err := tx.SAdd([]byte("set"), []byte("new")); err != nil {
+			return err
+		}
+		return tx.ZAdd([]byte("zset"), 2, []byte("new"))
+	}
+	errAbort := errors.New("abort")
+	err := db.Txn(func(tx *Tx) error {
+		if err := write(tx); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("got %v, want the error of function", err)
+	}
+	for dType, key := range dTypes {
+		if _, exist := db.expires[dType][key]; !exist || !db.keyExists(dType, key) {
+			t.Fatalf("the expired %s is cleared by a rolled back transaction", key)
+		}
+	}
+
+	if err := db.Txn(write); err != nil {
+		t.Fatal(err)
+	}
+	check := func(db *KVDB) {
+		t.Helper()
+		if fields := db.HKeys([]byte("hash")); len(fields) != 1 || fields[0] != "g" {
+			t.Fatalf("got the fields %q, want [g]", fields)
+		}
+		values, err := db.LRange([]byte("list"), 0, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 1 || string(values[0]) != "new" {
+			t.Fatalf("got the list %q, want [new]", values)
+		}
+		if members := db.SMembers([]byte("set")); len(members) != 1 || string(members[0]) != "new" {
+			t.Fatalf("got the set %q, want [new]", members)
+		}
+		if pairs := db.ZRange([]byte("zset"), 0, -1); len(pairs) != 1 || string(pairs[0].Member) != "new" {
+			t.Fatalf("got the zset %v, want [new]", pairs)
+		}
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
+
+// writeInterruptedTxn writes the entries of a transaction of hash and string without the commit marker,
+// as if the db crashed before committing.
+func writeInterruptedTxn(t *testing.T, db *KVDB, txId uint64) {
+	t.Helper()
+
+	unlock := db.lockMgr.Lock(Hash, String)
+	defer unlock()
+
+	entries := []*storage.Entry{
+		txnBlock{id: txId, coordinator: Hash, remaining: 1}.marker(Hash),
+		storage.NewEntry([]byte("hash"), []byte("lost"), []byte("f"), Hash, HashHSet),
+		txnBlock{id: txId, coordinator: Hash, remaining: 1}.marker(String),
+		storage.NewEntryNoExtra([]byte("str"), []byte("lost"), String, StringSet),
+	}
+	for _, e := range entries {
+		if err := db.store(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTxnInterrupted(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	if err := db.Set([]byte("str"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	writeInterruptedTxn(t, db, 1000)
+
+	check := func(db *KVDB) {
+		t.Helper()
+		val, err := db.Get([]byte("str"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertBytes(t, val, "old")
+		if db.HGet([]byte("hash"), []byte("f")) != nil {
+			t.Fatal("the entry of interrupted transaction is applied")
+		}
+	}
+	db = reopenTestDB(t, db)
+	check(db)
+	if db.txnId < 1000 {
+		t.Fatalf("the transaction id %d is reused", db.txnId)
+	}
+
+	// the interrupted entries are archived and discarded by reclaim.
+	for i := 0; i < 100; i++ {
+		if err := db.Set([]byte(fmt.Sprintf("filler%02d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.HSet([]byte("filler"), []byte(fmt.Sprintf("f%02d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Reclaim(); err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
+
+// A transaction crossing several db files is committed as a whole, and kept by reclaim.
+func TestTxnAcrossFiles(t *testing.T) {
+	db := openTestDB(t, smallFiles)
+
+	err := db.Txn(func(tx *Tx) error {
+		for i := 0; i < 40; i++ {
+			if err := tx.Set([]byte(fmt.Sprintf("k%02d", i)), []byte("v")); err != nil {
+				return err
+			}
+			if err := tx.HSet([]byte("hash"), []byte(fmt.Sprintf("f%02d", i)), []byte("v")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.archFiles[String]) == 0 || len(db.archFiles[Hash]) == 0 {
+		t.Fatal("the transaction doesn't cross the db files")
+	}
+
+	check := func(db *KVDB) {
+		t.Helper()
+		for i := 0; i < 40; i++ {
+			if _, err := db.Get([]byte(fmt.Sprintf("k%02d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := db.HLen([]byte("hash")); n != 40 {
+			t.Fatalf("HLen: got %d, want 40", n)
+		}
+	}
+	db = reopenTestDB(t, db)
+	check(db)
+	if err := db.Reclaim(); err != nil && err != ErrReclaimUnreached {
+		t.Fatal(err)
+	}
+	check(db)
+	db = reopenTestDB(t, db)
+	check(db)
+}
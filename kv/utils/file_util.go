@@ -16,6 +16,19 @@ func Exist(path string) bool {
 	return true
 }
 
+// SyncDir commits the entries of directory to disk, such as the created, renamed and removed files.
+func SyncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err = dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
 // CopyDir copy directory from src to dst.
 func CopyDir(src string, dst string) error {
 	var (
@@ -0,0 +1,83 @@
+package utils
+
+// GlobMatch reports whether s matches the glob-style pattern, the syntax is the same as the KEYS command of Redis.
+// '*' matches any sequence of characters, '?' matches any single character, and '\' escapes the next character.
+// [abc] matches one character in the brackets, [a-z] matches a range, and [^abc] or [!abc] negates the class.
+func GlobMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if GlobMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var matched bool
+			if matched, pattern = matchClass(pattern[1:], s[0]); !matched {
+				return false
+			}
+			s = s[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c with the character class after '[', and returns the pattern after ']'.
+func matchClass(pattern string, c byte) (bool, string) {
+	not := len(pattern) > 0 && (pattern[0] == '^' || pattern[0] == '!')
+	if not {
+		pattern = pattern[1:]
+	}
+
+	var matched bool
+	for len(pattern) > 0 && pattern[0] != ']' {
+		if pattern[0] == '\\' && len(pattern) > 1 {
+			pattern = pattern[1:]
+			matched = matched || pattern[0] == c
+			pattern = pattern[1:]
+			continue
+		}
+		if len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']' {
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			pattern = pattern[3:]
+			continue
+		}
+		matched = matched || pattern[0] == c
+		pattern = pattern[1:]
+	}
+	// an unclosed class ends at the end of pattern, like Redis.
+	if len(pattern) > 0 {
+		pattern = pattern[1:]
+	}
+	return matched != not, pattern
+}